@@ -13,6 +13,8 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -159,15 +161,54 @@ type APIClient interface {
 	CreateKey(keyID string, data []byte, acl ACL) (uint64, error)
 	GetKeys(keys map[string]string) ([]string, error)
 	DeleteKey(keyID string) error
+	PurgeKey(keyID string) error
+	RestoreKey(keyID string) error
+	RenameKey(keyID, newKeyID string) error
+	GetAlias(aliasID string) (*KeyAlias, error)
+	PutAlias(aliasID, keyID string, acl ACL) error
+	DeleteAlias(aliasID string) error
 	GetACL(keyID string) (*ACL, error)
 	PutAccess(keyID string, acl ...Access) error
 	AddVersion(keyID string, data []byte) (uint64, error)
 	UpdateVersion(keyID, versionID string, status VersionStatus) error
+	PurgeVersion(keyID, versionID string) error
+	GetVersionRetention(keyID string) (*VersionRetention, error)
+	PutVersionRetention(keyID string, r VersionRetention) error
+	GetQuorum(keyID string) (bool, error)
+	PutQuorum(keyID string, required bool) error
+	GetPendingOperations(keyID string) ([]PendingOperation, error)
+	ConfirmOperation(keyID string, operationID uint64) (*PendingOperation, error)
+	CancelOperation(keyID string, operationID uint64) error
+	CreateKeyInNamespace(keyID string, data []byte, acl ACL, path string) (uint64, error)
+	MoveKey(keyID, path string) error
+	GetNamespaces() ([]string, error)
+	GetNamespace(path string) (*Namespace, error)
+	CreateNamespace(path string, acl ACL) error
+	PutNamespaceAccess(path string, acl ...Access) error
+	DeleteNamespace(path string) error
+	ExplainAccess(keyID, principal string, groups []string, access AccessType, action string) (*AccessExplanation, error)
+	Whoami() (*Identity, error)
+	AccessReport(principal string, groups []string) ([]AccessReportEntry, error)
+	MigrateACL(from, to string, dryRun bool, after string, batch int) (*ACLMigrationResult, error)
+	StripAccess(principals []string) ([]ACLMigration, error)
+	ACLLintReport() ([]ACLLintFinding, error)
+	BreakGlass(keyID, justification string) (*BreakGlassGrant, error)
+	BreakGlassReport() ([]BreakGlassGrant, error)
+	OPADecisionReport() ([]OPADecision, error)
+	RequestAccess(keyID string, access AccessType, justification string, duration time.Duration) (*AccessRequest, error)
+	GetAccessRequests(keyID string) ([]AccessRequest, error)
+	DecideAccessRequest(keyID string, requestID uint64, approve bool) (*AccessRequest, error)
 	CacheGetKey(keyID string) (*Key, error)
 	NetworkGetKey(keyID string) (*Key, error)
 	GetKeyWithStatus(keyID string, status VersionStatus) (*Key, error)
 	CacheGetKeyWithStatus(keyID string, status VersionStatus) (*Key, error)
 	NetworkGetKeyWithStatus(keyID string, status VersionStatus) (*Key, error)
+	NetworkGetKeyWithETag(keyID string) (*Key, string, error)
+	GetACLWithETag(keyID string) (*ACL, string, error)
+	DeleteKeyIfMatch(keyID, etag string) error
+	PutAccessIfMatch(keyID, etag string, acl ...Access) error
+	AddVersionIfMatch(keyID, etag string, data []byte) (uint64, error)
+	UpdateVersionIfMatch(keyID, versionID, etag string, status VersionStatus) error
 }
 
 type HTTP interface {
@@ -304,12 +345,61 @@ func (c *HTTPClient) GetKeys(keys map[string]string) ([]string, error) {
 	return l, err
 }
 
-// DeleteKey deletes a key from Knox.
+// DeleteKey moves a key to the Knox trash, from which it can be restored.
 func (c HTTPClient) DeleteKey(keyID string) error {
 	err := c.getHTTPData("DELETE", "/v0/keys/"+keyID+"/", nil, nil)
 	return err
 }
 
+// PurgeKey permanently deletes a key from Knox, whether or not it is in the trash.
+func (c *HTTPClient) PurgeKey(keyID string) error {
+	err := c.getHTTPData("DELETE", "/v0/keys/"+keyID+"/?purge=true", nil, nil)
+	return err
+}
+
+// RestoreKey moves a key out of the Knox trash.
+func (c *HTTPClient) RestoreKey(keyID string) error {
+	err := c.getHTTPData("POST", "/v0/keys/"+keyID+"/restore/", nil, nil)
+	return err
+}
+
+// RenameKey moves a key to a new ID, leaving an alias at the old one.
+func (c *HTTPClient) RenameKey(keyID, newKeyID string) error {
+	d := url.Values{}
+	d.Set("id", newKeyID)
+	err := c.getHTTPData("POST", "/v0/keys/"+keyID+"/rename/", d, nil)
+	return err
+}
+
+// GetAlias gets a key alias by aliasID.
+func (c *HTTPClient) GetAlias(aliasID string) (*KeyAlias, error) {
+	alias := &KeyAlias{}
+	err := c.getHTTPData("GET", "/v0/aliases/"+aliasID+"/", nil, alias)
+	return alias, err
+}
+
+// PutAlias creates an alias for a key. If acl is nil, the alias gets a copy of
+// the key's ACL.
+func (c *HTTPClient) PutAlias(aliasID, keyID string, acl ACL) error {
+	d := url.Values{}
+	d.Set("key", keyID)
+	if acl != nil {
+		s, err := json.Marshal(acl)
+		if err != nil {
+			return err
+		}
+		d.Set("acl", string(s))
+	}
+	err := c.getHTTPData("PUT", "/v0/aliases/"+aliasID+"/", d, nil)
+	return err
+}
+
+// DeleteAlias removes a key alias.
+func (c *HTTPClient) DeleteAlias(aliasID string) error {
+	err := c.getHTTPData("DELETE", "/v0/aliases/"+aliasID+"/", nil, nil)
+	return err
+}
+
 // GetACL gets a knox key by keyID.
 func (c *HTTPClient) GetACL(keyID string) (*ACL, error) {
 	acl := &ACL{}
@@ -351,6 +441,377 @@ func (c *HTTPClient) UpdateVersion(keyID, versionID string, status VersionStatus
 	return err
 }
 
+// ifMatch returns the request headers that make a write conditional on etag.
+func ifMatch(etag string) http.Header {
+	return http.Header{"If-Match": []string{etag}}
+}
+
+// NetworkGetKeyWithETag gets a knox key by keyID along with its ETag.
+func (c *HTTPClient) NetworkGetKeyWithETag(keyID string) (*Key, string, error) {
+	key := &Key{}
+	h, err := c.getHTTPDataWithHeaders("GET", "/v0/keys/"+keyID+"/", nil, key, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, h.Get("ETag"), nil
+}
+
+// GetACLWithETag gets the ACL of a knox key along with the key's ETag.
+func (c *HTTPClient) GetACLWithETag(keyID string) (*ACL, string, error) {
+	acl := &ACL{}
+	h, err := c.getHTTPDataWithHeaders("GET", "/v0/keys/"+keyID+"/access/", nil, acl, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return acl, h.Get("ETag"), nil
+}
+
+// DeleteKeyIfMatch deletes a key from Knox if its ETag still matches etag.
+func (c *HTTPClient) DeleteKeyIfMatch(keyID, etag string) error {
+	_, err := c.getHTTPDataWithHeaders("DELETE", "/v0/keys/"+keyID+"/", nil, nil, ifMatch(etag))
+	return err
+}
+
+// PutAccessIfMatch adds ACL rules to a key if its ETag still matches etag.
+func (c *HTTPClient) PutAccessIfMatch(keyID, etag string, a ...Access) error {
+	d := url.Values{}
+	s, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	d.Set("acl", string(s))
+	_, err = c.getHTTPDataWithHeaders("PUT", "/v0/keys/"+keyID+"/access/", d, nil, ifMatch(etag))
+	return err
+}
+
+// AddVersionIfMatch adds a key version if the key's ETag still matches etag.
+func (c *HTTPClient) AddVersionIfMatch(keyID, etag string, data []byte) (uint64, error) {
+	var i uint64
+	d := url.Values{}
+	d.Set("data", base64.StdEncoding.EncodeToString(data))
+	_, err := c.getHTTPDataWithHeaders("POST", "/v0/keys/"+keyID+"/versions/", d, &i, ifMatch(etag))
+	return i, err
+}
+
+// UpdateVersionIfMatch promotes or demotes a key version if the key's ETag
+// still matches etag.
+func (c *HTTPClient) UpdateVersionIfMatch(keyID, versionID, etag string, status VersionStatus) error {
+	d := url.Values{}
+	s, err := status.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	d.Set("status", string(s))
+
+	_, err = c.getHTTPDataWithHeaders("PUT", "/v0/keys/"+keyID+"/versions/"+versionID+"/", d, nil, ifMatch(etag))
+	return err
+}
+
+// PurgeVersion permanently removes an Inactive key version.
+func (c *HTTPClient) PurgeVersion(keyID, versionID string) error {
+	err := c.getHTTPData("DELETE", "/v0/keys/"+keyID+"/versions/"+versionID+"/", nil, nil)
+	return err
+}
+
+// GetVersionRetention gets the policy for purging Inactive versions of a key.
+// It returns nil if the key has no policy.
+func (c *HTTPClient) GetVersionRetention(keyID string) (*VersionRetention, error) {
+	var r *VersionRetention
+	err := c.getHTTPData("GET", "/v0/keys/"+keyID+"/retention/", nil, &r)
+	return r, err
+}
+
+// PutVersionRetention sets the policy for purging Inactive versions of a key.
+// A policy without limits removes the key's policy.
+func (c *HTTPClient) PutVersionRetention(keyID string, r VersionRetention) error {
+	d := url.Values{}
+	s, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	d.Set("retention", string(s))
+	err = c.getHTTPData("PUT", "/v0/keys/"+keyID+"/retention/", d, nil)
+	return err
+}
+
+// GetQuorum gets whether destructive operations on a key need a second admin
+// to confirm them.
+func (c *HTTPClient) GetQuorum(keyID string) (bool, error) {
+	var required bool
+	err := c.getHTTPData("GET", "/v0/keys/"+keyID+"/quorum/", nil, &required)
+	return required, err
+}
+
+// PutQuorum sets whether destructive operations on a key need a second admin
+// to confirm them. Turning quorum off on a key that requires it is itself a
+// pending operation.
+func (c *HTTPClient) PutQuorum(keyID string, required bool) error {
+	d := url.Values{}
+	d.Set("required", strconv.FormatBool(required))
+	return c.getHTTPData("PUT", "/v0/keys/"+keyID+"/quorum/", d, nil)
+}
+
+// GetPendingOperations lists the pending operations on the keys the caller is
+// an admin of or that the caller asked for, for every key or, if keyID is not
+// empty, for one key.
+func (c *HTTPClient) GetPendingOperations(keyID string) ([]PendingOperation, error) {
+	path := "/v0/operations/"
+	if keyID != "" {
+		path += "?" + url.Values{"key_id": {keyID}}.Encode()
+	}
+	ops := []PendingOperation{}
+	err := c.getHTTPData("GET", path, nil, &ops)
+	return ops, err
+}
+
+// ConfirmOperation runs a pending operation that another principal asked for.
+func (c *HTTPClient) ConfirmOperation(keyID string, operationID uint64) (*PendingOperation, error) {
+	op := &PendingOperation{}
+	err := c.getHTTPData("POST", "/v0/keys/"+keyID+"/operations/"+strconv.FormatUint(operationID, 10)+"/", url.Values{}, op)
+	return op, err
+}
+
+// CancelOperation drops a pending operation without running it.
+func (c *HTTPClient) CancelOperation(keyID string, operationID uint64) error {
+	return c.getHTTPData("DELETE", "/v0/keys/"+keyID+"/operations/"+strconv.FormatUint(operationID, 10)+"/", nil, nil)
+}
+
+// CreateKeyInNamespace creates a knox key with given keyID, data and ACL in
+// the namespace at path.
+func (c *HTTPClient) CreateKeyInNamespace(keyID string, data []byte, acl ACL, path string) (uint64, error) {
+	var i uint64
+	d := url.Values{}
+	d.Set("id", keyID)
+	d.Set("data", base64.StdEncoding.EncodeToString(data))
+	d.Set("path", path)
+	s, err := json.Marshal(acl)
+	if err != nil {
+		return i, err
+	}
+	d.Set("acl", string(s))
+	err = c.getHTTPData("POST", "/v0/keys/", d, &i)
+	return i, err
+}
+
+// MoveKey moves a key into the namespace at path, or out of any namespace if
+// path is empty.
+func (c *HTTPClient) MoveKey(keyID, path string) error {
+	d := url.Values{}
+	d.Set("path", path)
+	err := c.getHTTPData("POST", "/v0/keys/"+keyID+"/move/", d, nil)
+	return err
+}
+
+// GetNamespaces gets the paths of the namespaces the client can read.
+func (c *HTTPClient) GetNamespaces() ([]string, error) {
+	var l []string
+	err := c.getHTTPData("GET", "/v0/namespaces/", nil, &l)
+	return l, err
+}
+
+// GetNamespace gets a namespace with the keys and namespaces directly inside it.
+func (c *HTTPClient) GetNamespace(path string) (*Namespace, error) {
+	n := &Namespace{}
+	err := c.getHTTPData("GET", "/v0/namespaces"+path+"/", nil, n)
+	return n, err
+}
+
+// CreateNamespace creates a namespace with the given ACL.
+func (c *HTTPClient) CreateNamespace(path string, acl ACL) error {
+	d := url.Values{}
+	s, err := json.Marshal(acl)
+	if err != nil {
+		return err
+	}
+	d.Set("acl", string(s))
+	err = c.getHTTPData("POST", "/v0/namespaces"+path+"/", d, nil)
+	return err
+}
+
+// PutNamespaceAccess will add ACL rules to a namespace.
+func (c *HTTPClient) PutNamespaceAccess(path string, a ...Access) error {
+	d := url.Values{}
+	s, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	d.Set("acl", string(s))
+	err = c.getHTTPData("PUT", "/v0/namespaces"+path+"/", d, nil)
+	return err
+}
+
+// DeleteNamespace removes an empty namespace.
+func (c *HTTPClient) DeleteNamespace(path string) error {
+	err := c.getHTTPData("DELETE", "/v0/namespaces"+path+"/", nil, nil)
+	return err
+}
+
+// ExplainAccess explains whether a principal has access to a key and why. An
+// empty principal explains the caller's own access; others are written as
+// Type:ID, such as Machine:host1.example.com, with groups for users. A None
+// access and an empty action check Read access for the GetKey action.
+func (c *HTTPClient) ExplainAccess(keyID, principal string, groups []string, access AccessType, action string) (*AccessExplanation, error) {
+	q := url.Values{}
+	if principal != "" {
+		q.Set("as", principal)
+	}
+	if len(groups) > 0 {
+		q.Set("groups", strings.Join(groups, ","))
+	}
+	if access != None {
+		s, err := access.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		q.Set("access", strings.Trim(string(s), `"`))
+	}
+	if action != "" {
+		q.Set("action", action)
+	}
+	path := "/v0/keys/" + keyID + "/explain/"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	e := &AccessExplanation{}
+	err := c.getHTTPData("GET", path, nil, e)
+	return e, err
+}
+
+// Whoami describes the principal the client is authenticated as.
+func (c *HTTPClient) Whoami() (*Identity, error) {
+	id := &Identity{}
+	err := c.getHTTPData("GET", "/v0/whoami/", nil, id)
+	return id, err
+}
+
+// AccessReport lists the keys a principal, written as Type:ID, has access to
+// with the highest access type it has to each. Users are given groups.
+func (c *HTTPClient) AccessReport(principal string, groups []string) ([]AccessReportEntry, error) {
+	q := url.Values{}
+	q.Set("principal", principal)
+	if len(groups) > 0 {
+		q.Set("groups", strings.Join(groups, ","))
+	}
+	report := []AccessReportEntry{}
+	err := c.getHTTPData("GET", "/v0/reports/access/?"+q.Encode(), nil, &report)
+	return report, err
+}
+
+// ACLLintReport checks the ACL of every key against the server's lint rules
+// and lists the findings.
+func (c *HTTPClient) ACLLintReport() ([]ACLLintFinding, error) {
+	report := []ACLLintFinding{}
+	err := c.getHTTPData("GET", "/v0/reports/acl-lint/", nil, &report)
+	return report, err
+}
+
+// RequestAccess files a request for the caller's access to a key, to be
+// approved or denied by its admins. A non-zero duration makes the access
+// temporary once approved.
+func (c *HTTPClient) RequestAccess(keyID string, access AccessType, justification string, duration time.Duration) (*AccessRequest, error) {
+	s, err := access.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	d := url.Values{}
+	d.Set("access", strings.Trim(string(s), `"`))
+	d.Set("justification", justification)
+	if duration != 0 {
+		d.Set("duration", duration.String())
+	}
+	r := &AccessRequest{}
+	err = c.getHTTPData("POST", "/v0/keys/"+keyID+"/requests/", d, r)
+	return r, err
+}
+
+// GetAccessRequests lists the pending access requests the caller can decide or
+// has filed, for every key or, if keyID is not empty, for one key.
+func (c *HTTPClient) GetAccessRequests(keyID string) ([]AccessRequest, error) {
+	path := "/v0/requests/"
+	if keyID != "" {
+		path += "?" + url.Values{"key_id": {keyID}}.Encode()
+	}
+	requests := []AccessRequest{}
+	err := c.getHTTPData("GET", path, nil, &requests)
+	return requests, err
+}
+
+// DecideAccessRequest approves or denies a pending access request. Approving
+// it adds the requested access to the key's ACL.
+func (c *HTTPClient) DecideAccessRequest(keyID string, requestID uint64, approve bool) (*AccessRequest, error) {
+	d := url.Values{}
+	if approve {
+		d.Set("decision", "approve")
+	} else {
+		d.Set("decision", "deny")
+	}
+	r := &AccessRequest{}
+	err := c.getHTTPData("POST", "/v0/keys/"+keyID+"/requests/"+strconv.FormatUint(requestID, 10)+"/", d, r)
+	return r, err
+}
+
+// BreakGlass takes emergency Read access to a key for the server's break-glass
+// window, without changing the key's ACL. The key is then read as usual.
+func (c *HTTPClient) BreakGlass(keyID, justification string) (*BreakGlassGrant, error) {
+	d := url.Values{}
+	d.Set("justification", justification)
+	g := &BreakGlassGrant{}
+	err := c.getHTTPData("POST", "/v0/keys/"+keyID+"/break-glass/", d, g)
+	return g, err
+}
+
+// BreakGlassReport lists every break-glass grant, including the expired ones
+// the server archives.
+func (c *HTTPClient) BreakGlassReport() ([]BreakGlassGrant, error) {
+	report := []BreakGlassGrant{}
+	err := c.getHTTPData("GET", "/v0/reports/break-glass/", nil, &report)
+	return report, err
+}
+
+// OPADecisionReport lists the most recent authorization decisions made with
+// the OPA policies embedded in the server, oldest first.
+func (c *HTTPClient) OPADecisionReport() ([]OPADecision, error) {
+	report := []OPADecision{}
+	err := c.getHTTPData("GET", "/v0/reports/opa-decisions/", nil, &report)
+	return report, err
+}
+
+// MigrateACL replaces the ACL entries for the principal from with entries for
+// the principal to, or removes them if to is empty, in a batch of up to batch
+// keys after the key ID after. Principals are written as Type:ID. A batch of 0
+// uses the server's default. With dryRun the changes are only reported.
+func (c *HTTPClient) MigrateACL(from, to string, dryRun bool, after string, batch int) (*ACLMigrationResult, error) {
+	d := url.Values{}
+	d.Set("from", from)
+	if to != "" {
+		d.Set("to", to)
+	} else {
+		d.Set("remove", "true")
+	}
+	if dryRun {
+		d.Set("dry_run", "true")
+	}
+	if after != "" {
+		d.Set("after", after)
+	}
+	if batch > 0 {
+		d.Set("batch", strconv.Itoa(batch))
+	}
+	r := &ACLMigrationResult{}
+	err := c.getHTTPData("POST", "/v0/acl-migrations/", d, r)
+	return r, err
+}
+
+// StripAccess removes every ACL entry of principals, whatever their access
+// type, from every key, alias and namespace. Principals are written as Type:ID.
+func (c *HTTPClient) StripAccess(principals []string) ([]ACLMigration, error) {
+	d := url.Values{}
+	d.Set("principals", strings.Join(principals, ";"))
+	changes := []ACLMigration{}
+	err := c.getHTTPData("POST", "/v0/acl-migrations/strip/", d, &changes)
+	return changes, err
+}
+
 func (c *HTTPClient) getClient() (HTTP, error) {
 	if c.Client == nil {
 		c.Client = &http.Client{}
@@ -359,21 +820,31 @@ func (c *HTTPClient) getClient() (HTTP, error) {
 }
 
 func (c *HTTPClient) getHTTPData(method string, path string, body url.Values, data interface{}) error {
+	_, err := c.getHTTPDataWithHeaders(method, path, body, data, nil)
+	return err
+}
+
+// getHTTPDataWithHeaders is getHTTPData with extra request headers. It returns
+// the headers of the successful response.
+func (c *HTTPClient) getHTTPDataWithHeaders(method string, path string, body url.Values, data interface{}, headers http.Header) (http.Header, error) {
 	r, err := http.NewRequest(method, "https://"+c.Host+path, bytes.NewBufferString(body.Encode()))
 
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for k, v := range headers {
+		r.Header[k] = v
 	}
 
 	auth := c.AuthHandler()
 	switch {
 	case auth == "":
-		return fmt.Errorf("no authentication data given. Use 'knox login' or set KNOX_USER_AUTH or KNOX_MACHINE_AUTH")
+		return nil, fmt.Errorf("no authentication data given. Use 'knox login' or set KNOX_USER_AUTH or KNOX_MACHINE_AUTH")
 	case auth[:2] == "0u":
 		r.Header.Set("Authorization", auth[2:])
 	case auth[:2] == "0s":
 	default:
-		return fmt.Errorf("wrong authentication type. Use 'knox login' or set KNOX_USER_AUTH or KNOX_MACHINE_AUTH")
+		return nil, fmt.Errorf("wrong authentication type. Use 'knox login' or set KNOX_USER_AUTH or KNOX_MACHINE_AUTH")
 	}
 	r.Header.Set("User-Agent", fmt.Sprintf("Knox_Client/%s", c.Version))
 
@@ -383,20 +854,21 @@ func (c *HTTPClient) getHTTPData(method string, path string, body url.Values, da
 
 	cli, err := c.getClient()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	resp := &Response{}
 	resp.Data = data
+	var respHeaders http.Header
 	// Contains retry logic if we decode a 500 error.
 	for i := 1; i <= maxRetryAttempts; i++ {
-		err = getHTTPResp(cli, r, resp)
+		respHeaders, err = getHTTPResp(cli, r, resp)
 		if err != nil {
-			return fmt.Errorf("bad response from server %+v\nerror: %v", resp, err)
+			return nil, fmt.Errorf("bad response from server %+v\nerror: %v", resp, err)
 		}
 		if resp.Status != "ok" {
 			if (resp.Code != InternalServerErrorCode) || (i == maxRetryAttempts) {
-				return fmt.Errorf(resp.Message)
+				return nil, fmt.Errorf(resp.Message)
 			}
 			time.Sleep(GetBackoffDuration(i))
 		} else {
@@ -404,18 +876,18 @@ func (c *HTTPClient) getHTTPData(method string, path string, body url.Values, da
 		}
 	}
 
-	return nil
+	return respHeaders, nil
 }
 
-func getHTTPResp(cli HTTP, r *http.Request, resp *Response) error {
+func getHTTPResp(cli HTTP, r *http.Request, resp *Response) (http.Header, error) {
 	w, err := cli.Do(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer w.Body.Close()
 
 	decoder := json.NewDecoder(w.Body)
-	return decoder.Decode(resp)
+	return w.Header, decoder.Decode(resp)
 }
 
 // MockClient builds a client that ignores certs and talks to the given host.
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	cmdAccessReport.Run = runAccessReport // break init cycle
+}
+
+var cmdAccessReport = &Command{
+	UsageLine: "access-report -principal <principal> [-groups <groups>] [-format csv|json]",
+	Short:     "lists the keys a principal can access",
+	Long: `
+Access-report lists every key a principal can access and the highest access it has to each key (Read, Write or Admin), for access reviews and offboarding.
+
+-principal is written as Type:ID, where Type is User, Machine, Service or UserGroup, for example User:alice, Machine:host1.example.com, Service:spiffe://example.com/serviceA or UserGroup:oncall. A UserGroup reports the access granted to members of the group. A Machine also matches the MachinePrefix entries that are prefixes of its hostname, so Machine:web reports what every machine whose name starts with web can access at least.
+-groups gives the comma separated groups of a User principal, whose access is included in the report. Users are not given their groups otherwise.
+-format chooses csv (default) or json output. CSV output has a header row followed by one row per key with its ID, namespace and access.
+
+Access granted by namespaces and by the server's global policy is included. Reports are only available when the server authorizes with ACLs.
+
+This command requires global admin permissions.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox explain, knox acl
+	`,
+}
+var accessReportPrincipal = cmdAccessReport.Flag.String("principal", "", "")
+var accessReportGroups = cmdAccessReport.Flag.String("groups", "", "")
+var accessReportFormat = cmdAccessReport.Flag.String("format", "csv", "")
+
+func runAccessReport(cmd *Command, args []string) {
+	if len(args) != 0 || *accessReportPrincipal == "" {
+		fatalf("access-report takes a -principal and no arguments. See 'knox help access-report'")
+	}
+	if *accessReportFormat != "csv" && *accessReportFormat != "json" {
+		fatalf("Unknown format %s. See 'knox help access-report'", *accessReportFormat)
+	}
+	var groups []string
+	if *accessReportGroups != "" {
+		groups = strings.Split(*accessReportGroups, ",")
+	}
+
+	report, err := cli.AccessReport(*accessReportPrincipal, groups)
+	if err != nil {
+		fatalf("Error getting access report: %s", err.Error())
+	}
+
+	if *accessReportFormat == "json" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fatalf("Could not marshal report: %s", err.Error())
+		}
+		fmt.Println(string(b))
+		return
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"key_id", "path", "access"})
+	for _, e := range report {
+		access, err := json.Marshal(e.Access)
+		if err != nil {
+			fatalf("Could not marshal access for %s", e.KeyID)
+		}
+		w.Write([]string{e.KeyID, e.Path, strings.Trim(string(access), `"`)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fatalf("Could not write report: %s", err.Error())
+	}
+}
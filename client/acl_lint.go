@@ -0,0 +1,60 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+func init() {
+	cmdACLLint.Run = runACLLint // break init cycle
+}
+
+var cmdACLLint = &Command{
+	UsageLine: "acl-lint [-json]",
+	Short:     "checks every key's ACL for risky entries",
+	Long: `
+Acl-lint checks the ACL of every key against the lint rules configured on the server, such as machine prefixes that are too short, keys without a human admin, broad user groups and entries made redundant by other entries, and prints one line per finding: the key ID, the rule, the message and the entry the finding is about, if any.
+
+Entries inherited from namespaces are taken into account. Depending on the server's configuration, the same rules are checked when access to a key is changed, and changes that introduce findings are either accepted with a warning or rejected.
+
+-json prints the findings as JSON instead.
+
+This command requires global admin permissions.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox access, knox access-report
+	`,
+}
+var aclLintJSON = cmdACLLint.Flag.Bool("json", false, "")
+
+func runACLLint(cmd *Command, args []string) {
+	if len(args) != 0 {
+		fatalf("acl-lint takes no arguments. See 'knox help acl-lint'")
+	}
+
+	report, err := cli.ACLLintReport()
+	if err != nil {
+		fatalf("Error getting ACL lint report: %s", err.Error())
+	}
+
+	if *aclLintJSON {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fatalf("Could not marshal report: %s", err.Error())
+		}
+		fmt.Println(string(b))
+		return
+	}
+	for _, f := range report {
+		if f.Access == nil {
+			fmt.Printf("%s %s: %s\n", f.KeyID, f.Rule, f.Message)
+			continue
+		}
+		access, err := json.Marshal(f.Access)
+		if err != nil {
+			fatalf("Could not marshal access for %s", f.KeyID)
+		}
+		fmt.Printf("%s %s: %s %s\n", f.KeyID, f.Rule, f.Message, string(access))
+	}
+}
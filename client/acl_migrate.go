@@ -0,0 +1,75 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+func init() {
+	cmdACLMigrate.Run = runACLMigrate // break init cycle
+}
+
+var cmdACLMigrate = &Command{
+	UsageLine: "acl-migrate -from <principal> (-to <principal> | -remove) [-dry-run] [-batch <size>]",
+	Short:     "replaces or removes a principal in every ACL",
+	Long: `
+Acl-migrate replaces every ACL entry for a principal with an entry for another principal, or removes the entries, across all keys, aliases and namespaces, including keys in the trash. Use it when a host is decommissioned or a group is renamed.
+
+Principals are written as Type:ID, where Type is User, UserGroup, Machine, MachinePrefix, Service or ServicePrefix, for example UserGroup:security-team or Machine:host1.example.com. Only entries with exactly that type and ID are changed.
+
+-from is the principal whose entries are changed.
+-to is the principal that replaces it. The new entries keep the access type and expiry of the old ones. If the new principal already has an entry in an ACL, the two are merged: a deny rule wins, otherwise the higher access is kept.
+-remove removes the entries instead.
+-dry-run prints the changes without making them.
+-batch sets how many keys the server changes per request (default 100).
+
+Each change is printed with the removed entries prefixed by - and the added entries prefixed by +, and the server writes an audit record for every changed key.
+
+This command requires global admin permissions.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox access, knox access-report
+	`,
+}
+var aclMigrateFrom = cmdACLMigrate.Flag.String("from", "", "")
+var aclMigrateTo = cmdACLMigrate.Flag.String("to", "", "")
+var aclMigrateRemove = cmdACLMigrate.Flag.Bool("remove", false, "")
+var aclMigrateDryRun = cmdACLMigrate.Flag.Bool("dry-run", false, "")
+var aclMigrateBatch = cmdACLMigrate.Flag.Int("batch", 0, "")
+
+func runACLMigrate(cmd *Command, args []string) {
+	if len(args) != 0 || *aclMigrateFrom == "" || (*aclMigrateTo == "") == !*aclMigrateRemove {
+		fatalf("acl-migrate takes -from and exactly one of -to and -remove. See 'knox help acl-migrate'")
+	}
+
+	changed := 0
+	after := ""
+	for {
+		r, err := cli.MigrateACL(*aclMigrateFrom, *aclMigrateTo, *aclMigrateDryRun, after, *aclMigrateBatch)
+		if err != nil {
+			fatalf("Error migrating ACLs after %d changed keys: %s", changed, err.Error())
+		}
+		for _, c := range r.Changes {
+			fmt.Println(c.KeyID)
+			for _, a := range c.Removed {
+				aEnc, _ := json.Marshal(a)
+				fmt.Printf("- %s\n", aEnc)
+			}
+			for _, a := range c.Added {
+				aEnc, _ := json.Marshal(a)
+				fmt.Printf("+ %s\n", aEnc)
+			}
+		}
+		changed += len(r.Changes)
+		if r.Next == "" {
+			break
+		}
+		after = r.Next
+	}
+	if *aclMigrateDryRun {
+		fmt.Printf("Dry run: would change %d keys\n", changed)
+	} else {
+		fmt.Printf("Changed %d keys\n", changed)
+	}
+}
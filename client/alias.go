@@ -0,0 +1,53 @@
+package client
+
+import (
+	"fmt"
+)
+
+func init() {
+	cmdAlias.Run = runAlias // break init cycle
+}
+
+var cmdAlias = &Command{
+	UsageLine: "alias [-d] <alias_identifier> [<key_identifier>]",
+	Short:     "manages key aliases",
+	Long: `
+Alias creates an alternative identifier for a key. Reading the alias returns the key it points to, including through knox register and the daemon, so consumers can keep using an old identifier after a key is renamed.
+
+With one argument, alias prints the key the alias points to. With two arguments, it creates the alias with a copy of the key's ACL.
+
+-d removes the alias. The key it points to is not changed.
+
+Reading a key through an alias requires read access under both the alias's and the key's ACL. Creating an alias requires admin permissions on the key, and removing one requires admin permissions on the alias.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox rename, knox get
+	`,
+}
+var aliasDelete = cmdAlias.Flag.Bool("d", false, "")
+
+func runAlias(cmd *Command, args []string) {
+	switch {
+	case *aliasDelete && len(args) == 1:
+		err := cli.DeleteAlias(args[0])
+		if err != nil {
+			fatalf("Error removing alias: %s", err.Error())
+		}
+		fmt.Printf("Successfully removed alias %s\n", args[0])
+	case !*aliasDelete && len(args) == 1:
+		alias, err := cli.GetAlias(args[0])
+		if err != nil {
+			fatalf("Error getting alias: %s", err.Error())
+		}
+		fmt.Printf("%s -> %s\n", alias.ID, alias.KeyID)
+	case !*aliasDelete && len(args) == 2:
+		err := cli.PutAlias(args[0], args[1], nil)
+		if err != nil {
+			fatalf("Error creating alias: %s", err.Error())
+		}
+		fmt.Printf("Successfully created alias %s for %s\n", args[0], args[1])
+	default:
+		fatalf("alias takes one or two arguments, or one with -d. See 'knox help alias'")
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+)
+
+func init() {
+	cmdApprove.Run = runApprove // break init cycle
+}
+
+var cmdApprove = &Command{
+	UsageLine: "approve [-deny] <key_identifier> <request_id>",
+	Short:     "approves or denies an access request",
+	Long: `
+Approve approves a pending access request, adding the requested access to the key's acl. The acl change is checked against the server's lint rules like any other.
+
+-deny denies the request instead, leaving the acl unchanged.
+
+Request IDs are listed by 'knox requests'.
+
+This command requires admin access to the key.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox requests, knox request-access
+	`,
+}
+var approveDeny = cmdApprove.Flag.Bool("deny", false, "")
+
+func runApprove(cmd *Command, args []string) {
+	if len(args) != 2 {
+		fatalf("approve takes exactly two arguments. See 'knox help approve'")
+	}
+	requestID, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		fatalf("Invalid request ID %s. See 'knox help approve'", args[1])
+	}
+
+	_, err = cli.DecideAccessRequest(args[0], requestID, !*approveDeny)
+	if err != nil {
+		fatalf("Error deciding access request: %s", err.Error())
+	}
+	if *approveDeny {
+		fmt.Printf("Denied access request %d for %s\n", requestID, args[0])
+	} else {
+		fmt.Printf("Approved access request %d for %s\n", requestID, args[0])
+	}
+}
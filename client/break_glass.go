@@ -0,0 +1,44 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+func init() {
+	cmdBreakGlass.Run = runBreakGlass // break init cycle
+}
+
+var cmdBreakGlass = &Command{
+	UsageLine: "break-glass -j <justification> <key_identifier>",
+	Short:     "takes emergency read access to a key",
+	Long: `
+Break-glass gives you read access to a key you have no acl entry for, during an incident. The access lasts a short window set by the server, after which it expires on its own; the key's acl is never changed. Read the key with 'knox get' while the window is open.
+
+-j gives the justification, such as the incident you are handling. It is required.
+
+Every break-glass grant and every read made with it is recorded in a high severity audit log and notifies the security team. Grants are listed by 'knox break-glass-report'.
+
+This command requires membership in one of the server's break-glass groups.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox get, knox break-glass-report, knox request-access
+	`,
+}
+var breakGlassJustification = cmdBreakGlass.Flag.String("j", "", "")
+
+func runBreakGlass(cmd *Command, args []string) {
+	if len(args) != 1 {
+		fatalf("break-glass takes only one argument. See 'knox help break-glass'")
+	}
+	if *breakGlassJustification == "" {
+		fatalf("break-glass requires a justification. See 'knox help break-glass'")
+	}
+
+	g, err := cli.BreakGlass(args[0], *breakGlassJustification)
+	if err != nil {
+		fatalf("Error breaking glass: %s", err.Error())
+	}
+	fmt.Printf("You can read %s until %s\n", g.KeyID, time.Unix(0, g.ExpiresAt).Format(time.RFC3339))
+}
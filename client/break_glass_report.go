@@ -0,0 +1,62 @@
+package client
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+func init() {
+	cmdBreakGlassReport.Run = runBreakGlassReport // break init cycle
+}
+
+var cmdBreakGlassReport = &Command{
+	UsageLine: "break-glass-report [-format csv|json]",
+	Short:     "lists break-glass grants",
+	Long: `
+Break-glass-report lists every break-glass grant taken with 'knox break-glass' in the order they were taken, for incident reviews. Expired grants are listed if the server keeps them in its break-glass archive.
+
+-format chooses csv (default) or json output. CSV output has a header row followed by one row per grant with its key ID, the user, the justification and the times it was granted and expires.
+
+This command requires global admin permissions.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox break-glass, knox access-report
+	`,
+}
+var breakGlassReportFormat = cmdBreakGlassReport.Flag.String("format", "csv", "")
+
+func runBreakGlassReport(cmd *Command, args []string) {
+	if len(args) != 0 {
+		fatalf("break-glass-report takes no arguments. See 'knox help break-glass-report'")
+	}
+	if *breakGlassReportFormat != "csv" && *breakGlassReportFormat != "json" {
+		fatalf("Unknown format %s. See 'knox help break-glass-report'", *breakGlassReportFormat)
+	}
+
+	report, err := cli.BreakGlassReport()
+	if err != nil {
+		fatalf("Error getting break-glass report: %s", err.Error())
+	}
+
+	if *breakGlassReportFormat == "json" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fatalf("Could not marshal report: %s", err.Error())
+		}
+		fmt.Println(string(b))
+		return
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"key_id", "principal", "justification", "granted_at", "expires_at"})
+	for _, g := range report {
+		w.Write([]string{g.KeyID, g.Principal, g.Justification, time.Unix(0, g.GrantedAt).Format(time.RFC3339), time.Unix(0, g.ExpiresAt).Format(time.RFC3339)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fatalf("Could not write report: %s", err.Error())
+	}
+}
@@ -133,8 +133,30 @@ var commands = []*Command{
 	cmdAdd,
 	cmdDeactivate,
 	cmdReactivate,
+	cmdPurgeVersion,
+	cmdRetention,
 	cmdUpdateAccess,
 	cmdDelete,
+	cmdUndelete,
+	cmdRename,
+	cmdAlias,
+	cmdNamespace,
+	cmdMove,
+	cmdExplain,
+	cmdWhoami,
+	cmdAccessReport,
+	cmdACLMigrate,
+	cmdStripAccess,
+	cmdACLLint,
+	cmdRequestAccess,
+	cmdRequests,
+	cmdApprove,
+	cmdQuorum,
+	cmdOperations,
+	cmdConfirm,
+	cmdBreakGlass,
+	cmdBreakGlassReport,
+	cmdOPADecisions,
 
 	// These are additional help topics
 	cmdListKeyTemplates,
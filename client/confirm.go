@@ -0,0 +1,52 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+)
+
+func init() {
+	cmdConfirm.Run = runConfirm // break init cycle
+}
+
+var cmdConfirm = &Command{
+	UsageLine: "confirm [-cancel] <key_identifier> <operation_id>",
+	Short:     "confirms or cancels a pending operation",
+	Long: `
+Confirm runs a pending operation on a key that requires quorum. The operation has to be confirmed by a different principal than the one that asked for it.
+
+-cancel drops the operation instead, without running it.
+
+Operation IDs are listed by 'knox operations'.
+
+This command requires admin access to the key. Removing an alias and changing the acl of a namespace also require admin access to the alias or namespace, and migrating principals requires global admin permissions.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox operations, knox quorum
+	`,
+}
+var confirmCancel = cmdConfirm.Flag.Bool("cancel", false, "")
+
+func runConfirm(cmd *Command, args []string) {
+	if len(args) != 2 {
+		fatalf("confirm takes exactly two arguments. See 'knox help confirm'")
+	}
+	operationID, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		fatalf("Invalid operation ID %s. See 'knox help confirm'", args[1])
+	}
+
+	if *confirmCancel {
+		if err := cli.CancelOperation(args[0], operationID); err != nil {
+			fatalf("Error cancelling operation: %s", err.Error())
+		}
+		fmt.Printf("Cancelled operation %d on %s\n", operationID, args[0])
+		return
+	}
+	op, err := cli.ConfirmOperation(args[0], operationID)
+	if err != nil {
+		fatalf("Error confirming operation: %s", err.Error())
+	}
+	fmt.Printf("Confirmed %s on %s\n", op.Operation, op.KeyID)
+}
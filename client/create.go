@@ -13,7 +13,7 @@ func init() {
 }
 
 var cmdCreate = &Command{
-	UsageLine: "create [--key-template template_name] <key_identifier>",
+	UsageLine: "create [--key-template template_name] [-path <path>] <key_identifier>",
 	Short:     "creates a new key",
 	Long: `
 Create will create a new key in knox with input as the primary key version. Key data should be sent to stdin unless a key-template is specified.
@@ -24,16 +24,19 @@ Please run "knox create <key_identifier>".
 Second way: the key-template option can be used to specify a template to generate the initial primary key version, instead of stdin. For available key templates, run "knox key-templates".
 Please run "knox create --key-template <template_name> <key_identifier>".
 
+-path creates the key in the namespace at path, such as /teams/payments/prod. This requires write permissions on the namespace, whose ACL then applies to the key as well.
+
 The original key version id will be print to stdout.
 
 To create a new key, user credentials are required. The default access list will include the creator of this key and a limited set of site reliablity and security engineers.
 
 For more about knox, see https://github.com/pavelzhurov/knox.
 
-See also: knox add, knox get
+See also: knox add, knox get, knox namespace
 	`,
 }
 var createTinkKeyset = cmdCreate.Flag.String("key-template", "", "name of a knox-supported Tink key template")
+var createPath = cmdCreate.Flag.String("path", "", "")
 
 func runCreate(cmd *Command, args []string) {
 	if len(args) != 1 {
@@ -57,7 +60,12 @@ func runCreate(cmd *Command, args []string) {
 	}
 	// TODO(devinlundberg): allow ACL to be entered as input
 	acl := knox.ACL{}
-	versionID, err := cli.CreateKey(keyID, data, acl)
+	var versionID uint64
+	if *createPath != "" {
+		versionID, err = cli.CreateKeyInNamespace(keyID, data, acl, *createPath)
+	} else {
+		versionID, err = cli.CreateKey(keyID, data, acl)
+	}
 	if err != nil {
 		fatalf("Error adding version: %s", err.Error())
 	}
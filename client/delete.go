@@ -4,24 +4,38 @@ import (
 	"fmt"
 )
 
+func init() {
+	cmdDelete.Run = runDelete // break init cycle
+}
+
 var cmdDelete = &Command{
-	Run:       runDelete,
-	UsageLine: "delete <key_identifier>",
+	UsageLine: "delete [-purge] <key_identifier>",
 	Short:     "deletes an existing key",
 	Long: `
-This will delete your key and all data from the knox server. This operation is dangerous and requires admin permissions
+This will move your key to the trash on the knox server. Deleted keys can no longer be read or listed, and are permanently removed once the server's trash retention period has passed. Until then they can be brought back with knox undelete. This operation is dangerous and requires admin permissions
+
+-purge permanently removes the key and all of its data right away, including keys that are already in the trash. This cannot be undone.
 
 For more about knox, see https://github.com/pavelzhurov/knox.
 
-See also: knox create
+See also: knox create, knox undelete
     `,
 }
+var deletePurge = cmdDelete.Flag.Bool("purge", false, "")
 
 func runDelete(cmd *Command, args []string) {
 	if len(args) != 1 {
-		fatalf("create takes exactly one argument. See 'knox help delete'")
+		fatalf("delete takes exactly one argument. See 'knox help delete'")
 	}
 
+	if *deletePurge {
+		err := cli.PurgeKey(args[0])
+		if err != nil {
+			fatalf("Error purging key: %s", err.Error())
+		}
+		fmt.Printf("Successfully purged key\n")
+		return
+	}
 	err := cli.DeleteKey(args[0])
 	if err != nil {
 		fatalf("Error deleting key: %s", err.Error())
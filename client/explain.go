@@ -0,0 +1,94 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func init() {
+	cmdExplain.Run = runExplain // break init cycle
+}
+
+var cmdExplain = &Command{
+	UsageLine: "explain [-as <principal> [-groups <groups>]] [-access <access>] [-action <action>] <key_identifier>",
+	Short:     "explains whether a principal can access a key",
+	Long: `
+Explain shows how the server decides whether a principal has access to a key, to debug "not authorized" errors.
+
+It prints the principal as the server authenticated it, with its type and groups, every ACL entry that applies to the key with whether it matched the principal and how (exact, prefix or group), and the final decision. Entries come from the server's global policy, the namespaces containing the key and the key itself. When the server authorizes with OPA, the input document sent to the policy and its result are printed instead of ACL entries.
+
+-as explains the access of another principal, written as Type:ID, where Type is User, Machine or Service, for example Machine:host1.example.com or Service:spiffe://example.com/serviceA. This requires admin access to the key.
+-groups gives the comma separated groups of the user named by -as.
+-access chooses the access type checked: Read (default), Write or Admin.
+-action chooses the action given to the OPA policy (default GetKey).
+
+This command requires read access to the key, and admin access with -as. When the OPA policy allows reading the key but not its ACL, only the ACL entries that match the principal are shown, and the ACL is left out of the input document.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox acl, knox access
+	`,
+}
+var explainAs = cmdExplain.Flag.String("as", "", "")
+var explainGroups = cmdExplain.Flag.String("groups", "", "")
+var explainAccess = cmdExplain.Flag.String("access", "", "")
+var explainAction = cmdExplain.Flag.String("action", "", "")
+
+func runExplain(cmd *Command, args []string) {
+	if len(args) != 1 {
+		fatalf("explain takes only one argument. See 'knox help explain'")
+	}
+	var groups []string
+	if *explainGroups != "" {
+		groups = strings.Split(*explainGroups, ",")
+	}
+	access := knox.None
+	if *explainAccess != "" {
+		if err := json.Unmarshal([]byte(strconv.Quote(*explainAccess)), &access); err != nil {
+			fatalf("Invalid access type %s. See 'knox help explain'", *explainAccess)
+		}
+	}
+
+	e, err := cli.ExplainAccess(args[0], *explainAs, groups, access, *explainAction)
+	if err != nil {
+		fatalf("Error explaining access: %s", err.Error())
+	}
+
+	fmt.Printf("Principal: %s (%s)\n", e.Principal, e.PrincipalType)
+	if len(e.PrincipalGroups) > 0 {
+		fmt.Printf("Groups: %s\n", strings.Join(e.PrincipalGroups, ", "))
+	}
+	accessName, _ := json.Marshal(e.AccessType)
+	fmt.Printf("Checked: %s access to %s for action %s\n", strings.Trim(string(accessName), `"`), e.KeyID, e.Action)
+	for _, entry := range e.Entries {
+		aEnc, err := json.Marshal(entry.Access)
+		if err != nil {
+			fatalf("Could not marshal entry:", entry.Access)
+		}
+		status := "no match"
+		switch {
+		case entry.Matched:
+			status = "matched (" + entry.Match + ")"
+		case entry.Match != "":
+			status = "expired (" + entry.Match + ")"
+		}
+		fmt.Printf("  [%s] %s %s\n", entry.Source, aEnc, status)
+	}
+	if e.OPAInput != nil {
+		input, err := json.MarshalIndent(e.OPAInput, "", "  ")
+		if err != nil {
+			fatalf("Could not marshal OPA input: %s", err.Error())
+		}
+		fmt.Printf("OPA input: %s\n", input)
+		fmt.Printf("OPA result: %t\n", *e.OPAResult)
+	}
+	decision := "denied"
+	if e.Allowed {
+		decision = "allowed"
+	}
+	fmt.Printf("Decision: %s, %s\n", decision, e.Reason)
+}
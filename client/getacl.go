@@ -3,6 +3,10 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pavelzhurov/knox"
 )
 
 func init() {
@@ -10,13 +14,15 @@ func init() {
 }
 
 var cmdGetACL = &Command{
-	UsageLine: "acl <key_identifier>",
+	UsageLine: "acl (<key_identifier> | <path>)",
 	Short:     "gets the ACL for a key",
 	Long: `
-Acl get the ACL for a key.
+Acl get the ACL for a key, or for the namespace at path if the argument starts with a slash. A key's ACL does not include the entries it inherits from its namespaces.
 
 This doesn't require any access to the key and allows, e.g., to see who has admin access to ask for grants.
 
+Temporary entries are followed by the time remaining until they expire.
+
 For more about knox, see https://github.com/pavelzhurov/knox.
 
 See also: knox keys, knox get
@@ -28,17 +34,39 @@ func runGetACL(cmd *Command, args []string) {
 		fatalf("acl takes only one argument. See 'knox help acl'")
 	}
 
+	if strings.HasPrefix(args[0], "/") {
+		n, err := cli.GetNamespace(args[0])
+		if err != nil {
+			fatalf("Error getting namespace ACL: %s", err.Error())
+		}
+		printACL(n.ACL)
+		return
+	}
+
 	keyID := args[0]
 	acl, err := cli.GetACL(keyID)
 	if err != nil {
 		fatalf("Error getting key ACL: %s", err.Error())
 	}
+	printACL(*acl)
+}
 
-	for _, a := range *acl {
+// printACL prints one JSON encoded entry per line.
+func printACL(acl knox.ACL) {
+	for _, a := range acl {
 		aEnc, err := json.Marshal(a)
 		if err != nil {
 			fatalf("Could not marshal entry:", a)
 		}
-		fmt.Println(string(aEnc))
+		if a.ExpiresAt == 0 {
+			fmt.Println(string(aEnc))
+			continue
+		}
+		remaining := time.Until(time.Unix(0, a.ExpiresAt)).Round(time.Second)
+		if remaining <= 0 {
+			fmt.Printf("%s (expired)\n", aEnc)
+		} else {
+			fmt.Printf("%s (expires in %s)\n", aEnc, remaining)
+		}
 	}
 }
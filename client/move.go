@@ -0,0 +1,40 @@
+package client
+
+import (
+	"fmt"
+)
+
+func init() {
+	cmdMove.Run = runMove // break init cycle
+}
+
+var cmdMove = &Command{
+	UsageLine: "move <key_identifier> <path>",
+	Short:     "moves a key to another namespace",
+	Long: `
+Move moves a key into the namespace at path, such as /teams/payments/prod. Use / as the path to move the key out of any namespace.
+
+The key keeps its own ACL but inherits the ACLs of its new namespace instead of those of its old one, so moving a key can give access to it to different principals.
+
+This command requires admin permissions on the key and write permissions on the namespace it is moved into.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox namespace, knox acl
+	`,
+}
+
+func runMove(cmd *Command, args []string) {
+	if len(args) != 2 {
+		fatalf("move takes exactly two arguments. See 'knox help move'")
+	}
+	path := args[1]
+	if path == "/" {
+		path = ""
+	}
+	err := cli.MoveKey(args[0], path)
+	if err != nil {
+		fatalf("Error moving key: %s", err.Error())
+	}
+	fmt.Printf("Successfully moved %s to %s\n", args[0], args[1])
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func init() {
+	cmdNamespace.Run = runNamespace // break init cycle
+}
+
+var cmdNamespace = &Command{
+	UsageLine: "namespace [-c [-acl <file>] | -d] [<path>]",
+	Short:     "manages key namespaces",
+	Long: `
+Namespace manages the folder-like namespaces keys can be grouped in, such as /teams/payments/prod.
+
+Without arguments, namespace lists the namespaces you have read access to. With a path, it prints the namespace's ACL followed by the namespaces and keys directly inside it.
+
+-c creates the namespace. The namespace containing it must already exist.
+-acl: Takes in a filename with a JSON formatted list of access rules for the new namespace.
+-d removes the namespace. Only namespaces without keys, including deleted keys that can still be restored, and without nested namespaces can be removed.
+
+A namespace's ACL applies to every key and namespace inside it in addition to their own ACLs, and a deny rule anywhere takes precedence over every rule that gives access. Use knox access and knox acl with a path to change or view a namespace's ACL, and knox move to move keys between namespaces.
+
+Creating a top level namespace requires user credentials. Creating a nested namespace or removing one requires admin permissions on the namespace containing it or on the namespace itself respectively, and reading a namespace requires read permissions.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox move, knox access, knox acl
+	`,
+}
+var namespaceCreate = cmdNamespace.Flag.Bool("c", false, "")
+var namespaceACL = cmdNamespace.Flag.String("acl", "", "")
+var namespaceDelete = cmdNamespace.Flag.Bool("d", false, "")
+
+func runNamespace(cmd *Command, args []string) {
+	switch {
+	case len(args) == 0 && !*namespaceCreate && !*namespaceDelete:
+		paths, err := cli.GetNamespaces()
+		if err != nil {
+			fatalf("Error getting namespaces: %s", err.Error())
+		}
+		for _, p := range paths {
+			fmt.Println(p)
+		}
+	case len(args) != 1 || (*namespaceCreate && *namespaceDelete):
+		fatalf("namespace takes at most one argument and only one of -c and -d. See 'knox help namespace'")
+	case *namespaceCreate:
+		acl := knox.ACL{}
+		if *namespaceACL != "" {
+			b, err := ioutil.ReadFile(*namespaceACL)
+			if err != nil {
+				fatalf("Could not read acl file %s", err.Error())
+			}
+			if err := json.Unmarshal(b, &acl); err != nil {
+				fatalf("Could not decode access list properly %s", err.Error())
+			}
+		}
+		if err := cli.CreateNamespace(args[0], acl); err != nil {
+			fatalf("Error creating namespace: %s", err.Error())
+		}
+		fmt.Printf("Successfully created namespace %s\n", args[0])
+	case *namespaceDelete:
+		if err := cli.DeleteNamespace(args[0]); err != nil {
+			fatalf("Error removing namespace: %s", err.Error())
+		}
+		fmt.Printf("Successfully removed namespace %s\n", args[0])
+	default:
+		n, err := cli.GetNamespace(args[0])
+		if err != nil {
+			fatalf("Error getting namespace: %s", err.Error())
+		}
+		printACL(n.ACL)
+		for _, p := range n.Namespaces {
+			fmt.Println(p + "/")
+		}
+		for _, k := range n.Keys {
+			fmt.Println(k)
+		}
+	}
+}
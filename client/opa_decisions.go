@@ -0,0 +1,66 @@
+package client
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+func init() {
+	cmdOPADecisions.Run = runOPADecisions // break init cycle
+}
+
+var cmdOPADecisions = &Command{
+	UsageLine: "opa-decisions [-format csv|json]",
+	Short:     "lists recent OPA authorization decisions",
+	Long: `
+Opa-decisions lists the most recent authorization decisions the server made with its embedded OPA policies, oldest first, to debug policies and review denials. The server keeps the last 1000 decisions; every decision is also written to its decision log.
+
+-format chooses csv (default) or json output. CSV output has a header row followed by one row per decision with its time, ID, principal, action, path, whether it was allowed and the evaluation error, if any. JSON output includes the whole input document of each decision.
+
+This command requires global admin permissions, and is only available on servers that evaluate OPA policies themselves rather than asking an OPA server.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox explain, knox break-glass-report
+	`,
+}
+var opaDecisionsFormat = cmdOPADecisions.Flag.String("format", "csv", "")
+
+func runOPADecisions(cmd *Command, args []string) {
+	if len(args) != 0 {
+		fatalf("opa-decisions takes no arguments. See 'knox help opa-decisions'")
+	}
+	if *opaDecisionsFormat != "csv" && *opaDecisionsFormat != "json" {
+		fatalf("Unknown format %s. See 'knox help opa-decisions'", *opaDecisionsFormat)
+	}
+
+	report, err := cli.OPADecisionReport()
+	if err != nil {
+		fatalf("Error getting OPA decisions: %s", err.Error())
+	}
+
+	if *opaDecisionsFormat == "json" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fatalf("Could not marshal report: %s", err.Error())
+		}
+		fmt.Println(string(b))
+		return
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"time", "decision_id", "principal", "action", "path", "allowed", "error"})
+	for _, d := range report {
+		principal, _ := d.Input["user"].(string)
+		action, _ := d.Input["action"].(string)
+		path, _ := d.Input["path"].(string)
+		w.Write([]string{time.Unix(0, d.Time).Format(time.RFC3339), d.ID, principal, action, path, strconv.FormatBool(d.Allowed), d.Error})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fatalf("Could not write report: %s", err.Error())
+	}
+}
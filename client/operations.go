@@ -0,0 +1,86 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func init() {
+	cmdOperations.Run = runOperations // break init cycle
+}
+
+var cmdOperations = &Command{
+	UsageLine: "operations [<key_identifier>]",
+	Short:     "lists operations waiting for confirmation",
+	Long: `
+Operations lists the pending operations on keys that require quorum, for the keys you have admin access to and the operations you asked for yourself. Given a key identifier, only the operations on that key are listed.
+
+Each operation is printed with its key, its ID, what it does, who asked for it and when it expires. Another admin of the key confirms or cancels it with 'knox confirm'.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox quorum, knox confirm
+	`,
+}
+
+func runOperations(cmd *Command, args []string) {
+	if len(args) > 1 {
+		fatalf("operations takes at most one argument. See 'knox help operations'")
+	}
+	keyID := ""
+	if len(args) == 1 {
+		keyID = args[0]
+	}
+
+	ops, err := cli.GetPendingOperations(keyID)
+	if err != nil {
+		fatalf("Error getting pending operations: %s", err.Error())
+	}
+	for _, op := range ops {
+		what := op.Operation
+		switch op.Operation {
+		case knox.OperationUpdateAccess:
+			aclEnc, err := json.Marshal(op.ACL)
+			if err != nil {
+				fatalf("Could not marshal acl: %v", op.ACL)
+			}
+			what += " " + string(aclEnc)
+		case knox.OperationPromote, knox.OperationPurgeVersion:
+			what += fmt.Sprintf(" version %d", op.VersionID)
+		case knox.OperationRename:
+			what += " to " + op.NewID
+		case knox.OperationMove:
+			what += fmt.Sprintf(" into %q", op.Path)
+		case knox.OperationDeleteAlias:
+			what += " " + op.AliasID
+		case knox.OperationUpdateNamespaceAccess:
+			aclEnc, err := json.Marshal(op.ACL)
+			if err != nil {
+				fatalf("Could not marshal acl: %v", op.ACL)
+			}
+			what += " " + op.Path + " " + string(aclEnc)
+		case knox.OperationMigrateACL:
+			migrationEnc, err := json.Marshal(op.Migration)
+			if err != nil {
+				fatalf("Could not marshal migration: %v", op.Migration)
+			}
+			what += " " + string(migrationEnc)
+		case knox.OperationSetRetention:
+			retentionEnc, err := json.Marshal(op.Retention)
+			if err != nil {
+				fatalf("Could not marshal retention: %v", op.Retention)
+			}
+			what += " " + string(retentionEnc)
+		case knox.OperationStripAccess:
+			aclEnc, err := json.Marshal(op.ACL)
+			if err != nil {
+				fatalf("Could not marshal acl: %v", op.ACL)
+			}
+			what += " " + string(aclEnc)
+		}
+		fmt.Printf("%s %d %s by %s, expires %s\n", op.KeyID, op.ID, what, op.RequestedBy, time.Unix(0, op.ExpiresAt).Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"fmt"
+)
+
+var cmdPurgeVersion = &Command{
+	Run:       runPurgeVersion,
+	UsageLine: "purge-version <key_identifier> <key_version>",
+	Short:     "permanently removes an inactive key version",
+	Long: `
+Purge-version permanently removes an inactive key version and its data from the knox server. This cannot be undone.
+
+Only inactive versions can be purged. Use knox deactivate first.
+
+This command requires admin access to the key.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox deactivate, knox retention
+	`,
+}
+
+func runPurgeVersion(cmd *Command, args []string) {
+	if len(args) != 2 {
+		fatalf("purge-version takes exactly two arguments. See 'knox help purge-version'")
+	}
+	keyID := args[0]
+	versionID := args[1]
+
+	err := cli.PurgeVersion(keyID, versionID)
+	if err != nil {
+		fatalf("Error purging version: %s", err.Error())
+	}
+	fmt.Printf("Purged %s successfully.\n", versionID)
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"fmt"
+)
+
+func init() {
+	cmdQuorum.Run = runQuorum // break init cycle
+}
+
+var cmdQuorum = &Command{
+	UsageLine: "quorum [-on | -off] <key_identifier>",
+	Short:     "manages the two-person rule for a key",
+	Long: `
+Quorum shows or sets whether a key requires quorum. Without flags, it prints the current setting.
+
+When a key requires quorum, deleting it, changing its acl, approving access requests for it, promoting or purging its versions, renaming or moving it, removing its aliases, changing the acl of a namespace it is in and migrating principals in its acl do not happen right away. Each of them becomes a pending operation that a second principal with admin access to the key has to confirm with 'knox confirm' before it expires. Pending operations are listed by 'knox operations'.
+
+-on makes the key require quorum.
+-off stops requiring quorum. For a key that requires quorum this is itself a pending operation.
+
+Showing the setting requires read access to the key, and changing it requires admin access.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox operations, knox confirm
+	`,
+}
+var quorumOn = cmdQuorum.Flag.Bool("on", false, "")
+var quorumOff = cmdQuorum.Flag.Bool("off", false, "")
+
+func runQuorum(cmd *Command, args []string) {
+	if len(args) != 1 {
+		fatalf("quorum takes exactly one argument. See 'knox help quorum'")
+	}
+	keyID := args[0]
+
+	switch {
+	case *quorumOn && *quorumOff:
+		fatalf("quorum takes only one of -on and -off. See 'knox help quorum'")
+	case *quorumOn, *quorumOff:
+		if err := cli.PutQuorum(keyID, *quorumOn); err != nil {
+			fatalf("Error setting quorum: %s", err.Error())
+		}
+		fmt.Println("Successfully updated quorum")
+	default:
+		required, err := cli.GetQuorum(keyID)
+		if err != nil {
+			fatalf("Error getting quorum: %s", err.Error())
+		}
+		if required {
+			fmt.Printf("%s requires quorum.\n", keyID)
+		} else {
+			fmt.Printf("%s does not require quorum.\n", keyID)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"fmt"
+)
+
+var cmdRename = &Command{
+	Run:       runRename,
+	UsageLine: "rename <key_identifier> <new_key_identifier>",
+	Short:     "renames a key",
+	Long: `
+Rename moves a key with all of its versions and its ACL to a new identifier. An alias with the key's ACL is left at the old identifier, so hosts that registered the old identifier keep receiving the key without any change.
+
+To use this command, you must have admin permissions on the key.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox alias
+	`,
+}
+
+func runRename(cmd *Command, args []string) {
+	if len(args) != 2 {
+		fatalf("rename takes exactly two arguments. See 'knox help rename'")
+	}
+
+	err := cli.RenameKey(args[0], args[1])
+	if err != nil {
+		fatalf("Error renaming key: %s", err.Error())
+	}
+	fmt.Printf("Successfully renamed %s to %s\n", args[0], args[1])
+}
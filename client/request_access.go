@@ -0,0 +1,53 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func init() {
+	cmdRequestAccess.Run = runRequestAccess // break init cycle
+}
+
+var cmdRequestAccess = &Command{
+	UsageLine: "request-access [-access <access>] [-for <duration>] -j <justification> <key_identifier>",
+	Short:     "asks the admins of a key for access to it",
+	Long: `
+Request-access files a request for your access to a key. The admins of the key see it with 'knox requests' and approve or deny it with 'knox approve'; approving it adds the access to the key's acl. Requests expire if they are not decided in time.
+
+-j gives the justification for the request, which the admins see. It is required.
+-access chooses the access type requested: Read (default), Write or Admin.
+-for makes the access temporary. It stops applying the given duration, such as 8h or 30m, after the request is approved.
+
+A new request for the same key replaces your pending one. The request ID printed is needed to approve or deny the request.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox requests, knox approve, knox access
+	`,
+}
+var requestAccessJustification = cmdRequestAccess.Flag.String("j", "", "")
+var requestAccessType = cmdRequestAccess.Flag.String("access", "Read", "")
+var requestAccessFor = cmdRequestAccess.Flag.Duration("for", 0, "")
+
+func runRequestAccess(cmd *Command, args []string) {
+	if len(args) != 1 {
+		fatalf("request-access takes only one argument. See 'knox help request-access'")
+	}
+	if *requestAccessJustification == "" {
+		fatalf("request-access requires a justification. See 'knox help request-access'")
+	}
+	var access knox.AccessType
+	if err := json.Unmarshal([]byte(strconv.Quote(*requestAccessType)), &access); err != nil || access <= knox.None {
+		fatalf("Invalid access type %s. See 'knox help request-access'", *requestAccessType)
+	}
+
+	r, err := cli.RequestAccess(args[0], access, *requestAccessJustification, *requestAccessFor)
+	if err != nil {
+		fatalf("Error requesting access: %s", err.Error())
+	}
+	fmt.Printf("Filed access request %d for %s\n", r.ID, r.KeyID)
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+func init() {
+	cmdRequests.Run = runRequests // break init cycle
+}
+
+var cmdRequests = &Command{
+	UsageLine: "requests [<key_identifier>]",
+	Short:     "lists pending access requests",
+	Long: `
+Requests lists the pending access requests for the keys you have admin access to, and the requests you filed yourself. Given a key identifier, only the requests for that key are listed.
+
+Each request is printed with its key, its ID, the requested acl entry, who filed it and when it expires, followed by its justification.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox request-access, knox approve
+	`,
+}
+
+func runRequests(cmd *Command, args []string) {
+	if len(args) > 1 {
+		fatalf("requests takes at most one argument. See 'knox help requests'")
+	}
+	keyID := ""
+	if len(args) == 1 {
+		keyID = args[0]
+	}
+
+	requests, err := cli.GetAccessRequests(keyID)
+	if err != nil {
+		fatalf("Error getting access requests: %s", err.Error())
+	}
+	for _, r := range requests {
+		aEnc, err := json.Marshal(r.Access)
+		if err != nil {
+			fatalf("Could not marshal entry: %v", r.Access)
+		}
+		fmt.Printf("%s %d %s by %s, expires %s\n", r.KeyID, r.ID, aEnc, r.RequestedBy, time.Unix(0, r.ExpiresAt).Format(time.RFC3339))
+		if r.Duration > 0 {
+			fmt.Printf("  for %s\n", time.Duration(r.Duration))
+		}
+		fmt.Printf("  %s\n", r.Justification)
+	}
+}
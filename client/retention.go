@@ -0,0 +1,74 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func init() {
+	cmdRetention.Run = runRetention // break init cycle
+}
+
+var cmdRetention = &Command{
+	UsageLine: "retention [-max-inactive N] [-inactive-days D] <key_identifier>",
+	Short:     "manages the retention policy for inactive key versions",
+	Long: `
+Retention shows or sets the policy the knox server uses to permanently remove inactive versions of a key. Without flags, it prints the current policy.
+
+-max-inactive keeps only the N most recently deactivated versions.
+-inactive-days removes versions that have been inactive for more than D days.
+
+A flag that is not given keeps its current value. Setting both limits to 0 removes the policy, so inactive versions are kept until they are purged with knox purge-version.
+
+Showing the policy requires read access to the key, and setting it requires admin access.
+
+If the key requires quorum, setting the policy files a pending operation that another admin confirms with knox confirm.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox purge-version, knox deactivate
+	`,
+}
+var retentionMaxInactive = cmdRetention.Flag.Int("max-inactive", -1, "")
+var retentionInactiveDays = cmdRetention.Flag.Int("inactive-days", -1, "")
+
+func runRetention(cmd *Command, args []string) {
+	if len(args) != 1 {
+		fatalf("retention takes exactly one argument. See 'knox help retention'")
+	}
+	keyID := args[0]
+
+	if *retentionMaxInactive < 0 && *retentionInactiveDays < 0 {
+		r, err := cli.GetVersionRetention(keyID)
+		if err != nil {
+			fatalf("Error getting retention: %s", err.Error())
+		}
+		if r == nil {
+			fmt.Printf("%s keeps inactive versions until they are purged.\n", keyID)
+			return
+		}
+		fmt.Printf("max inactive versions: %d\ninactive days: %d\n", r.MaxInactive, r.InactiveDays)
+		return
+	}
+
+	current, err := cli.GetVersionRetention(keyID)
+	if err != nil {
+		fatalf("Error getting retention: %s", err.Error())
+	}
+	r := knox.VersionRetention{}
+	if current != nil {
+		r = *current
+	}
+	if *retentionMaxInactive >= 0 {
+		r.MaxInactive = *retentionMaxInactive
+	}
+	if *retentionInactiveDays >= 0 {
+		r.InactiveDays = *retentionInactiveDays
+	}
+	err = cli.PutVersionRetention(keyID, r)
+	if err != nil {
+		fatalf("Error setting retention: %s", err.Error())
+	}
+	fmt.Printf("Updated retention for %s successfully.\n", keyID)
+}
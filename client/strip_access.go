@@ -0,0 +1,49 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+func init() {
+	cmdStripAccess.Run = runStripAccess // break init cycle
+}
+
+var cmdStripAccess = &Command{
+	UsageLine: "strip-access <principal> ...",
+	Short:     "removes principals from every ACL",
+	Long: `
+Strip-access removes every ACL entry for the given principals, whatever their access type, from all keys, aliases and namespaces, including keys in the trash. Run it once after moving default access that older servers copied into the ACL of each new key to the server's global policy (GLOBAL_ADMINS and GLOBAL_READERS).
+
+Principals are written as Type:ID, where Type is User, UserGroup, Machine, MachinePrefix, Service or ServicePrefix, for example UserGroup:security-team.
+
+Each changed key is printed with the removed entries prefixed by -, and the server writes an audit record for every changed key.
+
+If a key that would change requires quorum, or is in a namespace that would change, nothing is changed: the strip is filed as a pending operation on that key for another global admin to confirm with knox confirm.
+
+This command requires global admin permissions.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox acl-migrate
+	`,
+}
+
+func runStripAccess(cmd *Command, args []string) {
+	if len(args) == 0 {
+		fatalf("strip-access takes at least one principal. See 'knox help strip-access'")
+	}
+
+	changes, err := cli.StripAccess(args)
+	if err != nil {
+		fatalf("Error stripping access: %s", err.Error())
+	}
+	for _, c := range changes {
+		fmt.Println(c.KeyID)
+		for _, a := range c.Removed {
+			aEnc, _ := json.Marshal(a)
+			fmt.Printf("- %s\n", aEnc)
+		}
+	}
+	fmt.Printf("Changed %d keys\n", len(changes))
+}
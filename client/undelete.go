@@ -0,0 +1,32 @@
+package client
+
+import (
+	"fmt"
+)
+
+var cmdUndelete = &Command{
+	Run:       runUndelete,
+	UsageLine: "undelete <key_identifier>",
+	Short:     "restores a deleted key",
+	Long: `
+Undelete moves a key out of the trash, restoring it with its versions and ACL as they were when it was deleted. Keys can only be restored until the server's trash retention period has passed or they are purged.
+
+To use this command, you must have admin permissions on the key.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox delete
+	`,
+}
+
+func runUndelete(cmd *Command, args []string) {
+	if len(args) != 1 {
+		fatalf("undelete takes exactly one argument. See 'knox help undelete'")
+	}
+
+	err := cli.RestoreKey(args[0])
+	if err != nil {
+		fatalf("Error restoring key: %s", err.Error())
+	}
+	fmt.Printf("Successfully restored key\n")
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"strings"
+	"time"
 
 	"github.com/pavelzhurov/knox"
 )
@@ -13,10 +15,10 @@ func init() {
 }
 
 var cmdUpdateAccess = &Command{
-	UsageLine: "access (-acl <file> <key_identifier> | {-n|-r|-w|-a} {-M|-U|-G|-P} <key_identifier> <principal>)",
+	UsageLine: "access (-acl <file> (<key_identifier> | <path>) | {-n|-r|-w|-a|-d} {-M|-U|-G|-P} [-for <duration>] (<key_identifier> | <path>) <principal>)",
 	Short:     "access modifies the acl of a key",
 	Long: `
-Access will add or change the acl on a key by adding a specific access control rule.
+Access will add or change the acl on a key by adding a specific access control rule. If the key identifier is replaced with a path starting with a slash, such as /teams/payments, the acl of that namespace is changed instead, which applies to every key and namespace inside it.
 
 -acl: Takes in a filename with a JSON formatted list of access rules
 
@@ -24,6 +26,9 @@ Access will add or change the acl on a key by adding a specific access control r
 -r: This will grant the principal read access to the key. They will be able to read the keys data.
 -w: This will grant the principal write access to the key. They will be able to rotate keys in addition to all read permissions.
 -a: This will grant the principal admin access to the key. They will be able to update ACLs and delete keys in addition to all read and write permissions.
+-d: This will deny the principal all access to the key. A deny rule takes precedence over every rule that gives access, so for example denying the machine prefix 'web-untrusted' takes access away from those hosts even if the prefix 'web' is granted read access.
+
+-for: Makes the rule temporary. It stops applying after the given duration, such as 8h or 30m, and is then removed from the acl. Entries in an -acl file can set "expires_at" in unix nanoseconds instead.
 
 -M: A specific machine. The principal should be set to the exact hostname.
 -U: A specific user. The principal should be set to the ldap username of the user.
@@ -32,7 +37,7 @@ Access will add or change the acl on a key by adding a specific access control r
 -S: A specific service. The principal should be set to the exact SPIFFE ID. For example, 'spiffe://example.com/service'.
 -N: A service prefix (namespace). The principal should be set to a SPIFFE ID ending with a slash, such as 'spiffe://example.com/namespace/'. This will match all services under that prefix, so for example 'spiffe://example.com/namespace/service' would be allowed.
 
-This command requires admin access to the key.
+This command requires admin access to the key or namespace.
 
 For more about knox, see https://github.com/pavelzhurov/knox.
 
@@ -46,6 +51,9 @@ var updateAccessNone = cmdUpdateAccess.Flag.Bool("n", false, "")
 var updateAccessRead = cmdUpdateAccess.Flag.Bool("r", false, "")
 var updateAccessWrite = cmdUpdateAccess.Flag.Bool("w", false, "")
 var updateAccessAdmin = cmdUpdateAccess.Flag.Bool("a", false, "")
+var updateAccessDeny = cmdUpdateAccess.Flag.Bool("d", false, "")
+
+var updateAccessFor = cmdUpdateAccess.Flag.Duration("for", 0, "")
 
 var updateAccessMachine = cmdUpdateAccess.Flag.Bool("M", false, "")
 var updateAccessUser = cmdUpdateAccess.Flag.Bool("U", false, "")
@@ -69,7 +77,7 @@ func runUpdateAccess(cmd *Command, args []string) {
 		if err != nil {
 			fatalf("Could not decode access list properly %s", err.Error())
 		}
-		err = cli.PutAccess(keyID, acl...)
+		err = putAccess(keyID, acl...)
 		if err != nil {
 			fatalf("Failed to update access: %s", err.Error())
 		}
@@ -92,8 +100,10 @@ func runUpdateAccess(cmd *Command, args []string) {
 		access.AccessType = knox.Write
 	case *updateAccessAdmin:
 		access.AccessType = knox.Admin
+	case *updateAccessDeny:
+		access.AccessType = knox.Deny
 	default:
-		fatalf("access requires {-n,-r,-w,-a}. See 'knox help access'")
+		fatalf("access requires {-n,-r,-w,-a,-d}. See 'knox help access'")
 	}
 	switch {
 	case *updateAccessMachine:
@@ -111,9 +121,23 @@ func runUpdateAccess(cmd *Command, args []string) {
 	default:
 		fatalf("access requires {-M|-U|-G|-P|-S|-N}. See 'knox help access'")
 	}
-	err := cli.PutAccess(keyID, access)
+	if *updateAccessFor != 0 {
+		if *updateAccessFor < 0 || access.AccessType == knox.None {
+			fatalf("access requires a positive -for duration and a rule other than -n. See 'knox help access'")
+		}
+		access.ExpiresAt = time.Now().Add(*updateAccessFor).UnixNano()
+	}
+	err := putAccess(keyID, access)
 	if err != nil {
 		fatalf("Failed to update access: %s", err.Error())
 	}
 	fmt.Println("Successfully updated Access")
 }
+
+// putAccess changes the ACL of a key, or of a namespace if id is a path.
+func putAccess(id string, acl ...knox.Access) error {
+	if strings.HasPrefix(id, "/") {
+		return cli.PutNamespaceAccess(id, acl...)
+	}
+	return cli.PutAccess(id, acl...)
+}
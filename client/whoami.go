@@ -0,0 +1,51 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+func init() {
+	cmdWhoami.Run = runWhoami // break init cycle
+}
+
+var cmdWhoami = &Command{
+	UsageLine: "whoami",
+	Short:     "shows who the server authenticates you as",
+	Long: `
+Whoami shows the principal the server authenticates your requests as: its ID, type (user, machine or service) and groups, the auth provider that authenticated it (jwt for the token from knox login, mtls for a machine certificate, spiffe for a SPIFFE certificate), and when the token or certificate expires.
+
+This doesn't require any access to keys.
+
+For more about knox, see https://github.com/pavelzhurov/knox.
+
+See also: knox login, knox explain
+	`,
+}
+
+func runWhoami(cmd *Command, args []string) {
+	if len(args) != 0 {
+		fatalf("whoami takes no arguments. See 'knox help whoami'")
+	}
+	id, err := cli.Whoami()
+	if err != nil {
+		fatalf("Error getting identity: %s", err.Error())
+	}
+	fmt.Printf("ID: %s\n", id.ID)
+	fmt.Printf("Type: %s\n", id.Type)
+	if len(id.Groups) > 0 {
+		fmt.Printf("Groups: %s\n", strings.Join(id.Groups, ", "))
+	}
+	fmt.Printf("Provider: %s\n", id.Provider)
+	if id.ExpiresAt == 0 {
+		return
+	}
+	expiry := time.Unix(0, id.ExpiresAt)
+	remaining := time.Until(expiry).Round(time.Second)
+	if remaining <= 0 {
+		fmt.Printf("Expires: %s (expired)\n", expiry.Format(time.RFC3339))
+	} else {
+		fmt.Printf("Expires: %s (in %s)\n", expiry.Format(time.RFC3339), remaining)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestMockClient(t *testing.T) {
@@ -277,6 +278,623 @@ func TestDeleteKey(t *testing.T) {
 	}
 }
 
+func TestPurgeKey(t *testing.T) {
+	resp, err := buildGoodResponse("")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "DELETE" {
+			t.Fatalf("%s is not DELETE", r.Method)
+		}
+		if r.URL.Path != "/v0/keys/testkey/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/testkey/")
+		}
+		if r.URL.Query().Get("purge") != "true" {
+			t.Fatalf("%s is not true", r.URL.Query().Get("purge"))
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	err = cli.PurgeKey("testkey")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+}
+
+func TestRestoreKey(t *testing.T) {
+	resp, err := buildGoodResponse("")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "POST" {
+			t.Fatalf("%s is not POST", r.Method)
+		}
+		if r.URL.Path != "/v0/keys/testkey/restore/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/testkey/restore/")
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	err = cli.RestoreKey("testkey")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+}
+
+func TestRenameKey(t *testing.T) {
+	resp, err := buildGoodResponse("")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "POST" {
+			t.Fatalf("%s is not POST", r.Method)
+		}
+		if r.URL.Path != "/v0/keys/testkey/rename/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/testkey/rename/")
+		}
+		r.ParseForm()
+		if r.PostForm.Get("id") != "newkey" {
+			t.Fatalf("%s is not newkey", r.PostForm.Get("id"))
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	err = cli.RenameKey("testkey", "newkey")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+}
+
+func TestMoveKey(t *testing.T) {
+	resp, err := buildGoodResponse("")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "POST" {
+			t.Fatalf("%s is not POST", r.Method)
+		}
+		if r.URL.Path != "/v0/keys/testkey/move/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/testkey/move/")
+		}
+		r.ParseForm()
+		if r.PostForm.Get("path") != "/teams/payments" {
+			t.Fatalf("%s is not /teams/payments", r.PostForm.Get("path"))
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	err = cli.MoveKey("testkey", "/teams/payments")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+}
+
+func TestGetNamespace(t *testing.T) {
+	expected := Namespace{
+		Path:       "/teams",
+		ACL:        ACL{{ID: "testuser", AccessType: Admin, Type: User}},
+		Keys:       []string{"a1"},
+		Namespaces: []string{"/teams/payments"},
+	}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("%s is not GET", r.Method)
+		}
+		if r.URL.Path != "/v0/namespaces/teams/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/namespaces/teams/")
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	n, err := cli.GetNamespace("/teams")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if n.Path != expected.Path || len(n.ACL) != 1 || n.ACL[0] != expected.ACL[0] {
+		t.Fatalf("%v is not %v", n, expected)
+	}
+	if len(n.Keys) != 1 || n.Keys[0] != "a1" || len(n.Namespaces) != 1 || n.Namespaces[0] != "/teams/payments" {
+		t.Fatalf("%v is not %v", n, expected)
+	}
+}
+
+func TestExplainAccess(t *testing.T) {
+	expected := AccessExplanation{
+		KeyID:         "testkey",
+		Principal:     "host1",
+		PrincipalType: "machine",
+		AccessType:    Write,
+		Action:        "GetKey",
+		Entries:       []ExplainedAccess{{Access: Access{ID: "host", AccessType: Write, Type: MachinePrefix}, Source: "key", Matched: true, Match: "prefix"}},
+		Allowed:       true,
+	}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("%s is not GET", r.Method)
+		}
+		if r.URL.Path != "/v0/keys/testkey/explain/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/testkey/explain/")
+		}
+		q := r.URL.Query()
+		if q.Get("as") != "Machine:host1" || q.Get("access") != "Write" || q.Get("groups") != "" || q.Get("action") != "" {
+			t.Fatalf("unexpected query %s", r.URL.RawQuery)
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	e, err := cli.ExplainAccess("testkey", "Machine:host1", nil, Write, "")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if !e.Allowed || len(e.Entries) != 1 || e.Entries[0] != expected.Entries[0] {
+		t.Fatalf("%+v is not %+v", e, expected)
+	}
+}
+
+func TestWhoami(t *testing.T) {
+	expected := Identity{ID: "testuser", Type: "user", Groups: []string{"testgroup"}, Provider: "jwt", ExpiresAt: 10}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("%s is not GET", r.Method)
+		}
+		if r.URL.Path != "/v0/whoami/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/whoami/")
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	id, err := cli.Whoami()
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if id.ID != expected.ID || id.Provider != expected.Provider || id.ExpiresAt != expected.ExpiresAt || len(id.Groups) != 1 {
+		t.Fatalf("%+v is not %+v", id, expected)
+	}
+}
+
+func TestAccessReport(t *testing.T) {
+	expected := []AccessReportEntry{{KeyID: "a1", Path: "/teams", Access: Admin}}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("%s is not GET", r.Method)
+		}
+		if r.URL.Path != "/v0/reports/access/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/reports/access/")
+		}
+		q := r.URL.Query()
+		if q.Get("principal") != "User:alice" || q.Get("groups") != "a,b" {
+			t.Fatalf("unexpected query %s", r.URL.RawQuery)
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	report, err := cli.AccessReport("User:alice", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if len(report) != 1 || report[0] != expected[0] {
+		t.Fatalf("%v is not %v", report, expected)
+	}
+}
+
+func TestMigrateACL(t *testing.T) {
+	expected := ACLMigrationResult{
+		Changes: []ACLMigration{{KeyID: "a1", Removed: ACL{{ID: "old", AccessType: Read, Type: Machine}}}},
+		Next:    "a1",
+	}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "POST" {
+			t.Fatalf("%s is not POST", r.Method)
+		}
+		if r.URL.Path != "/v0/acl-migrations/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/acl-migrations/")
+		}
+		r.ParseForm()
+		f := r.PostForm
+		if f.Get("from") != "Machine:old" || f.Get("remove") != "true" || f.Get("to") != "" || f.Get("dry_run") != "true" || f.Get("batch") != "1" {
+			t.Fatalf("unexpected form %v", f)
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	r, err := cli.MigrateACL("Machine:old", "", true, "", 1)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if r.Next != "a1" || len(r.Changes) != 1 || r.Changes[0].Removed[0] != expected.Changes[0].Removed[0] {
+		t.Fatalf("%+v is not %+v", r, expected)
+	}
+}
+
+func TestStripAccess(t *testing.T) {
+	expected := []ACLMigration{{KeyID: "a1", Removed: ACL{{ID: "security-team", AccessType: Admin, Type: UserGroup}}}}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "POST" {
+			t.Fatalf("%s is not POST", r.Method)
+		}
+		if r.URL.Path != "/v0/acl-migrations/strip/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/acl-migrations/strip/")
+		}
+		r.ParseForm()
+		if p := r.PostForm.Get("principals"); p != "UserGroup:security-team;Machine:old" {
+			t.Fatalf("unexpected principals %s", p)
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	changes, err := cli.StripAccess([]string{"UserGroup:security-team", "Machine:old"})
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if len(changes) != 1 || changes[0].Removed[0] != expected[0].Removed[0] {
+		t.Fatalf("%+v is not %+v", changes, expected)
+	}
+}
+
+func TestACLLintReport(t *testing.T) {
+	a := Access{Type: MachinePrefix, ID: "web", AccessType: Read}
+	expected := []ACLLintFinding{{KeyID: "a1", Rule: "short-machine-prefix", Message: "too short", Access: &a}}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("%s is not GET", r.Method)
+		}
+		if r.URL.Path != "/v0/reports/acl-lint/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/reports/acl-lint/")
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	report, err := cli.ACLLintReport()
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if len(report) != 1 || report[0].Rule != expected[0].Rule || report[0].Access == nil || *report[0].Access != a {
+		t.Fatalf("%v is not %v", report, expected)
+	}
+}
+
+func TestRequestAccess(t *testing.T) {
+	expected := AccessRequest{ID: 7, KeyID: "a1", Access: Access{Type: User, ID: "alice", AccessType: Write}, Justification: "rotation"}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "POST" {
+			t.Fatalf("%s is not POST", r.Method)
+		}
+		if r.URL.Path != "/v0/keys/a1/requests/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/a1/requests/")
+		}
+		r.ParseForm()
+		f := r.PostForm
+		if f.Get("access") != "Write" || f.Get("justification") != "rotation" || f.Get("duration") != "8h0m0s" {
+			t.Fatalf("unexpected form %v", f)
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	r, err := cli.RequestAccess("a1", Write, "rotation", 8*time.Hour)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if *r != expected {
+		t.Fatalf("%+v is not %+v", r, expected)
+	}
+}
+
+func TestGetAccessRequests(t *testing.T) {
+	expected := []AccessRequest{{ID: 7, KeyID: "a1", Access: Access{Type: User, ID: "alice", AccessType: Read}}}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("%s is not GET", r.Method)
+		}
+		if r.URL.Path != "/v0/requests/" || r.URL.Query().Get("key_id") != "a1" {
+			t.Fatalf("unexpected URL %s", r.URL)
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	requests, err := cli.GetAccessRequests("a1")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if len(requests) != 1 || requests[0] != expected[0] {
+		t.Fatalf("%v is not %v", requests, expected)
+	}
+}
+
+func TestDecideAccessRequest(t *testing.T) {
+	resp, err := buildGoodResponse(AccessRequest{ID: 7, KeyID: "a1", Access: Access{Type: User, ID: "alice", AccessType: Read}})
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "POST" {
+			t.Fatalf("%s is not POST", r.Method)
+		}
+		if r.URL.Path != "/v0/keys/a1/requests/7/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/a1/requests/7/")
+		}
+		r.ParseForm()
+		if r.PostForm.Get("decision") != "deny" {
+			t.Fatalf("unexpected form %v", r.PostForm)
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	r, err := cli.DecideAccessRequest("a1", 7, false)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if r.ID != 7 {
+		t.Fatalf("%d is not 7", r.ID)
+	}
+}
+
+func TestPutQuorum(t *testing.T) {
+	resp, err := buildGoodResponse("")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "PUT" {
+			t.Fatalf("%s is not PUT", r.Method)
+		}
+		if r.URL.Path != "/v0/keys/a1/quorum/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/a1/quorum/")
+		}
+		r.ParseForm()
+		if r.PostForm.Get("required") != "true" {
+			t.Fatalf("unexpected form %v", r.PostForm)
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	if err := cli.PutQuorum("a1", true); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+}
+
+func TestPendingOperations(t *testing.T) {
+	expected := []PendingOperation{{ID: 7, KeyID: "a1", Operation: OperationPromote, VersionID: 3, RequestedBy: "alice"}}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("%s is not GET", r.Method)
+		}
+		if r.URL.Path != "/v0/operations/" || r.URL.Query().Get("key_id") != "a1" {
+			t.Fatalf("unexpected URL %s", r.URL)
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	ops, err := cli.GetPendingOperations("a1")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if len(ops) != 1 || !reflect.DeepEqual(ops[0], expected[0]) {
+		t.Fatalf("%v is not %v", ops, expected)
+	}
+}
+
+func TestConfirmOperation(t *testing.T) {
+	resp, err := buildGoodResponse(PendingOperation{ID: 7, KeyID: "a1", Operation: OperationDelete})
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	methods := []string{}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.URL.Path != "/v0/keys/a1/operations/7/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/a1/operations/7/")
+		}
+		methods = append(methods, r.Method)
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	op, err := cli.ConfirmOperation("a1", 7)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if op.Operation != OperationDelete {
+		t.Fatalf("%s is not %s", op.Operation, OperationDelete)
+	}
+	if err := cli.CancelOperation("a1", 7); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if !reflect.DeepEqual(methods, []string{"POST", "DELETE"}) {
+		t.Fatalf("%v is not [POST DELETE]", methods)
+	}
+}
+
+func TestBreakGlass(t *testing.T) {
+	expected := BreakGlassGrant{KeyID: "a1", Principal: "oncall", Justification: "incident 42", GrantedAt: 1, ExpiresAt: 2}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "POST" {
+			t.Fatalf("%s is not POST", r.Method)
+		}
+		if r.URL.Path != "/v0/keys/a1/break-glass/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/a1/break-glass/")
+		}
+		r.ParseForm()
+		if r.PostForm.Get("justification") != "incident 42" {
+			t.Fatalf("unexpected form %v", r.PostForm)
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	g, err := cli.BreakGlass("a1", "incident 42")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if *g != expected {
+		t.Fatalf("%v is not %v", *g, expected)
+	}
+}
+
+func TestBreakGlassReport(t *testing.T) {
+	expected := []BreakGlassGrant{{KeyID: "a1", Principal: "oncall", Justification: "incident 42"}}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("%s is not GET", r.Method)
+		}
+		if r.URL.Path != "/v0/reports/break-glass/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/reports/break-glass/")
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	report, err := cli.BreakGlassReport()
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("%v is not %v", report, expected)
+	}
+}
+
+func TestOPADecisionReport(t *testing.T) {
+	expected := []OPADecision{{ID: "d1", Query: "data.knox.authz.allow", Input: map[string]interface{}{"action": "GetKey"}, Allowed: true}}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("%s is not GET", r.Method)
+		}
+		if r.URL.Path != "/v0/reports/opa-decisions/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/reports/opa-decisions/")
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	report, err := cli.OPADecisionReport()
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("%v is not %v", report, expected)
+	}
+}
+
+func TestPutAlias(t *testing.T) {
+	resp, err := buildGoodResponse("")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "PUT" {
+			t.Fatalf("%s is not PUT", r.Method)
+		}
+		if r.URL.Path != "/v0/aliases/testalias/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/aliases/testalias/")
+		}
+		r.ParseForm()
+		if r.PostForm.Get("key") != "testkey" {
+			t.Fatalf("%s is not testkey", r.PostForm.Get("key"))
+		}
+		if _, ok := r.PostForm["acl"]; ok {
+			t.Fatal("acl should not be sent for a nil ACL")
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	err = cli.PutAlias("testalias", "testkey", nil)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+}
+
 func TestPutVersion(t *testing.T) {
 	resp, err := buildGoodResponse("")
 	if err != nil {
@@ -309,6 +927,56 @@ func TestPutVersion(t *testing.T) {
 	}
 }
 
+func TestPurgeVersion(t *testing.T) {
+	resp, err := buildGoodResponse("")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "DELETE" {
+			t.Fatalf("%s is not DELETE", r.Method)
+		}
+		if r.URL.Path != "/v0/keys/testkey/versions/123/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/testkey/versions/123/")
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	err = cli.PurgeVersion("testkey", "123")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+}
+
+func TestGetVersionRetention(t *testing.T) {
+	expected := VersionRetention{MaxInactive: 3}
+	resp, err := buildGoodResponse(expected)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	srv := buildServer(200, resp, func(r *http.Request) {
+		if r.Method != "GET" {
+			t.Fatalf("%s is not GET", r.Method)
+		}
+		if r.URL.Path != "/v0/keys/testkey/retention/" {
+			t.Fatalf("%s is not %s", r.URL.Path, "/v0/keys/testkey/retention/")
+		}
+	})
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	r, err := cli.GetVersionRetention("testkey")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if r == nil || *r != expected {
+		t.Fatalf("%+v does not equal %+v", r, expected)
+	}
+}
+
 func TestPutAccess(t *testing.T) {
 	resp, err := buildGoodResponse("")
 	if err != nil {
@@ -453,3 +1121,45 @@ func TestGetKeyWithStatus(t *testing.T) {
 		t.Fatalf("path '%v' is not empty", k.Path)
 	}
 }
+
+func TestETagPreconditions(t *testing.T) {
+	resp, err := buildGoodResponse(ACL{})
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	etag := `"abc123"`
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			if r.Header.Get("If-Match") != "" {
+				t.Fatalf("unexpected If-Match on %s", r.Method)
+			}
+			w.Header().Set("ETag", etag)
+		default:
+			if r.Header.Get("If-Match") != etag {
+				t.Fatalf("%s is not %s", r.Header.Get("If-Match"), etag)
+			}
+		}
+		w.WriteHeader(200)
+		w.Write(resp)
+	}))
+	defer srv.Close()
+
+	cli := MockClient(srv.Listener.Addr().String())
+
+	_, got, err := cli.GetACLWithETag("testkey")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if got != etag {
+		t.Fatalf("%s does not equal %s", got, etag)
+	}
+	err = cli.PutAccessIfMatch("testkey", got, Access{Type: User, AccessType: Read, ID: "test"})
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	err = cli.DeleteKeyIfMatch("testkey", got)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+}
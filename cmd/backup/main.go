@@ -0,0 +1,202 @@
+// Command backup exports a knox database to a portable backup file and
+// restores such a file into a (possibly different) knox database.
+//
+// The database is selected with the same environment variables as the server
+// (DB_TYPE, MYSQL_PASSWORD, ETCD_HOSTS, DB_ENCRYPTION_KEY, ...).
+//
+//	backup -export knox.bak [-public-key backup.pub]
+//	backup -import knox.bak [-private-key backup.pem] [-source-encryption-key <key>]
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/pavelzhurov/knox/server/keydb"
+)
+
+var (
+	flagExport     = flag.String("export", "", "write a backup of all keys to this file")
+	flagImport     = flag.String("import", "", "restore keys from this backup file")
+	flagPublicKey  = flag.String("public-key", "", "PEM encoded RSA public key to encrypt the backup to")
+	flagPrivateKey = flag.String("private-key", "", "PEM encoded RSA private key to decrypt an encrypted backup")
+	flagSourceKey  = flag.String("source-encryption-key", "", "DB encryption key of the server the backup was taken from (defaults to DB_ENCRYPTION_KEY)")
+)
+
+type timeSeconds time.Duration
+
+func (t *timeSeconds) UnmarshalText(text []byte) error {
+	tt, err := strconv.Atoi(string(text))
+	*t = timeSeconds(tt * int(time.Second))
+	return err
+}
+
+type timeMiliseconds time.Duration
+
+func (t *timeMiliseconds) UnmarshalText(text []byte) error {
+	tt, err := strconv.Atoi(string(text))
+	*t = timeMiliseconds(tt * int(time.Millisecond))
+	return err
+}
+
+type config struct {
+	EtcdHosts          []string        `env:"ETCD_HOSTS" envSeparator:";" envDefault:"localhost:2379"`
+	EtcdInitTimeout    timeSeconds     `env:"ETCD_INIT_TIMEOUT" envDefault:"2"`
+	EtcdDialTimeout    timeSeconds     `env:"ETCD_DIAL_TIMEOUT" envDefault:"5"`
+	EtcdContextTimeout timeMiliseconds `env:"ETCD_CONTEXT_TIMEOUT" envDefault:"100"`
+
+	DbEncryptionKey string `env:"DB_ENCRYPTION_KEY,unset" envDefault:"testtesttesttest"`
+	MySqlPassword   string `env:"MYSQL_PASSWORD,unset"`
+	DbType          string `env:"DB_TYPE" envDefault:"mysql"`
+}
+
+func main() {
+	flag.Parse()
+	if (*flagExport == "") == (*flagImport == "") {
+		fatalf("exactly one of -export and -import is required")
+	}
+
+	c := config{}
+	if err := env.Parse(&c); err != nil {
+		fatalf("read config error: %v", err)
+	}
+	db, err := openDB(&c)
+	if err != nil {
+		fatalf("can't open DB: %v", err)
+	}
+	cryptor := keydb.NewAESGCMCryptor(0, []byte(c.DbEncryptionKey))
+
+	if *flagExport != "" {
+		err = export(db, *flagExport, *flagPublicKey)
+	} else {
+		sourceKey := c.DbEncryptionKey
+		if *flagSourceKey != "" {
+			sourceKey = *flagSourceKey
+		}
+		err = restore(db, *flagImport, *flagPrivateKey, keydb.NewAESGCMCryptor(0, []byte(sourceKey)), cryptor)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func openDB(c *config) (keydb.DB, error) {
+	switch c.DbType {
+	case "etcd":
+		return keydb.NewEtcdConnector(c.EtcdHosts, time.Duration(c.EtcdInitTimeout),
+			time.Duration(c.EtcdDialTimeout), time.Duration(c.EtcdContextTimeout)), nil
+	case "mysql":
+		d, err := sql.Open("mysql", fmt.Sprintf("root:%v@tcp(mysql)/kms", c.MySqlPassword))
+		if err != nil {
+			return nil, err
+		}
+		return keydb.NewSQLDB(d)
+	default:
+		return nil, fmt.Errorf("unknown DB type %q", c.DbType)
+	}
+}
+
+func export(db keydb.DB, path, publicKeyPath string) error {
+	var pub *rsa.PublicKey
+	if publicKeyPath != "" {
+		b, err := readPEM(publicKeyPath)
+		if err != nil {
+			return err
+		}
+		key, err := x509.ParsePKIXPublicKey(b)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if pub, ok = key.(*rsa.PublicKey); !ok {
+			return fmt.Errorf("%s is not an RSA public key", publicKeyPath)
+		}
+	}
+
+	keys, err := db.GetAll()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	manifest, err := keydb.WriteBackup(f, keys, pub)
+	if err != nil {
+		return err
+	}
+	return printJSON(manifest)
+}
+
+func restore(db keydb.DB, path, privateKeyPath string, src, dst keydb.Cryptor) error {
+	var priv *rsa.PrivateKey
+	if privateKeyPath != "" {
+		b, err := readPEM(privateKeyPath)
+		if err != nil {
+			return err
+		}
+		priv, err = x509.ParsePKCS1PrivateKey(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	keys, _, err := keydb.ReadBackup(f, priv)
+	if err != nil {
+		return err
+	}
+	report, err := keydb.RestoreBackup(db, keys, src, dst)
+	if report != nil {
+		if printErr := printJSON(report); printErr != nil && err == nil {
+			err = printErr
+		}
+	}
+	if err == nil && (len(report.Conflicts) > 0 || len(report.Invalid) > 0) {
+		os.Exit(3)
+	}
+	return err
+}
+
+func readPEM(path string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM block", path)
+	}
+	return block.Bytes, nil
+}
+
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
+	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/server"
 )
 
 type TimeSeconds time.Duration
@@ -24,20 +27,112 @@ func (t *TimeMiliseconds) UnmarshalText(text []byte) error {
 	return err
 }
 
+// Principals is a semicolon separated list of principals written as Type:ID,
+// such as UserGroup:security-team;Machine:bastion.
+type Principals []knox.Access
+
+func (p *Principals) UnmarshalText(text []byte) error {
+	*p = Principals{}
+	for _, entry := range strings.Split(string(text), ";") {
+		if entry == "" {
+			continue
+		}
+		a, err := knox.ParseAccessPrincipal(entry)
+		if err != nil {
+			return err
+		}
+		if err := a.Type.IsValidPrincipal(a.ID, nil); err != nil {
+			return fmt.Errorf("principal %q is invalid: %w", entry, err)
+		}
+		*p = append(*p, a)
+	}
+	return nil
+}
+
+// OPATargets is a semicolon separated list of mappings from namespaces,
+// written as namespace:<path>, or key ID prefixes, written as prefix:<prefix>,
+// to the OPA partition and service of their policies, written as
+// <partition>/<service>, such as namespace:team-a=part-a/kms;prefix:b_=part-b/kms.
+type OPATargets []server.OPAMapping
+
+func (o *OPATargets) UnmarshalText(text []byte) error {
+	*o = OPATargets{}
+	for _, entry := range strings.Split(string(text), ";") {
+		if entry == "" {
+			continue
+		}
+		match, target, ok := strings.Cut(entry, "=")
+		partition, service, ok2 := strings.Cut(target, "/")
+		if !ok || !ok2 || partition == "" || service == "" {
+			return fmt.Errorf("OPA target %q is not <match>=<partition>/<service>", entry)
+		}
+		mp := server.OPAMapping{Target: server.OPATarget{Partition: partition, Service: service}}
+		switch kind, value, _ := strings.Cut(match, ":"); {
+		case kind == "namespace" && value != "":
+			mp.Namespace = value
+		case kind == "prefix" && value != "":
+			mp.KeyPrefix = value
+		default:
+			return fmt.Errorf("OPA target %q does not match a namespace:<path> or a prefix:<prefix>", entry)
+		}
+		*o = append(*o, mp)
+	}
+	return nil
+}
+
 type Config struct {
 	EtcdHosts          []string        `env:"ETCD_HOSTS" envSeparator:";" envDefault:"localhost:2379"`
 	EtcdInitTimeout    TimeSeconds     `env:"ETCD_INIT_TIMEOUT" envDefault:"2"`
 	EtcdDialTimeout    TimeSeconds     `env:"ETCD_DIAL_TIMEOUT" envDefault:"5"`
 	EtcdContextTimeout TimeMiliseconds `env:"ETCD_CONTEXT_TIMEOUT" envDefault:"100"`
 
+	BackgroundWorkers    bool        `env:"BACKGROUND_WORKERS" envDefault:"true"`
+	ReconcileInterval    TimeSeconds `env:"RECONCILE_INTERVAL" envDefault:"300"`
+	ConflictRetries      int         `env:"CONFLICT_RETRIES" envDefault:"5"`
+	CacheSize            int         `env:"CACHE_SIZE" envDefault:"0"`
+	CacheMaxStaleness    TimeSeconds `env:"CACHE_MAX_STALENESS" envDefault:"30"`
+	TrashRetention       TimeSeconds `env:"TRASH_RETENTION" envDefault:"2592000"`
+	TrashPurgeInterval   TimeSeconds `env:"TRASH_PURGE_INTERVAL" envDefault:"3600"`
+	RetentionInterval    TimeSeconds `env:"RETENTION_INTERVAL" envDefault:"3600"`
+	AccessExpiryInterval TimeSeconds `env:"ACCESS_EXPIRY_INTERVAL" envDefault:"60"`
+	AccessRequestTTL     TimeSeconds `env:"ACCESS_REQUEST_TTL" envDefault:"604800"`
+	PendingOperationTTL  TimeSeconds `env:"PENDING_OPERATION_TTL" envDefault:"86400"`
+
+	GlobalAdmins  Principals `env:"GLOBAL_ADMINS" envDefault:"UserGroup:security-team"`
+	GlobalReaders Principals `env:"GLOBAL_READERS"`
+
+	OpaTargets     OPATargets `env:"OPA_TARGETS"`
+	OpaPolicyPath  string     `env:"OPA_POLICY_PATH"`
+	OpaQuery       string     `env:"OPA_QUERY" envDefault:"data.knox.authz.allow"`
+	OpaDecisionLog string     `env:"OPA_DECISION_LOG"`
+
+	BreakGlassGroups  []string    `env:"BREAK_GLASS_GROUPS" envSeparator:";"`
+	BreakGlassWindow  TimeSeconds `env:"BREAK_GLASS_WINDOW" envDefault:"1800"`
+	BreakGlassLog     string      `env:"BREAK_GLASS_LOG"`
+	BreakGlassWebhook string      `env:"BREAK_GLASS_WEBHOOK"`
+	BreakGlassArchive string      `env:"BREAK_GLASS_ARCHIVE"`
+
+	WebhookConfig        string      `env:"WEBHOOK_CONFIG"`
+	WebhookRetries       int         `env:"WEBHOOK_RETRIES" envDefault:"5"`
+	WebhookBackoff       TimeSeconds `env:"WEBHOOK_BACKOFF" envDefault:"1"`
+	WebhookDeadLetterLog string      `env:"WEBHOOK_DEAD_LETTER_LOG"`
+
+	ACLLintMode             string   `env:"ACL_LINT_MODE" envDefault:"warn"`
+	ACLLintMinMachinePrefix int      `env:"ACL_LINT_MIN_MACHINE_PREFIX" envDefault:"4"`
+	ACLLintLargeGroups      []string `env:"ACL_LINT_LARGE_GROUPS" envSeparator:";"`
+
 	KnoxHosts        []string `env:"KNOX_DNS" envSeparator:";" envDefault:"localhost:9000"`
 	IsDevServer      bool     `env:"DEV_SERVER" envDefault:"false"`
 	OpaAuthorization bool     `env:"OPA_AUTHORIZATION" envDefault:"false"`
+	OpaPartition     string   `env:"OPA_PARTITION" envDefault:"pvc"`
+	OpaService       string   `env:"OPA_SERVICE" envDefault:"kms"`
 	RSAPubKey        string   `env:"RSA_PUBLIC_KEY,notEmpty"`
 	DbEncryptionKey  string   `env:"DB_ENCRYPTION_KEY,unset" envDefault:"testtesttesttest"`
 	Version          string   `env:"VERSION,notEmpty"`
 	MySqlPassword    string   `env:"MYSQL_PASSWORD,unset"`
 	DbType           string   `env:"DB_TYPE" envDefault:"mysql"`
+	SecondaryDbType  string   `env:"SECONDARY_DB_TYPE"`
+	DualWriteFlip    bool     `env:"DUAL_WRITE_FLIP" envDefault:"false"`
 	SpiffeCAPath     string   `env:"SPIFFE_CA_PATH" envDefault:"/certs/bundle.crt"`
 	SpiffeCA         string   `env:"SPIFFE_CA,file" envDefault:"${SPIFFE_CA_PATH}" envExpand:"true"`
 }
@@ -65,9 +160,26 @@ func verifyConfig(config *Config) error {
 		if config.DbType == "etcd" && len(config.EtcdHosts) == 0 {
 			return fmt.Errorf("etcd hosts are not set")
 		}
+		if config.SecondaryDbType == config.DbType {
+			return fmt.Errorf("secondary DB type must differ from DB type")
+		}
+		if config.SecondaryDbType == "etcd" && len(config.EtcdHosts) == 0 {
+			return fmt.Errorf("etcd hosts are not set")
+		}
+		if config.SecondaryDbType == "mysql" && config.MySqlPassword == "" {
+			return fmt.Errorf("mysql password is not set")
+		}
 		if config.SpiffeCA == "" {
 			return fmt.Errorf("spiffe certs are not set")
 		}
 	}
+	if config.OpaPolicyPath != "" && !config.OpaAuthorization {
+		return fmt.Errorf("OPA policy path is set without OPA authorization")
+	}
+	switch config.ACLLintMode {
+	case "off", "warn", "block":
+	default:
+		return fmt.Errorf("unknown ACL lint mode %q", config.ACLLintMode)
+	}
 	return nil
 }
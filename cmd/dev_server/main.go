@@ -1,12 +1,14 @@
 package main
 
 import (
+	"bytes"
 	crypto_rand "crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"database/sql"
+	"encoding/json"
 	"encoding/pem"
 	"flag"
 	"fmt"
@@ -14,7 +16,9 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pavelzhurov/knox"
@@ -56,29 +60,101 @@ func main() {
 		db = keydb.NewTempDB()
 		// db = keydb.NewEtcdConnector([]string{"localhost:2379", "etcd:2379"}, 5*time.Second, 100*time.Millisecond)
 	} else {
-		switch knoxConfig.DbType {
-		case "etcd":
-			db = keydb.NewEtcdConnector(knoxConfig.EtcdHosts, time.Duration(knoxConfig.EtcdInitTimeout),
-				time.Duration(knoxConfig.EtcdDialTimeout), time.Duration(knoxConfig.EtcdContextTimeout))
-		case "mysql":
-			d, err := sql.Open("mysql", fmt.Sprintf("root:%v@tcp(mysql)/kms", knoxConfig.MySqlPassword))
+		db, err = newDB(knoxConfig.DbType, knoxConfig)
+		if err != nil {
+			errLogger.Fatalf("Can't initialize keyDB: %v\n", err)
+		}
+		if knoxConfig.SecondaryDbType != "" {
+			secondary, err := newDB(knoxConfig.SecondaryDbType, knoxConfig)
 			if err != nil {
-				errLogger.Fatalf("Can't connect to MYSQL: %v\n", err)
+				errLogger.Fatalf("Can't initialize secondary keyDB: %v\n", err)
 			}
-			db, err = keydb.NewSQLDB(d)
-			if err != nil {
-				errLogger.Fatalf("Can't initialize keyDB: %v\n", err)
+			dualDB := keydb.NewDualDB(db, secondary)
+			if knoxConfig.DualWriteFlip {
+				dualDB.Swap()
+			}
+			// SIGUSR1 swaps the backends without a restart, once the
+			// reconciler reports no drift.
+			swaps := make(chan os.Signal, 1)
+			signal.Notify(swaps, syscall.SIGUSR1)
+			go func() {
+				for range swaps {
+					dualDB.Swap()
+					accLogger.OutputJSON(map[string]interface{}{"type": "dual_db_swap"})
+				}
+			}()
+			if knoxConfig.BackgroundWorkers {
+				dualDB.StartReconciler(time.Duration(knoxConfig.ReconcileInterval))
 			}
-		default:
-			errLogger.Fatal("Uknown DB type")
+			db = dualDB
 		}
 	}
 
-	server.AddDefaultAccess(&knox.Access{
-		Type:       knox.UserGroup,
-		ID:         "security-team",
-		AccessType: knox.Admin,
-	})
+	if knoxConfig.CacheSize > 0 {
+		cachedDB := keydb.NewCachedDB(db, knoxConfig.CacheSize, time.Duration(knoxConfig.CacheMaxStaleness))
+		go func() {
+			for range time.Tick(time.Minute) {
+				accLogger.OutputJSON(map[string]interface{}{"type": "cache", "stats": cachedDB.Stats()})
+			}
+		}()
+		db = cachedDB
+	}
+
+	// The workers change keys in the shared database, so with several
+	// replicas only one of them should run them.
+	if knoxConfig.BackgroundWorkers {
+		keydb.StartTrashPurger(db, time.Duration(knoxConfig.TrashRetention), time.Duration(knoxConfig.TrashPurgeInterval), server.NotifyPurged)
+		keydb.StartVersionRetention(db, time.Duration(knoxConfig.RetentionInterval))
+		keydb.StartAccessExpiry(db, time.Duration(knoxConfig.AccessExpiryInterval), func(keyID string, expired knox.ACL) {
+			accLogger.OutputJSON(map[string]interface{}{"type": "access_expired", "key_id": keyID, "acl": expired})
+			server.NotifyACLChanged(db, keyID)
+		})
+	}
+
+	server.SetAuditLogger(accLogger)
+	server.SetAccessRequestTTL(time.Duration(knoxConfig.AccessRequestTTL))
+	server.SetPendingOperationTTL(time.Duration(knoxConfig.PendingOperationTTL))
+	breakGlassLogger, err := setupFileLogging(knoxConfig.BreakGlassLog, knoxConfig.Version, hostname)
+	if err != nil {
+		errLogger.Fatal("Failed to open break-glass log: ", err)
+	}
+	server.SetBreakGlassLogger(breakGlassLogger)
+	server.SetBreakGlassPolicy(knoxConfig.BreakGlassGroups, time.Duration(knoxConfig.BreakGlassWindow))
+	if knoxConfig.BreakGlassWebhook != "" {
+		server.AddBreakGlassHook(breakGlassWebhook(knoxConfig.BreakGlassWebhook, errLogger))
+	}
+	if knoxConfig.BreakGlassArchive != "" {
+		server.SetBreakGlassArchive(server.NewFileBreakGlassArchive(knoxConfig.BreakGlassArchive))
+	}
+	if knoxConfig.BackgroundWorkers {
+		keydb.StartBreakGlassExpiry(db, time.Duration(knoxConfig.AccessExpiryInterval), server.ArchiveBreakGlassGrants)
+	}
+	if knoxConfig.WebhookConfig != "" {
+		subs, err := readWebhookConfig(knoxConfig.WebhookConfig)
+		if err != nil {
+			errLogger.Fatal("Failed to read webhook config: ", err)
+		}
+		deadLetterLogger, err := setupFileLogging(knoxConfig.WebhookDeadLetterLog, knoxConfig.Version, hostname)
+		if err != nil {
+			errLogger.Fatal("Failed to open webhook dead-letter log: ", err)
+		}
+		server.SetNotifier(server.NewNotifier(subs, knoxConfig.WebhookRetries, time.Duration(knoxConfig.WebhookBackoff), deadLetterLogger))
+	}
+	server.SetConflictRetries(knoxConfig.ConflictRetries)
+	server.SetGlobalPolicy(knoxConfig.GlobalAdmins, knoxConfig.GlobalReaders)
+
+	server.AddACLLintRule("short-machine-prefix", server.ShortMachinePrefixRule(knoxConfig.ACLLintMinMachinePrefix))
+	server.AddACLLintRule("no-human-admin", server.NoHumanAdminRule())
+	server.AddACLLintRule("redundant-entry", server.RedundantEntryRule())
+	if len(knoxConfig.ACLLintLargeGroups) > 0 {
+		server.AddACLLintRule("large-group", server.LargeGroupRule(knoxConfig.ACLLintLargeGroups))
+	}
+	switch knoxConfig.ACLLintMode {
+	case "warn":
+		server.SetACLLintMode(server.ACLLintWarn)
+	case "block":
+		server.SetACLLintMode(server.ACLLintBlock)
+	}
 
 	certPool := x509.NewCertPool()
 	if knoxConfig.IsDevServer {
@@ -108,6 +184,21 @@ func main() {
 	if knoxConfig.OpaAuthorization {
 		authzType = server.OpaAuthorization
 	}
+	server.SetOPATargets(server.OPATarget{Partition: knoxConfig.OpaPartition, Service: knoxConfig.OpaService}, knoxConfig.OpaTargets)
+	if knoxConfig.OpaPolicyPath != "" {
+		decisionLogger, err := setupFileLogging(knoxConfig.OpaDecisionLog, knoxConfig.Version, hostname)
+		if err != nil {
+			errLogger.Fatal("Failed to open OPA decision log: ", err)
+		}
+		evaluator, err := server.NewRegoEvaluator(knoxConfig.OpaPolicyPath, knoxConfig.OpaQuery, decisionLogger)
+		if err != nil {
+			errLogger.Fatal("Failed to load OPA policies: ", err)
+		}
+		if err := evaluator.Watch(nil); err != nil {
+			errLogger.Fatal("Failed to watch OPA policies: ", err)
+		}
+		server.SetOPAEvaluator(evaluator)
+	}
 
 	r, err := server.GetRouter(cryptor, db, authzType, decorators, make([]server.Route, 0))
 	if err != nil {
@@ -119,6 +210,23 @@ func main() {
 	errLogger.Fatal(serveTLS(tlsCert, tlsKey, *flagAddr))
 }
 
+// newDB connects to the key database backend of the given type.
+func newDB(dbType string, knoxConfig *Config) (keydb.DB, error) {
+	switch dbType {
+	case "etcd":
+		return keydb.NewEtcdConnector(knoxConfig.EtcdHosts, time.Duration(knoxConfig.EtcdInitTimeout),
+			time.Duration(knoxConfig.EtcdDialTimeout), time.Duration(knoxConfig.EtcdContextTimeout)), nil
+	case "mysql":
+		d, err := sql.Open("mysql", fmt.Sprintf("root:%v@tcp(mysql)/kms", knoxConfig.MySqlPassword))
+		if err != nil {
+			return nil, fmt.Errorf("can't connect to MYSQL: %v", err)
+		}
+		return keydb.NewSQLDB(d)
+	default:
+		return nil, fmt.Errorf("unknown DB type %q", dbType)
+	}
+}
+
 func setupLogging(gitSha, service string) (*log.Logger, *log.Logger) {
 	accLogger := log.New(os.Stderr, "", 0)
 	accLogger.SetVersion(gitSha)
@@ -130,6 +238,62 @@ func setupLogging(gitSha, service string) (*log.Logger, *log.Logger) {
 	return accLogger, errLogger
 }
 
+// setupFileLogging returns a logger that appends to the file at path or, if
+// path is empty, writes to stderr.
+func setupFileLogging(path, gitSha, service string) (*log.Logger, error) {
+	out := os.Stderr
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	l := log.New(out, "", 0)
+	l.SetVersion(gitSha)
+	l.SetService(service)
+	return l, nil
+}
+
+// readWebhookConfig reads the webhook subscriptions from the JSON file at path.
+func readWebhookConfig(path string) ([]server.WebhookSubscription, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	subs := []server.WebhookSubscription{}
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return nil, err
+	}
+	for _, s := range subs {
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return subs, nil
+}
+
+// breakGlassWebhook returns a break-glass hook that posts each grant as JSON
+// to url.
+func breakGlassWebhook(url string, errLogger *log.Logger) func(knox.BreakGlassGrant) {
+	return func(g knox.BreakGlassGrant) {
+		body, err := json.Marshal(g)
+		if err != nil {
+			errLogger.Printf("Failed to marshal break-glass grant: %v", err)
+			return
+		}
+		resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			errLogger.Printf("Failed to notify break-glass webhook: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			errLogger.Printf("Break-glass webhook returned %s", resp.Status)
+		}
+	}
+}
+
 func buildCert(hostnames []string) (certPEMBlock, keyPEMBlock []byte, err error) {
 	priv, err := rsa.GenerateKey(crypto_rand.Reader, 4096)
 	if err != nil {
@@ -22,6 +22,7 @@ func moveKeyData(sDB keydb.DB, sCrypt keydb.Cryptor, dDB keydb.DB, dCrypt keydb.
 		if err != nil {
 			return err
 		}
+		newDBK.CopyState(&dbk)
 		newDBKeys = append(newDBKeys, newDBK)
 	}
 
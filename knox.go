@@ -10,7 +10,9 @@ import (
 	"net/url"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	authz_utils "github.com/pavelzhurov/authz-utils"
 )
@@ -31,6 +33,7 @@ var (
 	ErrInactiveToPrimary = fmt.Errorf("Version must be Active to promote to Primary")
 	ErrPrimaryToActive   = fmt.Errorf("Primary Key can not be demoted. Specify Active key to promote.")
 	ErrPrimaryToInactive = fmt.Errorf("Version must be Active to demote to Inactive")
+	ErrPurgeNotInactive  = fmt.Errorf("Version must be Inactive to purge")
 
 	ErrMulitplePrimary = fmt.Errorf("More than one Primary key")
 	ErrSameVersionID   = fmt.Errorf("Repeated Version ID")
@@ -39,6 +42,22 @@ var (
 	ErrKeyVersionNotFound = fmt.Errorf("Key version not found")
 	ErrKeyIDNotFound      = fmt.Errorf("KeyID not found")
 	ErrKeyExists          = fmt.Errorf("Key Exists")
+	ErrKeyInTrash         = fmt.Errorf("Key is in the trash")
+
+	ErrPreconditionFailed = fmt.Errorf("Key does not match the If-Match precondition")
+
+	ErrInvalidRetention = fmt.Errorf("Retention limits can not be negative")
+
+	ErrInvalidNamespacePath = fmt.Errorf("Namespace path must look like /teams/payments/prod, with components of alphanumeric characters, colons, and underscores.")
+	ErrNamespaceNotFound    = fmt.Errorf("Namespace not found")
+	ErrNamespaceExists      = fmt.Errorf("Namespace Exists")
+	ErrNamespaceNotEmpty    = fmt.Errorf("Namespace still contains keys or namespaces")
+
+	ErrAccessRequestNotFound      = fmt.Errorf("Access request not found")
+	ErrInvalidAccessRequest       = fmt.Errorf("Access requests must ask for Read, Write or Admin access")
+	ErrAccessRequestJustification = fmt.Errorf("Access requests need a justification")
+
+	ErrPendingOperationNotFound = fmt.Errorf("Pending operation not found")
 )
 
 const (
@@ -168,6 +187,21 @@ func (s PrincipalType) MarshalJSON() ([]byte, error) {
 	}
 }
 
+// ParseAccessPrincipal parses a principal written as Type:ID, such as
+// UserGroup:security-team or Machine:host1.example.com, into an Access with
+// no access type. It does not validate the ID; see IsValidPrincipal.
+func ParseAccessPrincipal(s string) (Access, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return Access{}, fmt.Errorf("principal %q is not of the form Type:ID", s)
+	}
+	a := Access{ID: parts[1]}
+	if err := a.Type.UnmarshalJSON([]byte(strconv.Quote(parts[0]))); err != nil || a.Type == Unknown {
+		return Access{}, fmt.Errorf("principal %q has unknown type %q", s, parts[0])
+	}
+	return a, nil
+}
+
 // IsValidPrincipal verifies that the given id string matches our expectations
 // for what a principal should look like given the principal type. For example,
 // a service principal should be a valid SPIFFE ID.
@@ -253,6 +287,9 @@ const (
 	Write
 	// Admin denotes the ability to delete the key and modify the ACL.
 	Admin
+	// Deny denotes an explicit denial of all access. A Deny entry that matches
+	// a principal overrides every grant in the ACL that also matches it.
+	Deny AccessType = -1
 )
 
 // UnmarshalJSON parses JSON input to set an AccessType.
@@ -266,6 +303,8 @@ func (s *AccessType) UnmarshalJSON(b []byte) error {
 		*s = Admin
 	case `"None"`:
 		*s = None
+	case `"Deny"`:
+		*s = Deny
 	default:
 		return invalidTypeError{"AccessType"}
 	}
@@ -283,6 +322,8 @@ func (s AccessType) MarshalJSON() ([]byte, error) {
 		return json.Marshal("Admin")
 	case None:
 		return json.Marshal("None")
+	case Deny:
+		return json.Marshal("Deny")
 	default:
 		return nil, invalidTypeError{"AccessType"}
 	}
@@ -298,15 +339,17 @@ func (s AccessType) Type() (string, error) {
 		return "Admin", nil
 	case None:
 		return "None", nil
+	case Deny:
+		return "Deny", nil
 	default:
 		return "", invalidTypeError{"AccessType"}
 	}
 }
 
 // CanAccess uses a principal's AccessType to determine if the principal can
-// access a given resource.
+// access a given resource. Deny never grants access.
 func (s AccessType) CanAccess(resource AccessType) bool {
-	return s >= resource
+	return s != Deny && s >= resource
 }
 
 // ACL is a list of access information that provides authorization information
@@ -314,15 +357,27 @@ func (s AccessType) CanAccess(resource AccessType) bool {
 type ACL []Access
 
 // Access is a specific access grant as a part of an ACL specifying one
-// principal's or a group of principals' granted acccess.
+// principal's or a group of principals' granted acccess. An Access with the
+// Deny AccessType instead takes all access away from the principals it matches,
+// whatever other entries grant them.
 type Access struct {
 	Type       PrincipalType `json:"type"`
 	ID         string        `json:"id"`
 	AccessType AccessType    `json:"access"`
+	// ExpiresAt, if set, is the time in unix nanoseconds at which the entry
+	// stops applying. Expired entries are removed from stored ACLs by the
+	// server.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the entry has an expiry that has passed at now.
+func (a Access) Expired(now time.Time) bool {
+	return a.ExpiresAt != 0 && a.ExpiresAt <= now.UnixNano()
 }
 
 // Validate ensures the ACL is of valid form. Not specifying the same group
-// or id more than once.
+// or id more than once, so a principal cannot be both granted and denied by
+// entries of the same type.
 func (acl ACL) Validate() error {
 	for i, a := range acl {
 		if a.AccessType == None {
@@ -357,6 +412,46 @@ func (acl ACL) Add(a Access) ACL {
 	return append(acl, a)
 }
 
+// ReplacePrincipal returns a copy of acl where the entry for the principal of
+// from has the principal of to, keeping its access type and expiry, or is
+// removed if to is nil. If to already has an entry, the two are merged: a Deny
+// wins, otherwise the higher access type is kept. It also returns the entries
+// removed from and added to the ACL, which are empty if acl has no entry for
+// from.
+func (acl ACL) ReplacePrincipal(from Access, to *Access) (updated, removed, added ACL) {
+	var old *Access
+	updated = ACL{}
+	for i := range acl {
+		if acl[i].Type == from.Type && acl[i].ID == from.ID {
+			old = &acl[i]
+			continue
+		}
+		updated = append(updated, acl[i])
+	}
+	if old == nil {
+		return acl, nil, nil
+	}
+	removed = ACL{*old}
+	if to == nil {
+		return updated, removed, nil
+	}
+	migrated := *old
+	migrated.Type = to.Type
+	migrated.ID = to.ID
+	for i, a := range updated {
+		if a.Type != to.Type || a.ID != to.ID {
+			continue
+		}
+		if a.AccessType != Deny && (migrated.AccessType == Deny || migrated.AccessType > a.AccessType) {
+			removed = append(removed, a)
+			updated[i] = migrated
+			added = ACL{migrated}
+		}
+		return updated, removed, added
+	}
+	return append(updated, migrated), removed, ACL{migrated}
+}
+
 // KeyVersion is a specific version of a Key. All attributes should be immutable
 // except status.
 type KeyVersion struct {
@@ -397,14 +492,19 @@ type Key struct {
 	ACL         ACL            `json:"acl"`
 	VersionList KeyVersionList `json:"versions"`
 	VersionHash string         `json:"hash"`
-	Path        string         `json:"path,omitempty"`
+	// Path is the namespace the key lives in, such as /teams/payments/prod. It
+	// is empty for keys outside of any namespace.
+	Path string `json:"path,omitempty"`
 }
 
+var keyIDRegexp = regexp.MustCompile("^[a-zA-Z0-9_:-]+$")
+
+var namespacePathRegexp = regexp.MustCompile("^(/[a-zA-Z0-9_:-]+)+$")
+
 // Validate calls makes sure all attributes of key are in good state.
 func (k Key) Validate() error {
 	// Check keyID characters
-	re := regexp.MustCompile("^[a-zA-Z0-9_:-]+$")
-	if !re.MatchString(k.ID) {
+	if !keyIDRegexp.MatchString(k.ID) {
 		return ErrInvalidKeyID
 	}
 
@@ -419,6 +519,336 @@ func (k Key) Validate() error {
 	if k.VersionHash != k.VersionList.Hash() {
 		return ErrInvalidVersionHash
 	}
+	if k.Path != "" && !namespacePathRegexp.MatchString(k.Path) {
+		return ErrInvalidNamespacePath
+	}
+	return nil
+}
+
+// KeyAlias is an alternative ID that resolves to a key, typically left behind
+// when a key is renamed. Reading a key through an alias requires access under
+// the alias's own ACL as well as under the key's.
+type KeyAlias struct {
+	ID    string `json:"id"`
+	KeyID string `json:"key_id"`
+	ACL   ACL    `json:"acl"`
+	// Path is the namespace the alias lives in, the one its key was in when
+	// the alias was created. Its ACL applies to the alias.
+	Path string `json:"path,omitempty"`
+}
+
+// Validate makes sure the alias and the key it points to have valid IDs and
+// that its ACL is in good state.
+func (a KeyAlias) Validate() error {
+	if !keyIDRegexp.MatchString(a.ID) || !keyIDRegexp.MatchString(a.KeyID) {
+		return ErrInvalidKeyID
+	}
+	return a.ACL.Validate()
+}
+
+// Namespace is a folder-like group of keys identified by a path such as
+// /teams/payments/prod. Its ACL applies to the keys in it and to every nested
+// namespace, in addition to their own ACLs.
+type Namespace struct {
+	Path string `json:"path"`
+	ACL  ACL    `json:"acl"`
+	// Keys and Namespaces list the keys and the namespaces directly inside the
+	// namespace. They are only filled in when a namespace is read.
+	Keys       []string `json:"keys,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Validate makes sure the namespace has a valid path and that its ACL is in
+// good state.
+func (n Namespace) Validate() error {
+	if !namespacePathRegexp.MatchString(n.Path) {
+		return ErrInvalidNamespacePath
+	}
+	return n.ACL.Validate()
+}
+
+// AccessRequest is a request for access to a key, filed by a principal with a
+// justification, that an admin of the key approves or denies. Requests can only
+// be decided until they expire.
+type AccessRequest struct {
+	ID    uint64 `json:"id"`
+	KeyID string `json:"key_id"`
+	// Access is the ACL entry approving the request adds to the key.
+	Access        Access `json:"access"`
+	Justification string `json:"justification"`
+	RequestedBy   string `json:"requested_by"`
+	RequestedAt   int64  `json:"requested_at"`
+	ExpiresAt     int64  `json:"expires_at"`
+	// Duration makes the approved access temporary. It is in nanoseconds and
+	// counts from the approval.
+	Duration int64 `json:"duration,omitempty"`
+}
+
+// Validate checks that the request is for a valid principal and gives access.
+func (r AccessRequest) Validate() error {
+	if !keyIDRegexp.MatchString(r.KeyID) {
+		return ErrInvalidKeyID
+	}
+	if r.Access.AccessType <= None {
+		return ErrInvalidAccessRequest
+	}
+	if strings.TrimSpace(r.Justification) == "" {
+		return ErrAccessRequestJustification
+	}
+	return ACL{r.Access}.Validate()
+}
+
+// These are the operations on keys that require quorum wait for as pending
+// operations.
+const (
+	// OperationDelete moves the key to the trash.
+	OperationDelete = "delete"
+	// OperationPurge permanently removes the key.
+	OperationPurge = "purge"
+	// OperationUpdateAccess adds or changes the entries in ACL.
+	OperationUpdateAccess = "update_access"
+	// OperationPromote makes the version VersionID Primary.
+	OperationPromote = "promote"
+	// OperationDisableQuorum turns the key's quorum requirement off.
+	OperationDisableQuorum = "disable_quorum"
+	// OperationPurgeVersion permanently removes the Inactive version
+	// VersionID.
+	OperationPurgeVersion = "purge_version"
+	// OperationRename moves the key to NewID.
+	OperationRename = "rename"
+	// OperationMove moves the key into the namespace at Path.
+	OperationMove = "move"
+	// OperationDeleteAlias removes the alias AliasID of the key.
+	OperationDeleteAlias = "delete_alias"
+	// OperationUpdateNamespaceAccess adds or changes the entries in ACL in the
+	// ACL of the namespace at Path, which holds the key.
+	OperationUpdateNamespaceAccess = "update_namespace_access"
+	// OperationMigrateACL runs the batch of a principal migration in
+	// Migration, which changes the key's ACL.
+	OperationMigrateACL = "migrate_acl"
+	// OperationStripAccess removes the entries of the principals in ACL from
+	// every ACL, including the key's.
+	OperationStripAccess = "strip_access"
+	// OperationSetRetention sets the key's version retention policy to
+	// Retention, or removes it if Retention is nil. The policy permanently
+	// purges Inactive versions.
+	OperationSetRetention = "set_retention"
+)
+
+// PendingOperation is an operation on a key that requires quorum. It only runs
+// once a principal with Admin access other than the one that asked for it
+// confirms it, and it can be cancelled until then or until it expires.
+// Operations that change more than one key are held on the first of them, in
+// key ID order, that requires quorum.
+type PendingOperation struct {
+	ID        uint64 `json:"id"`
+	KeyID     string `json:"key_id"`
+	Operation string `json:"operation"`
+	// ACL is set for OperationUpdateAccess, OperationUpdateNamespaceAccess and
+	// OperationStripAccess, VersionID for OperationPromote and OperationPurgeVersion, NewID for
+	// OperationRename, Path for OperationMove and
+	// OperationUpdateNamespaceAccess, AliasID for OperationDeleteAlias,
+	// Migration for OperationMigrateACL and Retention for
+	// OperationSetRetention.
+	ACL         ACL                  `json:"acl,omitempty"`
+	VersionID   uint64               `json:"version_id,omitempty"`
+	NewID       string               `json:"new_id,omitempty"`
+	Path        string               `json:"path,omitempty"`
+	AliasID     string               `json:"alias_id,omitempty"`
+	Migration   *ACLMigrationRequest `json:"migration,omitempty"`
+	Retention   *VersionRetention    `json:"retention,omitempty"`
+	RequestedBy string               `json:"requested_by"`
+	RequestedAt int64                `json:"requested_at"`
+	ExpiresAt   int64                `json:"expires_at"`
+}
+
+// BreakGlassGrant is emergency Read access to a key that a member of a
+// break-glass group took during an incident. It is never added to the key's
+// ACL and only lasts a short window.
+type BreakGlassGrant struct {
+	KeyID string `json:"key_id"`
+	// Principal is the ID of the user the grant is for.
+	Principal     string `json:"principal"`
+	Justification string `json:"justification"`
+	GrantedAt     int64  `json:"granted_at"`
+	ExpiresAt     int64  `json:"expires_at"`
+}
+
+// OPADecision is the record of an authorization decision made with OPA
+// policies evaluated in the knox server.
+type OPADecision struct {
+	ID    string `json:"decision_id"`
+	Query string `json:"query"`
+	// Input is the input document the policies were evaluated with.
+	Input map[string]interface{} `json:"input"`
+	// Revision is that of the policy bundle, if its manifest has one.
+	Revision string `json:"revision,omitempty"`
+	Allowed  bool   `json:"allowed"`
+	// Error is set if the policies could not be evaluated, in which case the
+	// request was denied.
+	Error string `json:"error,omitempty"`
+	Time  int64  `json:"time"`
+	// Duration is how long the evaluation took in nanoseconds.
+	Duration int64 `json:"duration_ns"`
+}
+
+// These are the changes to keys that are sent to webhooks as KeyEvents.
+const (
+	// EventCreated is sent when a key is added, restored from the trash or
+	// renamed to its ID.
+	EventCreated = "created"
+	// EventVersionAdded is sent when a version VersionID is added to a key.
+	EventVersionAdded = "version_added"
+	// EventPromoted is sent when the version VersionID is made Primary.
+	EventPromoted = "promoted"
+	// EventDeactivated is sent when the version VersionID is made Inactive.
+	EventDeactivated = "deactivated"
+	// EventACLChanged is sent when entries in a key's ACL are added, changed
+	// or removed, including expired entries removed by the server, and when
+	// the key's effective ACL changes because the ACL of its namespace changes
+	// or it moves to another namespace.
+	EventACLChanged = "acl_changed"
+	// EventDeleted is sent when a key is moved to the trash or purged, and
+	// for the old ID of a renamed key.
+	EventDeleted = "deleted"
+	// EventPurged is sent when a key is permanently removed, whether it was
+	// purged or removed from the trash once its retention passed.
+	EventPurged = "purged"
+)
+
+// KeyEvent is a change to a key. It never contains key data.
+type KeyEvent struct {
+	Type      string `json:"type"`
+	KeyID     string `json:"key_id"`
+	VersionID uint64 `json:"version_id,omitempty"`
+	Time      int64  `json:"time"`
+}
+
+// Identity describes the principal a request was authenticated as and the
+// credential it was authenticated with.
+type Identity struct {
+	ID     string   `json:"id"`
+	Type   string   `json:"type"`
+	Groups []string `json:"groups,omitempty"`
+	// Provider is the name of the auth provider that authenticated the
+	// request, such as jwt, mtls or spiffe.
+	Provider string `json:"provider"`
+	// ExpiresAt is when the token or certificate expires in unix nanoseconds,
+	// or 0 if it does not say.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// ACLLintFinding is a risk an ACL lint rule found in the ACL of a key.
+type ACLLintFinding struct {
+	KeyID   string `json:"key_id"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	// Access is the entry the finding is about, if it is about one.
+	Access *Access `json:"access,omitempty"`
+}
+
+// ACLMigration is the change a principal migration made, or would make in a
+// dry run, to the ACL of a key, alias or namespace.
+type ACLMigration struct {
+	KeyID   string `json:"key_id"`
+	Removed ACL    `json:"removed"`
+	Added   ACL    `json:"added,omitempty"`
+}
+
+// ACLMigrationRequest is a batch of a principal migration: the entries for the
+// principal in From are replaced with entries for the principal in To, or
+// removed if To is nil, in at most Batch keys after the key ID After.
+type ACLMigrationRequest struct {
+	From  Access  `json:"from"`
+	To    *Access `json:"to,omitempty"`
+	After string  `json:"after,omitempty"`
+	Batch int     `json:"batch"`
+}
+
+// ACLMigrationResult is a batch of changes made by a principal migration. Next
+// is where the migration continues, or empty when it is done.
+type ACLMigrationResult struct {
+	Changes []ACLMigration `json:"changes"`
+	Next    string         `json:"next,omitempty"`
+}
+
+// AccessReportEntry is a key and the highest access type a principal has to it,
+// as listed by an access report.
+type AccessReportEntry struct {
+	KeyID  string     `json:"key_id"`
+	Path   string     `json:"path,omitempty"`
+	Access AccessType `json:"access"`
+}
+
+// AccessExplanation describes how the server decided whether a principal has
+// an access type to a key. It is returned by the explain endpoint for debugging
+// authorization failures.
+type AccessExplanation struct {
+	KeyID           string     `json:"key_id"`
+	Principal       string     `json:"principal"`
+	PrincipalType   string     `json:"principal_type"`
+	PrincipalGroups []string   `json:"principal_groups,omitempty"`
+	AccessType      AccessType `json:"access_type"`
+	Action          string     `json:"action"`
+	// Entries are the ACL entries considered under ACL authorization, in the
+	// order they are evaluated. Principals that cannot read the key's ACL only
+	// see the entries that match them.
+	Entries []ExplainedAccess `json:"entries,omitempty"`
+	// OPAInput and OPAResult are the input document sent to the policy and its
+	// result under OPA authorization.
+	OPAInput  map[string]interface{} `json:"opa_input,omitempty"`
+	OPAResult *bool                  `json:"opa_result,omitempty"`
+	Allowed   bool                   `json:"allowed"`
+	Reason    string                 `json:"reason"`
+}
+
+// ExplainedAccess is an ACL entry and how it applies to the explained
+// principal.
+type ExplainedAccess struct {
+	Access Access `json:"access"`
+	// Source is where the entry comes from: "key", "global" for the server's
+	// global policy, or the path of a namespace containing the key.
+	Source  string `json:"source"`
+	Matched bool   `json:"matched"`
+	// Match is how the entry names the principal: "exact", "prefix" or
+	// "group". Entries that would match but have expired have Match set and
+	// Matched false.
+	Match string `json:"match,omitempty"`
+}
+
+// NamespaceAncestry returns the namespaces whose ACLs apply inside the
+// namespace at path, from the outermost one down to path itself. It returns
+// nil for an empty path.
+func NamespaceAncestry(path string) []string {
+	paths := []string{}
+	for i := 1; i < len(path); i++ {
+		if path[i] == '/' {
+			paths = append(paths, path[:i])
+		}
+	}
+	if path != "" {
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	return paths
+}
+
+// VersionRetention is a per-key policy for permanently removing Inactive key
+// versions. Zero fields do not limit retention.
+type VersionRetention struct {
+	// MaxInactive is how many of the most recently deactivated versions are kept.
+	MaxInactive int `json:"max_inactive,omitempty"`
+	// InactiveDays is how many days a version is kept after it was deactivated.
+	InactiveDays int `json:"inactive_days,omitempty"`
+}
+
+// Validate makes sure the retention limits are not negative.
+func (r VersionRetention) Validate() error {
+	if r.MaxInactive < 0 || r.InactiveDays < 0 {
+		return ErrInvalidRetention
+	}
 	return nil
 }
 
@@ -543,6 +973,16 @@ const (
 	BadRequestDataCode
 	BadKeyFormatCode
 	BadPrincipalIdentifier
+	KeyConflictCode
+	PreconditionFailedCode
+	NamespaceDoesNotExistCode
+	NamespaceExistsCode
+	NamespaceNotEmptyCode
+	ACLLintFailedCode
+	AccessRequestDoesNotExistCode
+	OperationPendingCode
+	OperationDoesNotExistCode
+	KeyInTrashCode
 )
 
 // Response is the format for responses from the api server.
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"testing"
+	"time"
 
 	. "github.com/pavelzhurov/knox"
 )
@@ -116,7 +117,7 @@ func marshalUnmarshal(t *testing.T, in json.Marshaler, out json.Unmarshaler) {
 	}
 }
 func TestAccessTypeMarshaling(t *testing.T) {
-	for _, in := range []AccessType{Read, Write, Admin, None} {
+	for _, in := range []AccessType{Read, Write, Admin, None, Deny} {
 		var out AccessType
 		marshalUnmarshal(t, &in, &out)
 		if in != out {
@@ -224,6 +225,44 @@ func TestACLValidate(t *testing.T) {
 	if dupACL.Validate() == nil {
 		t.Error("dupACL should err")
 	}
+
+	a8 := Access{ID: "testmachine-untrusted", AccessType: Deny, Type: MachinePrefix}
+	denyACL := ACL([]Access{a1, a3, a8})
+	if denyACL.Validate() != nil {
+		t.Error("denyACL should be valid")
+	}
+
+	a9 := Access{ID: "testmachine", AccessType: Deny, Type: MachinePrefix}
+	grantAndDenyACL := ACL([]Access{a3, a9})
+	if grantAndDenyACL.Validate() == nil {
+		t.Error("grantAndDenyACL should err")
+	}
+}
+
+func TestAccessExpired(t *testing.T) {
+	now := time.Now()
+	permanent := Access{ID: "testuser", AccessType: Read, Type: User}
+	if permanent.Expired(now) {
+		t.Error("Access without expiry is expired")
+	}
+	temporary := Access{ID: "testuser", AccessType: Read, Type: User, ExpiresAt: now.Add(time.Hour).UnixNano()}
+	if temporary.Expired(now) {
+		t.Error("Access expired early")
+	}
+	if !temporary.Expired(now.Add(time.Hour)) {
+		t.Error("Access did not expire")
+	}
+	b, err := json.Marshal(temporary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Access
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != temporary {
+		t.Error("Unmarshaled not same as input ", temporary, out)
+	}
 }
 
 func TestACLAddMultiple(t *testing.T) {
@@ -282,6 +321,9 @@ func TestAccessTypeCanAccess(t *testing.T) {
 	if None.CanAccess(Admin) || None.CanAccess(Write) || None.CanAccess(Read) || !None.CanAccess(None) {
 		t.Error("None has incorrect access")
 	}
+	if Deny.CanAccess(Admin) || Deny.CanAccess(Write) || Deny.CanAccess(Read) || Deny.CanAccess(None) {
+		t.Error("Deny has incorrect access")
+	}
 }
 
 func TestKeyValidate(t *testing.T) {
@@ -466,3 +508,78 @@ func TestPrincipalValidation(t *testing.T) {
 	validatePrincipal(Service, "spiffe://example.com/service", true)
 	validatePrincipal(ServicePrefix, "spiffe://example.com/prefix/", true)
 }
+
+func TestParseAccessPrincipal(t *testing.T) {
+	a, err := ParseAccessPrincipal("UserGroup:security-team")
+	if err != nil || a.Type != UserGroup || a.ID != "security-team" {
+		t.Fatalf("unexpected principal %v, %v", a, err)
+	}
+	a, err = ParseAccessPrincipal("Service:spiffe://example.com/serviceA")
+	if err != nil || a.Type != Service || a.ID != "spiffe://example.com/serviceA" {
+		t.Fatalf("unexpected principal %v, %v", a, err)
+	}
+	for _, bad := range []string{"security-team", "Robot:r2d2"} {
+		if _, err := ParseAccessPrincipal(bad); err == nil {
+			t.Fatalf("expected error parsing %s", bad)
+		}
+	}
+}
+
+func TestReplacePrincipal(t *testing.T) {
+	oldHost := Access{ID: "old.example.com", AccessType: Write, Type: Machine, ExpiresAt: 10}
+	admin := Access{ID: "admin", AccessType: Admin, Type: User}
+	acl := ACL{oldHost, admin}
+	newHost := Access{ID: "new.example.com", Type: Machine}
+
+	updated, removed, added := acl.ReplacePrincipal(oldHost, &newHost)
+	migrated := Access{ID: "new.example.com", AccessType: Write, Type: Machine, ExpiresAt: 10}
+	if len(updated) != 2 || updated[0] != admin || updated[1] != migrated {
+		t.Fatalf("unexpected ACL %v", updated)
+	}
+	if len(removed) != 1 || removed[0] != oldHost || len(added) != 1 || added[0] != migrated {
+		t.Fatalf("unexpected changes %v, %v", removed, added)
+	}
+	if acl[0] != oldHost {
+		t.Fatal("the original ACL was changed")
+	}
+
+	updated, removed, added = acl.ReplacePrincipal(oldHost, nil)
+	if len(updated) != 1 || updated[0] != admin || len(removed) != 1 || added != nil {
+		t.Fatalf("unexpected removal %v, %v, %v", updated, removed, added)
+	}
+
+	updated, removed, _ = acl.ReplacePrincipal(newHost, nil)
+	if len(updated) != 2 || removed != nil {
+		t.Fatalf("unexpected change %v, %v", updated, removed)
+	}
+
+	// Merging keeps the higher access type unless either entry is a Deny.
+	readNew := Access{ID: "new.example.com", AccessType: Read, Type: Machine}
+	updated, removed, added = ACL{oldHost, readNew}.ReplacePrincipal(oldHost, &newHost)
+	if len(updated) != 1 || updated[0] != migrated || len(removed) != 2 || len(added) != 1 {
+		t.Fatalf("unexpected merge %v, %v, %v", updated, removed, added)
+	}
+	denyNew := Access{ID: "new.example.com", AccessType: Deny, Type: Machine}
+	updated, removed, added = ACL{oldHost, denyNew}.ReplacePrincipal(oldHost, &newHost)
+	if len(updated) != 1 || updated[0] != denyNew || len(removed) != 1 || added != nil {
+		t.Fatalf("unexpected merge %v, %v, %v", updated, removed, added)
+	}
+}
+
+func TestAccessRequestValidate(t *testing.T) {
+	alice := Access{ID: "alice", AccessType: Read, Type: User}
+	testCases := []struct {
+		request AccessRequest
+		err     error
+	}{
+		{AccessRequest{KeyID: "a1", Access: alice, Justification: "oncall"}, nil},
+		{AccessRequest{KeyID: "a/1", Access: alice, Justification: "oncall"}, ErrInvalidKeyID},
+		{AccessRequest{KeyID: "a1", Access: alice, Justification: " "}, ErrAccessRequestJustification},
+		{AccessRequest{KeyID: "a1", Access: Access{ID: "alice", AccessType: Deny, Type: User}, Justification: "oncall"}, ErrInvalidAccessRequest},
+	}
+	for _, tc := range testCases {
+		if err := tc.request.Validate(); err != tc.err {
+			t.Errorf("%+v: %v is not %v", tc.request, err, tc.err)
+		}
+	}
+}
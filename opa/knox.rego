@@ -0,0 +1,107 @@
+# Sample policy for knox under OPA authorization. It grants what the key's own
+# ACL grants, the same way ACL authorization does without namespaces, and lets
+# the security team administer everything from the internal network over TLS
+# 1.2 or later. See README.md for the input document it is given.
+package knox.authz
+
+import future.keywords.in
+
+default allow = false
+
+admin_group := "security-team"
+
+internal_networks := ["10.0.0.0/8", "192.168.0.0/16"]
+
+# Access types in the order they include each other.
+levels := {"Read": 1, "Write": 2, "Admin": 3}
+
+read_actions := {"GetKey", "GetACL", "GetQuorum", "GetRetention", "GetAlias"}
+
+write_actions := {"CreateVersion", "PutVersion"}
+
+# Actions on no particular key that every principal may perform. Listing keys
+# only returns the keys the principal may read.
+open_actions := {"ListKeys", "ListNamespaces", "CreateKey"}
+
+allow {
+	input.action in open_actions
+}
+
+allow {
+	input.resource.key_id
+	not input.action in open_actions
+	not denied
+	some entry in input.resource.acl
+	matches(entry)
+	live(entry)
+	levels[entry.access] >= required_level
+}
+
+allow {
+	admin_group in input.principal.groups
+	trusted_request
+}
+
+required_level := 1 {
+	input.action in read_actions
+} else := 2 {
+	input.action in write_actions
+} else := 3 {
+	true
+}
+
+denied {
+	some entry in input.resource.acl
+	entry.access == "Deny"
+	matches(entry)
+	live(entry)
+}
+
+matches(entry) {
+	entry.type == "User"
+	input.principal.type == "user"
+	entry.id == input.principal.id
+}
+
+matches(entry) {
+	entry.type == "UserGroup"
+	entry.id in input.principal.groups
+}
+
+matches(entry) {
+	entry.type == "Machine"
+	input.principal.type == "machine"
+	entry.id == input.principal.id
+}
+
+matches(entry) {
+	entry.type == "MachinePrefix"
+	input.principal.type == "machine"
+	startswith(input.principal.id, entry.id)
+}
+
+matches(entry) {
+	entry.type == "Service"
+	input.principal.type == "service"
+	entry.id == input.principal.id
+}
+
+matches(entry) {
+	entry.type == "ServicePrefix"
+	input.principal.type == "service"
+	startswith(input.principal.id, entry.id)
+}
+
+live(entry) {
+	not entry.expires_at
+}
+
+live(entry) {
+	entry.expires_at > time.now_ns()
+}
+
+trusted_request {
+	input.request.tls.version in {"1.2", "1.3"}
+	some network in internal_networks
+	net.cidr_contains(network, input.request.source_ip)
+}
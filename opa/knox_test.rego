@@ -0,0 +1,92 @@
+package knox.authz
+
+acl := [
+	{"type": "User", "id": "alice", "access": "Write"},
+	{"type": "UserGroup", "id": "payments", "access": "Read"},
+	{"type": "User", "id": "mallory", "access": "Deny"},
+	{"type": "MachinePrefix", "id": "web-", "access": "Read"},
+	{"type": "ServicePrefix", "id": "spiffe://example.com/billing/", "access": "Admin"},
+	{"type": "User", "id": "bob", "access": "Admin", "expires_at": 1},
+]
+
+key_input(principal, action) = {
+	"action": action,
+	"principal": principal,
+	"resource": {"key_id": "k1", "acl": acl},
+}
+
+alice := {"id": "alice", "type": "user", "groups": []}
+
+mallory := {"id": "mallory", "type": "user", "groups": ["payments"]}
+
+test_user_entry {
+	allow with input as key_input(alice, "GetKey")
+	allow with input as key_input(alice, "CreateVersion")
+	not allow with input as key_input(alice, "PutACL")
+}
+
+carol := {"id": "carol", "type": "user", "groups": ["payments"]}
+
+test_group_entry {
+	allow with input as key_input(carol, "GetKey")
+	not allow with input as key_input(carol, "PutVersion")
+}
+
+test_deny_overrides_grants {
+	not allow with input as key_input(mallory, "GetKey")
+}
+
+bob := {"id": "bob", "type": "user", "groups": []}
+
+test_expired_entry {
+	not allow with input as key_input(bob, "GetKey")
+}
+
+test_machine_prefix {
+	allow with input as key_input({"id": "web-01", "type": "machine", "groups": null}, "GetKey")
+	not allow with input as key_input({"id": "db-01", "type": "machine", "groups": null}, "GetKey")
+
+	# Prefix entries of one principal type do not apply to the others.
+	not allow with input as key_input({"id": "web-01", "type": "user", "groups": []}, "GetKey")
+}
+
+billing := {"id": "spiffe://example.com/billing/api", "type": "service", "groups": null}
+
+test_service_prefix {
+	allow with input as key_input(billing, "DeleteKey")
+}
+
+test_open_actions {
+	allow with input as {"action": "ListKeys", "principal": mallory, "resource": {}}
+	allow with input as {"action": "CreateKey", "principal": mallory, "resource": {"key_id": "k2"}}
+	not allow with input as {"action": "AccessReport", "principal": alice, "resource": {}}
+}
+
+admin := {"id": "erin", "type": "user", "groups": ["security-team"]}
+
+test_admin_from_internal_network {
+	allow with input as {
+		"action": "AccessReport",
+		"principal": admin,
+		"resource": {},
+		"request": {"source_ip": "10.1.2.3", "tls": {"version": "1.3"}},
+	}
+}
+
+test_admin_needs_trusted_request {
+	# Decisions made for reports rather than requests have no request.
+	not allow with input as {"action": "AccessReport", "principal": admin, "resource": {}}
+	not allow with input as {
+		"action": "AccessReport",
+		"principal": admin,
+		"resource": {},
+		"request": {"source_ip": "203.0.113.7", "tls": {"version": "1.3"}},
+	}
+
+	not allow with input as {
+		"action": "AccessReport",
+		"principal": admin,
+		"resource": {},
+		"request": {"source_ip": "10.1.2.3", "tls": {"version": "1.1"}},
+	}
+}
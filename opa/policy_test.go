@@ -0,0 +1,102 @@
+package opa_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/tester"
+	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/server/auth"
+)
+
+func TestRegoTests(t *testing.T) {
+	modules, store, err := tester.Load([]string{"."}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := tester.NewRunner().SetStore(store).Run(context.Background(), modules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for r := range ch {
+		n++
+		if r.Error != nil || r.Fail {
+			t.Errorf("%s failed: %v", r.Name, r.Error)
+		}
+	}
+	if n == 0 {
+		t.Fatal("no Rego tests were run")
+	}
+}
+
+// TestSamplePolicy evaluates the sample policy with input documents built the
+// way the server builds them, to check that it reads them correctly.
+func TestSamplePolicy(t *testing.T) {
+	ctx := context.Background()
+	query, err := rego.New(rego.Query("data.knox.authz.allow"), rego.Load([]string{"knox.rego"}, nil)).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acl := knox.ACL{
+		{Type: knox.User, ID: "alice", AccessType: knox.Write},
+		{Type: knox.UserGroup, ID: "payments", AccessType: knox.Read},
+		{Type: knox.Machine, ID: "web-01", AccessType: knox.Read},
+		{Type: knox.ServicePrefix, ID: "spiffe://example.com/billing/", AccessType: knox.Admin},
+		{Type: knox.User, ID: "mallory", AccessType: knox.Deny},
+		{Type: knox.User, ID: "bob", AccessType: knox.Read, ExpiresAt: time.Now().Add(-time.Hour).UnixNano()},
+		{Type: knox.User, ID: "carol", AccessType: knox.Read, ExpiresAt: time.Now().Add(time.Hour).UnixNano()},
+	}
+	resource := auth.OPAResource{KeyID: "k1", ACL: acl}
+	internal := &auth.OPARequest{Provider: "mtls", SourceIP: "10.0.0.5", TLS: &auth.OPATLS{Version: "1.3"}}
+	external := &auth.OPARequest{Provider: "mtls", SourceIP: "203.0.113.7", TLS: &auth.OPATLS{Version: "1.3"}}
+	admin := auth.NewUser("erin", []string{"security-team"})
+
+	for _, tc := range []struct {
+		principal knox.Principal
+		action    string
+		resource  auth.OPAResource
+		request   *auth.OPARequest
+		expected  bool
+	}{
+		{auth.NewUser("alice", nil), "GetKey", resource, internal, true},
+		{auth.NewUser("alice", nil), "PutVersion", resource, internal, true},
+		{auth.NewUser("alice", nil), "PutACL", resource, internal, false},
+		{auth.NewUser("dave", []string{"payments"}), "GetKey", resource, external, true},
+		{auth.NewUser("mallory", []string{"payments"}), "GetKey", resource, internal, false},
+		{auth.NewUser("bob", nil), "GetKey", resource, internal, false},
+		{auth.NewUser("carol", nil), "GetKey", resource, internal, true},
+		{auth.NewMachine("web-01"), "GetKey", resource, internal, true},
+		{auth.NewMachine("web-02"), "GetKey", resource, internal, false},
+		{auth.NewService("example.com", "billing/api"), "DeleteKey", resource, internal, true},
+		{auth.NewService("example.com", "shipping/api"), "GetKey", resource, internal, false},
+		{auth.NewUser("alice", nil), "ListKeys", auth.OPAResource{}, nil, true},
+		{admin, "PutACL", resource, internal, true},
+		{admin, "PutACL", resource, external, false},
+		{admin, "AccessReport", auth.OPAResource{}, nil, false},
+		{admin, "PutNamespaceACL", auth.OPAResource{Namespace: "team"}, internal, true},
+		{auth.NewUser("alice", nil), "PutNamespaceACL", auth.OPAResource{Namespace: "team"}, internal, false},
+	} {
+		// The evaluator sends the document as JSON, so the policy sees it
+		// the way it is marshaled.
+		data, err := json.Marshal(auth.OPAInput(tc.principal, "k1", tc.action, "pvc", "kms", tc.resource, tc.request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var input interface{}
+		if err := json.Unmarshal(data, &input); err != nil {
+			t.Fatal(err)
+		}
+		rs, err := query.Eval(ctx, rego.EvalInput(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := rs.Allowed(); allowed != tc.expected {
+			t.Errorf("%s %s %s: allowed is %v, not %v", tc.principal.GetID(), tc.action, tc.resource.KeyID, allowed, tc.expected)
+		}
+	}
+}
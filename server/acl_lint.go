@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pavelzhurov/knox"
+)
+
+// ACLLintRule checks the ACL of a key, acl, together with the entries it
+// inherits from the namespaces containing the key, inherited, and returns the
+// risks it finds in acl. The rule name and key ID of the findings are filled
+// in by the lint engine.
+type ACLLintRule func(acl, inherited knox.ACL) []knox.ACLLintFinding
+
+type namedACLLintRule struct {
+	name string
+	rule ACLLintRule
+}
+
+// Rules the lint engine applies to ACLs. This is by default empty and should be
+// expanded by the main function.
+var aclLintRules []namedACLLintRule
+
+// AddACLLintRule adds a rule the ACLs of keys are checked against by the ACL
+// lint report and, depending on the lint mode, when ACLs are changed.
+func AddACLLintRule(name string, rule ACLLintRule) {
+	aclLintRules = append(aclLintRules, namedACLLintRule{name, rule})
+}
+
+// ACLLintMode is what happens when an ACL change introduces lint findings.
+type ACLLintMode int
+
+const (
+	// ACLLintOff does not check ACL changes.
+	ACLLintOff ACLLintMode = iota
+	// ACLLintWarn logs the findings and returns them with the response.
+	ACLLintWarn
+	// ACLLintBlock rejects the change.
+	ACLLintBlock
+)
+
+var aclLintMode = ACLLintOff
+
+// SetACLLintMode sets what happens when an ACL change introduces lint
+// findings. The lint report is available whatever the mode.
+func SetACLLintMode(mode ACLLintMode) {
+	aclLintMode = mode
+}
+
+// lintACL applies every lint rule to the ACL of the key keyID.
+func lintACL(keyID string, acl, inherited knox.ACL) []knox.ACLLintFinding {
+	findings := []knox.ACLLintFinding{}
+	for _, r := range aclLintRules {
+		for _, f := range r.rule(acl, inherited) {
+			f.KeyID = keyID
+			f.Rule = r.name
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// newLintFindings returns the findings for the ACL updated that are not
+// findings for the ACL current as well, so that changes are only held
+// responsible for the risks they introduce.
+func newLintFindings(keyID string, current, updated, inherited knox.ACL) []knox.ACLLintFinding {
+	type findingKey struct {
+		rule, message string
+		access        knox.Access
+	}
+	key := func(f knox.ACLLintFinding) findingKey {
+		k := findingKey{rule: f.Rule, message: f.Message}
+		if f.Access != nil {
+			k.access = *f.Access
+		}
+		return k
+	}
+	existing := map[findingKey]bool{}
+	for _, f := range lintACL(keyID, current, inherited) {
+		existing[key(f)] = true
+	}
+	findings := []knox.ACLLintFinding{}
+	for _, f := range lintACL(keyID, updated, inherited) {
+		if !existing[key(f)] {
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// isGrant reports whether a gives access, as opposed to denying it.
+func isGrant(a knox.Access) bool {
+	return a.AccessType != knox.Deny && a.AccessType != knox.None
+}
+
+// ShortMachinePrefixRule finds MachinePrefix entries that give access and are
+// shorter than minLength, as they likely match many more machines than
+// intended.
+func ShortMachinePrefixRule(minLength int) ACLLintRule {
+	return func(acl, inherited knox.ACL) []knox.ACLLintFinding {
+		findings := []knox.ACLLintFinding{}
+		for _, a := range acl {
+			if a.Type == knox.MachinePrefix && isGrant(a) && len(a.ID) < minLength {
+				a := a
+				findings = append(findings, knox.ACLLintFinding{
+					Message: fmt.Sprintf("machine prefix %q is shorter than %d characters", a.ID, minLength),
+					Access:  &a,
+				})
+			}
+		}
+		return findings
+	}
+}
+
+// NoHumanAdminRule finds keys without a permanent Admin entry for a user or
+// user group, in their own ACL or inherited from their namespaces, which leaves
+// no one to manage the key.
+func NoHumanAdminRule() ACLLintRule {
+	return func(acl, inherited knox.ACL) []knox.ACLLintFinding {
+		for _, a := range append(append(knox.ACL{}, inherited...), acl...) {
+			if (a.Type == knox.User || a.Type == knox.UserGroup) && a.AccessType == knox.Admin && a.ExpiresAt == 0 {
+				return nil
+			}
+		}
+		return []knox.ACLLintFinding{{Message: "no user or user group has permanent admin access"}}
+	}
+}
+
+// LargeGroupRule finds entries that give access to one of the given user
+// groups, which are too large to be trusted with keys.
+func LargeGroupRule(groups []string) ACLLintRule {
+	large := map[string]bool{}
+	for _, g := range groups {
+		large[g] = true
+	}
+	return func(acl, inherited knox.ACL) []knox.ACLLintFinding {
+		findings := []knox.ACLLintFinding{}
+		for _, a := range acl {
+			if a.Type == knox.UserGroup && isGrant(a) && large[a.ID] {
+				a := a
+				findings = append(findings, knox.ACLLintFinding{
+					Message: fmt.Sprintf("large group %s has access", a.ID),
+					Access:  &a,
+				})
+			}
+		}
+		return findings
+	}
+}
+
+// RedundantEntryRule finds entries that give no access beyond another entry of
+// the key or of its namespaces, such as a Machine entry covered by a
+// MachinePrefix entry with the same or higher access that lasts at least as
+// long. Redundant entries keep access alive when the entry that looks
+// responsible for it is removed.
+func RedundantEntryRule() ACLLintRule {
+	return func(acl, inherited knox.ACL) []knox.ACLLintFinding {
+		all := append(append(knox.ACL{}, inherited...), acl...)
+		findings := []knox.ACLLintFinding{}
+		for i, a := range acl {
+			if !isGrant(a) {
+				continue
+			}
+			for j, b := range all {
+				if j == len(inherited)+i || !covers(b, a) {
+					continue
+				}
+				a := a
+				findings = append(findings, knox.ACLLintFinding{
+					Message: fmt.Sprintf("entry is covered by %s %s with %s access", principalTypeName(b.Type), b.ID, accessTypeName(b.AccessType)),
+					Access:  &a,
+				})
+				break
+			}
+		}
+		return findings
+	}
+}
+
+// covers reports whether the entry b gives every principal matched by the
+// entry a at least the access a gives, for at least as long.
+func covers(b, a knox.Access) bool {
+	if !isGrant(b) || b.AccessType < a.AccessType || (b.ExpiresAt != 0 && (a.ExpiresAt == 0 || b.ExpiresAt < a.ExpiresAt)) {
+		return false
+	}
+	switch b.Type {
+	case knox.MachinePrefix:
+		return (a.Type == knox.Machine || a.Type == knox.MachinePrefix) && strings.HasPrefix(a.ID, b.ID)
+	case knox.ServicePrefix:
+		return (a.Type == knox.Service || a.Type == knox.ServicePrefix) && strings.HasPrefix(a.ID, b.ID)
+	}
+	return b.Type == a.Type && b.ID == a.ID
+}
+
+// principalTypeName returns the name of a principal type as it is written in
+// JSON.
+func principalTypeName(pt knox.PrincipalType) string {
+	name, err := pt.MarshalJSON()
+	if err != nil {
+		return "Unknown"
+	}
+	return strings.Trim(string(name), `"`)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func TestACLLintRules(t *testing.T) {
+	alice := knox.Access{Type: knox.User, ID: "alice", AccessType: knox.Admin}
+	testCases := []struct {
+		name      string
+		rule      ACLLintRule
+		acl       knox.ACL
+		inherited knox.ACL
+		findings  int
+	}{
+		{"short prefix", ShortMachinePrefixRule(4), knox.ACL{{Type: knox.MachinePrefix, ID: "web", AccessType: knox.Read}}, nil, 1},
+		{"long prefix", ShortMachinePrefixRule(4), knox.ACL{{Type: knox.MachinePrefix, ID: "web-prod", AccessType: knox.Read}}, nil, 0},
+		{"denied short prefix", ShortMachinePrefixRule(4), knox.ACL{{Type: knox.MachinePrefix, ID: "w", AccessType: knox.Deny}}, nil, 0},
+		{"no human admin", NoHumanAdminRule(), knox.ACL{{Type: knox.Machine, ID: "host1", AccessType: knox.Admin}}, nil, 1},
+		{"expiring human admin", NoHumanAdminRule(), knox.ACL{{Type: knox.User, ID: "alice", AccessType: knox.Admin, ExpiresAt: 1}}, nil, 1},
+		{"human admin", NoHumanAdminRule(), knox.ACL{alice}, nil, 0},
+		{"inherited human admin", NoHumanAdminRule(), nil, knox.ACL{{Type: knox.UserGroup, ID: "oncall", AccessType: knox.Admin}}, 0},
+		{"large group", LargeGroupRule([]string{"everyone"}), knox.ACL{{Type: knox.UserGroup, ID: "everyone", AccessType: knox.Read}}, nil, 1},
+		{"small group", LargeGroupRule([]string{"everyone"}), knox.ACL{{Type: knox.UserGroup, ID: "oncall", AccessType: knox.Read}}, nil, 0},
+		{"covered machine", RedundantEntryRule(), knox.ACL{{Type: knox.MachinePrefix, ID: "web", AccessType: knox.Write}, {Type: knox.Machine, ID: "web01", AccessType: knox.Read}}, nil, 1},
+		{"machine with more access", RedundantEntryRule(), knox.ACL{{Type: knox.MachinePrefix, ID: "web", AccessType: knox.Read}, {Type: knox.Machine, ID: "web01", AccessType: knox.Write}}, nil, 0},
+		{"machine outliving prefix", RedundantEntryRule(), knox.ACL{{Type: knox.MachinePrefix, ID: "web", AccessType: knox.Write, ExpiresAt: 10}, {Type: knox.Machine, ID: "web01", AccessType: knox.Read}}, nil, 0},
+		{"inherited entry", RedundantEntryRule(), knox.ACL{alice}, knox.ACL{alice}, 1},
+	}
+	for _, tc := range testCases {
+		findings := tc.rule(tc.acl, tc.inherited)
+		if len(findings) != tc.findings {
+			t.Errorf("%s: expected %d findings, got %v", tc.name, tc.findings, findings)
+		}
+	}
+}
+
+func TestNewLintFindings(t *testing.T) {
+	defer func() { aclLintRules = nil }()
+	AddACLLintRule("short-machine-prefix", ShortMachinePrefixRule(4))
+
+	current := knox.ACL{{Type: knox.MachinePrefix, ID: "db", AccessType: knox.Read}}
+	updated := append(current, knox.Access{Type: knox.MachinePrefix, ID: "web", AccessType: knox.Read})
+	findings := newLintFindings("k1", current, updated, nil)
+	if len(findings) != 1 {
+		t.Fatalf("expected 1 finding, got %v", findings)
+	}
+	f := findings[0]
+	if f.KeyID != "k1" || f.Rule != "short-machine-prefix" || f.Access == nil || f.Access.ID != "web" {
+		t.Fatalf("unexpected finding %+v", f)
+	}
+	if findings := newLintFindings("k1", current, current, nil); len(findings) != 0 {
+		t.Fatalf("expected no findings, got %v", findings)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -54,6 +55,16 @@ var HTTPErrMap = map[int]*httpErrResp{
 	knox.BadRequestDataCode:            {http.StatusBadRequest, "Bad request format"},
 	knox.BadKeyFormatCode:              {http.StatusBadRequest, "Key ID contains unsupported characters"},
 	knox.BadPrincipalIdentifier:        {http.StatusBadRequest, "Invalid principal identifier"},
+	knox.KeyConflictCode:               {http.StatusConflict, "Key was modified concurrently"},
+	knox.PreconditionFailedCode:        {http.StatusPreconditionFailed, "Key does not match If-Match precondition"},
+	knox.NamespaceDoesNotExistCode:     {http.StatusNotFound, "Namespace does not exist"},
+	knox.NamespaceExistsCode:           {http.StatusBadRequest, "Namespace exists"},
+	knox.NamespaceNotEmptyCode:         {http.StatusConflict, "Namespace is not empty"},
+	knox.ACLLintFailedCode:             {http.StatusBadRequest, "ACL change rejected by lint rules"},
+	knox.AccessRequestDoesNotExistCode: {http.StatusNotFound, "Access request does not exist"},
+	knox.OperationPendingCode:          {http.StatusAccepted, "Operation is pending confirmation"},
+	knox.OperationDoesNotExistCode:     {http.StatusNotFound, "Pending operation does not exist"},
+	knox.KeyInTrashCode:                {http.StatusConflict, "Key identifier is held by a key in the trash"},
 }
 
 func combine(f, g func(http.HandlerFunc) http.HandlerFunc) func(http.HandlerFunc) http.HandlerFunc {
@@ -66,12 +77,14 @@ func combine(f, g func(http.HandlerFunc) http.HandlerFunc) func(http.HandlerFunc
 // All routes are declared in this file. Each handler itself takes in the db and
 // auth provider interfaces and returns a handler that the is processed through
 // the API Middleware.
+// The handlers call hooks, in order, around every change to a key.
 func GetRouter(
 	cryptor keydb.Cryptor,
 	db keydb.DB,
 	authzType authorizationType,
 	decorators [](func(http.HandlerFunc) http.HandlerFunc),
-	additionalRoutes []Route) (*mux.Router, error) {
+	additionalRoutes []Route,
+	hooks ...LifecycleHook) (*mux.Router, error) {
 
 	existingRouteIds := map[string]Route{}
 	existingRouteMethodAndPaths := map[string]map[string]Route{}
@@ -111,7 +124,7 @@ func GetRouter(
 		decorator = combine(decorators[j], decorator)
 	}
 
-	m := NewKeyManager(cryptor, db, authzType)
+	m := NewKeyManager(cryptor, db, authzType, hooks...)
 
 	r.NotFoundHandler = setupRoute("404", m)(decorator(writeErr(errF(knox.NotFoundCode, ""))))
 
@@ -187,6 +200,54 @@ func (p QueryParameter) Name() string {
 	return string(p)
 }
 
+// AuthParameter is an implementation of the Parameter interface that extracts
+// how the request was authenticated: "provider" is the name of the auth
+// provider and "expires_at" the expiry of the credential in unix nanoseconds,
+// if it has one. Authentication happens after the other parameters are parsed,
+// so these are filled in just before the handler is called.
+type AuthParameter string
+
+// Get returns the authentication detail once the request is authenticated
+func (p AuthParameter) Get(r *http.Request) (string, bool) {
+	info := getAuthInfo(r)
+	if info == nil {
+		return "", false
+	}
+	switch string(p) {
+	case "provider":
+		return info.provider, true
+	case "expires_at":
+		if info.expiresAt.IsZero() {
+			return "", false
+		}
+		return strconv.FormatInt(info.expiresAt.UnixNano(), 10), true
+	}
+	return "", false
+}
+
+// Name defines the key that this parameter maps to
+func (p AuthParameter) Name() string {
+	return string(p)
+}
+
+// HeaderParameter is an implementation of the Parameter interface that
+// extracts the value of a request header.
+type HeaderParameter string
+
+// Get returns the value of the header if it is present
+func (p HeaderParameter) Get(r *http.Request) (string, bool) {
+	val, ok := r.Header[http.CanonicalHeaderKey(string(p))]
+	if !ok || len(val) == 0 {
+		return "", false
+	}
+	return val[0], true
+}
+
+// Name represents the header name that is used as the key for this parameter
+func (p HeaderParameter) Name() string {
+	return string(p)
+}
+
 // PostParameter is an implementation of the Parameter interface that
 // extracts values embedded in the web form transmitted in the
 // request body
@@ -257,6 +318,12 @@ func writeErr(apiErr *HTTPError) http.HandlerFunc {
 	}
 }
 
+// etagged is returned by handlers to set the ETag header on their response.
+type etagged struct {
+	Data interface{}
+	ETag string
+}
+
 func writeData(w http.ResponseWriter, data interface{}) {
 	r := new(knox.Response)
 	r.Message = ""
@@ -280,7 +347,24 @@ func (r Route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	db := getDB(req)
 	principal := GetPrincipal(req)
 	ps := GetParams(req)
+	for _, p := range r.Parameters {
+		if ap, ok := p.(AuthParameter); ok {
+			if s, ok := ap.Get(req); ok {
+				if ps == nil {
+					ps = map[string]string{}
+				}
+				ps[ap.Name()] = s
+			}
+		}
+	}
+	if db != nil && db.GetAuthorizationType() == OpaAuthorization {
+		ps = withOPARequest(ps, req)
+	}
 	data, err := r.Handler(db, principal, ps)
+	if tagged, ok := data.(etagged); ok {
+		w.Header().Set("ETag", tagged.ETag)
+		data = tagged.Data
+	}
 
 	if err != nil {
 		writeErr(err)(w, req)
@@ -293,11 +377,32 @@ func (r Route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 // This is by default empty and should be expanded by the main function.
 var defaultAccess []knox.Access
 
-// AddDefaultAccess adds an access to every created key.
+// AddDefaultAccess adds an access to every created key. The entry is stored in
+// each key's ACL and outlives later changes to the default; use SetGlobalPolicy
+// for principals that should have access to every key.
 func AddDefaultAccess(a *knox.Access) {
 	defaultAccess = append(defaultAccess, *a)
 }
 
+// Principals with access to every key and namespace regardless of their ACLs.
+var globalPolicy knox.ACL
+
+// SetGlobalPolicy gives admins Admin access and readers Read access to every
+// key and namespace, replacing the previous global policy. Only the Type and ID
+// of the entries are used. The policy is checked when requests are authorized
+// with ACL authorization instead of being stored in ACLs, so changing it
+// applies to existing keys. Deny entries in ACLs still take precedence.
+func SetGlobalPolicy(admins, readers []knox.Access) {
+	policy := knox.ACL{}
+	for _, a := range admins {
+		policy = append(policy, knox.Access{Type: a.Type, ID: a.ID, AccessType: knox.Admin})
+	}
+	for _, a := range readers {
+		policy = append(policy, knox.Access{Type: a.Type, ID: a.ID, AccessType: knox.Read})
+	}
+	globalPolicy = policy
+}
+
 // Extra validators to apply on principals submitted to Knox.
 var extraPrincipalValidators []knox.PrincipalValidator
 
@@ -308,6 +413,108 @@ func AddPrincipalValidator(validator knox.PrincipalValidator) {
 	extraPrincipalValidators = append(extraPrincipalValidators, validator)
 }
 
+// Logger that audit records of changes made by admin operations are written
+// to. By default none are written; it should be set by the main function.
+var auditLogger *log.Logger
+
+// SetAuditLogger sets the logger that records of changes made by admin
+// operations, such as ACL migrations, are written to as JSON.
+func SetAuditLogger(l *log.Logger) {
+	auditLogger = l
+}
+
+// audit writes a record to the audit logger, if one is set.
+func audit(record map[string]interface{}) {
+	if auditLogger != nil {
+		auditLogger.OutputJSON(record)
+	}
+}
+
+// User groups whose members can take break-glass access to any key, and for
+// how long. By default nobody can; they should be set by the main function.
+var breakGlassGroups []string
+var breakGlassWindow = 30 * time.Minute
+
+// SetBreakGlassPolicy lets members of groups read any key for window after
+// they give a justification, without changing its ACL.
+func SetBreakGlassPolicy(groups []string, window time.Duration) {
+	breakGlassGroups = groups
+	breakGlassWindow = window
+}
+
+// Logger that break-glass grants and the reads made with them are written to,
+// kept apart from the audit logger because every record needs attention.
+var breakGlassLogger *log.Logger
+
+// SetBreakGlassLogger sets the logger that break-glass grants and reads are
+// written to as JSON.
+func SetBreakGlassLogger(l *log.Logger) {
+	breakGlassLogger = l
+}
+
+// Hooks that are notified of every break-glass grant.
+var breakGlassHooks []func(knox.BreakGlassGrant)
+
+// AddBreakGlassHook adds a hook that is called with every break-glass grant,
+// for example to page the security team. Hooks run in their own goroutine.
+func AddBreakGlassHook(hook func(knox.BreakGlassGrant)) {
+	breakGlassHooks = append(breakGlassHooks, hook)
+}
+
+// breakGlassAudit writes a high severity record to the break-glass logger, if
+// one is set.
+func breakGlassAudit(record map[string]interface{}) {
+	if breakGlassLogger != nil {
+		record["severity"] = "high"
+		breakGlassLogger.OutputJSON(record)
+	}
+}
+
+// How long access requests can be approved or denied after they are filed.
+var accessRequestTTL = 7 * 24 * time.Hour
+
+// SetAccessRequestTTL sets how long access requests wait to be approved or
+// denied before they expire.
+func SetAccessRequestTTL(ttl time.Duration) {
+	accessRequestTTL = ttl
+}
+
+// newAccessRequest creates a new AccessRequest filed by p with correctly set
+// defaults.
+func newAccessRequest(keyID string, access knox.Access, justification string, duration time.Duration, p knox.Principal) knox.AccessRequest {
+	now := time.Now().UnixNano()
+	return knox.AccessRequest{
+		ID:            uint64(rand.Int63()),
+		KeyID:         keyID,
+		Access:        access,
+		Justification: justification,
+		RequestedBy:   p.GetID(),
+		RequestedAt:   now,
+		ExpiresAt:     now + int64(accessRequestTTL),
+		Duration:      int64(duration),
+	}
+}
+
+// How long operations on keys that require quorum wait to be confirmed.
+var pendingOperationTTL = 24 * time.Hour
+
+// SetPendingOperationTTL sets how long operations on keys that require quorum
+// wait to be confirmed before they expire.
+func SetPendingOperationTTL(ttl time.Duration) {
+	pendingOperationTTL = ttl
+}
+
+// newPendingOperation creates a new PendingOperation asked for by p with
+// correctly set defaults.
+func newPendingOperation(op knox.PendingOperation, p knox.Principal) knox.PendingOperation {
+	now := time.Now().UnixNano()
+	op.ID = uint64(rand.Int63())
+	op.RequestedBy = p.GetID()
+	op.RequestedAt = now
+	op.ExpiresAt = now + int64(pendingOperationTTL)
+	return op
+}
+
 // newKeyVersion creates a new KeyVersion with correctly set defaults.
 func newKeyVersion(d []byte, s knox.VersionStatus) knox.KeyVersion {
 	version := knox.KeyVersion{}
@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/pavelzhurov/knox"
 	"github.com/pavelzhurov/knox/server/auth"
 	"github.com/pavelzhurov/knox/server/keydb"
@@ -381,3 +382,50 @@ func TestAdditionalRouteFunctionality(t *testing.T) {
 		)
 	}
 }
+
+type mockProvider struct{ name string }
+
+func (p mockProvider) Name() string  { return p.name }
+func (p mockProvider) Version() byte { return '0' }
+func (p mockProvider) Type() byte    { return 'u' }
+func (p mockProvider) Authenticate(r *http.Request) (knox.Principal, error) {
+	return auth.NewUser("testuser", []string{"testgroup"}), nil
+}
+
+func TestAuthParameter(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "testuser", "exp": expiry.Unix()}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var parameters map[string]string
+	route := Route{
+		Method: "GET",
+		Path:   "/v0/whoami/",
+		Id:     "whoami",
+		Handler: func(m KeyManager, principal knox.Principal, ps map[string]string) (interface{}, *HTTPError) {
+			parameters = ps
+			return nil, nil
+		},
+		Parameters: []Parameter{AuthParameter("provider"), AuthParameter("expires_at")},
+	}
+	handler := Authentication([]auth.Provider{mockProvider{"mtls"}, mockProvider{"jwt"}})(route.ServeHTTP)
+
+	r, err := http.NewRequest("GET", "http://www.com/v0/whoami/", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, ok := AuthParameter("provider").Get(r); ok {
+		t.Fatal("provider should not be present before authentication")
+	}
+	r.Header.Set("Authorization", token)
+	handler(httptest.NewRecorder(), r)
+
+	if parameters["provider"] != "jwt" {
+		t.Fatalf("provider %q is not jwt", parameters["provider"])
+	}
+	if parameters["expires_at"] != fmt.Sprint(expiry.UnixNano()) {
+		t.Fatalf("expires_at %q is not %d", parameters["expires_at"], expiry.UnixNano())
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
@@ -378,23 +379,116 @@ func (u user) Type() string {
 }
 
 // CanAccess determines if a User can access an object represented by the ACL
-// with a certain AccessType. It compares LDAP username and LDAP group.
+// with a certain AccessType. It compares LDAP username and LDAP group. A Deny
+// entry for the user or one of their groups overrides every grant.
 func (u user) CanAccess(acl knox.ACL, t knox.AccessType) bool {
+	return canAccess(acl, t, func(a knox.Access) bool { return u.match(a) != "" })
+}
+
+// match reports how the ACL entry a applies to the user, if it does.
+func (u user) match(a knox.Access) string {
+	switch a.Type {
+	case knox.User:
+		if a.ID == u.ID {
+			return MatchExact
+		}
+	case knox.UserGroup:
+		if u.inGroup(a.ID) {
+			return MatchGroup
+		}
+	}
+	return ""
+}
+
+// These describe how an ACL entry applies to a principal.
+const (
+	// MatchExact means the entry names the principal itself.
+	MatchExact = "exact"
+	// MatchPrefix means the entry names a prefix of the principal's ID.
+	MatchPrefix = "prefix"
+	// MatchGroup means the entry names a group the user is a member of.
+	MatchGroup = "group"
+)
+
+// MatchAccess reports how the ACL entry a applies to principal: MatchExact,
+// MatchPrefix or MatchGroup, or "" if it does not apply. Whether the entry has
+// expired is not considered.
+func MatchAccess(principal knox.Principal, a knox.Access) string {
+	switch p := principal.(type) {
+	case user:
+		return p.match(a)
+	case machine:
+		return p.match(a)
+	case service:
+		return p.match(a)
+	}
+	return ""
+}
+
+// Groups returns the sorted groups of a user principal, or nil for other
+// principals.
+func Groups(principal knox.Principal) []string {
+	u, ok := principal.(user)
+	if !ok {
+		return nil
+	}
+	groups := []string{}
+	for g := range u.groups {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// ParsePrincipal creates the principal written as Type:ID, where Type is User,
+// Machine or Service, such as Machine:host1.example.com or
+// Service:spiffe://example.com/serviceA. Users are given groups. UserGroup:name
+// creates a user without an ID that is only a member of the group name, which
+// has the access granted to the group.
+func ParsePrincipal(s string, groups []string) (knox.Principal, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("principal %q is not of the form Type:ID", s)
+	}
+	switch parts[0] {
+	case "User":
+		return NewUser(parts[1], groups), nil
+	case "UserGroup":
+		return NewUser("", []string{parts[1]}), nil
+	case "Machine":
+		return NewMachine(parts[1]), nil
+	case "Service":
+		return spiffeToPrincipal([]string{parts[1]})
+	}
+	return nil, fmt.Errorf("principal %q has unknown type %q", s, parts[0])
+}
+
+// canAccess determines if the entries of acl that match a principal grant it
+// the AccessType t. Deny entries take precedence: if any matching entry is a
+// Deny, access is refused regardless of the order of the entries or of how
+// specific the matching grants are. Expired entries, grants and denials alike,
+// are ignored.
+func canAccess(acl knox.ACL, t knox.AccessType, matches func(knox.Access) bool) bool {
+	now := time.Now()
+	granted := false
 	for _, a := range acl {
-		switch a.Type {
-		case knox.User:
-			if a.ID == u.ID && a.AccessType.CanAccess(t) {
-				return true
-			}
-		case knox.UserGroup:
-			if u.inGroup(a.ID) && a.AccessType.CanAccess(t) {
-				return true
-			}
+		if a.Expired(now) || !matches(a) {
+			continue
+		}
+		if a.AccessType == knox.Deny {
+			return false
+		}
+		if a.AccessType.CanAccess(t) {
+			granted = true
 		}
 	}
-	return false
+	return granted
 }
 
+// CanAccessOPA asks the OPA server of authenticator whether principal may
+// perform action on path, sending only the fields of the input document that
+// do not describe the key or the request. The knox server decides with the
+// full OPAInput document through an OPAEvaluator instead.
 func CanAccessOPA(principal knox.Principal, authenticator *authz_utils.Authenticator, path, action, partition, service string) bool {
 	result, err := authenticator.Authz(partition, service, principal.GetID(), action, path, nil)
 
@@ -424,21 +518,25 @@ func (m machine) Type() string {
 
 // CanAccess determines if a Machine can access an object represented by the ACL
 // with a certain AccessType. It compares Machine hostname and hostname prefix.
+// A Deny entry for the hostname or a matching prefix overrides every grant.
 func (m machine) CanAccess(acl knox.ACL, t knox.AccessType) bool {
-	for _, a := range acl {
-		switch a.Type {
-		case knox.Machine:
-			if a.ID == string(m) && a.AccessType.CanAccess(t) {
-				return true
-			}
-		case knox.MachinePrefix:
-			// TODO(devinlundberg): Investigate security implications of this
-			if strings.HasPrefix(string(m), a.ID) && a.AccessType.CanAccess(t) {
-				return true
-			}
+	return canAccess(acl, t, func(a knox.Access) bool { return m.match(a) != "" })
+}
+
+// match reports how the ACL entry a applies to the machine, if it does.
+func (m machine) match(a knox.Access) string {
+	switch a.Type {
+	case knox.Machine:
+		if a.ID == string(m) {
+			return MatchExact
+		}
+	case knox.MachinePrefix:
+		// TODO(devinlundberg): Investigate security implications of this
+		if strings.HasPrefix(string(m), a.ID) {
+			return MatchPrefix
 		}
 	}
-	return false
+	return ""
 }
 
 func (m machine) CanAccessOPA(authenticator *authz_utils.Authenticator, path, action, partition, service string) bool {
@@ -462,21 +560,25 @@ func (s service) Type() string {
 }
 
 // CanAccess determines if a Service can access an object represented by the ACL
-// with a certain AccessType. It compares Service id and id prefix.
+// with a certain AccessType. It compares Service id and id prefix. A Deny
+// entry for the id or a matching prefix overrides every grant.
 func (s service) CanAccess(acl knox.ACL, t knox.AccessType) bool {
-	for _, a := range acl {
-		switch a.Type {
-		case knox.Service:
-			if a.ID == string(s.GetID()) && a.AccessType.CanAccess(t) {
-				return true
-			}
-		case knox.ServicePrefix:
-			if strings.HasPrefix(s.GetID(), a.ID) && a.AccessType.CanAccess(t) {
-				return true
-			}
+	return canAccess(acl, t, func(a knox.Access) bool { return s.match(a) != "" })
+}
+
+// match reports how the ACL entry a applies to the service, if it does.
+func (s service) match(a knox.Access) string {
+	switch a.Type {
+	case knox.Service:
+		if a.ID == s.GetID() {
+			return MatchExact
+		}
+	case knox.ServicePrefix:
+		if strings.HasPrefix(s.GetID(), a.ID) {
+			return MatchPrefix
 		}
 	}
-	return false
+	return ""
 }
 
 func (s service) CanAccessOPA(authenticator *authz_utils.Authenticator, path, action, partition, service string) bool {
@@ -3,8 +3,10 @@ package auth
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"net/http"
+	"net/url"
 	"time"
 
 	"testing"
@@ -95,6 +97,80 @@ func TestServiceCanAccess(t *testing.T) {
 	}
 }
 
+func TestDenyPrecedence(t *testing.T) {
+	u := NewUser("test", []string{"group"})
+	userGrant := knox.Access{ID: "test", AccessType: knox.Admin, Type: knox.User}
+	groupDeny := knox.Access{ID: "group", AccessType: knox.Deny, Type: knox.UserGroup}
+	if u.CanAccess(knox.ACL{userGrant, groupDeny}, knox.Read) {
+		t.Error("user can access through a grant despite a group deny")
+	}
+	if u.CanAccess(knox.ACL{groupDeny, userGrant}, knox.Read) {
+		t.Error("deny precedence depends on ACL order")
+	}
+	if u.CanAccess(knox.ACL{groupDeny}, knox.None) {
+		t.Error("denied user has None access")
+	}
+	otherDeny := knox.Access{ID: "other", AccessType: knox.Deny, Type: knox.User}
+	if !u.CanAccess(knox.ACL{userGrant, otherDeny}, knox.Admin) {
+		t.Error("deny for another user takes access away")
+	}
+
+	webGrant := knox.Access{ID: "web", AccessType: knox.Read, Type: knox.MachinePrefix}
+	untrustedDeny := knox.Access{ID: "web-untrusted-", AccessType: knox.Deny, Type: knox.MachinePrefix}
+	acl := knox.ACL{webGrant, untrustedDeny}
+	if !machine("web001").CanAccess(acl, knox.Read) {
+		t.Error("machine can't access through a prefix grant")
+	}
+	if machine("web-untrusted-001").CanAccess(acl, knox.Read) {
+		t.Error("machine can access despite a prefix deny")
+	}
+	hostDeny := knox.Access{ID: "web002", AccessType: knox.Deny, Type: knox.Machine}
+	if machine("web002").CanAccess(knox.ACL{webGrant, hostDeny}, knox.Read) {
+		t.Error("machine can access despite a hostname deny")
+	}
+
+	s := NewService("example.com", "ns/serviceA")
+	nsGrant := knox.Access{ID: "spiffe://example.com/ns/", AccessType: knox.Write, Type: knox.ServicePrefix}
+	serviceDeny := knox.Access{ID: "spiffe://example.com/ns/serviceA", AccessType: knox.Deny, Type: knox.Service}
+	if s.CanAccess(knox.ACL{nsGrant, serviceDeny}, knox.Read) {
+		t.Error("service can access despite a service deny")
+	}
+	if !NewService("example.com", "ns/serviceB").CanAccess(knox.ACL{nsGrant, serviceDeny}, knox.Write) {
+		t.Error("service can't access through a prefix grant")
+	}
+}
+
+func TestExpiredAccess(t *testing.T) {
+	u := NewUser("test", []string{"group"})
+	past := time.Now().Add(-time.Minute).UnixNano()
+	future := time.Now().Add(time.Hour).UnixNano()
+
+	expiredGrant := knox.Access{ID: "test", AccessType: knox.Read, Type: knox.User, ExpiresAt: past}
+	if u.CanAccess(knox.ACL{expiredGrant}, knox.Read) {
+		t.Error("user can access through an expired grant")
+	}
+	temporaryGrant := knox.Access{ID: "test", AccessType: knox.Read, Type: knox.User, ExpiresAt: future}
+	if !u.CanAccess(knox.ACL{temporaryGrant}, knox.Read) {
+		t.Error("user can't access through a grant that has not expired")
+	}
+	expiredDeny := knox.Access{ID: "group", AccessType: knox.Deny, Type: knox.UserGroup, ExpiresAt: past}
+	if !u.CanAccess(knox.ACL{temporaryGrant, expiredDeny}, knox.Read) {
+		t.Error("expired deny takes access away")
+	}
+
+	m := machine("test001")
+	expiredPrefix := knox.Access{ID: "test", AccessType: knox.Admin, Type: knox.MachinePrefix, ExpiresAt: past}
+	if m.CanAccess(knox.ACL{expiredPrefix}, knox.Read) {
+		t.Error("machine can access through an expired grant")
+	}
+
+	s := NewService("example.com", "serviceA")
+	expiredService := knox.Access{ID: "spiffe://example.com/serviceA", AccessType: knox.Read, Type: knox.Service, ExpiresAt: past}
+	if s.CanAccess(knox.ACL{expiredService}, knox.Read) {
+		t.Error("service can access through an expired grant")
+	}
+}
+
 const caCert = `-----BEGIN CERTIFICATE-----
 MIICOjCCAeCgAwIBAgIUIKkBZQbtx8rVaWIOhpabkqZSqecwCgYIKoZIzj0EAwIw
 aTELMAkGA1UEBhMCVVMxEzARBgNVBAgTCkNhbGlmb3JuaWExFjAUBgNVBAcTDVNh
@@ -338,3 +414,94 @@ func TestSpiffeFallbackSuccess(t *testing.T) {
 	}
 	testSpiffeAuthFlow(t, "0sspiffe://example.com/service", &a)
 }
+
+func TestMatchAccess(t *testing.T) {
+	u := NewUser("alice", []string{"oncall"})
+	m := NewMachine("host1.example.com")
+	s := NewService("example.com", "serviceA")
+	cases := []struct {
+		p     knox.Principal
+		a     knox.Access
+		match string
+	}{
+		{u, knox.Access{Type: knox.User, ID: "alice"}, MatchExact},
+		{u, knox.Access{Type: knox.UserGroup, ID: "oncall"}, MatchGroup},
+		{u, knox.Access{Type: knox.Machine, ID: "alice"}, ""},
+		{m, knox.Access{Type: knox.Machine, ID: "host1.example.com"}, MatchExact},
+		{m, knox.Access{Type: knox.MachinePrefix, ID: "host"}, MatchPrefix},
+		{s, knox.Access{Type: knox.Service, ID: "spiffe://example.com/serviceA"}, MatchExact},
+		{s, knox.Access{Type: knox.ServicePrefix, ID: "spiffe://example.com/"}, MatchPrefix},
+		{s, knox.Access{Type: knox.ServicePrefix, ID: "spiffe://other.com/"}, ""},
+	}
+	for _, c := range cases {
+		if match := MatchAccess(c.p, c.a); match != c.match {
+			t.Fatalf("%s matched %v as %q not %q", c.p.GetID(), c.a, match, c.match)
+		}
+	}
+}
+
+func TestParsePrincipal(t *testing.T) {
+	p, err := ParsePrincipal("User:alice", []string{"oncall"})
+	if err != nil || !IsUser(p) || p.GetID() != "alice" || len(Groups(p)) != 1 {
+		t.Fatalf("unexpected principal %v, %v", p, err)
+	}
+	p, err = ParsePrincipal("Machine:host1.example.com", nil)
+	if err != nil || p.GetID() != "host1.example.com" || p.Type() != "machine" {
+		t.Fatalf("unexpected principal %v, %v", p, err)
+	}
+	p, err = ParsePrincipal("Service:spiffe://example.com/serviceA", nil)
+	if err != nil || !IsService(p) || p.GetID() != "spiffe://example.com/serviceA" {
+		t.Fatalf("unexpected principal %v, %v", p, err)
+	}
+	p, err = ParsePrincipal("UserGroup:oncall", nil)
+	if err != nil || !p.CanAccess(knox.ACL{{Type: knox.UserGroup, ID: "oncall", AccessType: knox.Read}}, knox.Read) {
+		t.Fatalf("unexpected principal %v, %v", p, err)
+	}
+	for _, bad := range []string{"alice", "User:", "Robot:r2d2", "Service:example.com/serviceA"} {
+		if _, err := ParsePrincipal(bad, nil); err == nil {
+			t.Fatalf("expected error parsing %s", bad)
+		}
+	}
+}
+
+func TestNewOPATLS(t *testing.T) {
+	if NewOPATLS(nil) != nil {
+		t.Fatal("expected no TLS details for a plain connection")
+	}
+	u, _ := url.Parse("spiffe://example.com/serviceA")
+	cert := &x509.Certificate{
+		Subject: pkix.Name{CommonName: "host1.example.com"},
+		Issuer:  pkix.Name{CommonName: "Example CA"},
+		URIs:    []*url.URL{u},
+	}
+	d := NewOPATLS(&tls.ConnectionState{
+		Version:          tls.VersionTLS12,
+		CipherSuite:      tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		PeerCertificates: []*x509.Certificate{cert},
+	})
+	if d.Version != "1.2" || d.CipherSuite != "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256" {
+		t.Fatalf("unexpected connection details %+v", d)
+	}
+	if d.ClientSubject != "CN=host1.example.com" || d.ClientIssuer != "CN=Example CA" || len(d.ClientURIs) != 1 || d.ClientURIs[0] != u.String() {
+		t.Fatalf("unexpected client certificate details %+v", d)
+	}
+}
+
+func TestOPAInput(t *testing.T) {
+	m := NewMachine("host1.example.com")
+	input := OPAInput(m, "team/k1", "GetKey", "pvc", "kms", OPAResource{KeyID: "k1", Namespace: "team"}, nil)
+	if input["user"] != "host1.example.com" || input["path"] != "team/k1" || input["action"] != "GetKey" {
+		t.Fatalf("unexpected legacy fields in %v", input)
+	}
+	principal := input["principal"].(map[string]interface{})
+	if principal["type"] != "machine" || principal["groups"].([]string) != nil {
+		t.Fatalf("unexpected principal %v", principal)
+	}
+	if _, ok := input["request"]; ok {
+		t.Fatal("expected no request for a decision without one")
+	}
+	input = OPAInput(m, "k1", "GetKey", "pvc", "kms", OPAResource{KeyID: "k1"}, &OPARequest{SourceIP: "10.0.0.5"})
+	if input["request"].(*OPARequest).SourceIP != "10.0.0.5" {
+		t.Fatalf("unexpected request in %v", input)
+	}
+}
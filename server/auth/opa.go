@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+// OPAResource is the key or namespace an authorization decision is about.
+type OPAResource struct {
+	// KeyID is empty for decisions about a namespace itself.
+	KeyID     string `json:"key_id,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// ACL is the key's own ACL, without the entries it inherits.
+	ACL knox.ACL `json:"acl,omitempty"`
+}
+
+// OPARequest describes the HTTP request an authorization decision is made for.
+type OPARequest struct {
+	// Provider is the name of the auth provider that authenticated the
+	// principal.
+	Provider string `json:"provider,omitempty"`
+	// SourceIP is the address of the connection the request came in on.
+	// Forwarding headers are not trusted.
+	SourceIP string  `json:"source_ip,omitempty"`
+	TLS      *OPATLS `json:"tls,omitempty"`
+}
+
+// OPATLS describes the TLS connection a request came in on.
+type OPATLS struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ServerName  string `json:"server_name,omitempty"`
+	// The client certificate fields are empty if the client did not present
+	// one.
+	ClientSubject string   `json:"client_subject,omitempty"`
+	ClientIssuer  string   `json:"client_issuer,omitempty"`
+	ClientURIs    []string `json:"client_uris,omitempty"`
+}
+
+// NewOPATLS describes the TLS connection state cs, or returns nil if the
+// request did not use TLS.
+func NewOPATLS(cs *tls.ConnectionState) *OPATLS {
+	if cs == nil {
+		return nil
+	}
+	t := &OPATLS{
+		Version:     tlsVersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ServerName:  cs.ServerName,
+	}
+	if len(cs.PeerCertificates) > 0 {
+		cert := cs.PeerCertificates[0]
+		t.ClientSubject = cert.Subject.String()
+		t.ClientIssuer = cert.Issuer.String()
+		for _, u := range cert.URIs {
+			t.ClientURIs = append(t.ClientURIs, u.String())
+		}
+	}
+	return t
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "1.0"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// OPAInput returns the input document OPA policies are evaluated with to decide
+// whether principal may perform action on the resource at path, the key's
+// namespace path and ID joined by a slash. The fields the authenticator of
+// authz-utils sends are kept for existing policies, with those from its
+// AUTHZ_* configuration read from the environment the same way. request is nil
+// for decisions that are not about the principal's own request, such as access
+// reports. The schema is documented in opa/README.md.
+func OPAInput(principal knox.Principal, path, action, partition, service string, resource OPAResource, request *OPARequest) map[string]interface{} {
+	input := map[string]interface{}{
+		"partition":   partition,
+		"service":     service,
+		"region":      os.Getenv("AUTHZ_REGION"),
+		"account_id":  os.Getenv("AUTHZ_ACCOUNT_ID"),
+		"project_id":  os.Getenv("AUTHZ_PROJECT_ID"),
+		"resource_id": os.Getenv("AUTHZ_RESOURCE_ID"),
+		"path":        path,
+		"action":      action,
+		"tags":        nil,
+		"user":        principal.GetID(),
+		"principal": map[string]interface{}{
+			"id":     principal.GetID(),
+			"type":   principal.Type(),
+			"groups": Groups(principal),
+		},
+		"resource": resource,
+	}
+	if request != nil {
+		input["request"] = request
+	}
+	return input
+}
+
+// OPAEvaluator decides whether the OPA policy allows what an input document
+// describes.
+type OPAEvaluator interface {
+	Eval(input map[string]interface{}) (bool, error)
+}
+
+// HTTPOPAEvaluator posts input documents to an OPA server, which answers with
+// a bare JSON boolean, the same way the authenticator of authz-utils does.
+type HTTPOPAEvaluator struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPOPAEvaluatorFromEnv builds an HTTPOPAEvaluator from the configuration
+// the authenticator of authz-utils reads: AUTHZ_OPA_URL, AUTHZ_OPA_TIMEOUT in
+// milliseconds and AUTHZ_OPA_INSECURE.
+func NewHTTPOPAEvaluatorFromEnv() (*HTTPOPAEvaluator, error) {
+	url, ok := os.LookupEnv("AUTHZ_OPA_URL")
+	if !ok {
+		return nil, fmt.Errorf("AUTHZ_OPA_URL is not set")
+	}
+	client := &http.Client{}
+	if s, ok := os.LookupEnv("AUTHZ_OPA_TIMEOUT"); ok {
+		ms, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("AUTHZ_OPA_TIMEOUT is not a number: %w", err)
+		}
+		client.Timeout = time.Duration(ms) * time.Millisecond
+	}
+	if s, ok := os.LookupEnv("AUTHZ_OPA_INSECURE"); ok {
+		insecure, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("AUTHZ_OPA_INSECURE is not a boolean: %w", err)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}}
+	}
+	return &HTTPOPAEvaluator{URL: url, Client: client}, nil
+}
+
+// Eval posts input to the OPA server and returns its decision.
+func (e *HTTPOPAEvaluator) Eval(input map[string]interface{}) (bool, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return false, err
+	}
+	resp, err := e.Client.Post(e.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("OPA request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read OPA response: %w", err)
+	}
+	var allowed bool
+	if err := json.Unmarshal(data, &allowed); err != nil {
+		return false, fmt.Errorf("failed to unmarshal OPA response: %w", err)
+	}
+	return allowed, nil
+}
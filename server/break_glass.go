@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/log"
+)
+
+// BreakGlassArchive keeps expired break-glass grants after they are removed
+// from their keys, for the break-glass report.
+type BreakGlassArchive interface {
+	Add(grants []knox.BreakGlassGrant) error
+	// All returns the archived grants in the order they were added.
+	All() ([]knox.BreakGlassGrant, error)
+}
+
+// FileBreakGlassArchive is a BreakGlassArchive that appends grants to a file
+// as JSON, one grant per line.
+type FileBreakGlassArchive struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileBreakGlassArchive returns an archive that keeps grants in the file at
+// path, which is created when the first grant is added.
+func NewFileBreakGlassArchive(path string) *FileBreakGlassArchive {
+	return &FileBreakGlassArchive{path: path}
+}
+
+// Add appends grants to the file.
+func (a *FileBreakGlassArchive) Add(grants []knox.BreakGlassGrant) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, g := range grants {
+		if err := enc.Encode(g); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// All reads every grant in the file.
+func (a *FileBreakGlassArchive) All() ([]knox.BreakGlassGrant, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	grants := []knox.BreakGlassGrant{}
+	f, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return grants, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var g knox.BreakGlassGrant
+		if err := json.Unmarshal(s.Bytes(), &g); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, s.Err()
+}
+
+// Archive that expired break-glass grants are kept in. Without one, they are
+// only written to the break-glass logger.
+var breakGlassArchive BreakGlassArchive
+
+// SetBreakGlassArchive sets the archive expired break-glass grants are kept in.
+func SetBreakGlassArchive(a BreakGlassArchive) {
+	breakGlassArchive = a
+}
+
+// ArchiveBreakGlassGrants writes grants that were removed from their keys
+// after they expired to the break-glass logger and adds them to the archive,
+// if one is set. It is passed to keydb.StartBreakGlassExpiry.
+func ArchiveBreakGlassGrants(grants []knox.BreakGlassGrant) {
+	for _, g := range grants {
+		breakGlassAudit(map[string]interface{}{
+			"type":          "break_glass_expired",
+			"principal":     g.Principal,
+			"key_id":        g.KeyID,
+			"justification": g.Justification,
+			"granted_at":    g.GrantedAt,
+			"expires_at":    g.ExpiresAt,
+		})
+	}
+	if breakGlassArchive == nil {
+		return
+	}
+	if err := breakGlassArchive.Add(grants); err != nil {
+		log.Println("Failed to archive break-glass grants: " + err.Error())
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/context"
 	"github.com/pavelzhurov/knox"
 	"github.com/pavelzhurov/knox/log"
@@ -19,6 +21,7 @@ const (
 	paramsContext
 	dbContext
 	idContext
+	authContext
 )
 
 // GetAPIError gets the HTTP error that will be returned from the server.
@@ -45,6 +48,23 @@ func setPrincipal(r *http.Request, val knox.Principal) {
 	context.Set(r, principalContext, val)
 }
 
+// authInfo records how the principal of a request was authenticated.
+type authInfo struct {
+	provider  string
+	expiresAt time.Time
+}
+
+func getAuthInfo(r *http.Request) *authInfo {
+	if rv := context.Get(r, authContext); rv != nil {
+		return rv.(*authInfo)
+	}
+	return nil
+}
+
+func setAuthInfo(r *http.Request, val *authInfo) {
+	context.Set(r, authContext, val)
+}
+
 // GetParams gets the parameters for the request through the parameters context.
 func GetParams(r *http.Request) map[string]string {
 	if rv := context.Get(r, paramsContext); rv != nil {
@@ -216,12 +236,33 @@ func Authentication(providers []auth.Provider) func(http.HandlerFunc) http.Handl
 			}
 
 			setPrincipal(r, principal)
+			setAuthInfo(r, &authInfo{providers[providerType].Name(), credentialExpiry(r, providerType)})
 			f(w, r)
 			return
 		}
 	}
 }
 
+// credentialExpiry returns when the credential a request was authenticated
+// with expires: the exp claim of a JWT or the end of the client certificate's
+// validity. It returns the zero time if the credential does not say.
+func credentialExpiry(r *http.Request, providerType ProviderType) time.Time {
+	if providerType == JWTProviderType {
+		claims := jwt.MapClaims{}
+		if _, _, err := new(jwt.Parser).ParseUnverified(r.Header.Get("Authorization"), claims); err != nil {
+			return time.Time{}
+		}
+		if exp, ok := claims["exp"].(float64); ok {
+			return time.Unix(int64(exp), 0)
+		}
+		return time.Time{}
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) != 0 {
+		return r.TLS.PeerCertificates[0].NotAfter
+	}
+	return time.Time{}
+}
+
 func getProvider(r *http.Request) ProviderType {
 	// If request contain JWT token, it'll be authorized using that token
 	if jwtHeader := r.Header.Get("Authorization"); jwtHeader != "" {
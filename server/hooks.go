@@ -0,0 +1,107 @@
+package server
+
+import (
+	"github.com/pavelzhurov/knox"
+)
+
+// LifecycleHook lets servers that embed knox intercept changes to keys made
+// through the API, for example to enforce naming rules, fill in new keys or
+// react to rotations.
+//
+// The Pre methods run once the request is authorized and valid, right before
+// the change is stored. For keys that require quorum, that is when the pending
+// operation is confirmed, not when it is filed, so a hook that rejects the
+// change fails the confirmation and the operation stays pending. Returning an
+// error rejects the change and sends the error to the client; its Subcode must be one of the knox error codes, such as
+// knox.BadRequestDataCode or knox.UnauthorizedCode. PreCreate may modify the
+// key before it is stored.
+//
+// The Post methods run after the change is stored, including when a pending
+// operation is confirmed, and are passed the principal that made it. Embed
+// NopLifecycleHook to implement only some of the methods. Hooks are given to
+// GetRouter, so every router calls only its own.
+type LifecycleHook interface {
+	PreCreate(principal knox.Principal, key *knox.Key) *HTTPError
+	PostCreate(principal knox.Principal, key *knox.Key)
+	PreAddVersion(principal knox.Principal, keyID string, version *knox.KeyVersion) *HTTPError
+	PostAddVersion(principal knox.Principal, keyID string, version *knox.KeyVersion)
+	PreUpdateVersion(principal knox.Principal, keyID string, versionID uint64, status knox.VersionStatus) *HTTPError
+	PostUpdateVersion(principal knox.Principal, keyID string, versionID uint64, status knox.VersionStatus)
+	PreUpdateAccess(principal knox.Principal, keyID string, acl knox.ACL) *HTTPError
+	PostUpdateAccess(principal knox.Principal, keyID string, acl knox.ACL)
+	// PreDelete and PostDelete are passed whether the key is purged rather
+	// than moved to the trash.
+	PreDelete(principal knox.Principal, keyID string, purge bool) *HTTPError
+	PostDelete(principal knox.Principal, keyID string, purge bool)
+	// PreRename and PostRename run when a key moves to newID, which becomes
+	// reachable like a new key, while keyID is left as an alias of it.
+	PreRename(principal knox.Principal, keyID, newID string) *HTTPError
+	PostRename(principal knox.Principal, keyID, newID string)
+	// PreAddAlias and PostAddAlias run when an alias makes a key reachable
+	// at alias.ID.
+	PreAddAlias(principal knox.Principal, alias *knox.KeyAlias) *HTTPError
+	PostAddAlias(principal knox.Principal, alias *knox.KeyAlias)
+}
+
+// NopLifecycleHook is a LifecycleHook that does nothing.
+type NopLifecycleHook struct{}
+
+func (NopLifecycleHook) PreCreate(knox.Principal, *knox.Key) *HTTPError {
+	return nil
+}
+
+func (NopLifecycleHook) PostCreate(knox.Principal, *knox.Key) {}
+
+func (NopLifecycleHook) PreAddVersion(knox.Principal, string, *knox.KeyVersion) *HTTPError {
+	return nil
+}
+
+func (NopLifecycleHook) PostAddVersion(knox.Principal, string, *knox.KeyVersion) {}
+
+func (NopLifecycleHook) PreUpdateVersion(knox.Principal, string, uint64, knox.VersionStatus) *HTTPError {
+	return nil
+}
+
+func (NopLifecycleHook) PostUpdateVersion(knox.Principal, string, uint64, knox.VersionStatus) {}
+
+func (NopLifecycleHook) PreUpdateAccess(knox.Principal, string, knox.ACL) *HTTPError {
+	return nil
+}
+
+func (NopLifecycleHook) PostUpdateAccess(knox.Principal, string, knox.ACL) {}
+
+func (NopLifecycleHook) PreDelete(knox.Principal, string, bool) *HTTPError {
+	return nil
+}
+
+func (NopLifecycleHook) PostDelete(knox.Principal, string, bool) {}
+
+func (NopLifecycleHook) PreRename(knox.Principal, string, string) *HTTPError {
+	return nil
+}
+
+func (NopLifecycleHook) PostRename(knox.Principal, string, string) {}
+
+func (NopLifecycleHook) PreAddAlias(knox.Principal, *knox.KeyAlias) *HTTPError {
+	return nil
+}
+
+func (NopLifecycleHook) PostAddAlias(knox.Principal, *knox.KeyAlias) {}
+
+// runPreHooks calls pre for every lifecycle hook of m until one rejects the
+// change, and returns its error.
+func runPreHooks(m KeyManager, pre func(LifecycleHook) *HTTPError) *HTTPError {
+	for _, h := range m.GetLifecycleHooks() {
+		if err := pre(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPostHooks calls post for every lifecycle hook of m.
+func runPostHooks(m KeyManager, post func(LifecycleHook)) {
+	for _, h := range m.GetLifecycleHooks() {
+		post(h)
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/server/auth"
+	"github.com/pavelzhurov/knox/server/keydb"
+)
+
+// namingHook only allows keys, renamed keys and aliases named svc-*, gives Read access to new keys to
+// the auditors group, refuses to purge keys, and records the changes made.
+type namingHook struct {
+	NopLifecycleHook
+	calls []string
+}
+
+func (h *namingHook) PreCreate(principal knox.Principal, key *knox.Key) *HTTPError {
+	if !strings.HasPrefix(key.ID, "svc-") {
+		return &HTTPError{knox.BadRequestDataCode, "key IDs must start with svc-"}
+	}
+	key.ACL = key.ACL.Add(knox.Access{Type: knox.UserGroup, ID: "auditors", AccessType: knox.Read})
+	return nil
+}
+
+func (h *namingHook) PostCreate(principal knox.Principal, key *knox.Key) {
+	h.calls = append(h.calls, "create "+key.ID+" by "+principal.GetID())
+}
+
+func (h *namingHook) PostAddVersion(principal knox.Principal, keyID string, version *knox.KeyVersion) {
+	h.calls = append(h.calls, fmt.Sprintf("add version %d to %s", version.ID, keyID))
+}
+
+func (h *namingHook) PostUpdateVersion(principal knox.Principal, keyID string, versionID uint64, status knox.VersionStatus) {
+	s, _ := status.MarshalJSON()
+	h.calls = append(h.calls, fmt.Sprintf("update version %d of %s to %s", versionID, keyID, s))
+}
+
+func (h *namingHook) PostUpdateAccess(principal knox.Principal, keyID string, acl knox.ACL) {
+	h.calls = append(h.calls, fmt.Sprintf("update access of %s by %s", keyID, principal.GetID()))
+}
+
+func (h *namingHook) PreDelete(principal knox.Principal, keyID string, purge bool) *HTTPError {
+	if purge {
+		return &HTTPError{knox.UnauthorizedCode, "keys can not be purged"}
+	}
+	return nil
+}
+
+func (h *namingHook) PostDelete(principal knox.Principal, keyID string, purge bool) {
+	h.calls = append(h.calls, "delete "+keyID)
+}
+
+func (h *namingHook) PreRename(principal knox.Principal, keyID, newID string) *HTTPError {
+	if !strings.HasPrefix(newID, "svc-") {
+		return &HTTPError{knox.BadRequestDataCode, "key IDs must start with svc-"}
+	}
+	return nil
+}
+
+func (h *namingHook) PostRename(principal knox.Principal, keyID, newID string) {
+	h.calls = append(h.calls, "rename "+keyID+" to "+newID)
+}
+
+func (h *namingHook) PreAddAlias(principal knox.Principal, alias *knox.KeyAlias) *HTTPError {
+	if !strings.HasPrefix(alias.ID, "svc-") {
+		return &HTTPError{knox.BadRequestDataCode, "alias IDs must start with svc-"}
+	}
+	return nil
+}
+
+func (h *namingHook) PostAddAlias(principal knox.Principal, alias *knox.KeyAlias) {
+	h.calls = append(h.calls, "alias "+alias.ID+" of "+alias.KeyID)
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	h := &namingHook{}
+	m := NewKeyManager(keydb.NewAESGCMCryptor(0, []byte("testtesttesttest")), &keydb.TempDB{}, AclAuthorization, h)
+	u := auth.NewUser("testuser", []string{})
+
+	_, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ=="})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+	// Hooks belong to their key manager, not to every one in the process.
+	other, _ := makeDB()
+	if _, err := postKeysHandler(other, u, map[string]string{"id": "a1", "data": "MQ=="}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if _, getErr := m.GetKey("a1", knox.Primary); getErr != knox.ErrKeyIDNotFound {
+		t.Fatalf("%v is not %v", getErr, knox.ErrKeyIDNotFound)
+	}
+
+	if _, err := postKeysHandler(m, u, map[string]string{"id": "svc-a1", "data": "MQ=="}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	auditors := knox.Access{Type: knox.UserGroup, ID: "auditors", AccessType: knox.Read}
+	found := false
+	for _, a := range mustGetKey(t, m, "svc-a1").ACL {
+		found = found || a == auditors
+	}
+	if !found {
+		t.Fatal("expected the hook to give auditors access")
+	}
+
+	i, err := postVersionHandler(m, u, map[string]string{"keyID": "svc-a1", "data": "Mg=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	versionID := i.(uint64)
+	_, err = putVersionsHandler(m, u, map[string]string{"keyID": "svc-a1", "versionID": strconv.FormatUint(versionID, 10), "status": "\"Primary\""})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = putAccessHandler(m, u, map[string]string{"keyID": "svc-a1", "access": `{"type":"User","id":"alice","access":"Admin"}`})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	_, err = putAliasHandler(m, u, map[string]string{"aliasID": "old-a1", "key": "svc-a1"})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+	if _, err := putAliasHandler(m, u, map[string]string{"aliasID": "svc-old-a1", "key": "svc-a1"}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	_, err = deleteKeyHandler(m, u, map[string]string{"keyID": "svc-a1", "purge": "true"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	// Deletions of keys that require quorum are reported once confirmed.
+	if err := m.SetQuorum("svc-a1", true); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	// Pre hooks run when the operation is confirmed, not when it is filed.
+	_, err = deleteKeyHandler(m, u, map[string]string{"keyID": "svc-a1", "purge": "true"})
+	if err == nil || err.Subcode != knox.OperationPendingCode {
+		t.Fatalf("Expected pending operation not %+v", err)
+	}
+	ops, _ := m.GetPendingOperations()
+	params := map[string]string{"keyID": "svc-a1", "operationID": strconv.FormatUint(ops[0].ID, 10)}
+	_, err = confirmOperationHandler(m, auth.NewUser("alice", []string{}), params)
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	if _, err := cancelOperationHandler(m, u, params); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = deleteKeyHandler(m, u, map[string]string{"keyID": "svc-a1"})
+	if err == nil || err.Subcode != knox.OperationPendingCode {
+		t.Fatalf("Expected pending operation not %+v", err)
+	}
+	ops, _ = m.GetPendingOperations()
+	if len(ops) != 1 {
+		t.Fatalf("expected one pending operation, got %v", ops)
+	}
+	_, err = confirmOperationHandler(m, auth.NewUser("alice", []string{}), map[string]string{"keyID": "svc-a1", "operationID": strconv.FormatUint(ops[0].ID, 10)})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	if _, err := postKeysHandler(m, u, map[string]string{"id": "svc-c1", "data": "MQ=="}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = renameKeyHandler(m, u, map[string]string{"keyID": "svc-c1", "id": "c2"})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+	_, err = renameKeyHandler(m, u, map[string]string{"keyID": "svc-c1", "id": "svc-c2", "If-Match": `"stale"`})
+	if err == nil || err.Subcode != knox.PreconditionFailedCode {
+		t.Fatalf("Expected precondition failed error not %+v", err)
+	}
+	if _, err := renameKeyHandler(m, u, map[string]string{"keyID": "svc-c1", "id": "svc-c2"}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	expected := []string{
+		"create svc-a1 by testuser",
+		fmt.Sprintf("add version %d to svc-a1", versionID),
+		fmt.Sprintf(`update version %d of svc-a1 to "Primary"`, versionID),
+		"update access of svc-a1 by testuser",
+		"alias svc-old-a1 of svc-a1",
+		"delete svc-a1",
+		"create svc-c1 by testuser",
+		"rename svc-c1 to svc-c2",
+	}
+	if strings.Join(h.calls, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("hooks were called with\n%s\nnot\n%s", strings.Join(h.calls, "\n"), strings.Join(expected, "\n"))
+	}
+}
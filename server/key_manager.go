@@ -1,11 +1,19 @@
 package server
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
 
 	authz_utils "github.com/pavelzhurov/authz-utils"
 	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/server/auth"
 	"github.com/pavelzhurov/knox/server/keydb"
 )
 
@@ -16,21 +24,109 @@ type KeyManager interface {
 	GetKey(id string, status knox.VersionStatus) (*knox.Key, error)
 	AddNewKey(*knox.Key) error
 	DeleteKey(id string) error
+	GetDeletedKey(id string) (*knox.Key, error)
+	RestoreKey(id string) error
+	PurgeKey(id string) error
+	RenameKey(id, newID string) error
+	GetAlias(id string) (*knox.KeyAlias, error)
+	AddAlias(*knox.KeyAlias) error
+	RemoveAlias(id string) error
 	UpdateAccess(string, ...knox.Access) error
 	AddVersion(string, *knox.KeyVersion) error
 	UpdateVersion(keyID string, versionID uint64, s knox.VersionStatus) error
+	PurgeVersion(keyID string, versionID uint64) error
+	GetVersionRetention(keyID string) (*knox.VersionRetention, error)
+	SetVersionRetention(keyID string, r *knox.VersionRetention) error
+	MoveKey(id, path string) error
+	GetNamespaces() ([]string, error)
+	GetNamespace(path string) (*knox.Namespace, error)
+	AddNamespace(*knox.Namespace) error
+	UpdateNamespaceAccess(path string, acl ...knox.Access) error
+	RemoveNamespace(path string) error
+	GetInheritedACL(path string) (knox.ACL, error)
+	MigratePrincipal(from knox.Access, to *knox.Access, after string, batch int, dryRun, confirmed bool) ([]knox.ACLMigration, string, error)
+	StripAccess(principals knox.ACL, confirmed bool) ([]knox.ACLMigration, error)
+	AddAccessRequest(*knox.AccessRequest) error
+	GetAccessRequests() ([]knox.AccessRequest, error)
+	GetAccessRequest(keyID string, id uint64) (*knox.AccessRequest, error)
+	RemoveAccessRequest(keyID string, id uint64) error
+	GetQuorum(keyID string) (bool, error)
+	SetQuorum(keyID string, required bool) error
+	AddPendingOperation(*knox.PendingOperation) error
+	GetPendingOperations() ([]knox.PendingOperation, error)
+	GetPendingOperation(keyID string, id uint64) (*knox.PendingOperation, error)
+	RemovePendingOperation(keyID string, id uint64) error
+	AddBreakGlassGrant(*knox.BreakGlassGrant) error
+	GetBreakGlassGrants(keyID string) ([]knox.BreakGlassGrant, error)
+	GetAllBreakGlassGrants() ([]knox.BreakGlassGrant, error)
+	// GetAuthenticator returns nil if the OPA evaluator was set before the
+	// key manager was built.
 	GetAuthenticator() *authz_utils.Authenticator
 	GetAuthorizationType() authorizationType
+	// GetLifecycleHooks returns the hooks the key handlers call around changes
+	// to keys, in the order they were given to NewKeyManager.
+	GetLifecycleHooks() []LifecycleHook
+	// IfMatch returns a key manager whose updates of a key fail with
+	// knox.ErrPreconditionFailed unless the ETag of the key, as read by the
+	// update itself, matches ifMatch. Conditional updates are not retried.
+	IfMatch(ifMatch string) KeyManager
 }
 
-// NewKeyManager builds a struct for interfacing with the keydb.
-func NewKeyManager(c keydb.Cryptor, db keydb.DB, authzType authorizationType) KeyManager {
+// NewKeyManager builds a struct for interfacing with the keydb. Unless the
+// OPA evaluator was set, such as to a RegoEvaluator that needs no OPA server,
+// it requires the AUTHZ_* configuration of the OPA server.
+func NewKeyManager(c keydb.Cryptor, db keydb.DB, authzType authorizationType, hooks ...LifecycleHook) KeyManager {
+	if opaEvaluator != nil {
+		return &keyManager{cryptor: c, db: db, authzType: authzType, conflictRetries: conflictRetries, hooks: hooks}
+	}
+	e, err := auth.NewHTTPOPAEvaluatorFromEnv()
+	if err != nil {
+		log.Fatal("Can't create OPA evaluator:", err.Error())
+	}
+	opaEvaluator = e
+
 	auth, err := authz_utils.NewAuthenticatorFromEnv()
 
 	if err != nil {
 		log.Fatal("Can't create authenticator:", err.Error())
 	}
-	return &keyManager{c, db, auth, authzType}
+	return &keyManager{cryptor: c, db: db, authenticator: auth, authzType: authzType, conflictRetries: conflictRetries, hooks: hooks}
+}
+
+// conflictRetries is how many times a read-modify-write of a key is retried
+// after losing a race with a concurrent writer (keydb.ErrDBVersion) by the key
+// managers built afterwards. It should be set by the main function.
+var conflictRetries = 5
+
+// conflictBackoff is the base backoff between conflict retries.
+const conflictBackoff = 10 * time.Millisecond
+
+// SetConflictRetries sets how many times key updates that conflict with a
+// concurrent update are retried before keydb.ErrDBVersion is returned. It
+// applies to key managers built afterwards, so it must be called before
+// GetRouter.
+func SetConflictRetries(n int) {
+	conflictRetries = n
+}
+
+// retryOnConflict runs update until it does not fail with keydb.ErrDBVersion
+// or the retry limit is reached, sleeping a jittered, exponentially growing
+// backoff between attempts. update must reread the key on every call.
+// Conditional updates run once, and a conflict fails their precondition: the
+// key changed after it was checked.
+func (m *keyManager) retryOnConflict(update func() error) error {
+	err := update()
+	if m.ifMatch != "" {
+		if err == keydb.ErrDBVersion {
+			return knox.ErrPreconditionFailed
+		}
+		return err
+	}
+	for attempt := 0; err == keydb.ErrDBVersion && attempt < m.conflictRetries; attempt++ {
+		time.Sleep(time.Duration(rand.Int63n(int64(conflictBackoff) << uint(attempt))))
+		err = update()
+	}
+	return err
 }
 
 type keyManager struct {
@@ -38,12 +134,60 @@ type keyManager struct {
 	db            keydb.DB
 	authenticator *authz_utils.Authenticator
 	authzType     authorizationType
+	// conflictRetries is the value of the package's conflictRetries when the
+	// key manager was built.
+	conflictRetries int
+	// ifMatch is the If-Match precondition of conditional key managers.
+	ifMatch string
+	hooks   []LifecycleHook
+}
+
+func (m *keyManager) IfMatch(ifMatch string) KeyManager {
+	c := *m
+	c.ifMatch = ifMatch
+	return &c
+}
+
+// checkPrecondition fails with knox.ErrPreconditionFailed if the key manager is
+// conditional and encK does not match its If-Match precondition.
+func (m *keyManager) checkPrecondition(encK *keydb.DBKey) error {
+	if m.ifMatch == "" || matchesETag(m.ifMatch, keyETag(encK.VersionHash, encK.ACL)) {
+		return nil
+	}
+	return knox.ErrPreconditionFailed
+}
+
+// keyETag identifies the state of a key that API clients act on: its active
+// versions and its ACL.
+func keyETag(versionHash string, acl knox.ACL) string {
+	data, _ := json.Marshal(acl)
+	h := sha256.New()
+	h.Write([]byte(versionHash))
+	h.Write([]byte{0})
+	h.Write(data)
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// matchesETag reports whether an If-Match header value matches etag. Weak
+// validators are compared as strong ones.
+func matchesETag(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
 }
 
 func (m *keyManager) GetAuthorizationType() authorizationType {
 	return m.authzType
 }
 
+func (m *keyManager) GetLifecycleHooks() []LifecycleHook {
+	return m.hooks
+}
+
 func (m *keyManager) GetAuthenticator() *authz_utils.Authenticator {
 	return m.authenticator
 }
@@ -55,6 +199,9 @@ func (m *keyManager) GetAllKeyIDs() ([]string, error) {
 	}
 	output := []string{}
 	for _, k := range keys {
+		if k.DeletedAt != 0 || k.AliasOf != "" || k.IsNamespace() {
+			continue
+		}
 		output = append(output, k.ID)
 	}
 	return output, nil
@@ -65,20 +212,63 @@ func (m *keyManager) GetUpdatedKeyIDs(versions map[string]string) ([]string, err
 	if err != nil {
 		return nil, err
 	}
+	byID := map[string]*keydb.DBKey{}
+	for i := range keys {
+		byID[keys[i].ID] = &keys[i]
+	}
 	output := []string{}
 	for _, k := range keys {
-		if v, ok := versions[k.ID]; ok && k.VersionHash != v {
+		v, ok := versions[k.ID]
+		if !ok || k.DeletedAt != 0 || k.IsNamespace() {
+			continue
+		}
+		// Aliases are compared against the key they resolve to.
+		hash := k.VersionHash
+		if k.AliasOf != "" {
+			target, ok := byID[k.AliasOf]
+			if !ok || target.DeletedAt != 0 {
+				continue
+			}
+			hash = target.VersionHash
+		}
+		if hash != v {
 			output = append(output, k.ID)
 		}
 	}
 	return output, nil
 }
 
-func (m *keyManager) GetKey(id string, status knox.VersionStatus) (*knox.Key, error) {
+// getLive returns the stored key unless it does not exist, is in the trash or
+// is an alias or a namespace.
+func (m *keyManager) getLive(id string) (*keydb.DBKey, error) {
+	encK, err := m.db.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if encK.DeletedAt != 0 || encK.AliasOf != "" || encK.IsNamespace() {
+		return nil, knox.ErrKeyIDNotFound
+	}
+	return encK, nil
+}
+
+// getStored returns the stored key for id, live or in the trash, but not an
+// alias or a namespace.
+func (m *keyManager) getStored(id string) (*keydb.DBKey, error) {
 	encK, err := m.db.Get(id)
 	if err != nil {
 		return nil, err
 	}
+	if encK.AliasOf != "" || encK.IsNamespace() {
+		return nil, knox.ErrKeyIDNotFound
+	}
+	return encK, nil
+}
+
+func (m *keyManager) GetKey(id string, status knox.VersionStatus) (*knox.Key, error) {
+	encK, err := m.getLive(id)
+	if err != nil {
+		return nil, err
+	}
 	k, err := m.cryptor.Decrypt(encK)
 	if err != nil {
 		return nil, fmt.Errorf("Error decrypting key: %s", err.Error())
@@ -101,22 +291,251 @@ func (m *keyManager) AddNewKey(k *knox.Key) error {
 	if err := k.Validate(); err != nil {
 		return err
 	}
+	if k.Path != "" {
+		if _, err := m.getNamespace(k.Path); err != nil {
+			return err
+		}
+	}
 	dbk, err := m.cryptor.Encrypt(k)
 	if err != nil {
 		return err
 	}
-	return m.db.Add(dbk)
+	if err := m.db.Add(dbk); err != nil {
+		return m.addError(k.ID, err)
+	}
+	notify(knox.EventCreated, k.ID, 0)
+	return nil
 }
 
+// DeleteKey moves the key to the trash. It is hidden from reads and listings
+// until it is restored or purged.
 func (m *keyManager) DeleteKey(id string) error {
+	err := m.retryOnConflict(func() error { return m.setDeletedAt(id, time.Now().UnixNano()) })
+	if err == nil {
+		notify(knox.EventDeleted, id, 0)
+	}
+	return err
+}
+
+// GetDeletedKey returns a key that is in the trash with all of its versions.
+func (m *keyManager) GetDeletedKey(id string) (*knox.Key, error) {
+	encK, err := m.db.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if encK.DeletedAt == 0 || encK.AliasOf != "" {
+		return nil, knox.ErrKeyIDNotFound
+	}
+	k, err := m.cryptor.Decrypt(encK)
+	if err != nil {
+		return nil, fmt.Errorf("Error decrypting key: %s", err.Error())
+	}
+	return k, nil
+}
+
+// RestoreKey moves a key out of the trash.
+func (m *keyManager) RestoreKey(id string) error {
+	err := m.retryOnConflict(func() error { return m.setDeletedAt(id, 0) })
+	if err == nil {
+		notify(knox.EventCreated, id, 0)
+	}
+	return err
+}
+
+// setDeletedAt moves a live key to the trash at deletedAt, or a key in the
+// trash back out of it if deletedAt is zero.
+func (m *keyManager) setDeletedAt(id string, deletedAt int64) error {
+	encK, err := m.db.Get(id)
+	if err != nil {
+		return err
+	}
+	if (encK.DeletedAt == 0) != (deletedAt != 0) || encK.AliasOf != "" || encK.IsNamespace() {
+		return knox.ErrKeyIDNotFound
+	}
+	if err := m.checkPrecondition(encK); err != nil {
+		return err
+	}
+	newEncK := encK.Copy()
+	newEncK.DeletedAt = deletedAt
+	return m.db.Update(newEncK)
+}
+
+// addError returns knox.ErrKeyInTrash instead of knox.ErrKeyExists if adding
+// the key failed because a key in the trash holds its ID.
+func (m *keyManager) addError(id string, err error) error {
+	if err != knox.ErrKeyExists {
+		return err
+	}
+	if encK, getErr := m.db.Get(id); getErr == nil && encK.DeletedAt != 0 {
+		return knox.ErrKeyInTrash
+	}
+	return err
+}
+
+// PurgeKey permanently removes a key, whether or not it is in the trash.
+func (m *keyManager) PurgeKey(id string) error {
+	// Keys in the trash were already reported deleted.
+	_, liveErr := m.getLive(id)
+	if m.ifMatch != "" {
+		var err error
+		if liveErr == nil {
+			// Moving the key to the trash checks the precondition in the same
+			// update, and keys in the trash are not changed until removed.
+			err = m.retryOnConflict(func() error { return m.setDeletedAt(id, time.Now().UnixNano()) })
+		} else if encK, getErr := m.db.Get(id); getErr != nil {
+			err = getErr
+		} else {
+			err = m.checkPrecondition(encK)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := m.db.Remove(id); err != nil {
+		return err
+	}
+	if liveErr == nil {
+		notify(knox.EventDeleted, id, 0)
+	}
+	notify(knox.EventPurged, id, 0)
+	return nil
+}
+
+// RenameKey moves a key to newID and leaves an alias with the key's ACL at the
+// old ID, so consumers of the old ID keep working. Aliases of the old ID are
+// pointed at newID.
+func (m *keyManager) RenameKey(id, newID string) error {
+	err := m.retryOnConflict(func() error { return m.renameKey(id, newID) })
+	if err != nil {
+		return err
+	}
+	notify(knox.EventCreated, newID, 0)
+	notify(knox.EventDeleted, id, 0)
+	aliases, err := m.db.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, a := range aliases {
+		if a.AliasOf != id {
+			continue
+		}
+		err = m.retryOnConflict(func() error { return m.repointAlias(a.ID, id, newID) })
+		if err != nil && err != knox.ErrKeyIDNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *keyManager) renameKey(id, newID string) error {
+	encK, err := m.getLive(id)
+	if err != nil {
+		return err
+	}
+	if err := m.checkPrecondition(encK); err != nil {
+		return err
+	}
+	k, err := m.cryptor.Decrypt(encK)
+	if err != nil {
+		return fmt.Errorf("Error decrypting key: %s", err.Error())
+	}
+	// Key versions are encrypted with the key ID as associated data, so the
+	// key has to be encrypted again under its new ID.
+	k.ID = newID
+	if err := k.Validate(); err != nil {
+		return err
+	}
+	newEncK, err := m.cryptor.Encrypt(k)
+	if err != nil {
+		return err
+	}
+	newEncK.CopyState(encK)
+	if err := m.db.Add(newEncK); err != nil {
+		return m.addError(newID, err)
+	}
+
+	alias := &keydb.DBKey{
+		ID:          id,
+		ACL:         encK.ACL,
+		VersionList: []keydb.EncKeyVersion{},
+		AliasOf:     newID,
+		Path:        encK.Path,
+		DBVersion:   encK.DBVersion,
+	}
+	if err := m.db.Update(alias); err != nil {
+		m.db.Remove(newID)
+		return err
+	}
+	return nil
+}
+
+func (m *keyManager) repointAlias(id, from, to string) error {
+	encK, err := m.db.Get(id)
+	if err != nil {
+		return err
+	}
+	if encK.AliasOf != from {
+		return nil
+	}
+	newEncK := encK.Copy()
+	newEncK.AliasOf = to
+	return m.db.Update(newEncK)
+}
+
+// GetAlias returns the alias with the given ID.
+func (m *keyManager) GetAlias(id string) (*knox.KeyAlias, error) {
+	encK, err := m.db.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if encK.AliasOf == "" {
+		return nil, knox.ErrKeyIDNotFound
+	}
+	return &knox.KeyAlias{ID: encK.ID, KeyID: encK.AliasOf, ACL: encK.ACL, Path: encK.Path}, nil
+}
+
+// AddAlias adds an alias for an existing key.
+func (m *keyManager) AddAlias(a *knox.KeyAlias) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	if _, err := m.getLive(a.KeyID); err != nil {
+		return err
+	}
+	err := m.db.Add(&keydb.DBKey{
+		ID:          a.ID,
+		ACL:         a.ACL,
+		VersionList: []keydb.EncKeyVersion{},
+		AliasOf:     a.KeyID,
+		Path:        a.Path,
+	})
+	return m.addError(a.ID, err)
+}
+
+// RemoveAlias removes an alias, leaving the key it resolved to untouched.
+func (m *keyManager) RemoveAlias(id string) error {
+	if _, err := m.GetAlias(id); err != nil {
+		return err
+	}
 	return m.db.Remove(id)
 }
 
 func (m *keyManager) UpdateAccess(id string, acl ...knox.Access) error {
-	encK, err := m.db.Get(id)
+	err := m.retryOnConflict(func() error { return m.updateAccess(id, acl...) })
+	if err == nil {
+		notify(knox.EventACLChanged, id, 0)
+	}
+	return err
+}
+
+func (m *keyManager) updateAccess(id string, acl ...knox.Access) error {
+	encK, err := m.getLive(id)
 	if err != nil {
 		return err
 	}
+	if err := m.checkPrecondition(encK); err != nil {
+		return err
+	}
 	newEncK := encK.Copy()
 	for _, a := range acl {
 		newEncK.ACL = newEncK.ACL.Add(a)
@@ -129,10 +548,21 @@ func (m *keyManager) UpdateAccess(id string, acl ...knox.Access) error {
 }
 
 func (m *keyManager) AddVersion(id string, v *knox.KeyVersion) error {
-	encK, err := m.db.Get(id)
+	err := m.retryOnConflict(func() error { return m.addVersion(id, v) })
+	if err == nil {
+		notify(knox.EventVersionAdded, id, v.ID)
+	}
+	return err
+}
+
+func (m *keyManager) addVersion(id string, v *knox.KeyVersion) error {
+	encK, err := m.getLive(id)
 	if err != nil {
 		return err
 	}
+	if err := m.checkPrecondition(encK); err != nil {
+		return err
+	}
 
 	k, err := m.cryptor.Decrypt(encK)
 	if err != nil {
@@ -158,10 +588,27 @@ func (m *keyManager) AddVersion(id string, v *knox.KeyVersion) error {
 }
 
 func (m *keyManager) UpdateVersion(keyID string, versionID uint64, s knox.VersionStatus) error {
-	encK, err := m.db.Get(keyID)
+	err := m.retryOnConflict(func() error { return m.updateVersion(keyID, versionID, s) })
+	if err != nil {
+		return err
+	}
+	switch s {
+	case knox.Primary:
+		notify(knox.EventPromoted, keyID, versionID)
+	case knox.Inactive:
+		notify(knox.EventDeactivated, keyID, versionID)
+	}
+	return nil
+}
+
+func (m *keyManager) updateVersion(keyID string, versionID uint64, s knox.VersionStatus) error {
+	encK, err := m.getLive(keyID)
 	if err != nil {
 		return err
 	}
+	if err := m.checkPrecondition(encK); err != nil {
+		return err
+	}
 	k, err := m.cryptor.Decrypt(encK)
 	if err != nil {
 		return fmt.Errorf("Error decrypting key: %s", err.Error())
@@ -180,6 +627,11 @@ func (m *keyManager) UpdateVersion(keyID string, versionID uint64, s knox.Versio
 	for j, v := range newEncK.VersionList {
 		for _, nv := range kvl {
 			if v.ID == nv.ID {
+				if nv.Status == knox.Inactive && v.Status != knox.Inactive {
+					newEncK.VersionList[j].DeactivatedAt = time.Now().UnixNano()
+				} else if nv.Status != knox.Inactive {
+					newEncK.VersionList[j].DeactivatedAt = 0
+				}
 				newEncK.VersionList[j].Status = nv.Status
 			}
 		}
@@ -187,3 +639,607 @@ func (m *keyManager) UpdateVersion(keyID string, versionID uint64, s knox.Versio
 	newEncK.VersionHash = k.VersionHash
 	return m.db.Update(newEncK)
 }
+
+// PurgeVersion permanently removes an Inactive version from a key.
+func (m *keyManager) PurgeVersion(keyID string, versionID uint64) error {
+	return m.retryOnConflict(func() error { return m.purgeVersion(keyID, versionID) })
+}
+
+func (m *keyManager) purgeVersion(keyID string, versionID uint64) error {
+	encK, err := m.getLive(keyID)
+	if err != nil {
+		return err
+	}
+	if err := m.checkPrecondition(encK); err != nil {
+		return err
+	}
+	newEncK := encK.Copy()
+	for i, v := range newEncK.VersionList {
+		if v.ID != versionID {
+			continue
+		}
+		if v.Status != knox.Inactive {
+			return knox.ErrPurgeNotInactive
+		}
+		newEncK.VersionList = append(newEncK.VersionList[:i], newEncK.VersionList[i+1:]...)
+		newEncK.RehashVersions()
+		return m.db.Update(newEncK)
+	}
+	return knox.ErrKeyVersionNotFound
+}
+
+// GetVersionRetention returns the retention policy of a key, or nil if it has
+// none.
+func (m *keyManager) GetVersionRetention(keyID string) (*knox.VersionRetention, error) {
+	encK, err := m.getLive(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return encK.VersionRetention, nil
+}
+
+// SetVersionRetention sets the retention policy of a key. A nil policy keeps
+// Inactive versions forever.
+func (m *keyManager) SetVersionRetention(keyID string, r *knox.VersionRetention) error {
+	if r != nil {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	return m.retryOnConflict(func() error {
+		encK, err := m.getLive(keyID)
+		if err != nil {
+			return err
+		}
+		newEncK := encK.Copy()
+		newEncK.VersionRetention = r
+		return m.db.Update(newEncK)
+	})
+}
+
+// MoveKey moves a key into the namespace at path, or out of any namespace if
+// path is empty.
+func (m *keyManager) MoveKey(id, path string) error {
+	if path != "" {
+		if _, err := m.getNamespace(path); err != nil {
+			return err
+		}
+	}
+	err := m.retryOnConflict(func() error {
+		encK, err := m.getLive(id)
+		if err != nil {
+			return err
+		}
+		newEncK := encK.Copy()
+		newEncK.Path = path
+		return m.db.Update(newEncK)
+	})
+	if err == nil {
+		notify(knox.EventACLChanged, id, 0)
+	}
+	return err
+}
+
+// getNamespace returns the stored namespace at path.
+func (m *keyManager) getNamespace(path string) (*keydb.DBKey, error) {
+	if err := (knox.Namespace{Path: path}).Validate(); err != nil {
+		return nil, err
+	}
+	encK, err := m.db.Get(path)
+	if err == knox.ErrKeyIDNotFound {
+		return nil, knox.ErrNamespaceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return encK, nil
+}
+
+// GetNamespaces returns the paths of all namespaces in sorted order.
+func (m *keyManager) GetNamespaces() ([]string, error) {
+	keys, err := m.db.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, k := range keys {
+		if k.IsNamespace() {
+			paths = append(paths, k.ID)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// GetNamespace returns the namespace at path with the keys and namespaces
+// directly inside it.
+func (m *keyManager) GetNamespace(path string) (*knox.Namespace, error) {
+	encN, err := m.getNamespace(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := m.db.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	n := &knox.Namespace{Path: path, ACL: encN.ACL, Keys: []string{}, Namespaces: []string{}}
+	for _, k := range keys {
+		switch {
+		case k.IsNamespace():
+			if strings.HasPrefix(k.ID, path+"/") && !strings.Contains(k.ID[len(path)+1:], "/") {
+				n.Namespaces = append(n.Namespaces, k.ID)
+			}
+		case k.Path == path && k.DeletedAt == 0 && k.AliasOf == "":
+			n.Keys = append(n.Keys, k.ID)
+		}
+	}
+	sort.Strings(n.Keys)
+	sort.Strings(n.Namespaces)
+	return n, nil
+}
+
+// AddNamespace adds a namespace. The namespace containing it must exist.
+func (m *keyManager) AddNamespace(n *knox.Namespace) error {
+	if err := n.Validate(); err != nil {
+		return err
+	}
+	if paths := knox.NamespaceAncestry(n.Path); len(paths) > 1 {
+		if _, err := m.getNamespace(paths[len(paths)-2]); err != nil {
+			return err
+		}
+	}
+	err := m.db.Add(&keydb.DBKey{
+		ID:          n.Path,
+		ACL:         n.ACL,
+		VersionList: []keydb.EncKeyVersion{},
+	})
+	if err == knox.ErrKeyExists {
+		return knox.ErrNamespaceExists
+	}
+	return err
+}
+
+// UpdateNamespaceAccess adds or changes entries of a namespace's ACL in the
+// same way UpdateAccess does for keys.
+func (m *keyManager) UpdateNamespaceAccess(path string, acl ...knox.Access) error {
+	err := m.retryOnConflict(func() error {
+		encN, err := m.getNamespace(path)
+		if err != nil {
+			return err
+		}
+		newEncN := encN.Copy()
+		for _, a := range acl {
+			newEncN.ACL = newEncN.ACL.Add(a)
+		}
+		if err := newEncN.ACL.Validate(); err != nil {
+			return err
+		}
+		return m.db.Update(newEncN)
+	})
+	if err == nil {
+		notifyACLChanged(m.db, path)
+	}
+	return err
+}
+
+// RemoveNamespace removes a namespace that holds no keys, including keys in
+// the trash, and no other namespaces.
+func (m *keyManager) RemoveNamespace(path string) error {
+	if _, err := m.getNamespace(path); err != nil {
+		return err
+	}
+	keys, err := m.db.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if k.Path == path || strings.HasPrefix(k.ID, path+"/") {
+			return knox.ErrNamespaceNotEmpty
+		}
+	}
+	return m.db.Remove(path)
+}
+
+// GetInheritedACL returns the entries that the namespace at path and the
+// namespaces containing it add to the ACLs of the keys inside it, outermost
+// namespace first. Namespaces that no longer exist add nothing.
+func (m *keyManager) GetInheritedACL(path string) (knox.ACL, error) {
+	acl := knox.ACL{}
+	for _, p := range knox.NamespaceAncestry(path) {
+		encN, err := m.db.Get(p)
+		if err == knox.ErrKeyIDNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		acl = append(acl, encN.ACL...)
+	}
+	return acl, nil
+}
+
+// MigratePrincipal replaces the ACL entries for the principal from with
+// entries for the principal to, or removes them if to is nil (see
+// knox.ACL.ReplacePrincipal), in every key, alias and namespace whose ID sorts
+// after after, in ID order, including keys in the trash. At most batch of
+// them are changed; when the batch is full, the ID of the last one changed is
+// returned to continue the migration from, and otherwise the returned ID is
+// empty. With dryRun the changes are returned without being stored. Unless
+// the migration was confirmed as a pending operation, it stops with a
+// *QuorumRequiredError before changing a key that requires quorum or a
+// namespace holding one.
+func (m *keyManager) MigratePrincipal(from knox.Access, to *knox.Access, after string, batch int, dryRun, confirmed bool) ([]knox.ACLMigration, string, error) {
+	keys, err := m.db.GetAll()
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	changes := []knox.ACLMigration{}
+	for _, k := range keys {
+		if k.ID <= after {
+			continue
+		}
+		if _, removed, _ := k.ACL.ReplacePrincipal(from, to); len(removed) == 0 {
+			continue
+		}
+		var change knox.ACLMigration
+		err := m.retryOnConflict(func() error {
+			encK, err := m.db.Get(k.ID)
+			if err != nil {
+				return err
+			}
+			newEncK := encK.Copy()
+			newEncK.ACL, change.Removed, change.Added = encK.ACL.ReplacePrincipal(from, to)
+			if len(change.Removed) == 0 || dryRun {
+				return nil
+			}
+			if !confirmed {
+				if err := m.checkQuorum(encK); err != nil {
+					return err
+				}
+			}
+			if err := newEncK.ACL.Validate(); err != nil {
+				return err
+			}
+			return m.db.Update(newEncK)
+		})
+		if err == knox.ErrKeyIDNotFound {
+			continue
+		}
+		if err != nil {
+			return changes, "", err
+		}
+		if len(change.Removed) == 0 {
+			continue
+		}
+		if !dryRun {
+			notifyACLChanged(m.db, k.ID)
+		}
+		change.KeyID = k.ID
+		changes = append(changes, change)
+		if len(changes) == batch {
+			return changes, k.ID, nil
+		}
+	}
+	return changes, "", nil
+}
+
+// QuorumRequiredError is returned by changes to the ACLs of many keys that
+// reach KeyID, a key that requires quorum, before they were confirmed.
+type QuorumRequiredError struct {
+	KeyID string
+}
+
+func (e *QuorumRequiredError) Error() string {
+	return fmt.Sprintf("Key %s requires quorum", e.KeyID)
+}
+
+// checkQuorum returns a *QuorumRequiredError if k is a key that requires
+// quorum or, if k is a namespace, if a key inside it or the namespaces in it
+// does. Keys in the trash count, as they can be restored.
+func (m *keyManager) checkQuorum(k *keydb.DBKey) error {
+	if !k.IsNamespace() {
+		if k.AliasOf == "" && k.RequiresQuorum {
+			return &QuorumRequiredError{KeyID: k.ID}
+		}
+		return nil
+	}
+	keys, err := m.db.GetAll()
+	if err != nil {
+		return err
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	for _, c := range keys {
+		if c.AliasOf != "" || c.IsNamespace() || !c.RequiresQuorum {
+			continue
+		}
+		if c.Path == k.ID || strings.HasPrefix(c.Path, k.ID+"/") {
+			return &QuorumRequiredError{KeyID: c.ID}
+		}
+	}
+	return nil
+}
+
+// StripAccess removes the ACL entries of principals, whatever their access
+// type, from every key, alias and namespace (see keydb.StripAccess) and returns
+// the changes sorted by key ID. Changes made before an error are returned with
+// it. Unless it was confirmed as a pending operation, it fails with a
+// *QuorumRequiredError before changing a key that requires quorum or a
+// namespace holding one.
+func (m *keyManager) StripAccess(principals knox.ACL, confirmed bool) ([]knox.ACLMigration, error) {
+	var check func(*keydb.DBKey) error
+	if !confirmed {
+		check = m.checkQuorum
+	}
+	removed, err := keydb.StripAccess(m.db, principals, check)
+	changes := []knox.ACLMigration{}
+	for id, acl := range removed {
+		notifyACLChanged(m.db, id)
+		changes = append(changes, knox.ACLMigration{KeyID: id, Removed: acl})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].KeyID < changes[j].KeyID })
+	return changes, err
+}
+
+// pendingRequests returns the access requests of the stored key that have not
+// expired, with their key ID set to the key's current ID.
+func pendingRequests(k *keydb.DBKey, now int64) []knox.AccessRequest {
+	pending := []knox.AccessRequest{}
+	for _, r := range k.AccessRequests {
+		if r.ExpiresAt > now {
+			r.KeyID = k.ID
+			pending = append(pending, r)
+		}
+	}
+	return pending
+}
+
+// AddAccessRequest stores a request for access to a live key. A principal has
+// at most one pending request per key: a new one replaces it. Requests that
+// have expired are dropped.
+func (m *keyManager) AddAccessRequest(r *knox.AccessRequest) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	return m.retryOnConflict(func() error {
+		encK, err := m.getLive(r.KeyID)
+		if err != nil {
+			return err
+		}
+		newEncK := encK.Copy()
+		newEncK.AccessRequests = []knox.AccessRequest{}
+		for _, p := range pendingRequests(encK, time.Now().UnixNano()) {
+			if p.RequestedBy != r.RequestedBy {
+				newEncK.AccessRequests = append(newEncK.AccessRequests, p)
+			}
+		}
+		newEncK.AccessRequests = append(newEncK.AccessRequests, *r)
+		return m.db.Update(newEncK)
+	})
+}
+
+// GetAccessRequests returns the pending access requests of every live key,
+// sorted by key ID and then by the time they were filed.
+func (m *keyManager) GetAccessRequests() ([]knox.AccessRequest, error) {
+	keys, err := m.db.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixNano()
+	requests := []knox.AccessRequest{}
+	for i := range keys {
+		if keys[i].DeletedAt != 0 || keys[i].AliasOf != "" || keys[i].IsNamespace() {
+			continue
+		}
+		requests = append(requests, pendingRequests(&keys[i], now)...)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].KeyID != requests[j].KeyID {
+			return requests[i].KeyID < requests[j].KeyID
+		}
+		return requests[i].RequestedAt < requests[j].RequestedAt
+	})
+	return requests, nil
+}
+
+// GetAccessRequest returns a pending access request for a live key.
+func (m *keyManager) GetAccessRequest(keyID string, id uint64) (*knox.AccessRequest, error) {
+	encK, err := m.getLive(keyID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range pendingRequests(encK, time.Now().UnixNano()) {
+		if r.ID == id {
+			return &r, nil
+		}
+	}
+	return nil, knox.ErrAccessRequestNotFound
+}
+
+// RemoveAccessRequest removes a pending access request once it is decided.
+func (m *keyManager) RemoveAccessRequest(keyID string, id uint64) error {
+	return m.retryOnConflict(func() error {
+		encK, err := m.getLive(keyID)
+		if err != nil {
+			return err
+		}
+		newEncK := encK.Copy()
+		newEncK.AccessRequests = []knox.AccessRequest{}
+		found := false
+		for _, r := range pendingRequests(encK, time.Now().UnixNano()) {
+			if r.ID == id {
+				found = true
+				continue
+			}
+			newEncK.AccessRequests = append(newEncK.AccessRequests, r)
+		}
+		if !found {
+			return knox.ErrAccessRequestNotFound
+		}
+		return m.db.Update(newEncK)
+	})
+}
+
+// GetQuorum returns whether destructive operations on a key, live or in the
+// trash, wait for a second admin to confirm them.
+func (m *keyManager) GetQuorum(keyID string) (bool, error) {
+	encK, err := m.getStored(keyID)
+	if err != nil {
+		return false, err
+	}
+	return encK.RequiresQuorum, nil
+}
+
+// SetQuorum sets whether destructive operations on a key wait for a second
+// admin to confirm them.
+func (m *keyManager) SetQuorum(keyID string, required bool) error {
+	return m.retryOnConflict(func() error {
+		encK, err := m.getLive(keyID)
+		if err != nil {
+			return err
+		}
+		newEncK := encK.Copy()
+		newEncK.RequiresQuorum = required
+		return m.db.Update(newEncK)
+	})
+}
+
+// pendingOperations returns the pending operations of the stored key that
+// have not expired, with their key ID set to the key's current ID.
+func pendingOperations(k *keydb.DBKey, now int64) []knox.PendingOperation {
+	pending := []knox.PendingOperation{}
+	for _, op := range k.PendingOperations {
+		if op.ExpiresAt > now {
+			op.KeyID = k.ID
+			pending = append(pending, op)
+		}
+	}
+	return pending
+}
+
+// AddPendingOperation stores an operation on a key, live or in the trash,
+// until it is confirmed or cancelled. Operations that have expired are
+// dropped.
+func (m *keyManager) AddPendingOperation(op *knox.PendingOperation) error {
+	return m.retryOnConflict(func() error {
+		encK, err := m.getStored(op.KeyID)
+		if err != nil {
+			return err
+		}
+		newEncK := encK.Copy()
+		newEncK.PendingOperations = append(pendingOperations(encK, time.Now().UnixNano()), *op)
+		return m.db.Update(newEncK)
+	})
+}
+
+// GetPendingOperations returns the pending operations of every key, including
+// keys in the trash, sorted by key ID and then by the time they were asked for.
+func (m *keyManager) GetPendingOperations() ([]knox.PendingOperation, error) {
+	keys, err := m.db.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixNano()
+	ops := []knox.PendingOperation{}
+	for i := range keys {
+		if keys[i].AliasOf != "" || keys[i].IsNamespace() {
+			continue
+		}
+		ops = append(ops, pendingOperations(&keys[i], now)...)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].KeyID != ops[j].KeyID {
+			return ops[i].KeyID < ops[j].KeyID
+		}
+		return ops[i].RequestedAt < ops[j].RequestedAt
+	})
+	return ops, nil
+}
+
+// GetPendingOperation returns a pending operation on a key, live or in the
+// trash.
+func (m *keyManager) GetPendingOperation(keyID string, id uint64) (*knox.PendingOperation, error) {
+	encK, err := m.getStored(keyID)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range pendingOperations(encK, time.Now().UnixNano()) {
+		if op.ID == id {
+			return &op, nil
+		}
+	}
+	return nil, knox.ErrPendingOperationNotFound
+}
+
+// RemovePendingOperation removes a pending operation when it is confirmed or
+// cancelled.
+func (m *keyManager) RemovePendingOperation(keyID string, id uint64) error {
+	return m.retryOnConflict(func() error {
+		encK, err := m.getStored(keyID)
+		if err != nil {
+			return err
+		}
+		newEncK := encK.Copy()
+		newEncK.PendingOperations = []knox.PendingOperation{}
+		found := false
+		for _, op := range pendingOperations(encK, time.Now().UnixNano()) {
+			if op.ID == id {
+				found = true
+				continue
+			}
+			newEncK.PendingOperations = append(newEncK.PendingOperations, op)
+		}
+		if !found {
+			return knox.ErrPendingOperationNotFound
+		}
+		return m.db.Update(newEncK)
+	})
+}
+
+// AddBreakGlassGrant records emergency access to a live key. The key's ACL is
+// left unchanged.
+func (m *keyManager) AddBreakGlassGrant(g *knox.BreakGlassGrant) error {
+	return m.retryOnConflict(func() error {
+		encK, err := m.getLive(g.KeyID)
+		if err != nil {
+			return err
+		}
+		newEncK := encK.Copy()
+		newEncK.BreakGlassGrants = append(newEncK.BreakGlassGrants, *g)
+		return m.db.Update(newEncK)
+	})
+}
+
+// GetBreakGlassGrants returns the break-glass grants of a live key, including
+// expired ones that have not been removed yet.
+func (m *keyManager) GetBreakGlassGrants(keyID string) ([]knox.BreakGlassGrant, error) {
+	encK, err := m.getLive(keyID)
+	if err != nil {
+		return nil, err
+	}
+	grants := []knox.BreakGlassGrant{}
+	for _, g := range encK.BreakGlassGrants {
+		g.KeyID = encK.ID
+		grants = append(grants, g)
+	}
+	return grants, nil
+}
+
+// GetAllBreakGlassGrants returns the break-glass grants kept on every key,
+// including keys in the trash, in the order they were taken. Expired grants
+// are only kept until keydb.RemoveExpiredBreakGlassGrants removes them.
+func (m *keyManager) GetAllBreakGlassGrants() ([]knox.BreakGlassGrant, error) {
+	keys, err := m.db.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	grants := []knox.BreakGlassGrant{}
+	for _, k := range keys {
+		for _, g := range k.BreakGlassGrants {
+			g.KeyID = k.ID
+			grants = append(grants, g)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].GrantedAt < grants[j].GrantedAt })
+	return grants, nil
+}
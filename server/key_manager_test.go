@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"reflect"
 	"sort"
 	"testing"
@@ -103,8 +104,8 @@ func TestGetAllKeyIDs(t *testing.T) {
 		t.Fatal("Unexpected # of keys in get all keys response")
 	}
 
-	m.DeleteKey("id1")
-	m.DeleteKey("id2")
+	m.PurgeKey("id1")
+	m.PurgeKey("id2")
 }
 
 func TestGetUpdatedKeyIDs(t *testing.T) {
@@ -187,8 +188,8 @@ func TestGetUpdatedKeyIDs(t *testing.T) {
 		t.Fatal("expected no keys")
 	}
 
-	m.DeleteKey("id1")
-	m.DeleteKey("id2")
+	m.PurgeKey("id1")
+	m.PurgeKey("id2")
 }
 
 func TestAddNewKey(t *testing.T) {
@@ -247,7 +248,7 @@ func TestAddNewKey(t *testing.T) {
 		t.Fatal("Should be an error")
 	}
 
-	m.DeleteKey("id1")
+	m.PurgeKey("id1")
 }
 
 func TestUpdateAccess(t *testing.T) {
@@ -312,7 +313,7 @@ func TestUpdateAccess(t *testing.T) {
 		}
 	}
 
-	m.DeleteKey("id1")
+	m.PurgeKey("id1")
 }
 
 func TestAddUpdateVersion(t *testing.T) {
@@ -454,7 +455,7 @@ func TestAddUpdateVersion(t *testing.T) {
 		t.Fatalf("%d does equal %d", kv1.CreationTime, kv.CreationTime)
 	}
 
-	m.DeleteKey("id1")
+	m.PurgeKey("id1")
 }
 
 func TestGetInactiveKeyVersions(t *testing.T) {
@@ -512,5 +513,168 @@ func TestGetInactiveKeyVersions(t *testing.T) {
 		t.Fatalf("Wanted two key versions, got: %d", len(key.VersionList))
 	}
 
-	m.DeleteKey("id1")
+	m.PurgeKey("id1")
+}
+
+// racingDB simulates a concurrent writer: the next `conflicts` updates lose
+// the race because another ACL entry is written first.
+type racingDB struct {
+	*keydb.TempDB
+	conflicts int
+}
+
+func (db *racingDB) Update(k *keydb.DBKey) error {
+	if db.conflicts > 0 {
+		db.conflicts--
+		current, err := db.TempDB.Get(k.ID)
+		if err != nil {
+			return err
+		}
+		other := current.Copy()
+		other.ACL = other.ACL.Add(knox.Access{Type: knox.Machine, ID: fmt.Sprintf("racer%d", db.conflicts), AccessType: knox.Read})
+		if err := db.TempDB.Update(other); err != nil {
+			return err
+		}
+	}
+	return db.TempDB.Update(k)
+}
+
+func TestConflictRetries(t *testing.T) {
+	db := &racingDB{TempDB: &keydb.TempDB{}}
+	cryptor := keydb.NewAESGCMCryptor(0, []byte("testtesttesttest"))
+	m := NewKeyManager(cryptor, db, AclAuthorization)
+	u := auth.NewUser("test", []string{})
+	key := newKey("id1", knox.ACL{}, []byte("data"), u)
+	if err := m.AddNewKey(&key); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+
+	db.conflicts = 2
+	access := knox.Access{Type: knox.User, ID: "other", AccessType: knox.Write}
+	if err := m.UpdateAccess(key.ID, access); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	stored, err := m.GetKey(key.ID, knox.Primary)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	// Both the concurrent writes and the retried change must survive.
+	if len(stored.ACL) != 4 {
+		t.Fatalf("expected 4 ACL entries, got %v", stored.ACL)
+	}
+
+	db.conflicts = 1
+	v := newKeyVersion([]byte("data2"), knox.Active)
+	if err := m.AddVersion(key.ID, &v); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	db.conflicts = 1
+	if err := m.UpdateVersion(key.ID, v.ID, knox.Primary); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	stored, err = m.GetKey(key.ID, knox.Primary)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if stored.VersionList[0].ID != v.ID {
+		t.Fatalf("expected %d to be primary", v.ID)
+	}
+
+	m.(*keyManager).conflictRetries = 1
+	db.conflicts = 5
+	if err := m.UpdateAccess(key.ID, access); err != keydb.ErrDBVersion {
+		t.Fatalf("%v does not equal %s", err, keydb.ErrDBVersion)
+	}
+}
+
+func TestConditionalUpdates(t *testing.T) {
+	db := &racingDB{TempDB: &keydb.TempDB{}}
+	cryptor := keydb.NewAESGCMCryptor(0, []byte("testtesttesttest"))
+	m := NewKeyManager(cryptor, db, AclAuthorization)
+	u := auth.NewUser("test", []string{})
+	key := newKey("id1", knox.ACL{}, []byte("data"), u)
+	if err := m.AddNewKey(&key); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	etag := keyETag(key.VersionHash, key.ACL)
+	access := knox.Access{Type: knox.User, ID: "other", AccessType: knox.Write}
+
+	// A write that lands between the check and the update fails the
+	// precondition instead of being retried over.
+	db.conflicts = 1
+	if err := m.IfMatch(etag).UpdateAccess(key.ID, access); err != knox.ErrPreconditionFailed {
+		t.Fatalf("%v does not equal %s", err, knox.ErrPreconditionFailed)
+	}
+	stored, err := m.GetKey(key.ID, knox.Primary)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if len(stored.ACL) != 2 {
+		t.Fatalf("expected only the concurrent write, got %v", stored.ACL)
+	}
+
+	// The concurrent write changed the ETag.
+	if err := m.IfMatch(etag).DeleteKey(key.ID); err != knox.ErrPreconditionFailed {
+		t.Fatalf("%v does not equal %s", err, knox.ErrPreconditionFailed)
+	}
+	etag = keyETag(stored.VersionHash, stored.ACL)
+	if err := m.IfMatch(etag).UpdateAccess(key.ID, access); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if err := m.IfMatch(etag).PurgeKey(key.ID); err != knox.ErrPreconditionFailed {
+		t.Fatalf("%v does not equal %s", err, knox.ErrPreconditionFailed)
+	}
+	if err := m.IfMatch(etag).RenameKey(key.ID, "id2"); err != knox.ErrPreconditionFailed {
+		t.Fatalf("%v does not equal %s", err, knox.ErrPreconditionFailed)
+	}
+	if err := m.IfMatch("*").PurgeKey(key.ID); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+}
+
+func TestAccessRequestStorage(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("test", []string{})
+	key := newKey("id1", knox.ACL{}, []byte("data"), u)
+	if err := m.AddNewKey(&key); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	alice := knox.Access{Type: knox.User, ID: "alice", AccessType: knox.Read}
+
+	r1 := newAccessRequest("id1", alice, "oncall", 0, u)
+	if err := m.AddAccessRequest(&r1); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	alice.AccessType = knox.Write
+	r2 := newAccessRequest("id1", alice, "rotation", 0, u)
+	if err := m.AddAccessRequest(&r2); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	missing := newAccessRequest("id2", alice, "rotation", 0, u)
+	if err := m.AddAccessRequest(&missing); err != knox.ErrKeyIDNotFound {
+		t.Fatalf("%v is not %v", err, knox.ErrKeyIDNotFound)
+	}
+
+	// The newer request for the same principal replaces the older one, and
+	// requests follow their key when it is renamed.
+	if err := m.RenameKey("id1", "id3"); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	requests, err := m.GetAccessRequests()
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if len(requests) != 1 || requests[0].ID != r2.ID || requests[0].KeyID != "id3" {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	if _, err := m.GetAccessRequest("id3", r1.ID); err != knox.ErrAccessRequestNotFound {
+		t.Fatalf("%v is not %v", err, knox.ErrAccessRequestNotFound)
+	}
+
+	if err := m.RemoveAccessRequest("id3", r2.ID); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if err := m.RemoveAccessRequest("id3", r2.ID); err != knox.ErrAccessRequestNotFound {
+		t.Fatalf("%v is not %v", err, knox.ErrAccessRequestNotFound)
+	}
 }
@@ -0,0 +1,99 @@
+package keydb
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+// removeExpiredAccess removes the entries of k's ACL that have expired at now
+// and returns them.
+func (k *DBKey) removeExpiredAccess(now time.Time) knox.ACL {
+	expired := knox.ACL{}
+	acl := knox.ACL{}
+	for _, a := range k.ACL {
+		if a.Expired(now) {
+			expired = append(expired, a)
+			continue
+		}
+		acl = append(acl, a)
+	}
+	if len(expired) > 0 {
+		k.ACL = acl
+	}
+	return expired
+}
+
+// removeExpiredRequests drops the access requests of k that expired at now
+// without being decided and reports whether there were any.
+func (k *DBKey) removeExpiredRequests(now time.Time) bool {
+	pending := []knox.AccessRequest{}
+	for _, r := range k.AccessRequests {
+		if r.ExpiresAt > now.UnixNano() {
+			pending = append(pending, r)
+		}
+	}
+	if len(pending) == len(k.AccessRequests) {
+		return false
+	}
+	k.AccessRequests = pending
+	return true
+}
+
+// RemoveExpiredAccess removes the ACL entries of the keys in db that have
+// expired at now, and drops their expired access requests. It returns the
+// removed entries by key ID. Keys that are changed concurrently are left for
+// the next run.
+func RemoveExpiredAccess(db DB, now time.Time) (map[string]knox.ACL, error) {
+	keys, err := db.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	removed := map[string]knox.ACL{}
+	for i := range keys {
+		k := keys[i].Copy()
+		expired := k.removeExpiredAccess(now)
+		if !k.removeExpiredRequests(now) && len(expired) == 0 {
+			continue
+		}
+		err := db.Update(k)
+		if err == ErrDBVersion || err == knox.ErrKeyIDNotFound {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if len(expired) > 0 {
+			removed[k.ID] = expired
+		}
+	}
+	return removed, nil
+}
+
+// StartAccessExpiry runs RemoveExpiredAccess every interval until the returned
+// function is called. Each key's removed entries are passed to audit.
+func StartAccessExpiry(db DB, interval time.Duration, audit func(keyID string, expired knox.ACL)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				removed, err := RemoveExpiredAccess(db, time.Now())
+				if err != nil {
+					log.Printf("access expiry: failed: %s", err.Error())
+				}
+				for id, expired := range removed {
+					audit(id, expired)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
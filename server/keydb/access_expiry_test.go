@@ -0,0 +1,75 @@
+package keydb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func TestRemoveExpiredAccess(t *testing.T) {
+	db := NewTempDB()
+	now := time.Now()
+	permanent := knox.Access{ID: "admin", AccessType: knox.Admin, Type: knox.User}
+	expired := knox.Access{ID: "contractor", AccessType: knox.Read, Type: knox.User, ExpiresAt: now.Add(-time.Minute).UnixNano()}
+	pending := knox.Access{ID: "responder", AccessType: knox.Write, Type: knox.User, ExpiresAt: now.Add(time.Hour).UnixNano()}
+
+	k := newDBKey("temporary", []byte("a"), 0)
+	k.ACL = knox.ACL{permanent, expired, pending}
+	untouched := newDBKey("untouched", []byte("b"), 0)
+	untouched.ACL = knox.ACL{permanent, pending}
+	if err := db.Add(&k, &untouched); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+
+	removed, err := RemoveExpiredAccess(db, now)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(removed) != 1 || len(removed["temporary"]) != 1 || removed["temporary"][0] != expired {
+		t.Fatalf("unexpected removed entries %v", removed)
+	}
+	stored, err := db.Get("temporary")
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(stored.ACL) != 2 || stored.ACL[0] != permanent || stored.ACL[1] != pending {
+		t.Fatalf("unexpected ACL %v", stored.ACL)
+	}
+
+	removed, err = RemoveExpiredAccess(db, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("unexpected removed entries %v", removed)
+	}
+}
+
+func TestRemoveExpiredRequests(t *testing.T) {
+	db := NewTempDB()
+	now := time.Now()
+	k := newDBKey("requested", []byte("a"), 0)
+	k.AccessRequests = []knox.AccessRequest{
+		{ID: 1, RequestedBy: "alice", ExpiresAt: now.Add(-time.Minute).UnixNano()},
+		{ID: 2, RequestedBy: "bob", ExpiresAt: now.Add(time.Hour).UnixNano()},
+	}
+	if err := db.Add(&k); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+
+	removed, err := RemoveExpiredAccess(db, now)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("unexpected removed entries %v", removed)
+	}
+	stored, err := db.Get("requested")
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(stored.AccessRequests) != 1 || stored.AccessRequests[0].ID != 2 {
+		t.Fatalf("unexpected requests %v", stored.AccessRequests)
+	}
+}
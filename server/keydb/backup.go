@@ -0,0 +1,321 @@
+package keydb
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+// BackupFormat identifies knox backup files.
+const BackupFormat = "knox-backup"
+
+// BackupVersion is the version of the backup format written by WriteBackup.
+const BackupVersion = 1
+
+var (
+	ErrBackupFormat     = fmt.Errorf("Not a knox backup file")
+	ErrBackupVersion    = fmt.Errorf("Unsupported backup format version")
+	ErrBackupChecksum   = fmt.Errorf("Backup checksum does not match")
+	ErrBackupCount      = fmt.Errorf("Backup key count does not match")
+	ErrBackupNoManifest = fmt.Errorf("Backup is truncated: missing manifest")
+	ErrBackupNeedsKey   = fmt.Errorf("Backup is encrypted and no private key was given")
+)
+
+// BackupHeader is the first line of a backup file. It is never encrypted.
+type BackupHeader struct {
+	Format    string `json:"format"`
+	Version   int    `json:"version"`
+	Encrypted bool   `json:"encrypted"`
+	// WrappedKey is the AES-256 record key encrypted to the backup public key
+	// with RSA-OAEP (SHA-256). It is only set on encrypted backups.
+	WrappedKey []byte `json:"wrapped_key,omitempty"`
+}
+
+// BackupManifest is the last record of a backup file. Checksum is the hex
+// encoded SHA-256 of every key record in the order they were written.
+type BackupManifest struct {
+	Count     int    `json:"count"`
+	Checksum  string `json:"checksum"`
+	CreatedAt int64  `json:"ts"`
+}
+
+// backupRecord is a single line in the body of a backup file. Exactly one of
+// Key and Manifest is set.
+type backupRecord struct {
+	Key      *DBKey          `json:"key,omitempty"`
+	Manifest *BackupManifest `json:"manifest,omitempty"`
+}
+
+// WriteBackup writes keys to w in the knox backup format: a header line, one
+// line per DBKey and a trailing manifest. Keys are written as stored, so their
+// data stays encrypted with the cryptor of the database they came from. If pub
+// is not nil, every record line is additionally sealed with a random AES-GCM
+// key that is wrapped with pub.
+func WriteBackup(w io.Writer, keys []DBKey, pub *rsa.PublicKey) (*BackupManifest, error) {
+	header := BackupHeader{Format: BackupFormat, Version: BackupVersion}
+	var gcm cipher.AEAD
+	if pub != nil {
+		recordKey := make([]byte, 32)
+		if _, err := rand.Read(recordKey); err != nil {
+			return nil, err
+		}
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, recordKey, []byte(BackupFormat))
+		if err != nil {
+			return nil, err
+		}
+		gcm, err = newBackupAEAD(recordKey)
+		if err != nil {
+			return nil, err
+		}
+		header.Encrypted = true
+		header.WrappedKey = wrapped
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeBackupLine(bw, header); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.New()
+	var seq uint64
+	for i := range keys {
+		line, err := json.Marshal(backupRecord{Key: &keys[i]})
+		if err != nil {
+			return nil, err
+		}
+		sum.Write(line)
+		if err := writeRecordLine(bw, gcm, seq, line); err != nil {
+			return nil, err
+		}
+		seq++
+	}
+
+	manifest := &BackupManifest{
+		Count:     len(keys),
+		Checksum:  hex.EncodeToString(sum.Sum(nil)),
+		CreatedAt: time.Now().UnixNano(),
+	}
+	line, err := json.Marshal(backupRecord{Manifest: manifest})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeRecordLine(bw, gcm, seq, line); err != nil {
+		return nil, err
+	}
+	return manifest, bw.Flush()
+}
+
+// ReadBackup reads a backup written by WriteBackup and verifies its manifest.
+// priv is only required for encrypted backups.
+func ReadBackup(r io.Reader, priv *rsa.PrivateKey) ([]DBKey, *BackupManifest, error) {
+	scanner := bufio.NewScanner(r)
+	// Records hold whole keys with all of their versions.
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrBackupFormat
+	}
+	var header BackupHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != BackupFormat {
+		return nil, nil, ErrBackupFormat
+	}
+	if header.Version != BackupVersion {
+		return nil, nil, ErrBackupVersion
+	}
+
+	var gcm cipher.AEAD
+	if header.Encrypted {
+		if priv == nil {
+			return nil, nil, ErrBackupNeedsKey
+		}
+		recordKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, header.WrappedKey, []byte(BackupFormat))
+		if err != nil {
+			return nil, nil, err
+		}
+		gcm, err = newBackupAEAD(recordKey)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	keys := []DBKey{}
+	sum := sha256.New()
+	var seq uint64
+	for scanner.Scan() {
+		line, err := readRecordLine(gcm, seq, scanner.Bytes())
+		if err != nil {
+			return nil, nil, err
+		}
+		seq++
+
+		var rec backupRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case rec.Key != nil:
+			sum.Write(line)
+			keys = append(keys, *rec.Key)
+		case rec.Manifest != nil:
+			if rec.Manifest.Count != len(keys) {
+				return nil, nil, ErrBackupCount
+			}
+			if rec.Manifest.Checksum != hex.EncodeToString(sum.Sum(nil)) {
+				return nil, nil, ErrBackupChecksum
+			}
+			return keys, rec.Manifest, nil
+		default:
+			return nil, nil, ErrBackupFormat
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return nil, nil, ErrBackupNoManifest
+}
+
+// RestoreIssue describes a key from a backup that was not restored.
+type RestoreIssue struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// RestoreReport summarizes the result of RestoreBackup.
+type RestoreReport struct {
+	Restored  []string       `json:"restored"`
+	Conflicts []RestoreIssue `json:"conflicts"`
+	Invalid   []RestoreIssue `json:"invalid"`
+}
+
+// RestoreBackup adds keys read from a backup to db. Every key is decrypted with
+// src and checked with knox.Key.Validate before it is written. If dst is not
+// nil, keys are re-encrypted with it so a backup can be restored under a new
+// master key. Keys that already exist in db are reported as conflicts and are
+// never overwritten.
+func RestoreBackup(db DB, keys []DBKey, src, dst Cryptor) (*RestoreReport, error) {
+	report := &RestoreReport{
+		Restored:  []string{},
+		Conflicts: []RestoreIssue{},
+		Invalid:   []RestoreIssue{},
+	}
+	for i := range keys {
+		dbk := &keys[i]
+		k, err := src.Decrypt(dbk)
+		if err != nil {
+			report.Invalid = append(report.Invalid, RestoreIssue{dbk.ID, err.Error()})
+			continue
+		}
+		// Aliases and namespaces hold no versions, so only their ID and ACL can
+		// be checked.
+		if dbk.AliasOf != "" {
+			err = knox.KeyAlias{ID: dbk.ID, KeyID: dbk.AliasOf, ACL: dbk.ACL}.Validate()
+		} else if dbk.IsNamespace() {
+			err = knox.Namespace{Path: dbk.ID, ACL: dbk.ACL}.Validate()
+		} else {
+			err = k.Validate()
+		}
+		if err != nil {
+			report.Invalid = append(report.Invalid, RestoreIssue{dbk.ID, err.Error()})
+			continue
+		}
+
+		if _, err := db.Get(dbk.ID); err == nil {
+			report.Conflicts = append(report.Conflicts, RestoreIssue{dbk.ID, knox.ErrKeyExists.Error()})
+			continue
+		} else if err != knox.ErrKeyIDNotFound {
+			return report, err
+		}
+
+		if dst != nil {
+			state := dbk
+			dbk, err = dst.Encrypt(k)
+			if err != nil {
+				return report, err
+			}
+			dbk.CopyState(state)
+		}
+		err = db.Add(dbk)
+		switch err {
+		case nil:
+			report.Restored = append(report.Restored, dbk.ID)
+		case knox.ErrKeyExists:
+			report.Conflicts = append(report.Conflicts, RestoreIssue{dbk.ID, err.Error()})
+		default:
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func newBackupAEAD(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// backupAD binds a sealed record to its position in the file so records
+// cannot be reordered or moved between backups with the same key.
+func backupAD(seq uint64) []byte {
+	ad := make([]byte, 8)
+	binary.BigEndian.PutUint64(ad, seq)
+	return ad
+}
+
+func writeBackupLine(w *bufio.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
+
+func writeRecordLine(w *bufio.Writer, gcm cipher.AEAD, seq uint64, line []byte) error {
+	if gcm != nil {
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		sealed := gcm.Seal(nonce, nonce, line, backupAD(seq))
+		line = []byte(base64.StdEncoding.EncodeToString(sealed))
+	}
+	if _, err := w.Write(line); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
+
+func readRecordLine(gcm cipher.AEAD, seq uint64, line []byte) ([]byte, error) {
+	if gcm == nil {
+		// The scanner reuses its buffer between lines.
+		return append([]byte(nil), line...), nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(line))
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrBackupFormat
+	}
+	nonce := sealed[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, sealed[gcm.NonceSize():], backupAD(seq))
+}
@@ -0,0 +1,176 @@
+package keydb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func makeBackupKeys(t *testing.T, crypt Cryptor, ids ...string) []DBKey {
+	keys := []DBKey{}
+	for _, id := range ids {
+		k := makeTestKey()
+		k.ID = id
+		k.VersionHash = k.VersionList.Hash()
+		dbk, err := crypt.Encrypt(k)
+		if err != nil {
+			t.Fatalf("%s is not nil", err)
+		}
+		keys = append(keys, *dbk)
+	}
+	return keys
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	crypt := NewAESGCMCryptor(0, testSecret)
+	keys := makeBackupKeys(t, crypt, "a1", "a2", "a3")
+
+	var buf bytes.Buffer
+	manifest, err := WriteBackup(&buf, keys, nil)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if manifest.Count != 3 {
+		t.Fatalf("expected count 3 not %d", manifest.Count)
+	}
+
+	read, readManifest, err := ReadBackup(&buf, nil)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if len(read) != 3 || read[0].ID != "a1" || read[2].ID != "a3" {
+		t.Fatalf("unexpected keys %v", read)
+	}
+	if readManifest.Checksum != manifest.Checksum {
+		t.Fatalf("%s does not equal %s", readManifest.Checksum, manifest.Checksum)
+	}
+}
+
+func TestBackupEncrypted(t *testing.T) {
+	crypt := NewAESGCMCryptor(0, testSecret)
+	keys := makeBackupKeys(t, crypt, "plaintext_key_id", "a2")
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	_, err = WriteBackup(&buf, keys, &priv.PublicKey)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if strings.Contains(buf.String(), "plaintext_key_id") {
+		t.Fatal("encrypted backup contains plaintext key ID")
+	}
+	encrypted := buf.Bytes()
+
+	_, _, err = ReadBackup(bytes.NewReader(encrypted), nil)
+	if err != ErrBackupNeedsKey {
+		t.Fatalf("%v does not equal %s", err, ErrBackupNeedsKey)
+	}
+
+	read, _, err := ReadBackup(bytes.NewReader(encrypted), priv)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if len(read) != 2 || read[1].ID != "a2" {
+		t.Fatalf("unexpected keys %v", read)
+	}
+
+	// Swapping two sealed records must be detected.
+	lines := bytes.Split(bytes.TrimSpace(encrypted), []byte("\n"))
+	lines[1], lines[2] = lines[2], lines[1]
+	_, _, err = ReadBackup(bytes.NewReader(bytes.Join(lines, []byte("\n"))), priv)
+	if err == nil {
+		t.Fatal("Expected err")
+	}
+}
+
+func TestBackupTampered(t *testing.T) {
+	crypt := NewAESGCMCryptor(0, testSecret)
+	keys := makeBackupKeys(t, crypt, "a1", "a2")
+
+	var buf bytes.Buffer
+	if _, err := WriteBackup(&buf, keys, nil); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	tampered := strings.Join(append([]string{lines[0], strings.Replace(lines[1], "a1", "b1", 1)}, lines[2:]...), "\n")
+	_, _, err := ReadBackup(strings.NewReader(tampered), nil)
+	if err != ErrBackupChecksum {
+		t.Fatalf("%v does not equal %s", err, ErrBackupChecksum)
+	}
+
+	dropped := strings.Join(append([]string{lines[0]}, lines[2:]...), "\n")
+	_, _, err = ReadBackup(strings.NewReader(dropped), nil)
+	if err != ErrBackupCount {
+		t.Fatalf("%v does not equal %s", err, ErrBackupCount)
+	}
+
+	truncated := strings.Join(lines[:len(lines)-1], "\n")
+	_, _, err = ReadBackup(strings.NewReader(truncated), nil)
+	if err != ErrBackupNoManifest {
+		t.Fatalf("%v does not equal %s", err, ErrBackupNoManifest)
+	}
+
+	_, _, err = ReadBackup(strings.NewReader("{}\n"), nil)
+	if err != ErrBackupFormat {
+		t.Fatalf("%v does not equal %s", err, ErrBackupFormat)
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	src := NewAESGCMCryptor(0, testSecret)
+	dst := NewAESGCMCryptor(1, []byte("othersecretotherkey1234567890abc"))
+	keys := makeBackupKeys(t, src, "a1", "a2", "a3")
+	// Break the version hash of one key so that validation fails.
+	keys[2].VersionHash = "bogus"
+
+	db := NewTempDB()
+	existing := newDBKey("a2", []byte("data"), 0)
+	if err := db.Add(&existing); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+
+	report, err := RestoreBackup(db, keys, src, dst)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if len(report.Restored) != 1 || report.Restored[0] != "a1" {
+		t.Fatalf("unexpected restored keys %v", report.Restored)
+	}
+	if len(report.Conflicts) != 1 || report.Conflicts[0].ID != "a2" {
+		t.Fatalf("unexpected conflicts %v", report.Conflicts)
+	}
+	if len(report.Invalid) != 1 || report.Invalid[0].ID != "a3" {
+		t.Fatalf("unexpected invalid keys %v", report.Invalid)
+	}
+
+	restored, err := db.Get("a1")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	k, err := dst.Decrypt(restored)
+	if err != nil {
+		t.Fatalf("restored key is not encrypted with destination cryptor: %s", err)
+	}
+	if string(k.VersionList[0].Data) != "data" {
+		t.Fatalf("unexpected data %s", k.VersionList[0].Data)
+	}
+
+	conflict, err := db.Get("a2")
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if len(conflict.ACL) != 0 {
+		t.Fatal("existing key was overwritten")
+	}
+	if _, err := db.Get("a3"); err != knox.ErrKeyIDNotFound {
+		t.Fatalf("%v does not equal %s", err, knox.ErrKeyIDNotFound)
+	}
+}
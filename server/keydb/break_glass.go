@@ -0,0 +1,82 @@
+package keydb
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+// removeExpiredBreakGlassGrants removes the break-glass grants of k that have
+// expired at now and returns them with their key ID set.
+func (k *DBKey) removeExpiredBreakGlassGrants(now time.Time) []knox.BreakGlassGrant {
+	expired := []knox.BreakGlassGrant{}
+	active := []knox.BreakGlassGrant{}
+	for _, g := range k.BreakGlassGrants {
+		if g.ExpiresAt <= now.UnixNano() {
+			g.KeyID = k.ID
+			expired = append(expired, g)
+			continue
+		}
+		active = append(active, g)
+	}
+	if len(expired) > 0 {
+		k.BreakGlassGrants = active
+	}
+	return expired
+}
+
+// RemoveExpiredBreakGlassGrants removes the break-glass grants of the keys in
+// db, including keys in the trash, that have expired at now, so that keys do
+// not keep a record of every grant ever taken. It returns the removed grants.
+// Keys that are changed concurrently are left for the next run.
+func RemoveExpiredBreakGlassGrants(db DB, now time.Time) ([]knox.BreakGlassGrant, error) {
+	keys, err := db.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	removed := []knox.BreakGlassGrant{}
+	for i := range keys {
+		k := keys[i].Copy()
+		expired := k.removeExpiredBreakGlassGrants(now)
+		if len(expired) == 0 {
+			continue
+		}
+		err := db.Update(k)
+		if err == ErrDBVersion || err == knox.ErrKeyIDNotFound {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, expired...)
+	}
+	return removed, nil
+}
+
+// StartBreakGlassExpiry runs RemoveExpiredBreakGlassGrants every interval until
+// the returned function is called. The removed grants are passed to archive.
+func StartBreakGlassExpiry(db DB, interval time.Duration, archive func([]knox.BreakGlassGrant)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				removed, err := RemoveExpiredBreakGlassGrants(db, time.Now())
+				if err != nil {
+					log.Printf("break-glass expiry: failed: %s", err.Error())
+				}
+				if len(removed) > 0 {
+					archive(removed)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,51 @@
+package keydb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func TestRemoveExpiredBreakGlassGrants(t *testing.T) {
+	db := NewTempDB()
+	now := time.Now()
+	expired := knox.BreakGlassGrant{Principal: "oncall", Justification: "incident 42", GrantedAt: now.Add(-time.Hour).UnixNano(), ExpiresAt: now.Add(-time.Minute).UnixNano()}
+	active := knox.BreakGlassGrant{Principal: "oncall", Justification: "incident 43", GrantedAt: now.UnixNano(), ExpiresAt: now.Add(time.Hour).UnixNano()}
+
+	k := newDBKey("broken", []byte("a"), 0)
+	k.BreakGlassGrants = []knox.BreakGlassGrant{expired, active}
+	trashed := newDBKey("trashed", []byte("b"), 0)
+	trashed.DeletedAt = now.UnixNano()
+	trashed.BreakGlassGrants = []knox.BreakGlassGrant{expired}
+	if err := db.Add(&k, &trashed); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+
+	removed, err := RemoveExpiredBreakGlassGrants(db, now)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("unexpected removed grants %v", removed)
+	}
+	for _, g := range removed {
+		if g.Justification != expired.Justification || (g.KeyID != "broken" && g.KeyID != "trashed") {
+			t.Fatalf("unexpected removed grant %+v", g)
+		}
+	}
+	stored, err := db.Get("broken")
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(stored.BreakGlassGrants) != 1 || stored.BreakGlassGrants[0] != active {
+		t.Fatalf("unexpected grants %v", stored.BreakGlassGrants)
+	}
+	stored, err = db.Get("trashed")
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(stored.BreakGlassGrants) != 0 {
+		t.Fatalf("unexpected grants %v", stored.BreakGlassGrants)
+	}
+}
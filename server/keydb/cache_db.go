@@ -0,0 +1,218 @@
+package keydb
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChangeNotifier is implemented by backends that can report keys changed by
+// other knox servers sharing the same database.
+type ChangeNotifier interface {
+	// Watch calls onChange with the ID and new DBVersion of every key that is
+	// added, updated or removed, with version 0 for removals, until ctx is
+	// done. It calls onReset every time the watch is established, since
+	// changes made while it was down are not reported.
+	Watch(ctx context.Context, onChange func(id string, version int64), onReset func())
+}
+
+// CacheStats are the counters of a CachedDB.
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// CachedDB is a DB that keeps recently read keys in a bounded LRU cache in
+// front of another DB, keyed by ID and DBVersion. Entries are dropped when
+// this server writes the key, when the backend reports a version of the key
+// other than the cached one (see ChangeNotifier), when the backend's watch is
+// reestablished and at the latest after maxStaleness, which bounds how out of
+// date a read can be when a change made by another server is missed.
+//
+// GetAll is not cached.
+type CachedDB struct {
+	db           DB
+	maxEntries   int
+	maxStaleness time.Duration
+	now          func() time.Time
+	cancelWatch  context.CancelFunc
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	// versions is the DBVersion of the cached copy of each key.
+	versions map[string]int64
+	lru      *list.List
+	// invalidations is bumped on every invalidation so that a read that raced
+	// with a write does not put the old value back into the cache.
+	invalidations uint64
+
+	hits   uint64
+	misses uint64
+}
+
+type cacheKey struct {
+	id      string
+	version int64
+}
+
+type cacheEntry struct {
+	key     DBKey
+	fetched time.Time
+}
+
+func (e *cacheEntry) cacheKey() cacheKey {
+	return cacheKey{e.key.ID, e.key.DBVersion}
+}
+
+// NewCachedDB wraps db with a cache holding at most maxEntries keys for at most
+// maxStaleness each. If db implements ChangeNotifier, its notifications are
+// used to invalidate entries until Close is called.
+func NewCachedDB(db DB, maxEntries int, maxStaleness time.Duration) *CachedDB {
+	c := &CachedDB{
+		db:           db,
+		maxEntries:   maxEntries,
+		maxStaleness: maxStaleness,
+		now:          time.Now,
+		entries:      map[cacheKey]*list.Element{},
+		versions:     map[string]int64{},
+		lru:          list.New(),
+	}
+	if notifier, ok := db.(ChangeNotifier); ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancelWatch = cancel
+		go notifier.Watch(ctx, c.changed, c.Flush)
+	}
+	return c
+}
+
+// Close stops listening for backend change notifications.
+func (c *CachedDB) Close() {
+	if c.cancelWatch != nil {
+		c.cancelWatch()
+	}
+}
+
+// Stats returns the cache hit and miss counters.
+func (c *CachedDB) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Entries: entries,
+	}
+}
+
+// Invalidate drops the cached copy of the key, if any.
+func (c *CachedDB) Invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidations++
+	c.remove(id)
+}
+
+// changed drops the cached copy of the key unless it is the version the
+// backend reports, such as when the notification is for a write this server
+// already read back.
+func (c *CachedDB) changed(id string, version int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.versions[id]; ok && cached == version {
+		return
+	}
+	c.invalidations++
+	c.remove(id)
+}
+
+// Flush drops every cached key.
+func (c *CachedDB) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidations++
+	c.entries = map[cacheKey]*list.Element{}
+	c.versions = map[string]int64{}
+	c.lru.Init()
+}
+
+// remove drops the cached copy of the key. c.mu must be held.
+func (c *CachedDB) remove(id string) {
+	version, ok := c.versions[id]
+	if !ok {
+		return
+	}
+	k := cacheKey{id, version}
+	c.lru.Remove(c.entries[k])
+	delete(c.entries, k)
+	delete(c.versions, id)
+}
+
+// Get returns the key specified by the ID, from the cache if it is fresh.
+func (c *CachedDB) Get(id string) (*DBKey, error) {
+	c.mu.Lock()
+	if version, ok := c.versions[id]; ok {
+		e := c.entries[cacheKey{id, version}]
+		entry := e.Value.(*cacheEntry)
+		if c.now().Sub(entry.fetched) <= c.maxStaleness {
+			c.lru.MoveToFront(e)
+			k := entry.key.Copy()
+			c.mu.Unlock()
+			atomic.AddUint64(&c.hits, 1)
+			return k, nil
+		}
+		c.remove(id)
+	}
+	generation := c.invalidations
+	c.mu.Unlock()
+
+	atomic.AddUint64(&c.misses, 1)
+	k, err := c.db.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.invalidations && c.maxEntries > 0 {
+		c.remove(id)
+		entry := &cacheEntry{*k.Copy(), c.now()}
+		c.entries[entry.cacheKey()] = c.lru.PushFront(entry)
+		c.versions[id] = k.DBVersion
+		for c.lru.Len() > c.maxEntries {
+			c.remove(c.lru.Back().Value.(*cacheEntry).key.ID)
+		}
+	}
+	return k, nil
+}
+
+// GetAll returns all of the keys in the underlying database.
+func (c *CachedDB) GetAll() ([]DBKey, error) {
+	return c.db.GetAll()
+}
+
+// Update makes an update to DBKey indexed by its ID and drops the cached copy.
+func (c *CachedDB) Update(key *DBKey) error {
+	// Invalidate even if the update failed: a version conflict means the
+	// cached copy is out of date.
+	defer c.Invalidate(key.ID)
+	return c.db.Update(key)
+}
+
+// Add adds the key(s) to the DB.
+func (c *CachedDB) Add(keys ...*DBKey) error {
+	defer func() {
+		for _, k := range keys {
+			c.Invalidate(k.ID)
+		}
+	}()
+	return c.db.Add(keys...)
+}
+
+// Remove permanently removes the key and drops the cached copy.
+func (c *CachedDB) Remove(id string) error {
+	defer c.Invalidate(id)
+	return c.db.Remove(id)
+}
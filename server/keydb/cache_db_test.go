@@ -0,0 +1,197 @@
+package keydb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func TestCachedDB(t *testing.T) {
+	db := NewCachedDB(NewTempDB(), 10, time.Minute)
+	timeout := 100 * time.Millisecond
+	TesterAddGet(t, db, timeout)
+	TesterAddUpdate(t, db, timeout)
+	TesterAddRemove(t, db, timeout)
+}
+
+func TestCachedDBHitsAndMisses(t *testing.T) {
+	backend := NewTempDB()
+	db := NewCachedDB(backend, 10, time.Minute)
+	k := newDBKey("cached1", []byte("a"), 0)
+	if err := db.Add(&k); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := db.Get(k.ID); err != nil {
+			t.Fatalf("%s not nil", err)
+		}
+	}
+	stats := db.Stats()
+	if stats.Misses != 1 || stats.Hits != 2 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// Writes through the cache invalidate the entry.
+	stored, _ := db.Get(k.ID)
+	stored.VersionHash = "updated"
+	if err := db.Update(stored); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	got, err := db.Get(k.ID)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if got.VersionHash != "updated" {
+		t.Fatalf("%s does not equal updated", got.VersionHash)
+	}
+
+	// Returned keys must not alias the cached copy.
+	got.VersionHash = "mutated"
+	got, _ = db.Get(k.ID)
+	if got.VersionHash != "updated" {
+		t.Fatalf("cached key was modified through a returned copy")
+	}
+
+	if err := db.Remove(k.ID); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if _, err := db.Get(k.ID); err != knox.ErrKeyIDNotFound {
+		t.Fatalf("%v does not equal %s", err, knox.ErrKeyIDNotFound)
+	}
+}
+
+func TestCachedDBStaleness(t *testing.T) {
+	backend := NewTempDB()
+	db := NewCachedDB(backend, 10, time.Second)
+	now := time.Unix(0, 0)
+	db.now = func() time.Time { return now }
+
+	k := newDBKey("cached1", []byte("a"), 0)
+	if err := backend.Add(&k); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if _, err := db.Get(k.ID); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+
+	// A change the cache does not hear about is served stale until the bound.
+	stored, _ := backend.Get(k.ID)
+	stored.VersionHash = "elsewhere"
+	if err := backend.Update(stored); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	got, _ := db.Get(k.ID)
+	if got.VersionHash == "elsewhere" {
+		t.Fatal("expected cached value within staleness bound")
+	}
+	now = now.Add(2 * time.Second)
+	got, _ = db.Get(k.ID)
+	if got.VersionHash != "elsewhere" {
+		t.Fatal("expected fresh value after staleness bound")
+	}
+}
+
+func TestCachedDBEviction(t *testing.T) {
+	backend := NewTempDB()
+	db := NewCachedDB(backend, 2, time.Minute)
+	for _, id := range []string{"a", "b", "c"} {
+		k := newDBKey(id, []byte("a"), 0)
+		if err := db.Add(&k); err != nil {
+			t.Fatalf("%s not nil", err)
+		}
+		if _, err := db.Get(id); err != nil {
+			t.Fatalf("%s not nil", err)
+		}
+	}
+	if stats := db.Stats(); stats.Entries != 2 {
+		t.Fatalf("expected 2 entries not %d", stats.Entries)
+	}
+	// "a" was the least recently used and must have been evicted.
+	db.Get("a")
+	if stats := db.Stats(); stats.Misses != 4 {
+		t.Fatalf("expected 4 misses not %d", stats.Misses)
+	}
+}
+
+type watchCallbacks struct {
+	onChange func(string, int64)
+	onReset  func()
+}
+
+type notifyingDB struct {
+	DB
+	watching chan watchCallbacks
+}
+
+func (n *notifyingDB) Watch(ctx context.Context, onChange func(id string, version int64), onReset func()) {
+	onReset()
+	n.watching <- watchCallbacks{onChange, onReset}
+	<-ctx.Done()
+}
+
+func newNotifyingDB() *notifyingDB {
+	return &notifyingDB{NewTempDB(), make(chan watchCallbacks, 1)}
+}
+
+func TestCachedDBChangeNotifications(t *testing.T) {
+	backend := newNotifyingDB()
+	db := NewCachedDB(backend, 10, time.Minute)
+	defer db.Close()
+	watch := <-backend.watching
+
+	k := newDBKey("cached1", []byte("a"), 0)
+	if err := backend.Add(&k); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	cached, _ := db.Get(k.ID)
+
+	// A notification for the version already cached keeps the entry.
+	watch.onChange(k.ID, cached.DBVersion)
+	db.Get(k.ID)
+	if stats := db.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	stored, _ := backend.Get(k.ID)
+	stored.VersionHash = "elsewhere"
+	if err := backend.Update(stored); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	updated, _ := backend.Get(k.ID)
+	watch.onChange(k.ID, updated.DBVersion)
+	got, _ := db.Get(k.ID)
+	if got.VersionHash != "elsewhere" {
+		t.Fatal("expected notification to invalidate cached key")
+	}
+}
+
+func TestCachedDBWatchReset(t *testing.T) {
+	backend := newNotifyingDB()
+	db := NewCachedDB(backend, 10, time.Minute)
+	defer db.Close()
+	watch := <-backend.watching
+
+	k := newDBKey("cached1", []byte("a"), 0)
+	if err := backend.Add(&k); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	db.Get(k.ID)
+	stored, _ := backend.Get(k.ID)
+	stored.VersionHash = "while disconnected"
+	if err := backend.Update(stored); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	// The change was missed while the watch was down; reestablishing it
+	// flushes the cache.
+	watch.onReset()
+	if stats := db.Stats(); stats.Entries != 0 {
+		t.Fatalf("expected an empty cache not %+v", stats)
+	}
+	got, _ := db.Get(k.ID)
+	if got.VersionHash != "while disconnected" {
+		t.Fatal("expected reset to invalidate cached key")
+	}
+}
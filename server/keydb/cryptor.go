@@ -111,6 +111,7 @@ func (c *aesGCMCryptor) Encrypt(k *knox.Key) (*DBKey, error) {
 		ACL:         k.ACL,
 		VersionList: dbVersions,
 		VersionHash: k.VersionHash,
+		Path:        k.Path,
 	}
 	return &newKey, nil
 }
@@ -130,6 +131,7 @@ func (c *aesGCMCryptor) Decrypt(k *DBKey) (*knox.Key, error) {
 		ACL:         k.ACL,
 		VersionList: versions,
 		VersionHash: k.VersionHash,
+		Path:        k.Path,
 	}
 	return &newKey, nil
 }
@@ -0,0 +1,301 @@
+package keydb
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+// DualDB is a DB that serves reads from a primary backend and mirrors every
+// write to a secondary backend. It is meant for moving between backends
+// without downtime: run with the old backend as primary until Reconcile
+// reports no drift, then Swap so the new backend becomes primary.
+//
+// The primary is authoritative. A failed write to the secondary is logged and
+// left for the next reconciliation instead of failing the request.
+type DualDB struct {
+	mu        sync.RWMutex
+	primary   DB
+	secondary DB
+	// swapped is closed and replaced on every Swap.
+	swapped chan struct{}
+}
+
+// NewDualDB creates a DualDB that reads from primary and mirrors to secondary.
+func NewDualDB(primary, secondary DB) *DualDB {
+	return &DualDB{primary: primary, secondary: secondary, swapped: make(chan struct{})}
+}
+
+// Swap makes the secondary backend the primary and vice versa.
+func (d *DualDB) Swap() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.primary, d.secondary = d.secondary, d.primary
+	close(d.swapped)
+	d.swapped = make(chan struct{})
+}
+
+// Watch forwards the change notifications of the primary backend, if it is a
+// ChangeNotifier, until ctx is done. After a Swap it watches the new primary
+// and calls onReset, since reads are then served from another backend.
+func (d *DualDB) Watch(ctx context.Context, onChange func(id string, version int64), onReset func()) {
+	for {
+		d.mu.RLock()
+		primary, swapped := d.primary, d.swapped
+		d.mu.RUnlock()
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		if notifier, ok := primary.(ChangeNotifier); ok {
+			go func() {
+				defer close(done)
+				notifier.Watch(watchCtx, onChange, onReset)
+			}()
+		} else {
+			close(done)
+		}
+		select {
+		case <-ctx.Done():
+			cancel()
+			<-done
+			return
+		case <-swapped:
+			cancel()
+			<-done
+			onReset()
+		}
+	}
+}
+
+func (d *DualDB) backends() (DB, DB) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.primary, d.secondary
+}
+
+// Get returns the key specified by the ID from the primary backend.
+func (d *DualDB) Get(id string) (*DBKey, error) {
+	primary, _ := d.backends()
+	return primary.Get(id)
+}
+
+// GetAll returns all of the keys in the primary backend.
+func (d *DualDB) GetAll() ([]DBKey, error) {
+	primary, _ := d.backends()
+	return primary.GetAll()
+}
+
+// Update updates the key in the primary backend and mirrors it to the secondary.
+func (d *DualDB) Update(key *DBKey) error {
+	primary, secondary := d.backends()
+	if err := primary.Update(key); err != nil {
+		return err
+	}
+	if err := mirrorPut(secondary, key); err != nil {
+		log.Printf("dual db: failed to mirror update of %s: %s", key.ID, err.Error())
+	}
+	return nil
+}
+
+// Add adds the keys to the primary backend and mirrors them to the secondary.
+func (d *DualDB) Add(keys ...*DBKey) error {
+	primary, secondary := d.backends()
+	if err := primary.Add(keys...); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := mirrorPut(secondary, key); err != nil {
+			log.Printf("dual db: failed to mirror add of %s: %s", key.ID, err.Error())
+		}
+	}
+	return nil
+}
+
+// Remove removes the key from the primary backend and mirrors the removal to
+// the secondary.
+func (d *DualDB) Remove(id string) error {
+	primary, secondary := d.backends()
+	if err := primary.Remove(id); err != nil {
+		return err
+	}
+	if err := secondary.Remove(id); err != nil && err != knox.ErrKeyIDNotFound {
+		log.Printf("dual db: failed to mirror remove of %s: %s", id, err.Error())
+	}
+	return nil
+}
+
+// mirrorPut writes key to db regardless of the DBVersion db currently holds
+// for it, since versions are not comparable across backends.
+func mirrorPut(db DB, key *DBKey) error {
+	existing, err := db.Get(key.ID)
+	switch err {
+	case nil:
+		k := key.Copy()
+		k.DBVersion = existing.DBVersion
+		return db.Update(k)
+	case knox.ErrKeyIDNotFound:
+		return db.Add(key)
+	default:
+		return err
+	}
+}
+
+// HashMismatch is a key whose VersionHash differed between the two backends.
+type HashMismatch struct {
+	ID        string `json:"id"`
+	Primary   string `json:"primary"`
+	Secondary string `json:"secondary"`
+}
+
+// ReconcileReport describes the drift that Reconcile found and repaired.
+type ReconcileReport struct {
+	// Copied are keys that were missing from the secondary.
+	Copied []string `json:"copied"`
+	// Updated are keys whose ACL or versions differed in the secondary.
+	Updated []string `json:"updated"`
+	// Removed are keys that only existed in the secondary. Keys that were
+	// written while Reconcile ran are left for the next run.
+	Removed []string `json:"removed"`
+	// Mismatched are the updated keys whose VersionHash differed.
+	Mismatched []HashMismatch `json:"mismatched"`
+}
+
+// InSync reports whether reconciliation found no drift at all.
+func (r *ReconcileReport) InSync() bool {
+	return len(r.Copied) == 0 && len(r.Updated) == 0 && len(r.Removed) == 0
+}
+
+// Reconcile makes the secondary backend match the primary.
+func (d *DualDB) Reconcile() (*ReconcileReport, error) {
+	primary, secondary := d.backends()
+	report := &ReconcileReport{
+		Copied:     []string{},
+		Updated:    []string{},
+		Removed:    []string{},
+		Mismatched: []HashMismatch{},
+	}
+
+	primaryKeys, err := primary.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	secondaryKeys, err := secondary.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	mirrored := map[string]*DBKey{}
+	for i := range secondaryKeys {
+		mirrored[secondaryKeys[i].ID] = &secondaryKeys[i]
+	}
+
+	for i := range primaryKeys {
+		k := &primaryKeys[i]
+		m, ok := mirrored[k.ID]
+		delete(mirrored, k.ID)
+		if !ok {
+			if err := secondary.Add(k); err != nil {
+				return report, err
+			}
+			report.Copied = append(report.Copied, k.ID)
+			continue
+		}
+		same, err := sameDBKey(k, m)
+		if err != nil {
+			return report, err
+		}
+		if same {
+			continue
+		}
+		if k.VersionHash != m.VersionHash {
+			report.Mismatched = append(report.Mismatched, HashMismatch{k.ID, k.VersionHash, m.VersionHash})
+		}
+		update := k.Copy()
+		update.DBVersion = m.DBVersion
+		if err := secondary.Update(update); err != nil {
+			return report, err
+		}
+		report.Updated = append(report.Updated, k.ID)
+	}
+
+	for id, m := range mirrored {
+		stale, err := staleMirror(primary, secondary, m)
+		if err != nil {
+			return report, err
+		}
+		if !stale {
+			continue
+		}
+		if err := secondary.Remove(id); err != nil && err != knox.ErrKeyIDNotFound {
+			return report, err
+		}
+		report.Removed = append(report.Removed, id)
+	}
+	return report, nil
+}
+
+// staleMirror reports whether m, a key that was only in the secondary when
+// Reconcile listed the backends, is still unchanged in the secondary and
+// missing from the primary. A key that was added or mirrored since then is
+// not stale.
+func staleMirror(primary, secondary DB, m *DBKey) (bool, error) {
+	current, err := secondary.Get(m.ID)
+	if err == knox.ErrKeyIDNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if current.DBVersion != m.DBVersion {
+		return false, nil
+	}
+	_, err = primary.Get(m.ID)
+	if err == knox.ErrKeyIDNotFound {
+		return true, nil
+	}
+	return false, err
+}
+
+// StartReconciler runs Reconcile every interval until the returned function is
+// called, logging any drift that was repaired.
+func (d *DualDB) StartReconciler(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report, err := d.Reconcile()
+				if err != nil {
+					log.Printf("dual db: reconciliation failed: %s", err.Error())
+					continue
+				}
+				if !report.InSync() {
+					b, _ := json.Marshal(report)
+					log.Printf("dual db: reconciled drift: %s", b)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// sameDBKey compares the stored content of two keys, ignoring DBVersion.
+func sameDBKey(a, b *DBKey) (bool, error) {
+	aj, err := json.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	bj, err := json.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+	return string(aj) == string(bj), nil
+}
@@ -0,0 +1,208 @@
+package keydb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func TestDualDBMirrorsWrites(t *testing.T) {
+	primary := NewTempDB()
+	secondary := NewTempDB()
+	db := NewDualDB(primary, secondary)
+	timeout := 100 * time.Millisecond
+	TesterAddGet(t, db, timeout)
+	TesterAddUpdate(t, db, timeout)
+	TesterAddRemove(t, db, timeout)
+
+	k := newDBKey("dual1", []byte("a"), 0)
+	if err := db.Add(&k); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	stored, err := db.Get(k.ID)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	update := stored.Copy()
+	update.VersionHash = "updated"
+	if err := db.Update(update); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	mirrored, err := secondary.Get(k.ID)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if mirrored.VersionHash != "updated" {
+		t.Fatalf("%s does not equal updated", mirrored.VersionHash)
+	}
+
+	if err := db.Remove(k.ID); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if _, err := secondary.Get(k.ID); err != knox.ErrKeyIDNotFound {
+		t.Fatalf("%v does not equal %s", err, knox.ErrKeyIDNotFound)
+	}
+}
+
+func TestDualDBStaleUpdateFails(t *testing.T) {
+	db := NewDualDB(NewTempDB(), NewTempDB())
+	k := newDBKey("dual1", []byte("a"), 0)
+	if err := db.Add(&k); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	stale := k.Copy()
+	stale.DBVersion = -1
+	if err := db.Update(stale); err != ErrDBVersion {
+		t.Fatalf("%v does not equal %s", err, ErrDBVersion)
+	}
+}
+
+func TestDualDBReconcile(t *testing.T) {
+	primary := NewTempDB()
+	secondary := NewTempDB()
+	db := NewDualDB(primary, secondary)
+
+	missing := newDBKey("missing", []byte("a"), 0)
+	drifted := newDBKey("drifted", []byte("b"), 0)
+	drifted.VersionHash = "new"
+	if err := primary.Add(&missing, &drifted); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	oldDrifted := drifted.Copy()
+	oldDrifted.VersionHash = "old"
+	extra := newDBKey("extra", []byte("c"), 0)
+	if err := secondary.Add(oldDrifted, &extra); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+
+	report, err := db.Reconcile()
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(report.Copied) != 1 || report.Copied[0] != "missing" {
+		t.Fatalf("unexpected copied keys %v", report.Copied)
+	}
+	if len(report.Updated) != 1 || report.Updated[0] != "drifted" {
+		t.Fatalf("unexpected updated keys %v", report.Updated)
+	}
+	if len(report.Removed) != 1 || report.Removed[0] != "extra" {
+		t.Fatalf("unexpected removed keys %v", report.Removed)
+	}
+	if len(report.Mismatched) != 1 || report.Mismatched[0] != (HashMismatch{"drifted", "new", "old"}) {
+		t.Fatalf("unexpected mismatches %v", report.Mismatched)
+	}
+
+	report, err = db.Reconcile()
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if !report.InSync() {
+		t.Fatalf("expected backends to be in sync: %+v", report)
+	}
+}
+
+// racingDB runs race once with the keys it just listed, like a write that
+// lands while Reconcile works through the listing.
+type racingDB struct {
+	DB
+	race func(listed []DBKey)
+}
+
+func (d *racingDB) GetAll() ([]DBKey, error) {
+	keys, err := d.DB.GetAll()
+	// TempDB returns the slice it stores keys in.
+	keys = append([]DBKey{}, keys...)
+	if d.race != nil {
+		d.race(keys)
+		d.race = nil
+	}
+	return keys, err
+}
+
+func TestDualDBReconcileKeepsConcurrentWrites(t *testing.T) {
+	primary := &racingDB{DB: NewTempDB()}
+	secondary := &racingDB{DB: NewTempDB()}
+	db := NewDualDB(primary, secondary)
+
+	stale := newDBKey("stale", []byte("a"), 0)
+	changed := newDBKey("changed", []byte("b"), 0)
+	if err := secondary.Add(&stale, &changed); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	// A key is added to both backends after the primary was listed.
+	added := newDBKey("added", []byte("c"), 0)
+	primary.race = func([]DBKey) {
+		if err := db.Add(&added); err != nil {
+			t.Fatalf("%s not nil", err)
+		}
+	}
+	// A key only in the secondary changes after the secondary was listed, so
+	// the listing holds an older version of it.
+	secondary.race = func(listed []DBKey) {
+		for i := range listed {
+			if listed[i].ID == "changed" {
+				listed[i].DBVersion--
+			}
+		}
+	}
+
+	report, err := db.Reconcile()
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(report.Removed) != 1 || report.Removed[0] != "stale" {
+		t.Fatalf("unexpected removed keys %v", report.Removed)
+	}
+	for _, id := range []string{"added", "changed"} {
+		if _, err := secondary.Get(id); err != nil {
+			t.Fatalf("%s not nil", err)
+		}
+	}
+}
+
+func TestDualDBSwap(t *testing.T) {
+	primary := NewTempDB()
+	secondary := NewTempDB()
+	db := NewDualDB(primary, secondary)
+	k := newDBKey("only_secondary", []byte("a"), 0)
+	if err := secondary.Add(&k); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if _, err := db.Get(k.ID); err != knox.ErrKeyIDNotFound {
+		t.Fatalf("%v does not equal %s", err, knox.ErrKeyIDNotFound)
+	}
+	db.Swap()
+	if _, err := db.Get(k.ID); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+}
+
+func TestDualDBWatch(t *testing.T) {
+	primary := newNotifyingDB()
+	secondary := newNotifyingDB()
+	db := NewDualDB(primary, secondary)
+	resets := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go db.Watch(ctx, func(string, int64) {}, func() { resets <- struct{}{} })
+
+	<-primary.watching
+	<-resets
+	db.Swap()
+	// The swap resets and the new primary is watched instead.
+	<-secondary.watching
+	<-resets
+	<-resets
+	select {
+	case <-primary.watching:
+		t.Fatal("the old primary is watched again")
+	default:
+	}
+
+	// A cache in front of the DualDB is told about changes.
+	cached := NewCachedDB(db, 10, time.Minute)
+	defer cached.Close()
+	<-secondary.watching
+}
@@ -133,6 +133,31 @@ func (connector *EtcdConnector) Remove(id string) error {
 	return err
 }
 
+// Watch calls onChange with the ID and version of every key changed in etcd,
+// including changes made by other knox servers, until ctx is done. onReset is
+// called every time the watch is (re)established.
+func (connector *EtcdConnector) Watch(ctx context.Context, onChange func(id string, version int64), onReset func()) {
+	for {
+		watch := connector.etcdClient.Watch(ctx, "", clientv3.WithPrefix())
+		onReset()
+		for response := range watch {
+			if err := response.Err(); err != nil {
+				log.Printf("etcd watch error: %s", err)
+			}
+			for _, event := range response.Events {
+				onChange(string(event.Kv.Key), event.Kv.Version)
+			}
+		}
+		// The watch channel closes on cancellation or unrecoverable errors;
+		// reestablish it after a pause unless we are done.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
 func (connector *EtcdConnector) checkExistenceAndVersion(key *DBKey) error {
 	keyInDb, err := connector.Get(key.ID)
 
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,22 +19,103 @@ type DBKey struct {
 	ACL         knox.ACL        `json:"acl"`
 	VersionList []EncKeyVersion `json:"versions"`
 	VersionHash string          `json:"hash"`
+	// DeletedAt is the time in unix nanoseconds at which the key was moved to
+	// the trash. It is zero for live keys.
+	DeletedAt int64 `json:"deleted_at,omitempty"`
+	// AliasOf is set for aliases and holds the ID of the key the alias
+	// resolves to. Aliases have an ACL but no versions.
+	AliasOf string `json:"alias_of,omitempty"`
+	// VersionRetention is the optional policy for purging Inactive versions.
+	VersionRetention *knox.VersionRetention `json:"version_retention,omitempty"`
+	// Path is the namespace the key lives in. Namespaces themselves are stored
+	// as DBKeys whose ID is their path, with an ACL but no versions.
+	Path string `json:"path,omitempty"`
+	// AccessRequests are the requests for access to the key that are waiting
+	// to be approved or denied.
+	AccessRequests []knox.AccessRequest `json:"access_requests,omitempty"`
+	// RequiresQuorum holds destructive operations on the key as
+	// PendingOperations until a second admin confirms them.
+	RequiresQuorum    bool                    `json:"requires_quorum,omitempty"`
+	PendingOperations []knox.PendingOperation `json:"pending_operations,omitempty"`
+	// BreakGlassGrants are the emergency accesses taken to the key. Expired
+	// grants are moved out by RemoveExpiredBreakGlassGrants.
+	BreakGlassGrants []knox.BreakGlassGrant `json:"break_glass_grants,omitempty"`
 	// The version should be set by the db provider and is not part of the data.
 	DBVersion int64 `json:"-"`
 }
 
+// RehashVersions sets VersionHash from the version list, after versions were
+// removed from it or changed status without decrypting the key.
+func (k *DBKey) RehashVersions() {
+	kvl := make(knox.KeyVersionList, len(k.VersionList))
+	for i, v := range k.VersionList {
+		kvl[i] = knox.KeyVersion{ID: v.ID, Status: v.Status}
+	}
+	k.VersionHash = kvl.Hash()
+}
+
 // Copy provides a deep copy of database keys so that VersionLists can be edited in a copy.
 func (k *DBKey) Copy() *DBKey {
 	versionList := make([]EncKeyVersion, len(k.VersionList))
 	copy(versionList, k.VersionList)
 	acl := make([]knox.Access, len(k.ACL))
 	copy(acl, k.ACL)
-	return &DBKey{
-		ID:          k.ID,
-		ACL:         acl,
-		VersionList: versionList,
-		VersionHash: k.VersionHash,
-		DBVersion:   k.DBVersion,
+	c := &DBKey{
+		ID:             k.ID,
+		ACL:            acl,
+		VersionList:    versionList,
+		VersionHash:    k.VersionHash,
+		DeletedAt:      k.DeletedAt,
+		AliasOf:        k.AliasOf,
+		Path:           k.Path,
+		DBVersion:      k.DBVersion,
+		RequiresQuorum: k.RequiresQuorum,
+	}
+	if k.VersionRetention != nil {
+		r := *k.VersionRetention
+		c.VersionRetention = &r
+	}
+	if k.AccessRequests != nil {
+		c.AccessRequests = make([]knox.AccessRequest, len(k.AccessRequests))
+		copy(c.AccessRequests, k.AccessRequests)
+	}
+	if k.PendingOperations != nil {
+		c.PendingOperations = make([]knox.PendingOperation, len(k.PendingOperations))
+		copy(c.PendingOperations, k.PendingOperations)
+	}
+	if k.BreakGlassGrants != nil {
+		c.BreakGlassGrants = make([]knox.BreakGlassGrant, len(k.BreakGlassGrants))
+		copy(c.BreakGlassGrants, k.BreakGlassGrants)
+	}
+	return c
+}
+
+// IsNamespace reports whether k holds a namespace rather than a key or an
+// alias. Key IDs can not contain slashes, so namespaces are told apart by
+// their path.
+func (k *DBKey) IsNamespace() bool {
+	return strings.HasPrefix(k.ID, "/")
+}
+
+// CopyState copies the fields of from that are kept outside of the ACL and key
+// material: whether the key is in the trash or is an alias, its retention
+// policy, when its versions were deactivated, its pending access requests and
+// operations, whether it requires quorum and its break-glass grants. It is
+// used to carry them over when a key is encrypted again.
+func (k *DBKey) CopyState(from *DBKey) {
+	k.DeletedAt = from.DeletedAt
+	k.AliasOf = from.AliasOf
+	k.VersionRetention = from.VersionRetention
+	k.AccessRequests = from.AccessRequests
+	k.RequiresQuorum = from.RequiresQuorum
+	k.PendingOperations = from.PendingOperations
+	k.BreakGlassGrants = from.BreakGlassGrants
+	deactivated := map[uint64]int64{}
+	for _, v := range from.VersionList {
+		deactivated[v.ID] = v.DeactivatedAt
+	}
+	for i := range k.VersionList {
+		k.VersionList[i].DeactivatedAt = deactivated[k.VersionList[i].ID]
 	}
 }
 
@@ -44,6 +126,9 @@ type EncKeyVersion struct {
 	Status         knox.VersionStatus `json:"status"`
 	CreationTime   int64              `json:"ts"`
 	CryptoMetadata []byte             `json:"crypt"`
+	// DeactivatedAt is the time in unix nanoseconds at which the version last
+	// became Inactive.
+	DeactivatedAt int64 `json:"deactivated,omitempty"`
 }
 
 // DB is the underlying database connection that KeyDB uses for all of its operations.
@@ -186,30 +271,92 @@ var sqlCreateKeys = `CREATE TABLE IF NOT EXISTS secrets (
 	acl TEXT NOT NULL,
 	version_hash TEXT NOT NULL,
 	versions TEXT NOT NULL,
-	last_updated BIGINT NOT NULL
+	last_updated BIGINT NOT NULL,
+	attributes TEXT
 );`
 
+// sqlAddAttributes upgrades tables created before the attributes column existed.
+var sqlAddAttributes = `ALTER TABLE secrets ADD COLUMN attributes TEXT`
+
+// keyAttributes are the DBKey fields that SQLDB stores as JSON in the
+// attributes column rather than in columns of their own.
+type keyAttributes struct {
+	DeletedAt         int64                   `json:"deleted_at,omitempty"`
+	AliasOf           string                  `json:"alias_of,omitempty"`
+	VersionRetention  *knox.VersionRetention  `json:"version_retention,omitempty"`
+	Path              string                  `json:"path,omitempty"`
+	AccessRequests    []knox.AccessRequest    `json:"access_requests,omitempty"`
+	RequiresQuorum    bool                    `json:"requires_quorum,omitempty"`
+	PendingOperations []knox.PendingOperation `json:"pending_operations,omitempty"`
+	BreakGlassGrants  []knox.BreakGlassGrant  `json:"break_glass_grants,omitempty"`
+}
+
+func (k *DBKey) attributes() ([]byte, error) {
+	return json.Marshal(keyAttributes{
+		DeletedAt:         k.DeletedAt,
+		AliasOf:           k.AliasOf,
+		VersionRetention:  k.VersionRetention,
+		Path:              k.Path,
+		AccessRequests:    k.AccessRequests,
+		RequiresQuorum:    k.RequiresQuorum,
+		PendingOperations: k.PendingOperations,
+		BreakGlassGrants:  k.BreakGlassGrants,
+	})
+}
+
+func (k *DBKey) setAttributes(b []byte) error {
+	var a keyAttributes
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &a); err != nil {
+			return err
+		}
+	}
+	k.DeletedAt = a.DeletedAt
+	k.AliasOf = a.AliasOf
+	k.VersionRetention = a.VersionRetention
+	k.Path = a.Path
+	k.AccessRequests = a.AccessRequests
+	k.RequiresQuorum = a.RequiresQuorum
+	k.PendingOperations = a.PendingOperations
+	k.BreakGlassGrants = a.BreakGlassGrants
+	return nil
+}
+
+// createSQLTable creates the secrets table if needed and adds any columns
+// missing from a table created by an older version of knox.
+func createSQLTable(sqlDB *sql.DB) error {
+	_, err := sqlDB.Exec(sqlCreateKeys)
+	if err != nil {
+		return err
+	}
+	if _, err := sqlDB.Exec("SELECT attributes FROM secrets WHERE 1=0"); err != nil {
+		_, err = sqlDB.Exec(sqlAddAttributes)
+		return err
+	}
+	return nil
+}
+
 // NewPostgreSQLDB will create a SQLDB with the necessary statements for using postgres.
 func NewPostgreSQLDB(sqlDB *sql.DB) (DB, error) {
 	db := &SQLDB{}
 	var err error
-	_, err = sqlDB.Exec(sqlCreateKeys)
+	err = createSQLTable(sqlDB)
 	if err != nil {
 		return nil, err
 	}
-	db.getStmt, err = sqlDB.Prepare("SELECT id, acl, version_hash, versions, last_updated FROM secrets WHERE id=$1")
+	db.getStmt, err = sqlDB.Prepare("SELECT id, acl, version_hash, versions, last_updated, attributes FROM secrets WHERE id=$1")
 	if err != nil {
 		return nil, err
 	}
-	db.getAllStmt, err = sqlDB.Prepare("SELECT id, acl, version_hash, versions, last_updated FROM secrets")
+	db.getAllStmt, err = sqlDB.Prepare("SELECT id, acl, version_hash, versions, last_updated, attributes FROM secrets")
 	if err != nil {
 		return nil, err
 	}
-	db.UpdateStmt, err = sqlDB.Prepare("UPDATE secrets SET versions=$1, version_hash=$2,last_updated=$3,acl=$4 WHERE id=$5 AND last_updated=$6")
+	db.UpdateStmt, err = sqlDB.Prepare("UPDATE secrets SET versions=$1, version_hash=$2,last_updated=$3,acl=$4,attributes=$5 WHERE id=$6 AND last_updated=$7")
 	if err != nil {
 		return nil, err
 	}
-	db.AddStmt, err = sqlDB.Prepare("INSERT INTO secrets (id, acl, versions, version_hash, last_updated) VALUES ($1,$2,$3,$4,$5)")
+	db.AddStmt, err = sqlDB.Prepare("INSERT INTO secrets (id, acl, versions, version_hash, last_updated, attributes) VALUES ($1,$2,$3,$4,$5,$6)")
 	if err != nil {
 		return nil, err
 	}
@@ -224,23 +371,23 @@ func NewPostgreSQLDB(sqlDB *sql.DB) (DB, error) {
 func NewSQLDB(sqlDB *sql.DB) (DB, error) {
 	db := &SQLDB{}
 	var err error
-	_, err = sqlDB.Exec(sqlCreateKeys)
+	err = createSQLTable(sqlDB)
 	if err != nil {
 		return nil, err
 	}
-	db.getStmt, err = sqlDB.Prepare("SELECT id, acl, version_hash, versions, last_updated FROM secrets WHERE id=?")
+	db.getStmt, err = sqlDB.Prepare("SELECT id, acl, version_hash, versions, last_updated, attributes FROM secrets WHERE id=?")
 	if err != nil {
 		return nil, err
 	}
-	db.getAllStmt, err = sqlDB.Prepare("SELECT id, acl, version_hash, versions, last_updated FROM secrets")
+	db.getAllStmt, err = sqlDB.Prepare("SELECT id, acl, version_hash, versions, last_updated, attributes FROM secrets")
 	if err != nil {
 		return nil, err
 	}
-	db.UpdateStmt, err = sqlDB.Prepare("UPDATE secrets SET versions=?, version_hash=?,last_updated=?,acl=? WHERE id=? AND last_updated=?")
+	db.UpdateStmt, err = sqlDB.Prepare("UPDATE secrets SET versions=?, version_hash=?,last_updated=?,acl=?,attributes=? WHERE id=? AND last_updated=?")
 	if err != nil {
 		return nil, err
 	}
-	db.AddStmt, err = sqlDB.Prepare("INSERT INTO secrets (id, acl, versions, version_hash, last_updated) VALUES (?,?,?,?,?)")
+	db.AddStmt, err = sqlDB.Prepare("INSERT INTO secrets (id, acl, versions, version_hash, last_updated, attributes) VALUES (?,?,?,?,?,?)")
 	if err != nil {
 		return nil, err
 	}
@@ -254,11 +401,15 @@ func NewSQLDB(sqlDB *sql.DB) (DB, error) {
 // Get will return the key given its key ID.
 func (db *SQLDB) Get(id string) (*DBKey, error) {
 	var key DBKey
-	var acl, versions []byte
-	err := db.getStmt.QueryRow(id).Scan(&key.ID, &acl, &key.VersionHash, &versions, &key.DBVersion)
+	var acl, versions, attributes []byte
+	err := db.getStmt.QueryRow(id).Scan(&key.ID, &acl, &key.VersionHash, &versions, &key.DBVersion, &attributes)
 	if err != nil {
 		return nil, knox.ErrKeyIDNotFound
 	}
+	err = key.setAttributes(attributes)
+	if err != nil {
+		return nil, err
+	}
 	err = json.Unmarshal(acl, &key.ACL)
 	if err != nil {
 		return nil, err
@@ -279,8 +430,12 @@ func (db *SQLDB) GetAll() ([]DBKey, error) {
 	}
 	for rows.Next() {
 		var key DBKey
-		var acl, versions []byte
-		err := rows.Scan(&key.ID, &acl, &key.VersionHash, &versions, &key.DBVersion)
+		var acl, versions, attributes []byte
+		err := rows.Scan(&key.ID, &acl, &key.VersionHash, &versions, &key.DBVersion, &attributes)
+		if err != nil {
+			return nil, err
+		}
+		err = key.setAttributes(attributes)
 		if err != nil {
 			return nil, err
 		}
@@ -312,8 +467,12 @@ func (db *SQLDB) Update(key *DBKey) error {
 	if err != nil {
 		return err
 	}
+	attributes, err := key.attributes()
+	if err != nil {
+		return err
+	}
 	updateTime := time.Now().UnixNano()
-	r, err := db.UpdateStmt.Exec(versions, key.VersionHash, updateTime, acl, key.ID, key.DBVersion)
+	r, err := db.UpdateStmt.Exec(versions, key.VersionHash, updateTime, acl, attributes, key.ID, key.DBVersion)
 	if err != nil {
 		return err
 	}
@@ -348,8 +507,12 @@ func (db *SQLDB) Add(keys ...*DBKey) error {
 		if err != nil {
 			return err
 		}
+		attributes, err := key.attributes()
+		if err != nil {
+			return err
+		}
 		updateTime := time.Now().UnixNano()
-		_, err = db.AddStmt.Exec(key.ID, acl, versions, key.VersionHash, updateTime)
+		_, err = db.AddStmt.Exec(key.ID, acl, versions, key.VersionHash, updateTime, attributes)
 		if err != nil {
 			// Not sure how to properly differentiate here...
 			return knox.ErrKeyExists
@@ -42,6 +42,7 @@ func TestTemp(t *testing.T) {
 	TesterAddGet(t, db, timeout)
 	TesterAddUpdate(t, db, timeout)
 	TesterAddRemove(t, db, timeout)
+	TesterState(t, db)
 }
 
 func TestDBCopy(t *testing.T) {
@@ -95,6 +96,7 @@ func TestMySQL(t *testing.T) {
 	TesterAddGet(t, db, timeout)
 	TesterAddUpdate(t, db, timeout)
 	TesterAddRemove(t, db, timeout)
+	TesterState(t, db)
 }
 
 // TestSQLite runs all keydb tests on a file it requires this file to be empty.
@@ -111,6 +113,7 @@ func TestSQLite(t *testing.T) {
 	TesterAddGet(t, db, timeout)
 	TesterAddUpdate(t, db, timeout)
 	TesterAddRemove(t, db, timeout)
+	TesterState(t, db)
 }
 
 // TestPostgreSQL runs all keydb tests on a postgres db. It requires an empty db.
@@ -127,6 +130,7 @@ func TestPostgreSQL(t *testing.T) {
 	TesterAddGet(t, db, timeout)
 	TesterAddUpdate(t, db, timeout)
 	TesterAddRemove(t, db, timeout)
+	TesterState(t, db)
 }
 */
 func TestTempErrs(t *testing.T) {
@@ -351,3 +355,46 @@ func TesterAddUpdate(t *testing.T, db DB, timeout time.Duration) {
 
 	db.Remove(k.ID)
 }
+
+// TesterState checks that the trash and alias state of keys is stored.
+func TesterState(t *testing.T, db DB) {
+	k := newDBKey("TesterState1", []byte("a"), 0)
+	k.DeletedAt = 42
+	k.Path = "/teams/payments"
+	alias := DBKey{ID: "TesterState2", ACL: knox.ACL{}, VersionList: []EncKeyVersion{}, AliasOf: k.ID}
+	if err := db.Add(&k, &alias); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	defer db.Remove(k.ID)
+	defer db.Remove(alias.ID)
+
+	stored, err := db.Get(k.ID)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if stored.DeletedAt != 42 {
+		t.Fatalf("%d does not equal 42", stored.DeletedAt)
+	}
+	if stored.Path != k.Path {
+		t.Fatalf("%s does not equal %s", stored.Path, k.Path)
+	}
+	stored.DeletedAt = 0
+	if err := db.Update(stored); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	stored, err = db.Get(k.ID)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if stored.DeletedAt != 0 {
+		t.Fatalf("%d does not equal 0", stored.DeletedAt)
+	}
+
+	stored, err = db.Get(alias.ID)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if stored.AliasOf != k.ID {
+		t.Fatalf("%s does not equal %s", stored.AliasOf, k.ID)
+	}
+}
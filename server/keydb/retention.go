@@ -0,0 +1,119 @@
+package keydb
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+// applyRetention removes the Inactive versions of k that its retention policy
+// no longer keeps and returns their IDs. Inactive versions that were
+// deactivated before deactivation times were recorded are stamped with now, so
+// their retention period starts when the policy first sees them. It reports
+// whether k was changed.
+func (k *DBKey) applyRetention(now time.Time) ([]uint64, bool) {
+	r := k.VersionRetention
+	if r == nil || (r.MaxInactive == 0 && r.InactiveDays == 0) {
+		return nil, false
+	}
+	changed := false
+	inactive := []*EncKeyVersion{}
+	for i := range k.VersionList {
+		v := &k.VersionList[i]
+		if v.Status != knox.Inactive {
+			continue
+		}
+		if v.DeactivatedAt == 0 {
+			v.DeactivatedAt = now.UnixNano()
+			changed = true
+		}
+		inactive = append(inactive, v)
+	}
+	// Most recently deactivated first.
+	sort.SliceStable(inactive, func(i, j int) bool {
+		return inactive[i].DeactivatedAt > inactive[j].DeactivatedAt
+	})
+
+	purge := map[uint64]bool{}
+	cutoff := now.Add(-time.Duration(r.InactiveDays) * 24 * time.Hour).UnixNano()
+	for i, v := range inactive {
+		if (r.MaxInactive > 0 && i >= r.MaxInactive) || (r.InactiveDays > 0 && v.DeactivatedAt < cutoff) {
+			purge[v.ID] = true
+		}
+	}
+	if len(purge) == 0 {
+		return nil, changed
+	}
+
+	purged := []uint64{}
+	versions := []EncKeyVersion{}
+	for _, v := range k.VersionList {
+		if purge[v.ID] {
+			purged = append(purged, v.ID)
+			continue
+		}
+		versions = append(versions, v)
+	}
+	k.VersionList = versions
+	k.RehashVersions()
+	return purged, true
+}
+
+// ApplyVersionRetention permanently removes the Inactive versions that the
+// retention policies of the keys in db no longer keep. It returns the IDs of
+// the removed versions by key ID. Keys that are changed concurrently are left
+// for the next run.
+func ApplyVersionRetention(db DB, now time.Time) (map[string][]uint64, error) {
+	keys, err := db.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	purged := map[string][]uint64{}
+	for i := range keys {
+		k := keys[i].Copy()
+		versions, changed := k.applyRetention(now)
+		if !changed {
+			continue
+		}
+		err := db.Update(k)
+		if err == ErrDBVersion || err == knox.ErrKeyIDNotFound {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		if len(versions) > 0 {
+			purged[k.ID] = versions
+		}
+	}
+	return purged, nil
+}
+
+// StartVersionRetention runs ApplyVersionRetention every interval until the
+// returned function is called.
+func StartVersionRetention(db DB, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				purged, err := ApplyVersionRetention(db, time.Now())
+				if err != nil {
+					log.Printf("version retention: failed: %s", err.Error())
+				}
+				for id, versions := range purged {
+					log.Printf("version retention: permanently removed versions %v of %s", versions, id)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
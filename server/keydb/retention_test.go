@@ -0,0 +1,111 @@
+package keydb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func TestApplyVersionRetention(t *testing.T) {
+	db := NewTempDB()
+	now := time.Now()
+	day := 24 * time.Hour
+
+	k := newDBKey("retained", []byte("a"), 0)
+	for i, age := range []time.Duration{time.Hour, 2 * day, 5 * day, 10 * day} {
+		v := newEncKeyVersion([]byte{byte(i)}, knox.Inactive)
+		v.DeactivatedAt = now.Add(-age).UnixNano()
+		k.VersionList = append(k.VersionList, v)
+	}
+	unstamped := newEncKeyVersion([]byte("b"), knox.Inactive)
+	k.VersionList = append(k.VersionList, unstamped)
+	k.VersionRetention = &knox.VersionRetention{MaxInactive: 3, InactiveDays: 7}
+
+	unlimited := newDBKey("unlimited", []byte("a"), 0)
+	unlimited.VersionList = append(unlimited.VersionList, newEncKeyVersion([]byte("c"), knox.Inactive))
+	if err := db.Add(&k, &unlimited); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+
+	purged, err := ApplyVersionRetention(db, now)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	// The unstamped version counts as just deactivated, which pushes the one
+	// deactivated 5 days ago over the count limit; the 10 day old one is over
+	// the age limit.
+	if len(purged) != 1 || len(purged["retained"]) != 2 {
+		t.Fatalf("unexpected purged versions %v", purged)
+	}
+	stored, err := db.Get("retained")
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(stored.VersionList) != 4 {
+		t.Fatalf("expected 4 versions not %d", len(stored.VersionList))
+	}
+	for _, v := range stored.VersionList {
+		if v.ID == k.VersionList[3].ID || v.ID == k.VersionList[4].ID {
+			t.Fatalf("version %d should have been purged", v.ID)
+		}
+		if v.Status == knox.Inactive && v.DeactivatedAt == 0 {
+			t.Fatalf("version %d was not stamped", v.ID)
+		}
+	}
+
+	purged, err = ApplyVersionRetention(db, now)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(purged) != 0 {
+		t.Fatalf("unexpected purged versions %v", purged)
+	}
+}
+
+func TestApplyVersionRetentionHash(t *testing.T) {
+	db := NewTempDB()
+	now := time.Now()
+	cryptor := NewAESGCMCryptor(0, []byte("testtesttesttest"))
+	key := &knox.Key{
+		ID:  "retained",
+		ACL: knox.ACL{},
+		VersionList: knox.KeyVersionList{
+			{ID: 1, Data: []byte("a"), Status: knox.Primary},
+			{ID: 2, Data: []byte("b"), Status: knox.Inactive},
+		},
+	}
+	key.VersionHash = key.VersionList.Hash()
+	k, err := cryptor.Encrypt(key)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	k.VersionRetention = &knox.VersionRetention{InactiveDays: 1}
+	for i := range k.VersionList {
+		if k.VersionList[i].Status == knox.Inactive {
+			k.VersionList[i].DeactivatedAt = now.Add(-48 * time.Hour).UnixNano()
+		}
+	}
+	if err := db.Add(k); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+
+	purged, err := ApplyVersionRetention(db, now)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(purged["retained"]) != 1 {
+		t.Fatalf("unexpected purged versions %v", purged)
+	}
+	stored, err := db.Get("retained")
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	decrypted, err := cryptor.Decrypt(stored)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if err := decrypted.Validate(); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+}
@@ -0,0 +1,90 @@
+package keydb
+
+import (
+	"github.com/pavelzhurov/knox"
+)
+
+// stripAttempts is how many times StripAccess retries a key that is changed
+// concurrently.
+const stripAttempts = 3
+
+// removePrincipals removes the entries of k's ACL whose principal is one of
+// principals, whatever their access type, and returns them.
+func (k *DBKey) removePrincipals(principals knox.ACL) knox.ACL {
+	removed := knox.ACL{}
+	acl := knox.ACL{}
+	for _, a := range k.ACL {
+		matched := false
+		for _, p := range principals {
+			if a.Type == p.Type && a.ID == p.ID {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			removed = append(removed, a)
+			continue
+		}
+		acl = append(acl, a)
+	}
+	if len(removed) > 0 {
+		k.ACL = acl
+	}
+	return removed
+}
+
+// StripAccess removes the ACL entries of the given principals from every key
+// and namespace in db. It is used to migrate ACLs that had default access
+// copied into them to the global policy. It returns the removed entries by
+// key ID. Unless check is nil, it is called with every key before its entries
+// are removed, first for all of them and then again with the version that is
+// updated, and StripAccess stops at the first error it returns.
+func StripAccess(db DB, principals knox.ACL, check func(*DBKey) error) (map[string]knox.ACL, error) {
+	keys, err := db.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	removed := map[string]knox.ACL{}
+	if check != nil {
+		for i := range keys {
+			if len(keys[i].Copy().removePrincipals(principals)) == 0 {
+				continue
+			}
+			if err := check(&keys[i]); err != nil {
+				return removed, err
+			}
+		}
+	}
+	for i := range keys {
+		k := keys[i].Copy()
+		for attempt := 1; ; attempt++ {
+			stripped := k.removePrincipals(principals)
+			if len(stripped) == 0 {
+				break
+			}
+			if check != nil {
+				if err := check(k); err != nil {
+					return removed, err
+				}
+			}
+			err = db.Update(k)
+			if err == nil {
+				removed[k.ID] = stripped
+				break
+			}
+			if err == knox.ErrKeyIDNotFound {
+				break
+			}
+			if err != ErrDBVersion || attempt == stripAttempts {
+				return removed, err
+			}
+			if k, err = db.Get(k.ID); err != nil {
+				if err == knox.ErrKeyIDNotFound {
+					break
+				}
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,57 @@
+package keydb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func TestStripAccess(t *testing.T) {
+	db := NewTempDB()
+	owner := knox.Access{ID: "owner", AccessType: knox.Admin, Type: knox.User}
+	baked := knox.Access{ID: "security-team", AccessType: knox.Admin, Type: knox.UserGroup}
+	sameIDMachine := knox.Access{ID: "security-team", AccessType: knox.Read, Type: knox.Machine}
+
+	k := newDBKey("baked", []byte("a"), 0)
+	k.ACL = knox.ACL{owner, baked, sameIDMachine}
+	clean := newDBKey("clean", []byte("b"), 0)
+	clean.ACL = knox.ACL{owner}
+	if err := db.Add(&k, &clean); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+
+	// A failed check stops the strip before any key changes.
+	refused := fmt.Errorf("refused")
+	checked := []string{}
+	removed, err := StripAccess(db, knox.ACL{{ID: "security-team", Type: knox.UserGroup}}, func(k *DBKey) error {
+		checked = append(checked, k.ID)
+		return refused
+	})
+	if err != refused || len(removed) != 0 || len(checked) != 1 || checked[0] != "baked" {
+		t.Fatalf("unexpected result %v, %v after checking %v", removed, err, checked)
+	}
+
+	removed, err = StripAccess(db, knox.ACL{{ID: "security-team", Type: knox.UserGroup}}, nil)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(removed) != 1 || len(removed["baked"]) != 1 || removed["baked"][0] != baked {
+		t.Fatalf("unexpected removed entries %v", removed)
+	}
+	stored, err := db.Get("baked")
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(stored.ACL) != 2 || stored.ACL[0] != owner || stored.ACL[1] != sameIDMachine {
+		t.Fatalf("unexpected ACL %v", stored.ACL)
+	}
+
+	removed, err = StripAccess(db, knox.ACL{{ID: "security-team", Type: knox.UserGroup}}, nil)
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("unexpected removed entries %v", removed)
+	}
+}
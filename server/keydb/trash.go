@@ -0,0 +1,68 @@
+package keydb
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+// PurgeTrash permanently removes the keys that were moved to the trash before
+// the given time and returns their IDs.
+func PurgeTrash(db DB, before time.Time) ([]string, error) {
+	keys, err := db.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	purged := []string{}
+	for _, k := range keys {
+		if k.DeletedAt == 0 || k.DeletedAt >= before.UnixNano() {
+			continue
+		}
+		// Reread the key so that one restored since GetAll is left alone.
+		current, err := db.Get(k.ID)
+		if err == knox.ErrKeyIDNotFound {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		if current.DeletedAt == 0 || current.DeletedAt >= before.UnixNano() {
+			continue
+		}
+		if err := db.Remove(k.ID); err != nil && err != knox.ErrKeyIDNotFound {
+			return purged, err
+		}
+		purged = append(purged, k.ID)
+	}
+	return purged, nil
+}
+
+// StartTrashPurger runs PurgeTrash every interval until the returned function
+// is called, removing keys that have been in the trash for longer than
+// retention. The ID of each removed key is passed to purged.
+func StartTrashPurger(db DB, retention, interval time.Duration, purged func(keyID string)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ids, err := PurgeTrash(db, time.Now().Add(-retention))
+				if err != nil {
+					log.Printf("trash: purge failed: %s", err.Error())
+				}
+				for _, id := range ids {
+					log.Printf("trash: permanently removed %s", id)
+					purged(id)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
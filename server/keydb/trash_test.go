@@ -0,0 +1,37 @@
+package keydb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+)
+
+func TestPurgeTrash(t *testing.T) {
+	db := NewTempDB()
+	now := time.Now()
+	live := newDBKey("live", []byte("a"), 0)
+	old := newDBKey("old", []byte("b"), 0)
+	old.DeletedAt = now.Add(-2 * time.Hour).UnixNano()
+	recent := newDBKey("recent", []byte("c"), 0)
+	recent.DeletedAt = now.Add(-time.Minute).UnixNano()
+	if err := db.Add(&live, &old, &recent); err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+
+	purged, err := PurgeTrash(db, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("%s not nil", err)
+	}
+	if len(purged) != 1 || purged[0] != "old" {
+		t.Fatalf("unexpected purged keys %v", purged)
+	}
+	if _, err := db.Get("old"); err != knox.ErrKeyIDNotFound {
+		t.Fatalf("%v does not equal %s", err, knox.ErrKeyIDNotFound)
+	}
+	for _, id := range []string{"live", "recent"} {
+		if _, err := db.Get(id); err != nil {
+			t.Fatalf("%s not nil", err)
+		}
+	}
+}
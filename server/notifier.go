@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/log"
+	"github.com/pavelzhurov/knox/server/keydb"
+)
+
+// WebhookSubscription is an HTTP endpoint that key events are posted to as
+// JSON. Each payload is signed with an HMAC-SHA256 of the body keyed by
+// Secret, sent hex encoded in the X-Knox-Signature header as sha256=<mac>.
+type WebhookSubscription struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+	// KeyPrefix limits the subscription to keys whose ID starts with it.
+	KeyPrefix string `json:"key_prefix,omitempty"`
+	// Events limits the subscription to these event types. If it is empty
+	// every event is sent.
+	Events []string `json:"events,omitempty"`
+}
+
+// Validate returns an error if the subscription has no URL or filters on an
+// unknown event type.
+func (s WebhookSubscription) Validate() error {
+	if s.URL == "" {
+		return fmt.Errorf("webhook subscription has no URL")
+	}
+	for _, t := range s.Events {
+		switch t {
+		case knox.EventCreated, knox.EventVersionAdded, knox.EventPromoted,
+			knox.EventDeactivated, knox.EventACLChanged, knox.EventDeleted,
+			knox.EventPurged:
+		default:
+			return fmt.Errorf("webhook subscription for %s has unknown event type %q", s.URL, t)
+		}
+	}
+	return nil
+}
+
+func (s WebhookSubscription) matches(e knox.KeyEvent) bool {
+	if !strings.HasPrefix(e.KeyID, s.KeyPrefix) {
+		return false
+	}
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, t := range s.Events {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// DeadLetter is an event that could not be delivered to a webhook.
+type DeadLetter struct {
+	URL      string        `json:"url"`
+	Event    knox.KeyEvent `json:"event"`
+	Attempts int           `json:"attempts"`
+	Error    string        `json:"error"`
+	FailedAt int64         `json:"failed_at"`
+}
+
+// maxDeadLetters is how many dead letters a Notifier keeps in memory.
+const maxDeadLetters = 1000
+
+// Notifier posts key events to the webhooks subscribed to them. Deliveries
+// that fail are retried with exponential backoff and, once the retries run
+// out, recorded as dead letters.
+type Notifier struct {
+	subscriptions []WebhookSubscription
+	client        *http.Client
+	retries       int
+	backoff       time.Duration
+	deadLogger    *log.Logger
+
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+	deadLetters []DeadLetter
+}
+
+// NewNotifier builds a notifier for subs that retries failed deliveries
+// retries times, waiting backoff before the first retry and twice as long
+// before each one after. Dead letters are written as JSON to deadLogger if it
+// is not nil.
+func NewNotifier(subs []WebhookSubscription, retries int, backoff time.Duration, deadLogger *log.Logger) *Notifier {
+	return &Notifier{
+		subscriptions: subs,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		retries:       retries,
+		backoff:       backoff,
+		deadLogger:    deadLogger,
+	}
+}
+
+// Notify sends e to every subscription it matches. Deliveries run in the
+// background, so Notify does not block.
+func (n *Notifier) Notify(e knox.KeyEvent) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	for _, s := range n.subscriptions {
+		if !s.matches(e) {
+			continue
+		}
+		n.wg.Add(1)
+		go func(s WebhookSubscription) {
+			defer n.wg.Done()
+			n.deliver(s, e, body)
+		}(s)
+	}
+}
+
+// Wait blocks until every event notified so far has been delivered or dead
+// lettered.
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// DeadLetters returns the most recent events that could not be delivered,
+// oldest first.
+func (n *Notifier) DeadLetters() []DeadLetter {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]DeadLetter{}, n.deadLetters...)
+}
+
+func (n *Notifier) deliver(s WebhookSubscription, e knox.KeyEvent, body []byte) {
+	err := n.post(s, e, body)
+	attempts := 1
+	for ; err != nil && attempts <= n.retries; attempts++ {
+		time.Sleep(n.backoff << uint(attempts-1))
+		err = n.post(s, e, body)
+	}
+	if err == nil {
+		return
+	}
+	d := DeadLetter{
+		URL:      s.URL,
+		Event:    e,
+		Attempts: attempts,
+		Error:    err.Error(),
+		FailedAt: time.Now().UnixNano(),
+	}
+	n.mu.Lock()
+	n.deadLetters = append(n.deadLetters, d)
+	if len(n.deadLetters) > maxDeadLetters {
+		n.deadLetters = n.deadLetters[len(n.deadLetters)-maxDeadLetters:]
+	}
+	n.mu.Unlock()
+	if n.deadLogger != nil {
+		n.deadLogger.OutputJSON(d)
+	}
+}
+
+func (n *Notifier) post(s WebhookSubscription, e knox.KeyEvent, body []byte) error {
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Knox-Event", e.Type)
+	req.Header.Set("X-Knox-Signature", "sha256="+SignWebhookPayload(s.Secret, body))
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SignWebhookPayload returns the hex encoded HMAC-SHA256 of body keyed by
+// secret, which receivers compare to the X-Knox-Signature header.
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notifier key changes are sent to. By default there is none; it should be set
+// by the main function.
+var notifier *Notifier
+
+// SetNotifier sets the notifier that the key manager sends key events to after
+// they are stored.
+func SetNotifier(n *Notifier) {
+	notifier = n
+}
+
+// notify sends an event of type t about the key keyID to the notifier, if one
+// is set.
+func notify(t, keyID string, versionID uint64) {
+	if notifier != nil {
+		notifier.Notify(knox.KeyEvent{Type: t, KeyID: keyID, VersionID: versionID, Time: time.Now().UnixNano()})
+	}
+}
+
+// notifyACLChanged sends EventACLChanged about the key or alias id, or, if id
+// is a namespace, about every live key inside it and the namespaces in it,
+// whose effective ACL changes with the namespace's.
+func notifyACLChanged(db keydb.DB, id string) {
+	if notifier == nil {
+		return
+	}
+	if !strings.HasPrefix(id, "/") {
+		notify(knox.EventACLChanged, id, 0)
+		return
+	}
+	keys, err := db.GetAll()
+	if err != nil {
+		log.Println("Failed to notify the keys in namespace " + id + ": " + err.Error())
+		return
+	}
+	for _, k := range keys {
+		if k.DeletedAt != 0 || k.AliasOf != "" || k.IsNamespace() {
+			continue
+		}
+		if k.Path == id || strings.HasPrefix(k.Path, id+"/") {
+			notify(knox.EventACLChanged, k.ID, 0)
+		}
+	}
+}
+
+// NotifyACLChanged sends EventACLChanged for an ACL that was changed without
+// the key manager, as keydb.RemoveExpiredAccess does. For a namespace it is
+// sent about every key inside it. It is passed to keydb.StartAccessExpiry.
+func NotifyACLChanged(db keydb.DB, id string) {
+	notifyACLChanged(db, id)
+}
+
+// NotifyPurged sends EventPurged for a key that keydb.PurgeTrash permanently
+// removed from the trash. It is passed to keydb.StartTrashPurger.
+func NotifyPurged(keyID string) {
+	notify(knox.EventPurged, keyID, 0)
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/server/auth"
+	"github.com/pavelzhurov/knox/server/keydb"
+)
+
+// webhookReceiver records the events posted to it after checking their
+// signature, and answers with the status codes in statuses before answering
+// 200.
+type webhookReceiver struct {
+	t        *testing.T
+	secret   string
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	events   []knox.KeyEvent
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Errorf("%s is not nil", err)
+	}
+	if sig := req.Header.Get("X-Knox-Signature"); sig != "sha256="+SignWebhookPayload(r.secret, body) {
+		r.t.Errorf("bad signature %q", sig)
+	}
+	var e knox.KeyEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		r.t.Errorf("%s is not nil", err)
+	}
+	if req.Header.Get("X-Knox-Event") != e.Type {
+		r.t.Errorf("event header %q is not %q", req.Header.Get("X-Knox-Event"), e.Type)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	if len(r.statuses) > 0 {
+		w.WriteHeader(r.statuses[0])
+		r.statuses = r.statuses[1:]
+		return
+	}
+	r.events = append(r.events, e)
+}
+
+func (r *webhookReceiver) received() []knox.KeyEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]knox.KeyEvent{}, r.events...)
+}
+
+func TestNotifierFilters(t *testing.T) {
+	all := &webhookReceiver{t: t, secret: "s1"}
+	allSrv := httptest.NewServer(all)
+	defer allSrv.Close()
+	filtered := &webhookReceiver{t: t, secret: "s2"}
+	filteredSrv := httptest.NewServer(filtered)
+	defer filteredSrv.Close()
+
+	n := NewNotifier([]WebhookSubscription{
+		{URL: allSrv.URL, Secret: "s1"},
+		{URL: filteredSrv.URL, Secret: "s2", KeyPrefix: "db:", Events: []string{knox.EventPromoted}},
+	}, 0, 0, nil)
+	events := []knox.KeyEvent{
+		{Type: knox.EventPromoted, KeyID: "db:a1", VersionID: 2},
+		{Type: knox.EventPromoted, KeyID: "web:a1", VersionID: 2},
+		{Type: knox.EventDeleted, KeyID: "db:a1"},
+	}
+	for _, e := range events {
+		n.Notify(e)
+	}
+	n.Wait()
+
+	if got := all.received(); len(got) != 3 {
+		t.Fatalf("expected every event, got %v", got)
+	}
+	if got := filtered.received(); len(got) != 1 || got[0] != events[0] {
+		t.Fatalf("expected %v, got %v", events[:1], got)
+	}
+	if d := n.DeadLetters(); len(d) != 0 {
+		t.Fatalf("unexpected dead letters %v", d)
+	}
+}
+
+func TestNotifierRetries(t *testing.T) {
+	r := &webhookReceiver{t: t, secret: "s", statuses: []int{500, 503}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	n := NewNotifier([]WebhookSubscription{{URL: srv.URL, Secret: "s"}}, 2, time.Millisecond, nil)
+	e := knox.KeyEvent{Type: knox.EventCreated, KeyID: "a1"}
+	n.Notify(e)
+	n.Wait()
+
+	if got := r.received(); len(got) != 1 || got[0] != e || r.attempts != 3 {
+		t.Fatalf("expected %v after 3 attempts, got %v after %d", e, got, r.attempts)
+	}
+	if d := n.DeadLetters(); len(d) != 0 {
+		t.Fatalf("unexpected dead letters %v", d)
+	}
+}
+
+func TestNotifierDeadLetters(t *testing.T) {
+	r := &webhookReceiver{t: t, secret: "s", statuses: []int{500, 500, 500}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	n := NewNotifier([]WebhookSubscription{{URL: srv.URL, Secret: "s"}}, 2, time.Millisecond, nil)
+	e := knox.KeyEvent{Type: knox.EventCreated, KeyID: "a1"}
+	n.Notify(e)
+	n.Wait()
+
+	d := n.DeadLetters()
+	if len(d) != 1 || d[0].URL != srv.URL || d[0].Event != e || d[0].Attempts != 3 {
+		t.Fatalf("unexpected dead letters %+v", d)
+	}
+	if got := r.received(); len(got) != 0 {
+		t.Fatalf("unexpected events %v", got)
+	}
+}
+
+func TestWebhookSubscriptionValidate(t *testing.T) {
+	if err := (WebhookSubscription{URL: "https://example.com", Events: []string{knox.EventACLChanged}}).Validate(); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if err := (WebhookSubscription{Events: []string{knox.EventACLChanged}}).Validate(); err == nil {
+		t.Fatal("expected an error for a subscription without URL")
+	}
+	if err := (WebhookSubscription{URL: "https://example.com", Events: []string{"rotated"}}).Validate(); err == nil {
+		t.Fatal("expected an error for an unknown event type")
+	}
+}
+
+func TestKeyManagerNotifies(t *testing.T) {
+	r := &webhookReceiver{t: t, secret: "s"}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	n := NewNotifier([]WebhookSubscription{{URL: srv.URL, Secret: "s"}}, 0, 0, nil)
+	SetNotifier(n)
+	defer SetNotifier(nil)
+
+	m, _ := makeDB()
+	u := auth.NewUser("test", []string{})
+	k := newKey("a1", knox.ACL{}, []byte("data"), u)
+	v := newKeyVersion([]byte("data2"), knox.Active)
+	steps := []func() error{
+		func() error { return m.AddNewKey(&k) },
+		func() error { return m.AddVersion("a1", &v) },
+		func() error { return m.UpdateVersion("a1", v.ID, knox.Primary) },
+		func() error { return m.UpdateVersion("a1", k.VersionList[0].ID, knox.Inactive) },
+		func() error {
+			return m.UpdateAccess("a1", knox.Access{Type: knox.User, ID: "alice", AccessType: knox.Read})
+		},
+		// Failed writes are not reported.
+		func() error {
+			if err := m.AddVersion("NOTAKEY", &v); err != knox.ErrKeyIDNotFound {
+				t.Fatalf("%v is not %v", err, knox.ErrKeyIDNotFound)
+			}
+			return nil
+		},
+		func() error { return m.DeleteKey("a1") },
+		// Purging a key in the trash reports it purged, not deleted again.
+		func() error { return m.PurgeKey("a1") },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("%s is not nil", err)
+		}
+		n.Wait()
+	}
+
+	expected := []knox.KeyEvent{
+		{Type: knox.EventCreated, KeyID: "a1"},
+		{Type: knox.EventVersionAdded, KeyID: "a1", VersionID: v.ID},
+		{Type: knox.EventPromoted, KeyID: "a1", VersionID: v.ID},
+		{Type: knox.EventDeactivated, KeyID: "a1", VersionID: k.VersionList[0].ID},
+		{Type: knox.EventACLChanged, KeyID: "a1"},
+		{Type: knox.EventDeleted, KeyID: "a1"},
+		{Type: knox.EventPurged, KeyID: "a1"},
+	}
+	got := r.received()
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i, e := range got {
+		if e.Time == 0 {
+			t.Fatalf("event %v has no time", e)
+		}
+		e.Time = 0
+		if e != expected[i] {
+			t.Fatalf("event %d is %v, not %v", i, e, expected[i])
+		}
+	}
+}
+
+func TestIndirectChangesNotify(t *testing.T) {
+	r := &webhookReceiver{t: t, secret: "s"}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	n := NewNotifier([]WebhookSubscription{{URL: srv.URL, Secret: "s"}}, 0, 0, nil)
+	SetNotifier(n)
+	defer SetNotifier(nil)
+
+	m, db := makeDB()
+	u := auth.NewUser("test", []string{})
+	for _, path := range []string{"/teams", "/teams/db"} {
+		if err := m.AddNamespace(&knox.Namespace{Path: path, ACL: knox.ACL{}}); err != nil {
+			t.Fatalf("%s is not nil", err)
+		}
+	}
+	k := newKey("a1", knox.ACL{}, []byte("data"), u)
+	k.Path = "/teams/db"
+	if err := m.AddNewKey(&k); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	n.Wait()
+	r.mu.Lock()
+	r.events = nil
+	r.mu.Unlock()
+
+	alice := knox.Access{Type: knox.User, ID: "alice", AccessType: knox.Read}
+	bob := knox.Access{Type: knox.User, ID: "bob"}
+	expiring := knox.Access{Type: knox.User, ID: "carol", AccessType: knox.Read, ExpiresAt: time.Now().Add(time.Hour).UnixNano()}
+	steps := []func() error{
+		// Namespace ACL changes are reported for the keys in nested
+		// namespaces too.
+		func() error { return m.UpdateNamespaceAccess("/teams", alice) },
+		func() error {
+			_, _, err := m.MigratePrincipal(alice, &bob, "", 100, false, false)
+			return err
+		},
+		func() error { return m.UpdateNamespaceAccess("/teams", expiring) },
+		func() error {
+			removed, err := keydb.RemoveExpiredAccess(db, time.Now().Add(2*time.Hour))
+			for id := range removed {
+				NotifyACLChanged(db, id)
+			}
+			return err
+		},
+		func() error { return m.MoveKey("a1", "") },
+		func() error { return m.RenameKey("a1", "a2") },
+		func() error { return m.DeleteKey("a2") },
+		func() error { return m.RestoreKey("a2") },
+		func() error { return m.DeleteKey("a2") },
+		func() error {
+			purged, err := keydb.PurgeTrash(db, time.Now())
+			for _, id := range purged {
+				NotifyPurged(id)
+			}
+			return err
+		},
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("%s is not nil", err)
+		}
+		n.Wait()
+	}
+
+	expected := []knox.KeyEvent{
+		{Type: knox.EventACLChanged, KeyID: "a1"},
+		{Type: knox.EventACLChanged, KeyID: "a1"},
+		{Type: knox.EventACLChanged, KeyID: "a1"},
+		{Type: knox.EventACLChanged, KeyID: "a1"},
+		{Type: knox.EventACLChanged, KeyID: "a1"},
+		{Type: knox.EventCreated, KeyID: "a2"},
+		{Type: knox.EventDeleted, KeyID: "a1"},
+		{Type: knox.EventDeleted, KeyID: "a2"},
+		{Type: knox.EventCreated, KeyID: "a2"},
+		{Type: knox.EventDeleted, KeyID: "a2"},
+		{Type: knox.EventPurged, KeyID: "a2"},
+	}
+	// Events of the same change, such as a rename, may arrive in any order.
+	counts := map[knox.KeyEvent]int{}
+	for _, e := range expected {
+		counts[e]++
+	}
+	got := r.received()
+	for _, e := range got {
+		e.Time = 0
+		counts[e]--
+	}
+	for _, c := range counts {
+		if c != 0 {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/server/auth"
+)
+
+// OPATarget is the partition and service that the OPA policy authorizing a
+// request is looked up under.
+type OPATarget struct {
+	Partition string `json:"partition"`
+	Service   string `json:"service"`
+}
+
+// OPAMapping sends the authorization of the keys and namespaces it matches to
+// the policy of Target, so that one server can serve several tenants. It
+// matches by Namespace, the namespace and every key and namespace nested in it,
+// or, if Namespace is empty, by KeyPrefix, the keys whose ID starts with it.
+type OPAMapping struct {
+	Namespace string    `json:"namespace,omitempty"`
+	KeyPrefix string    `json:"key_prefix,omitempty"`
+	Target    OPATarget `json:"target"`
+}
+
+func (mp OPAMapping) matches(path, keyID string) bool {
+	if mp.Namespace != "" {
+		return path == mp.Namespace || strings.HasPrefix(path, mp.Namespace+"/")
+	}
+	return keyID != "" && strings.HasPrefix(keyID, mp.KeyPrefix)
+}
+
+// The OPA target of requests no mapping matches, and the mappings in the order
+// they are tried. They should be set by the main function.
+var defaultOPATarget = OPATarget{Partition: "pvc", Service: "kms"}
+var opaMappings []OPAMapping
+
+// SetOPATargets sets the partition and service OPA policies are looked up
+// under: those of the first of mappings that matches a key or namespace, and
+// def for the others and for server-wide actions such as listing keys.
+func SetOPATargets(def OPATarget, mappings []OPAMapping) {
+	defaultOPATarget = def
+	opaMappings = mappings
+}
+
+// opaTarget returns the OPA target of the key keyID in the namespace at path.
+// For a namespace keyID is empty, and for server-wide actions both are.
+func opaTarget(path, keyID string) OPATarget {
+	for _, mp := range opaMappings {
+		if mp.matches(path, keyID) {
+			return mp.Target
+		}
+	}
+	return defaultOPATarget
+}
+
+// Evaluator the OPA policy is evaluated with. NewKeyManager sets it to post
+// the input documents to the OPA server at AUTHZ_OPA_URL unless the main
+// function sets another one first.
+var opaEvaluator auth.OPAEvaluator
+
+// SetOPAEvaluator sets the evaluator OPA authorization decisions are made
+// with.
+func SetOPAEvaluator(e auth.OPAEvaluator) {
+	opaEvaluator = e
+}
+
+// opaRequestParameter is the handler parameter that carries the description
+// of the request OPA policies are given, as JSON. Route.ServeHTTP sets it
+// under OPA authorization.
+const opaRequestParameter = "opa_request"
+
+// withOPARequest returns a copy of parameters with the description of r that
+// OPA policies are given. The copy keeps it out of the access log, which
+// records the connection itself.
+func withOPARequest(parameters map[string]string, r *http.Request) map[string]string {
+	req := auth.OPARequest{TLS: auth.NewOPATLS(r.TLS)}
+	if info := getAuthInfo(r); info != nil {
+		req.Provider = info.provider
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		req.SourceIP = host
+	} else {
+		req.SourceIP = r.RemoteAddr
+	}
+	ps := map[string]string{}
+	for k, v := range parameters {
+		ps[k] = v
+	}
+	data, _ := json.Marshal(req)
+	ps[opaRequestParameter] = string(data)
+	return ps
+}
+
+// opaInput returns the input document the OPA policy is given to decide
+// whether principal may perform action on the key keyID, whose own ACL is acl,
+// in the namespace at path. parameters are those of the request the decision
+// is made for, or nil if it is not about a request.
+func opaInput(principal knox.Principal, acl knox.ACL, path, keyID, action string, parameters map[string]string) map[string]interface{} {
+	var req *auth.OPARequest
+	if data, ok := parameters[opaRequestParameter]; ok {
+		req = &auth.OPARequest{}
+		if err := json.Unmarshal([]byte(data), req); err != nil {
+			req = nil
+		}
+	}
+	t := opaTarget(path, keyID)
+	resource := auth.OPAResource{KeyID: keyID, Namespace: path, ACL: acl}
+	return auth.OPAInput(principal, resourcePath(path, keyID), action, t.Partition, t.Service, resource, req)
+}
+
+// canAccessOPA asks the OPA policy whether principal may perform action on the
+// key keyID, whose own ACL is acl, in the namespace at path. For a namespace
+// keyID is empty and acl is nil, and for server-wide actions path is empty as
+// well.
+func canAccessOPA(principal knox.Principal, acl knox.ACL, path, keyID, action string, parameters map[string]string) bool {
+	if opaEvaluator == nil {
+		log.Println("No OPA evaluator is set")
+		return false
+	}
+	allowed, err := opaEvaluator.Eval(opaInput(principal, acl, path, keyID, action, parameters))
+	if err != nil {
+		log.Println("OPA evaluation error: " + err.Error())
+		return false
+	}
+	return allowed
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/server/auth"
+	"github.com/pavelzhurov/knox/server/keydb"
+)
+
+func TestOPATarget(t *testing.T) {
+	def := OPATarget{Partition: "pvc", Service: "kms"}
+	teamA := OPATarget{Partition: "part-a", Service: "kms-a"}
+	teamB := OPATarget{Partition: "part-b", Service: "kms-b"}
+	SetOPATargets(def, []OPAMapping{
+		{Namespace: "team-a", Target: teamA},
+		{KeyPrefix: "b_", Target: teamB},
+		{KeyPrefix: "b_a", Target: teamA},
+	})
+	defer SetOPATargets(OPATarget{Partition: "pvc", Service: "kms"}, nil)
+
+	for _, tc := range []struct {
+		path, keyID string
+		expected    OPATarget
+	}{
+		{"", "", def},
+		{"", "a1", def},
+		{"team-a", "", teamA},
+		{"team-a", "a1", teamA},
+		{"team-a/db", "a1", teamA},
+		{"team-ab", "a1", def},
+		{"", "b_a1", teamB},
+		{"team-a", "b_a1", teamA},
+		{"other", "b_a1", teamB},
+		{"b_", "", def},
+	} {
+		if got := opaTarget(tc.path, tc.keyID); got != tc.expected {
+			t.Errorf("target of %q in %q is %v, not %v", tc.keyID, tc.path, got, tc.expected)
+		}
+	}
+}
+
+// recordingEvaluator allows everything and records the input documents it is
+// given.
+type recordingEvaluator struct {
+	inputs []map[string]interface{}
+}
+
+func (e *recordingEvaluator) Eval(input map[string]interface{}) (bool, error) {
+	e.inputs = append(e.inputs, input)
+	return true, nil
+}
+
+func TestOPAInputDocument(t *testing.T) {
+	e := &recordingEvaluator{}
+	old := opaEvaluator
+	SetOPAEvaluator(e)
+	defer SetOPAEvaluator(old)
+	m := NewKeyManager(keydb.NewAESGCMCryptor(0, []byte("testtesttesttest")), &keydb.TempDB{}, OpaAuthorization)
+	u := auth.NewUser("testuser", []string{"payments"})
+
+	if _, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ==", "acl": `[{"type":"UserGroup","id":"payments","access":"Read"}]`}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	r, err := http.NewRequest("GET", "https://knox/v0/keys/a1/", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	r.RemoteAddr = "10.0.0.5:41234"
+	r.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, ServerName: "knox"}
+	setAuthInfo(r, &authInfo{provider: "jwt"})
+	e.inputs = nil
+	if _, err := getKeyHandler(m, u, withOPARequest(map[string]string{"keyID": "a1"}, r)); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if len(e.inputs) != 1 {
+		t.Fatalf("expected one decision, got %d", len(e.inputs))
+	}
+	data, _ := json.Marshal(e.inputs[0])
+	var input struct {
+		Action    string
+		User      string
+		Principal struct {
+			ID     string
+			Type   string
+			Groups []string
+		}
+		Resource auth.OPAResource
+		Request  auth.OPARequest
+	}
+	if err := json.Unmarshal(data, &input); err != nil {
+		t.Fatal(err.Error())
+	}
+	if input.Action != "GetKey" || input.User != "testuser" || input.Principal.ID != "testuser" || input.Principal.Type != "user" {
+		t.Fatalf("unexpected input %s", data)
+	}
+	if len(input.Principal.Groups) != 1 || input.Principal.Groups[0] != "payments" {
+		t.Fatalf("unexpected groups in %s", data)
+	}
+	payments := knox.Access{Type: knox.UserGroup, ID: "payments", AccessType: knox.Read}
+	if input.Resource.KeyID != "a1" || len(input.Resource.ACL) == 0 || input.Resource.ACL[0] != payments {
+		t.Fatalf("unexpected resource in %s", data)
+	}
+	expected := auth.OPATLS{Version: "1.3", CipherSuite: "TLS_AES_128_GCM_SHA256", ServerName: "knox"}
+	if input.Request.Provider != "jwt" || input.Request.SourceIP != "10.0.0.5" || input.Request.TLS == nil || input.Request.TLS.Version != expected.Version || input.Request.TLS.CipherSuite != expected.CipherSuite || input.Request.TLS.ServerName != expected.ServerName {
+		t.Fatalf("unexpected request in %s", data)
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/log"
+	"gopkg.in/fsnotify.v1"
+)
+
+// maxDecisions is how many decisions a RegoEvaluator keeps in memory.
+const maxDecisions = 1000
+
+// RegoEvaluator evaluates OPA policies in process, so that OPA authorization
+// does not depend on a separately deployed OPA server. The policies are loaded
+// as a bundle from a directory or a bundle tarball and can be reloaded while
+// the server runs. Every decision is written to the decision logger, if one is
+// set, and the most recent are kept for the decision report.
+type RegoEvaluator struct {
+	path   string
+	query  string
+	logger *log.Logger
+
+	mu       sync.RWMutex
+	prepared rego.PreparedEvalQuery
+	revision string
+
+	decisionsMu sync.Mutex
+	decisions   []knox.OPADecision
+}
+
+// NewRegoEvaluator loads the policy bundle at path and prepares query, such as
+// data.knox.authz.allow, which must evaluate to true for a request to be
+// allowed. Decisions are written to logger as JSON unless it is nil.
+func NewRegoEvaluator(path, query string, logger *log.Logger) (*RegoEvaluator, error) {
+	e := &RegoEvaluator{path: path, query: query, logger: logger}
+	if err := e.Load(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Load reloads the policy bundle. If it fails to load or compile, the
+// previously loaded policies stay in use.
+func (e *RegoEvaluator) Load() error {
+	b, err := loader.NewFileLoader().AsBundle(e.path)
+	if err != nil {
+		return fmt.Errorf("failed to load policy bundle %s: %w", e.path, err)
+	}
+	prepared, err := rego.New(
+		rego.Query(e.query),
+		rego.ParsedBundle(e.path, b),
+	).PrepareForEval(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to compile policy bundle %s: %w", e.path, err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.prepared = prepared
+	e.revision = b.Manifest.Revision
+	return nil
+}
+
+// Watch reloads the policy bundle whenever a file in it changes, until stop is
+// closed. Failed reloads are logged and keep the previous policies in use.
+func (e *RegoEvaluator) Watch(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(e.path)
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+	if info.IsDir() {
+		err = watchDirs(watcher, e.path)
+	} else {
+		// Watch the directory so that replacing the tarball is noticed.
+		err = watcher.Add(filepath.Dir(e.path))
+	}
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-stop:
+				return
+			case event := <-watcher.Events:
+				if !info.IsDir() && filepath.Clean(event.Name) != filepath.Clean(e.path) {
+					continue
+				}
+				if info.IsDir() && event.Op&fsnotify.Create != 0 {
+					if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+						watchDirs(watcher, event.Name)
+					}
+				}
+				if err := e.Load(); err != nil {
+					log.Println("Failed to reload OPA policies: " + err.Error())
+				} else {
+					log.Println("Reloaded OPA policies from " + e.path)
+				}
+			case err := <-watcher.Errors:
+				log.Println("OPA policy watcher error: " + err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+// watchDirs adds root and every directory in it to watcher.
+func watchDirs(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// Eval evaluates the policies with input and records the decision.
+func (e *RegoEvaluator) Eval(input map[string]interface{}) (bool, error) {
+	start := time.Now()
+	e.mu.RLock()
+	prepared, revision := e.prepared, e.revision
+	e.mu.RUnlock()
+
+	d := knox.OPADecision{
+		ID:       newDecisionID(),
+		Query:    e.query,
+		Input:    input,
+		Revision: revision,
+		Time:     start.UnixNano(),
+	}
+	allowed, err := evalPrepared(prepared, input)
+	d.Allowed = allowed
+	if err != nil {
+		d.Error = err.Error()
+	}
+	d.Duration = time.Since(start).Nanoseconds()
+	e.record(d)
+	return allowed, err
+}
+
+// evalPrepared evaluates prepared with input as the OPA server would see it,
+// after a round trip through JSON.
+func evalPrepared(prepared rego.PreparedEvalQuery, input map[string]interface{}) (bool, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return false, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return false, err
+	}
+	rs, err := prepared.Eval(context.Background(), rego.EvalInput(doc))
+	if err != nil {
+		return false, err
+	}
+	return rs.Allowed(), nil
+}
+
+func (e *RegoEvaluator) record(d knox.OPADecision) {
+	if e.logger != nil {
+		e.logger.OutputJSON(d)
+	}
+	e.decisionsMu.Lock()
+	defer e.decisionsMu.Unlock()
+	e.decisions = append(e.decisions, d)
+	if len(e.decisions) > maxDecisions {
+		e.decisions = e.decisions[len(e.decisions)-maxDecisions:]
+	}
+}
+
+// Decisions returns the most recent decisions, oldest first.
+func (e *RegoEvaluator) Decisions() []knox.OPADecision {
+	e.decisionsMu.Lock()
+	defer e.decisionsMu.Unlock()
+	return append([]knox.OPADecision{}, e.decisions...)
+}
+
+func newDecisionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/log"
+	"github.com/pavelzhurov/knox/server/auth"
+	"github.com/pavelzhurov/knox/server/keydb"
+)
+
+const readOnlyPolicy = `package knox.authz
+
+default allow = false
+
+allow {
+	input.action == "GetKey"
+}
+`
+
+const adminPolicy = `package knox.authz
+
+default allow = false
+
+allow {
+	input.principal.groups[_] == "security-team"
+}
+`
+
+func writePolicy(t *testing.T, dir, policy string) {
+	if err := os.WriteFile(filepath.Join(dir, "policy.rego"), []byte(policy), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestRegoEvaluator(t *testing.T) {
+	dir := t.TempDir()
+	writePolicy(t, dir, readOnlyPolicy)
+	var buf bytes.Buffer
+	e, err := NewRegoEvaluator(dir, "data.knox.authz.allow", log.New(&buf, "", 0))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	u := auth.NewUser("testuser", []string{})
+	resource := auth.OPAResource{KeyID: "a1", ACL: knox.ACL{{Type: knox.User, ID: "testuser", AccessType: knox.Read}}}
+
+	for action, expected := range map[string]bool{"GetKey": true, "PutACL": false} {
+		allowed, err := e.Eval(auth.OPAInput(u, "a1", action, "pvc", "kms", resource, nil))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if allowed != expected {
+			t.Fatalf("%s: allowed is %v, not %v", action, allowed, expected)
+		}
+	}
+
+	decisions := e.Decisions()
+	if len(decisions) != 2 {
+		t.Fatalf("expected two decisions, got %v", decisions)
+	}
+	for _, d := range decisions {
+		if d.ID == "" || d.Query != "data.knox.authz.allow" || d.Allowed != (d.Input["action"] == "GetKey") {
+			t.Fatalf("unexpected decision %+v", d)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two decision log lines, got %q", buf.String())
+	}
+	var message struct {
+		Payload knox.OPADecision `json:"payload"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &message); err != nil || message.Payload.ID != decisions[0].ID {
+		t.Fatalf("unexpected decision log line %s: %v", lines[0], err)
+	}
+
+	// Policies that do not compile are not loaded.
+	writePolicy(t, dir, "package knox.authz\n\nallow {")
+	if err := e.Load(); err == nil {
+		t.Fatal("expected an error loading a broken policy")
+	}
+	if allowed, _ := e.Eval(auth.OPAInput(u, "a1", "GetKey", "pvc", "kms", resource, nil)); !allowed {
+		t.Fatal("expected the previous policy to stay in use")
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	if err := e.Watch(stop); err != nil {
+		t.Fatal(err.Error())
+	}
+	writePolicy(t, dir, adminPolicy)
+	admin := auth.NewUser("erin", []string{"security-team"})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		allowed, _ := e.Eval(auth.OPAInput(admin, "a1", "PutACL", "pvc", "kms", resource, nil))
+		if allowed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the changed policy to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOPADecisionReport(t *testing.T) {
+	dir := t.TempDir()
+	writePolicy(t, dir, adminPolicy)
+	e, err := NewRegoEvaluator(dir, "data.knox.authz.allow", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	old := opaEvaluator
+	SetOPAEvaluator(e)
+	defer SetOPAEvaluator(old)
+	m := NewKeyManager(keydb.NewAESGCMCryptor(0, []byte("testtesttesttest")), &keydb.TempDB{}, OpaAuthorization)
+
+	_, httpErr := opaDecisionReportHandler(m, auth.NewUser("testuser", []string{}), map[string]string{})
+	if httpErr == nil || httpErr.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", httpErr)
+	}
+	i, httpErr := opaDecisionReportHandler(m, auth.NewUser("erin", []string{"security-team"}), map[string]string{})
+	if httpErr != nil {
+		t.Fatalf("%+v is not nil", httpErr)
+	}
+	decisions := i.([]knox.OPADecision)
+	if len(decisions) != 2 || decisions[0].Allowed || !decisions[1].Allowed || decisions[1].Input["action"] != "OPADecisionReport" {
+		t.Fatalf("unexpected decisions %+v", decisions)
+	}
+}
+
+func TestExplainAccessOPA(t *testing.T) {
+	dir := t.TempDir()
+	writePolicy(t, dir, readOnlyPolicy)
+	e, err := NewRegoEvaluator(dir, "data.knox.authz.allow", nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	old := opaEvaluator
+	SetOPAEvaluator(e)
+	defer SetOPAEvaluator(old)
+	db := &keydb.TempDB{}
+	m := NewKeyManager(keydb.NewAESGCMCryptor(0, []byte("testtesttesttest")), db, OpaAuthorization)
+	u := auth.NewUser("testuser", []string{})
+	key := newKey("a1", knox.ACL{{Type: knox.Machine, ID: "MrRoboto", AccessType: knox.Read}}, []byte("data"), u)
+	if err := m.AddNewKey(&key); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The policy lets everyone read the key but nobody read its ACL.
+	i, httpErr := explainAccessHandler(m, u, map[string]string{"keyID": "a1"})
+	if httpErr != nil {
+		t.Fatalf("%+v is not nil", httpErr)
+	}
+	explanation := i.(knox.AccessExplanation)
+	if !explanation.Allowed {
+		t.Fatalf("unexpected explanation %+v", explanation)
+	}
+	if resource := explanation.OPAInput["resource"].(auth.OPAResource); resource.KeyID != "a1" || resource.ACL != nil {
+		t.Fatalf("unexpected resource %+v", resource)
+	}
+}
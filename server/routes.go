@@ -4,13 +4,22 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pavelzhurov/knox"
 	"github.com/pavelzhurov/knox/server/auth"
+	"github.com/pavelzhurov/knox/server/keydb"
 )
 
+// namespacePathVar matches a namespace path such as /teams/payments/prod in a
+// route, including its leading slash.
+const namespacePathVar = "{path:(?:/[a-zA-Z0-9_:-]+)+}"
+
 var routes = [...]Route{
 	{
 		Method:  "GET",
@@ -30,6 +39,7 @@ var routes = [...]Route{
 			PostParameter("id"),
 			PostParameter("data"),
 			PostParameter("acl"),
+			PostParameter("path"),
 		},
 	},
 
@@ -50,6 +60,112 @@ var routes = [...]Route{
 		Handler: deleteKeyHandler,
 		Parameters: []Parameter{
 			UrlParameter("keyID"),
+			QueryParameter("purge"),
+			HeaderParameter("If-Match"),
+		},
+	},
+	{
+		Method:  "POST",
+		Id:      "restorekey",
+		Path:    "/v0/keys/{keyID}/restore/",
+		Handler: restoreKeyHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+		},
+	},
+	{
+		Method:  "POST",
+		Id:      "renamekey",
+		Path:    "/v0/keys/{keyID}/rename/",
+		Handler: renameKeyHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+			PostParameter("id"),
+			HeaderParameter("If-Match"),
+		},
+	},
+	{
+		Method:  "POST",
+		Id:      "movekey",
+		Path:    "/v0/keys/{keyID}/move/",
+		Handler: moveKeyHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+			PostParameter("path"),
+		},
+	},
+	{
+		Method:  "GET",
+		Id:      "getnamespaces",
+		Path:    "/v0/namespaces/",
+		Handler: getNamespacesHandler,
+	},
+	{
+		Method:  "GET",
+		Id:      "getnamespace",
+		Path:    "/v0/namespaces" + namespacePathVar + "/",
+		Handler: getNamespaceHandler,
+		Parameters: []Parameter{
+			UrlParameter("path"),
+		},
+	},
+	{
+		Method:  "POST",
+		Id:      "postnamespace",
+		Path:    "/v0/namespaces" + namespacePathVar + "/",
+		Handler: postNamespaceHandler,
+		Parameters: []Parameter{
+			UrlParameter("path"),
+			PostParameter("acl"),
+		},
+	},
+	{
+		Method:  "PUT",
+		Id:      "putnamespaceaccess",
+		Path:    "/v0/namespaces" + namespacePathVar + "/",
+		Handler: putNamespaceAccessHandler,
+		Parameters: []Parameter{
+			UrlParameter("path"),
+			PostParameter("access"),
+			PostParameter("acl"),
+		},
+	},
+	{
+		Method:  "DELETE",
+		Id:      "deletenamespace",
+		Path:    "/v0/namespaces" + namespacePathVar + "/",
+		Handler: deleteNamespaceHandler,
+		Parameters: []Parameter{
+			UrlParameter("path"),
+		},
+	},
+	{
+		Method:  "GET",
+		Id:      "getalias",
+		Path:    "/v0/aliases/{aliasID}/",
+		Handler: getAliasHandler,
+		Parameters: []Parameter{
+			UrlParameter("aliasID"),
+		},
+	},
+	{
+		Method:  "PUT",
+		Id:      "putalias",
+		Path:    "/v0/aliases/{aliasID}/",
+		Handler: putAliasHandler,
+		Parameters: []Parameter{
+			UrlParameter("aliasID"),
+			PostParameter("key"),
+			PostParameter("acl"),
+		},
+	},
+	{
+		Method:  "DELETE",
+		Id:      "deletealias",
+		Path:    "/v0/aliases/{aliasID}/",
+		Handler: deleteAliasHandler,
+		Parameters: []Parameter{
+			UrlParameter("aliasID"),
 		},
 	},
 	{
@@ -61,6 +177,80 @@ var routes = [...]Route{
 			UrlParameter("keyID"),
 		},
 	},
+	{
+		Method:  "GET",
+		Id:      "accessreport",
+		Path:    "/v0/reports/access/",
+		Handler: accessReportHandler,
+		Parameters: []Parameter{
+			QueryParameter("principal"),
+			QueryParameter("groups"),
+		},
+	},
+	{
+		Method:  "POST",
+		Id:      "migrateacl",
+		Path:    "/v0/acl-migrations/",
+		Handler: migrateACLHandler,
+		Parameters: []Parameter{
+			PostParameter("from"),
+			PostParameter("to"),
+			PostParameter("remove"),
+			PostParameter("dry_run"),
+			PostParameter("after"),
+			PostParameter("batch"),
+		},
+	},
+	{
+		Method:  "POST",
+		Id:      "stripaccess",
+		Path:    "/v0/acl-migrations/strip/",
+		Handler: stripAccessHandler,
+		Parameters: []Parameter{
+			PostParameter("principals"),
+		},
+	},
+	{
+		Method:  "GET",
+		Id:      "acllintreport",
+		Path:    "/v0/reports/acl-lint/",
+		Handler: aclLintReportHandler,
+	},
+	{
+		Method:  "GET",
+		Id:      "breakglassreport",
+		Path:    "/v0/reports/break-glass/",
+		Handler: breakGlassReportHandler,
+	},
+	{
+		Method:  "GET",
+		Id:      "opadecisionreport",
+		Path:    "/v0/reports/opa-decisions/",
+		Handler: opaDecisionReportHandler,
+	},
+	{
+		Method:  "GET",
+		Id:      "whoami",
+		Path:    "/v0/whoami/",
+		Handler: whoamiHandler,
+		Parameters: []Parameter{
+			AuthParameter("provider"),
+			AuthParameter("expires_at"),
+		},
+	},
+	{
+		Method:  "GET",
+		Id:      "explainaccess",
+		Path:    "/v0/keys/{keyID}/explain/",
+		Handler: explainAccessHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+			QueryParameter("as"),
+			QueryParameter("groups"),
+			QueryParameter("access"),
+			QueryParameter("action"),
+		},
+	},
 	{
 		Method:  "PUT",
 		Id:      "putaccess",
@@ -70,6 +260,97 @@ var routes = [...]Route{
 			UrlParameter("keyID"),
 			PostParameter("access"),
 			PostParameter("acl"),
+			HeaderParameter("If-Match"),
+		},
+	},
+	{
+		Method:  "POST",
+		Id:      "postaccessrequest",
+		Path:    "/v0/keys/{keyID}/requests/",
+		Handler: postAccessRequestHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+			PostParameter("access"),
+			PostParameter("justification"),
+			PostParameter("duration"),
+		},
+	},
+	{
+		Method:  "POST",
+		Id:      "decideaccessrequest",
+		Path:    "/v0/keys/{keyID}/requests/{requestID}/",
+		Handler: decideAccessRequestHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+			UrlParameter("requestID"),
+			PostParameter("decision"),
+		},
+	},
+	{
+		Method:  "GET",
+		Id:      "getaccessrequests",
+		Path:    "/v0/requests/",
+		Handler: getAccessRequestsHandler,
+		Parameters: []Parameter{
+			QueryParameter("key_id"),
+		},
+	},
+	{
+		Method:  "GET",
+		Id:      "getquorum",
+		Path:    "/v0/keys/{keyID}/quorum/",
+		Handler: getQuorumHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+		},
+	},
+	{
+		Method:  "PUT",
+		Id:      "putquorum",
+		Path:    "/v0/keys/{keyID}/quorum/",
+		Handler: putQuorumHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+			PostParameter("required"),
+		},
+	},
+	{
+		Method:  "GET",
+		Id:      "getoperations",
+		Path:    "/v0/operations/",
+		Handler: getPendingOperationsHandler,
+		Parameters: []Parameter{
+			QueryParameter("key_id"),
+		},
+	},
+	{
+		Method:  "POST",
+		Id:      "confirmoperation",
+		Path:    "/v0/keys/{keyID}/operations/{operationID}/",
+		Handler: confirmOperationHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+			UrlParameter("operationID"),
+		},
+	},
+	{
+		Method:  "DELETE",
+		Id:      "canceloperation",
+		Path:    "/v0/keys/{keyID}/operations/{operationID}/",
+		Handler: cancelOperationHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+			UrlParameter("operationID"),
+		},
+	},
+	{
+		Method:  "POST",
+		Id:      "breakglass",
+		Path:    "/v0/keys/{keyID}/break-glass/",
+		Handler: breakGlassHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+			PostParameter("justification"),
 		},
 	},
 	{
@@ -80,6 +361,7 @@ var routes = [...]Route{
 		Parameters: []Parameter{
 			UrlParameter("keyID"),
 			PostParameter("data"),
+			HeaderParameter("If-Match"),
 		},
 	},
 	{
@@ -91,6 +373,37 @@ var routes = [...]Route{
 			UrlParameter("keyID"),
 			UrlParameter("versionID"),
 			PostParameter("status"),
+			HeaderParameter("If-Match"),
+		},
+	},
+	{
+		Method:  "DELETE",
+		Id:      "deleteversion",
+		Path:    "/v0/keys/{keyID}/versions/{versionID}/",
+		Handler: deleteVersionHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+			UrlParameter("versionID"),
+			HeaderParameter("If-Match"),
+		},
+	},
+	{
+		Method:  "GET",
+		Id:      "getretention",
+		Path:    "/v0/keys/{keyID}/retention/",
+		Handler: getRetentionHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+		},
+	},
+	{
+		Method:  "PUT",
+		Id:      "putretention",
+		Path:    "/v0/keys/{keyID}/retention/",
+		Handler: putRetentionHandler,
+		Parameters: []Parameter{
+			UrlParameter("keyID"),
+			PostParameter("retention"),
 		},
 	},
 }
@@ -108,7 +421,7 @@ func getKeysHandler(m KeyManager, principal knox.Principal, parameters map[strin
 
 	queryString := parameters["queryString"]
 
-	if !principal.CanAccessOPA(m.GetAuthenticator(), "", "ListKeys", "pvc", "kms") {
+	if !canAccessOPA(principal, nil, "", "", "ListKeys", parameters) {
 		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to list keys", principal.GetID()))
 	}
 
@@ -127,7 +440,7 @@ func getKeysHandler(m KeyManager, principal knox.Principal, parameters map[strin
 		if err != nil {
 			return nil, errF(knox.InternalServerErrorCode, err.Error())
 		}
-		verified_keys, err := verifyKeys(m, principal, keys)
+		verified_keys, err := verifyKeys(m, principal, keys, parameters)
 		if err != nil {
 			return nil, errF(knox.InternalServerErrorCode, err.Error())
 		}
@@ -138,45 +451,127 @@ func getKeysHandler(m KeyManager, principal knox.Principal, parameters map[strin
 	if err != nil {
 		return nil, errF(knox.InternalServerErrorCode, err.Error())
 	}
-	verified_keys, err := verifyKeys(m, principal, keys)
+	verified_keys, err := verifyKeys(m, principal, keys, parameters)
 	if err != nil {
 		return nil, errF(knox.InternalServerErrorCode, err.Error())
 	}
 	return verified_keys, nil
 }
 
-func CanAccess(principal knox.Principal, m KeyManager, acl knox.ACL, at knox.AccessType, keyID, action, partition, service string) bool {
+// CanAccess determines if principal has access of type at to the key keyID in
+// the namespace at path, whose own ACL is acl. In ACL mode the global policy
+// and the ACLs of the namespace and of the namespaces containing it apply as
+// well; in OPA mode the policy of the key's OPA target (see SetOPATargets) is
+// given the key's full path, its ACL and the request described by parameters,
+// which are nil for decisions that are not about the principal's own request.
+// For a namespace itself, keyID is empty and acl is nil.
+func CanAccess(principal knox.Principal, m KeyManager, acl knox.ACL, at knox.AccessType, path, keyID, action string, parameters map[string]string) bool {
 	switch m.GetAuthorizationType() {
 	case AclAuthorization:
-		return principal.CanAccess(acl, at)
+		full := append(knox.ACL{}, globalPolicy...)
+		if path != "" {
+			inherited, err := m.GetInheritedACL(path)
+			if err != nil {
+				log.Printf("Failed to get ACL inherited from %s: %s", path, err.Error())
+				return false
+			}
+			full = append(full, inherited...)
+		}
+		return principal.CanAccess(append(full, acl...), at)
 	case OpaAuthorization:
-		return principal.CanAccessOPA(m.GetAuthenticator(), keyID, action, partition, service)
+		return canAccessOPA(principal, acl, path, keyID, action, parameters)
 	}
 	return false
 }
 
+// resourcePath joins the namespace path of a key and its ID into the resource
+// path passed to OPA. Keys outside of any namespace are passed by ID alone.
+func resourcePath(path, keyID string) string {
+	if path == "" || keyID == "" {
+		return path + keyID
+	}
+	return path + "/" + keyID
+}
+
+// checkPrecondition returns an error if the request carries an If-Match header
+// that does not match the current ETag of the key. It rejects requests made on
+// an outdated view of the key before hooks run or quorum is asked for; the
+// update itself checks the precondition again through conditional.
+func checkPrecondition(key *knox.Key, parameters map[string]string) *HTTPError {
+	ifMatch, ok := parameters["If-Match"]
+	if !ok || matchesETag(ifMatch, keyETag(key.VersionHash, key.ACL)) {
+		return nil
+	}
+	return errF(knox.PreconditionFailedCode, fmt.Sprintf("Key %s does not match %s", key.ID, ifMatch))
+}
+
+// conditional returns the key manager to update a key with: one that checks
+// the request's If-Match header in the update if it has one, or m.
+func conditional(m KeyManager, parameters map[string]string) KeyManager {
+	if ifMatch, ok := parameters["If-Match"]; ok {
+		return m.IfMatch(ifMatch)
+	}
+	return m
+}
+
 // Authorize access to keys. If user is not authorized to read key, it won't be returned
-func verifyKeys(m KeyManager, principal knox.Principal, keys []string) ([]string, error) {
+func verifyKeys(m KeyManager, principal knox.Principal, keys []string, parameters map[string]string) ([]string, error) {
 	var return_keys []string
 
 	for _, keyID := range keys {
-		key, err := m.GetKey(keyID, knox.Active)
+		key, err := getKeyOrAlias(m, principal, keyID, knox.Active, parameters)
 		if err != nil {
+			if err.Subcode == knox.UnauthorizedCode {
+				continue
+			}
 			return nil, fmt.Errorf("can't verify principal %s access to one of the keys", principal.GetID())
 		}
 
-		if CanAccess(principal, m, key.ACL, knox.Read, keyID, "GetKey", "pvc", "kms") {
+		if CanAccess(principal, m, key.ACL, knox.Read, key.Path, key.ID, "GetKey", parameters) {
 			return_keys = append(return_keys, keyID)
 		}
 	}
 	return return_keys, nil
 }
 
+// getKeyOrAlias gets the key matching keyID or, if keyID is an alias, the key
+// the alias resolves to. Reading through an alias requires Read access under
+// the alias's own ACL or an active break-glass grant, which is kept on the key
+// the alias resolves to. Callers still check access to the returned key, so an
+// alias never grants more than the key's ACL does.
+func getKeyOrAlias(m KeyManager, principal knox.Principal, keyID string, status knox.VersionStatus, parameters map[string]string) (*knox.Key, *HTTPError) {
+	key, getErr := m.GetKey(keyID, status)
+	if getErr == knox.ErrKeyIDNotFound {
+		alias, aliasErr := m.GetAlias(keyID)
+		if aliasErr == nil {
+			if !CanAccess(principal, m, alias.ACL, knox.Read, alias.Path, keyID, "GetKey", parameters) {
+				grant, err := activeBreakGlassGrant(m, principal, alias.KeyID)
+				if err != nil {
+					return nil, err
+				}
+				if grant == nil {
+					return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to read %s", principal.GetID(), keyID))
+				}
+			}
+			key, getErr = m.GetKey(alias.KeyID, status)
+		}
+	}
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+	return key, nil
+}
+
 // postKeysHandler creates a new key and stores it. It reads from the post data
-// key ID, base64 encoded data, and JSON encoded ACL.
+// key ID, base64 encoded data, JSON encoded ACL and optionally the path of the
+// namespace to create the key in.
 // It returns the key version ID of the original Primary key version.
 // The route for this handler is POST /v0/keys/
-// The postKeysHandler must be a User.
+// The postKeysHandler must be a User, with Write access to the namespace if one
+// is given.
 func postKeysHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
 
 	keyID, keyIDOK := parameters["id"]
@@ -184,11 +579,17 @@ func postKeysHandler(m KeyManager, principal knox.Principal, parameters map[stri
 		return nil, errF(knox.NoKeyIDCode, "Missing parameter 'id'")
 	}
 
+	path := parameters["path"]
+
 	// Authorize
 	if (!auth.IsUser(principal) && m.GetAuthorizationType() == AclAuthorization) ||
-		(!principal.CanAccessOPA(m.GetAuthenticator(), keyID, "CreateKey", "pvc", "kms") && m.GetAuthorizationType() == OpaAuthorization) {
+		(!canAccessOPA(principal, nil, path, keyID, "CreateKey", parameters) && m.GetAuthorizationType() == OpaAuthorization) {
 		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to create key", principal.GetID()))
 	}
+	// Keys can only be created in namespaces the creator can write to.
+	if path != "" && m.GetAuthorizationType() == AclAuthorization && !CanAccess(principal, m, nil, knox.Write, path, "", "CreateKey", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to create keys in %s", principal.GetID(), path))
+	}
 
 	data, dataOK := parameters["data"]
 	if !dataOK {
@@ -211,23 +612,40 @@ func postKeysHandler(m KeyManager, principal knox.Principal, parameters map[stri
 
 	// Create and add new key
 	key := newKey(keyID, acl, decodedData, principal)
+	key.Path = path
+	if err := runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreCreate(principal, &key) }); err != nil {
+		return nil, err
+	}
 	err := m.AddNewKey(&key)
 	if err != nil {
 		if err == knox.ErrKeyExists {
 			return nil, errF(knox.KeyIdentifierExistsCode, fmt.Sprintf("Key %s already exists", keyID))
 		}
+		if err == knox.ErrKeyInTrash {
+			return nil, errF(knox.KeyInTrashCode, fmt.Sprintf("Key %s is in the trash, restore or purge it", keyID))
+		}
 		if err == knox.ErrInvalidKeyID {
 			return nil, errF(knox.BadKeyFormatCode, fmt.Sprintf("KeyID includes unsupported characters %s", keyID))
 		}
+		if err == knox.ErrNamespaceNotFound {
+			return nil, errF(knox.NamespaceDoesNotExistCode, fmt.Sprintf("No such namespace %s", path))
+		}
+		if err == knox.ErrInvalidNamespacePath {
+			return nil, errF(knox.BadRequestDataCode, err.Error())
+		}
 
 		return nil, errF(knox.InternalServerErrorCode, err.Error())
 	}
+	runPostHooks(m, func(h LifecycleHook) { h.PostCreate(principal, &key) })
 	return key.VersionList[0].ID, nil
 }
 
-// getKeyHandler gets the key matching the keyID in the request.
+// getKeyHandler gets the key matching the keyID in the request. If keyID is an
+// alias, the key it resolves to is returned.
 // The route for this handler is GET /v0/keys/<key_id>/
-// The principal must have Read access to the key
+// The principal must have Read access to the key, and to the alias if one is used,
+// or an active break-glass grant for the key.
+// The response carries the key's ETag.
 func getKeyHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
 	keyID := parameters["keyID"]
 
@@ -241,30 +659,50 @@ func getKeyHandler(m KeyManager, principal knox.Principal, parameters map[string
 	}
 
 	// Get data
-	key, getErr := m.GetKey(keyID, status)
+	key, getErr := getKeyOrAlias(m, principal, keyID, status, parameters)
 	if getErr != nil {
-		if getErr == knox.ErrKeyIDNotFound {
-			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
-		}
-		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+		return nil, getErr
 	}
 
 	// Authorize access to data
-	if !CanAccess(principal, m, key.ACL, knox.Read, keyID, "GetKey", "pvc", "kms") {
-		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to read %s", principal.GetID(), keyID))
+	if !CanAccess(principal, m, key.ACL, knox.Read, key.Path, key.ID, "GetKey", parameters) {
+		grant, err := activeBreakGlassGrant(m, principal, key.ID)
+		if err != nil {
+			return nil, err
+		}
+		if grant == nil {
+			return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to read %s", principal.GetID(), keyID))
+		}
+		breakGlassAudit(map[string]interface{}{
+			"type":          "break_glass_read",
+			"principal":     principal.GetID(),
+			"key_id":        key.ID,
+			"justification": grant.Justification,
+			"expires_at":    grant.ExpiresAt,
+		})
 	}
+	etag := keyETag(key.VersionHash, key.ACL)
 	// Zero ACL for key response, in order to avoid caching unnecessarily
 	key.ACL = knox.ACL{}
-	return key, nil
+	return etagged{key, etag}, nil
 }
 
-// deleteKeyHandler deletes the key matching the keyID in the request.
+// deleteKeyHandler moves the key matching the keyID in the request to the
+// trash, from which it can be restored until it is purged. With purge=true the
+// key is instead removed permanently, whether or not it is already in the trash.
 // The route for this handler is DELETE /v0/keys/<key_id>/
 // The principal needs Admin access to the key.
+// If-Match makes the deletion conditional on the key's ETag.
+// Deleting or purging a key that requires quorum, including a key in the
+// trash, files a pending operation instead.
 func deleteKeyHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
 	keyID := parameters["keyID"]
+	purge := parameters["purge"] == "true"
 
 	key, getErr := m.GetKey(keyID, knox.Primary)
+	if getErr == knox.ErrKeyIDNotFound && purge {
+		key, getErr = m.GetDeletedKey(keyID)
+	}
 	if getErr != nil {
 		if getErr == knox.ErrKeyIDNotFound {
 			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
@@ -273,20 +711,81 @@ func deleteKeyHandler(m KeyManager, principal knox.Principal, parameters map[str
 	}
 
 	// Authorize
-	if !CanAccess(principal, m, key.ACL, knox.Admin, keyID, "DeleteKey", "pvc", "kms") {
+	if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, "DeleteKey", parameters) {
 		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to delete %s", principal.GetID(), keyID))
 	}
+	if err := checkPrecondition(key, parameters); err != nil {
+		return nil, err
+	}
+	// Purging a key in the trash is held too: moving it there could be undone.
+	op := knox.PendingOperation{KeyID: keyID, Operation: knox.OperationDelete}
+	if purge {
+		op.Operation = knox.OperationPurge
+	}
+	if err := holdForQuorum(m, principal, op); err != nil {
+		return nil, err
+	}
+	if err := runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreDelete(principal, keyID, purge) }); err != nil {
+		return nil, err
+	}
 
 	// Delete the key
-	err := m.DeleteKey(keyID)
-	if err != nil {
+	var err error
+	if purge {
+		err = conditional(m, parameters).PurgeKey(keyID)
+	} else {
+		err = conditional(m, parameters).DeleteKey(keyID)
+	}
+	switch err {
+	case nil:
+		runPostHooks(m, func(h LifecycleHook) { h.PostDelete(principal, keyID, purge) })
+		return nil, nil
+	case knox.ErrKeyIDNotFound:
+		return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+	case knox.ErrPreconditionFailed:
+		return nil, errF(knox.PreconditionFailedCode, fmt.Sprintf("Key %s does not match %s", keyID, parameters["If-Match"]))
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+}
+
+// restoreKeyHandler moves the key matching the keyID in the request out of the
+// trash.
+// The route for this handler is POST /v0/keys/<key_id>/restore/
+// The principal needs Admin access to the key.
+func restoreKeyHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	keyID := parameters["keyID"]
+
+	key, getErr := m.GetDeletedKey(keyID)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No deleted key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+
+	// Authorize
+	if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, "RestoreKey", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to restore %s", principal.GetID(), keyID))
+	}
+
+	switch err := m.RestoreKey(keyID); err {
+	case nil:
+		return nil, nil
+	case knox.ErrKeyIDNotFound:
+		return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No deleted key %s", keyID))
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
 		return nil, errF(knox.InternalServerErrorCode, err.Error())
 	}
-	return nil, nil
 }
 
 // getAccessHandler gets the ACL for a specific Key.
 // The route for this handler is GET /v0/keys/<key_id>/access/
+// The response carries the key's ETag.
 func getAccessHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
 
 	keyID := parameters["keyID"]
@@ -300,11 +799,11 @@ func getAccessHandler(m KeyManager, principal knox.Principal, parameters map[str
 		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
 	}
 
-	if !CanAccess(principal, m, key.ACL, knox.Read, keyID, "GetACL", "pvc", "kms") {
+	if !CanAccess(principal, m, key.ACL, knox.Read, key.Path, keyID, "GetACL", parameters) {
 		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to get acl %s", principal.GetID(), keyID))
 	}
 
-	return key.ACL, nil
+	return etagged{key.ACL, keyETag(key.VersionHash, key.ACL)}, nil
 }
 
 // putAccessHandler adds or updates the existing ACL with an Access object
@@ -313,22 +812,116 @@ func getAccessHandler(m KeyManager, principal knox.Principal, parameters map[str
 // existing access rules will not be modified unless the same Type and Name is used
 // The route for this handler is PUT /v0/keys/<key_id>/access/
 // The principal needs Admin access.
+// If-Match makes the update conditional on the key's ETag.
+// Updating the ACL of a key that requires quorum files a pending operation
+// instead.
 func putAccessHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
 	keyID := parameters["keyID"]
 
-	accessStr, accessOK := parameters["access"]
-	aclStr, aclOK := parameters["acl"]
+	acl, parseErr := parseAccessUpdate(parameters)
+	if parseErr != nil {
+		return nil, parseErr
+	}
 
-	acl := []knox.Access{}
-	if accessOK {
-		access := knox.Access{}
-		// If JSON decode fails, try a base64 encoded JSON string (both options are around for backwards compatibility)
-		jsonErr := json.Unmarshal([]byte(accessStr), &access)
-		if jsonErr != nil {
-			decodedData, decodeErr := base64.RawURLEncoding.DecodeString(accessStr)
-			if decodeErr != nil {
-				return nil, errF(knox.BadRequestDataCode, decodeErr.Error())
-			}
+	// Get the Key
+	key, getErr := m.GetKey(keyID, knox.Primary)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+
+	// Authorize
+	if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, "PutACL", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to update access for %s", principal.GetID(), keyID))
+	}
+	if err := checkPrecondition(key, parameters); err != nil {
+		return nil, err
+	}
+
+	if err := validateAccessUpdate(acl); err != nil {
+		return nil, err
+	}
+	findings, lintErr := lintAccessUpdate(m, key, acl)
+	if lintErr != nil {
+		return nil, lintErr
+	}
+	if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: keyID, Operation: knox.OperationUpdateAccess, ACL: acl}); err != nil {
+		return nil, err
+	}
+	if err := runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreUpdateAccess(principal, keyID, acl) }); err != nil {
+		return nil, err
+	}
+
+	// Update Access
+	updateErr := conditional(m, parameters).UpdateAccess(keyID, acl...)
+	if updateErr != nil {
+		if updateErr == keydb.ErrDBVersion {
+			return nil, errF(knox.KeyConflictCode, updateErr.Error())
+		}
+		if updateErr == knox.ErrPreconditionFailed {
+			return nil, errF(knox.PreconditionFailedCode, fmt.Sprintf("Key %s does not match %s", keyID, parameters["If-Match"]))
+		}
+		return nil, errF(knox.InternalServerErrorCode, updateErr.Error())
+	}
+	runPostHooks(m, func(h LifecycleHook) { h.PostUpdateAccess(principal, keyID, acl) })
+	if len(findings) > 0 {
+		return findings, nil
+	}
+	return nil, nil
+}
+
+// lintAccessUpdate checks the ACL that updating key's ACL with acl results in
+// against the lint rules. Depending on the lint mode, the findings introduced
+// by the update are returned as warnings or reject it.
+func lintAccessUpdate(m KeyManager, key *knox.Key, acl knox.ACL) ([]knox.ACLLintFinding, *HTTPError) {
+	if aclLintMode == ACLLintOff {
+		return nil, nil
+	}
+	updated := append(knox.ACL{}, key.ACL...)
+	for _, a := range acl {
+		updated = updated.Add(a)
+	}
+	var inherited knox.ACL
+	if key.Path != "" {
+		var err error
+		if inherited, err = m.GetInheritedACL(key.Path); err != nil {
+			return nil, errF(knox.InternalServerErrorCode, err.Error())
+		}
+	}
+	findings := newLintFindings(key.ID, key.ACL, updated, inherited)
+	if len(findings) == 0 {
+		return nil, nil
+	}
+	messages := []string{}
+	for _, f := range findings {
+		messages = append(messages, f.Rule+": "+f.Message)
+	}
+	if aclLintMode == ACLLintBlock {
+		return nil, errF(knox.ACLLintFailedCode, strings.Join(messages, "; "))
+	}
+	log.Printf("ACL lint warnings for %s: %s", key.ID, strings.Join(messages, "; "))
+	return findings, nil
+}
+
+// parseAccessUpdate reads the ACL entries to add or change from the access
+// form data, which holds a single rule, or from the acl form data, which holds
+// a list of rules.
+func parseAccessUpdate(parameters map[string]string) (knox.ACL, *HTTPError) {
+	accessStr, accessOK := parameters["access"]
+	aclStr, aclOK := parameters["acl"]
+
+	acl := []knox.Access{}
+	if accessOK {
+		access := knox.Access{}
+		// If JSON decode fails, try a base64 encoded JSON string (both options are around for backwards compatibility)
+		jsonErr := json.Unmarshal([]byte(accessStr), &access)
+		if jsonErr != nil {
+			decodedData, decodeErr := base64.RawURLEncoding.DecodeString(accessStr)
+			if decodeErr != nil {
+				return nil, errF(knox.BadRequestDataCode, decodeErr.Error())
+			}
 			jsonErr := json.Unmarshal(decodedData, &access)
 			if jsonErr != nil {
 				return nil, errF(knox.BadRequestDataCode, jsonErr.Error())
@@ -343,21 +936,12 @@ func putAccessHandler(m KeyManager, principal knox.Principal, parameters map[str
 	} else {
 		return nil, errF(knox.BadRequestDataCode, "Missing acl and access parameters")
 	}
+	return acl, nil
+}
 
-	// Get the Key
-	key, getErr := m.GetKey(keyID, knox.Primary)
-	if getErr != nil {
-		if getErr == knox.ErrKeyIDNotFound {
-			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
-		}
-		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
-	}
-
-	// Authorize
-	if !CanAccess(principal, m, key.ACL, knox.Admin, keyID, "PutACL", "pvc", "kms") {
-		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to update access for %s", principal.GetID(), keyID))
-	}
-
+// validateAccessUpdate checks the principals of ACL entries that are added or
+// changed.
+func validateAccessUpdate(acl knox.ACL) *HTTPError {
 	for _, access := range acl {
 		// If access type change is not "None" (i.e. we're adding, not deleting, an ACL entry) then
 		// we apply validation on the ID string to make sure it conforms to the expectations of the
@@ -366,90 +950,357 @@ func putAccessHandler(m KeyManager, principal knox.Principal, parameters map[str
 		if access.AccessType != knox.None {
 			principalErr := access.Type.IsValidPrincipal(access.ID, extraPrincipalValidators)
 			if principalErr != nil {
-				return nil, errF(knox.BadPrincipalIdentifier, principalErr.Error())
+				return errF(knox.BadPrincipalIdentifier, principalErr.Error())
 			}
 		}
 	}
+	return nil
+}
 
-	// Update Access
-	updateErr := m.UpdateAccess(keyID, acl...)
-	if updateErr != nil {
-		return nil, errF(knox.InternalServerErrorCode, updateErr.Error())
+// principalAccess returns an ACL entry that names the principal.
+func principalAccess(principal knox.Principal) (knox.Access, *HTTPError) {
+	switch principal.Type() {
+	case "user":
+		return knox.Access{Type: knox.User, ID: principal.GetID()}, nil
+	case "machine":
+		return knox.Access{Type: knox.Machine, ID: principal.GetID()}, nil
+	case "service":
+		return knox.Access{Type: knox.Service, ID: principal.GetID()}, nil
 	}
-	return nil, nil
+	return knox.Access{}, errF(knox.BadPrincipalIdentifier, fmt.Sprintf("Unknown principal type %s", principal.Type()))
 }
 
-// postVersionHandler creates a new key version. This version is immediately
-// added as an Active key.
-// The route for this handler is PUT /v0/keys/<key_id>/versions/
-// The principal needs Write access.
-func postVersionHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+// postAccessRequestHandler files a request for access to a key for the
+// requesting principal. access is the requested access type and justification
+// explains why it is needed. duration, such as 8h, makes the access temporary
+// once approved. Requests for keys that do not exist are answered like any
+// other and dropped, so that they do not tell whether a key exists.
+// The route for this handler is POST /v0/keys/<key_id>/requests/
+// Any authenticated principal can file requests for itself.
+func postAccessRequestHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	keyID := parameters["keyID"]
+
+	var at knox.AccessType
+	accessStr := parameters["access"]
+	if err := json.Unmarshal([]byte(strconv.Quote(accessStr)), &at); err != nil || at <= knox.None {
+		return nil, errF(knox.BadRequestDataCode, fmt.Sprintf("Invalid access type %s", accessStr))
+	}
+	access, accessErr := principalAccess(principal)
+	if accessErr != nil {
+		return nil, accessErr
+	}
+	access.AccessType = at
+	if err := validateAccessUpdate(knox.ACL{access}); err != nil {
+		return nil, err
+	}
+	var duration time.Duration
+	if durationStr, ok := parameters["duration"]; ok && durationStr != "" {
+		d, err := time.ParseDuration(durationStr)
+		if err != nil || d <= 0 {
+			return nil, errF(knox.BadRequestDataCode, fmt.Sprintf("Invalid duration %s", durationStr))
+		}
+		duration = d
+	}
+
+	r := newAccessRequest(keyID, access, parameters["justification"], duration, principal)
+	if err := m.AddAccessRequest(&r); err != nil {
+		switch err {
+		case knox.ErrKeyIDNotFound:
+			return r, nil
+		case knox.ErrInvalidKeyID, knox.ErrInvalidAccessRequest, knox.ErrAccessRequestJustification:
+			return nil, errF(knox.BadRequestDataCode, err.Error())
+		case keydb.ErrDBVersion:
+			return nil, errF(knox.KeyConflictCode, err.Error())
+		}
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	audit(map[string]interface{}{
+		"type":          "access_request",
+		"principal":     principal.GetID(),
+		"key_id":        keyID,
+		"request_id":    r.ID,
+		"access":        r.Access,
+		"justification": r.Justification,
+	})
+	return r, nil
+}
+
+// getAccessRequestsHandler lists the pending access requests that the
+// principal can decide, because it has Admin access to their keys, or that it
+// filed. key_id limits the list to the requests for one key.
+// The route for this handler is GET /v0/requests/
+func getAccessRequestsHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	requests, err := m.GetAccessRequests()
+	if err != nil {
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	keyID := parameters["key_id"]
+	canDecide := map[string]bool{}
+	visible := []knox.AccessRequest{}
+	for _, r := range requests {
+		if keyID != "" && r.KeyID != keyID {
+			continue
+		}
+		decide, ok := canDecide[r.KeyID]
+		if !ok {
+			key, err := m.GetKey(r.KeyID, knox.Primary)
+			if err != nil && err != knox.ErrKeyIDNotFound {
+				return nil, errF(knox.InternalServerErrorCode, err.Error())
+			}
+			decide = err == nil && CanAccess(principal, m, key.ACL, knox.Admin, key.Path, r.KeyID, "ListAccessRequests", parameters)
+			canDecide[r.KeyID] = decide
+		}
+		if decide || r.RequestedBy == principal.GetID() {
+			visible = append(visible, r)
+		}
+	}
+	return visible, nil
+}
 
+// decideAccessRequestHandler approves or denies a pending access request, as
+// decision says. Approving adds the requested entry to the key's ACL, subject
+// to the ACL lint rules.
+// The route for this handler is POST /v0/keys/<key_id>/requests/<request_id>/
+// The principal needs Admin access.
+func decideAccessRequestHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
 	keyID := parameters["keyID"]
-	dataStr, dataOK := parameters["data"]
-	if !dataOK {
-		return nil, errF(knox.BadRequestDataCode, "Missing parameter 'data'")
+	requestID, err := strconv.ParseUint(parameters["requestID"], 10, 64)
+	if err != nil {
+		return nil, errF(knox.BadRequestDataCode, fmt.Sprintf("Invalid request ID %s", parameters["requestID"]))
 	}
-	decodedData, decodeErr := base64.StdEncoding.DecodeString(dataStr)
-	if decodeErr != nil {
-		return nil, errF(knox.BadRequestDataCode, decodeErr.Error())
+	decision := parameters["decision"]
+	var action, event string
+	switch decision {
+	case "approve":
+		action, event = "ApproveAccessRequest", "access_request_approved"
+	case "deny":
+		action, event = "DenyAccessRequest", "access_request_denied"
+	default:
+		return nil, errF(knox.BadRequestDataCode, fmt.Sprintf("Decision must be approve or deny, not %s", decision))
 	}
 
-	// Get the key
-	key, getErr := m.GetKey(keyID, knox.Inactive)
+	key, getErr := m.GetKey(keyID, knox.Primary)
 	if getErr != nil {
 		if getErr == knox.ErrKeyIDNotFound {
 			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
 		}
 		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
 	}
-
-	// Authorize
-	if !CanAccess(principal, m, key.ACL, knox.Write, keyID, "CreateVersion", "pvc", "kms") {
-		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to write %s", principal.GetID(), keyID))
+	if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, action, parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to decide access requests for %s", principal.GetID(), keyID))
 	}
 
-	// Create and add the new version
-	version := newKeyVersion(decodedData, knox.Active)
+	r, err := m.GetAccessRequest(keyID, requestID)
+	if err == knox.ErrAccessRequestNotFound {
+		return nil, errF(knox.AccessRequestDoesNotExistCode, fmt.Sprintf("No pending access request %d for %s", requestID, keyID))
+	}
+	if err != nil {
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
 
-	err := m.AddVersion(keyID, &version)
+	if decision == "approve" {
+		access := r.Access
+		if r.Duration > 0 {
+			access.ExpiresAt = time.Now().UnixNano() + r.Duration
+		}
+		if err := validateAccessUpdate(knox.ACL{access}); err != nil {
+			return nil, err
+		}
+		if _, err := lintAccessUpdate(m, key, knox.ACL{access}); err != nil {
+			return nil, err
+		}
+		// On keys that require quorum the request turns into a pending
+		// operation.
+		if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: keyID, Operation: knox.OperationUpdateAccess, ACL: knox.ACL{access}}); err != nil {
+			if err.Subcode == knox.OperationPendingCode {
+				m.RemoveAccessRequest(keyID, requestID)
+			}
+			return nil, err
+		}
+		if err := runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreUpdateAccess(principal, keyID, knox.ACL{access}) }); err != nil {
+			return nil, err
+		}
+		if err := m.UpdateAccess(keyID, access); err != nil {
+			if err == keydb.ErrDBVersion {
+				return nil, errF(knox.KeyConflictCode, err.Error())
+			}
+			return nil, errF(knox.InternalServerErrorCode, err.Error())
+		}
+		runPostHooks(m, func(h LifecycleHook) { h.PostUpdateAccess(principal, keyID, knox.ACL{access}) })
+		r.Access = access
+	}
+	// A concurrent decision may have removed the request already. Approving
+	// it twice adds the same entry, so that is not an error.
+	err = m.RemoveAccessRequest(keyID, requestID)
+	if err == knox.ErrAccessRequestNotFound && decision == "deny" {
+		return nil, errF(knox.AccessRequestDoesNotExistCode, fmt.Sprintf("No pending access request %d for %s", requestID, keyID))
+	}
+	if err != nil && err != knox.ErrAccessRequestNotFound {
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	audit(map[string]interface{}{
+		"type":       event,
+		"principal":  principal.GetID(),
+		"key_id":     keyID,
+		"request_id": r.ID,
+		"access":     r.Access,
+	})
+	return r, nil
+}
 
+// activeBreakGlassGrant returns the break-glass grant that currently lets the
+// principal read the key, or nil if there is none.
+func activeBreakGlassGrant(m KeyManager, principal knox.Principal, keyID string) (*knox.BreakGlassGrant, *HTTPError) {
+	if principal.Type() != "user" {
+		return nil, nil
+	}
+	grants, err := m.GetBreakGlassGrants(keyID)
+	if err == knox.ErrKeyIDNotFound {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, errF(knox.InternalServerErrorCode, err.Error())
 	}
-	return version.ID, nil
+	now := time.Now().UnixNano()
+	for _, g := range grants {
+		if g.Principal == principal.GetID() && g.ExpiresAt > now {
+			return &g, nil
+		}
+	}
+	return nil, nil
 }
 
-// putVersionsHandler rotates key versions by changing the version status.
-// It takes the new status as input. Accepted inputs include:
-// If the key version is Inactive, it can become Active.
-// If the key version is Active, it can become Inactive or Primary. Note that,
-//   this will change the current Primary key to Active.
-// If the key version is Primary, the version status cannot be changed. Instead
-//   promote another key version to Primary to replace it.
-// The route for this handler is PUT /v0/keys/<key_id>/versions/<version_id>/
-// The principal needs Write access.
-func putVersionsHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
-
+// breakGlassHandler gives the principal Read access to a key for the
+// break-glass window, without changing the key's ACL, so it can get the key
+// during an incident. It requires a justification. The grant is written to the
+// break-glass log and the break-glass hooks are notified.
+// The route for this handler is POST /v0/keys/<key_id>/break-glass/
+// The principal needs to be a user in a break-glass group.
+func breakGlassHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
 	keyID := parameters["keyID"]
-	versionID := parameters["versionID"]
 
-	statusStr, statusOK := parameters["status"]
-	if !statusOK {
-		return nil, errF(knox.BadRequestDataCode, "Missing parameter 'status'")
+	allowed := false
+	if principal.Type() == "user" {
+		for _, g := range auth.Groups(principal) {
+			for _, b := range breakGlassGroups {
+				allowed = allowed || g == b
+			}
+		}
 	}
-	status := knox.Active
-	statusErr := status.UnmarshalJSON([]byte(statusStr))
-	if statusErr != nil {
-		return nil, errF(knox.BadRequestDataCode, statusErr.Error())
+	if !allowed {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to break glass", principal.GetID()))
 	}
-	id, intErr := strconv.ParseUint(versionID, 10, 64)
-	if intErr != nil {
-		return nil, errF(knox.BadRequestDataCode, intErr.Error())
+	justification := strings.TrimSpace(parameters["justification"])
+	if justification == "" {
+		return nil, errF(knox.BadRequestDataCode, "Breaking glass needs a justification")
 	}
 
-	// Get the key
-	key, getErr := m.GetKey(keyID, knox.Inactive)
+	now := time.Now().UnixNano()
+	grant := knox.BreakGlassGrant{
+		KeyID:         keyID,
+		Principal:     principal.GetID(),
+		Justification: justification,
+		GrantedAt:     now,
+		ExpiresAt:     now + int64(breakGlassWindow),
+	}
+	switch err := m.AddBreakGlassGrant(&grant); err {
+	case nil:
+	case knox.ErrKeyIDNotFound:
+		return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	breakGlassAudit(map[string]interface{}{
+		"type":          "break_glass",
+		"principal":     grant.Principal,
+		"key_id":        keyID,
+		"justification": grant.Justification,
+		"expires_at":    grant.ExpiresAt,
+	})
+	for _, hook := range breakGlassHooks {
+		go hook(grant)
+	}
+	return grant, nil
+}
+
+// holdForQuorum files op as a pending operation if its key requires quorum and
+// returns the error that tells the caller so. It returns nil if the operation
+// can run right away.
+func holdForQuorum(m KeyManager, principal knox.Principal, op knox.PendingOperation) *HTTPError {
+	required, err := m.GetQuorum(op.KeyID)
+	if err == knox.ErrKeyIDNotFound {
+		return errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", op.KeyID))
+	}
+	if err != nil {
+		return errF(knox.InternalServerErrorCode, err.Error())
+	}
+	if !required {
+		return nil
+	}
+	op = newPendingOperation(op, principal)
+	if err := m.AddPendingOperation(&op); err != nil {
+		if err == keydb.ErrDBVersion {
+			return errF(knox.KeyConflictCode, err.Error())
+		}
+		return errF(knox.InternalServerErrorCode, err.Error())
+	}
+	audit(map[string]interface{}{
+		"type":         "operation_pending",
+		"principal":    principal.GetID(),
+		"key_id":       op.KeyID,
+		"operation_id": op.ID,
+		"operation":    op.Operation,
+	})
+	return errF(knox.OperationPendingCode, fmt.Sprintf("Key %s requires quorum: %s is pending operation %d, which another admin needs to confirm", op.KeyID, op.Operation, op.ID))
+}
+
+// firstQuorumKey returns the first of keyIDs, in sorted order, that is a key
+// requiring quorum, or an empty string if there is none.
+func firstQuorumKey(m KeyManager, keyIDs []string) (string, *HTTPError) {
+	sorted := append([]string{}, keyIDs...)
+	sort.Strings(sorted)
+	for _, keyID := range sorted {
+		required, err := m.GetQuorum(keyID)
+		if err == knox.ErrKeyIDNotFound {
+			continue
+		}
+		if err != nil {
+			return "", errF(knox.InternalServerErrorCode, err.Error())
+		}
+		if required {
+			return keyID, nil
+		}
+	}
+	return "", nil
+}
+
+// namespaceKeyIDs returns the IDs of the keys inside the namespace at path and
+// inside the namespaces in it.
+func namespaceKeyIDs(m KeyManager, path string) ([]string, error) {
+	n, err := m.GetNamespace(path)
+	if err != nil {
+		return nil, err
+	}
+	keyIDs := n.Keys
+	for _, p := range n.Namespaces {
+		nested, err := namespaceKeyIDs(m, p)
+		if err != nil && err != knox.ErrNamespaceNotFound {
+			return nil, err
+		}
+		keyIDs = append(keyIDs, nested...)
+	}
+	return keyIDs, nil
+}
+
+// getQuorumHandler returns whether a key requires quorum.
+// The route for this handler is GET /v0/keys/<key_id>/quorum/
+// The principal needs Read access.
+func getQuorumHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	keyID := parameters["keyID"]
+
+	key, getErr := m.GetKey(keyID, knox.Primary)
 	if getErr != nil {
 		if getErr == knox.ErrKeyIDNotFound {
 			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
@@ -457,21 +1308,1455 @@ func putVersionsHandler(m KeyManager, principal knox.Principal, parameters map[s
 		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
 	}
 
-	// Authorize
-	if !CanAccess(principal, m, key.ACL, knox.Write, keyID, "PutVersion", "pvc", "kms") {
-		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to write %s", principal.GetID(), keyID))
+	if !CanAccess(principal, m, key.ACL, knox.Read, key.Path, keyID, "GetQuorum", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to read %s", principal.GetID(), keyID))
+	}
+
+	required, err := m.GetQuorum(keyID)
+	if err != nil {
+		if err == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
 	}
+	return required, nil
+}
+
+// putQuorumHandler sets whether a key requires quorum, as the required form
+// data says. Once a key requires quorum, deleting it, changing its ACL and
+// promoting its versions wait as pending operations until a second admin
+// confirms them. Turning quorum off for a key that requires it is itself held
+// for confirmation.
+// The route for this handler is PUT /v0/keys/<key_id>/quorum/
+// The principal needs Admin access.
+func putQuorumHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	keyID := parameters["keyID"]
 
-	err := m.UpdateVersion(keyID, id, status)
+	required, parseErr := strconv.ParseBool(parameters["required"])
+	if parseErr != nil {
+		return nil, errF(knox.BadRequestDataCode, fmt.Sprintf("Invalid required value %s", parameters["required"]))
+	}
 
-	switch err {
+	key, getErr := m.GetKey(keyID, knox.Primary)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+
+	if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, "PutQuorum", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to update quorum for %s", principal.GetID(), keyID))
+	}
+	if !required {
+		if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: keyID, Operation: knox.OperationDisableQuorum}); err != nil {
+			return nil, err
+		}
+	}
+
+	switch err := m.SetQuorum(keyID, required); err {
 	case nil:
 		return nil, nil
-	case knox.ErrKeyVersionNotFound:
-		return nil, errF(knox.KeyVersionDoesNotExistCode, err.Error())
-	case knox.ErrPrimaryToInactive, knox.ErrPrimaryToActive, knox.ErrInactiveToPrimary:
-		return nil, errF(knox.BadRequestDataCode, err.Error())
+	case knox.ErrKeyIDNotFound:
+		return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
 	default:
 		return nil, errF(knox.InternalServerErrorCode, err.Error())
 	}
 }
+
+// getPendingOperationsHandler lists the pending operations on keys the
+// principal has Admin access to, and the ones it asked for. key_id limits the
+// list to the operations on one key.
+// The route for this handler is GET /v0/operations/
+func getPendingOperationsHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	ops, err := m.GetPendingOperations()
+	if err != nil {
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	keyID := parameters["key_id"]
+	isAdmin := map[string]bool{}
+	visible := []knox.PendingOperation{}
+	for _, op := range ops {
+		if keyID != "" && op.KeyID != keyID {
+			continue
+		}
+		admin, ok := isAdmin[op.KeyID]
+		if !ok {
+			key, err := getKeyOrDeleted(m, op.KeyID)
+			if err != nil && err != knox.ErrKeyIDNotFound {
+				return nil, errF(knox.InternalServerErrorCode, err.Error())
+			}
+			admin = err == nil && CanAccess(principal, m, key.ACL, knox.Admin, key.Path, op.KeyID, "ListPendingOperations", parameters)
+			isAdmin[op.KeyID] = admin
+		}
+		if admin || op.RequestedBy == principal.GetID() {
+			visible = append(visible, op)
+		}
+	}
+	return visible, nil
+}
+
+// getKeyOrDeleted returns the key with keyID, or the key in the trash with it,
+// which can still have pending operations such as purging it.
+func getKeyOrDeleted(m KeyManager, keyID string) (*knox.Key, error) {
+	key, err := m.GetKey(keyID, knox.Primary)
+	if err == knox.ErrKeyIDNotFound {
+		return m.GetDeletedKey(keyID)
+	}
+	return key, err
+}
+
+// getPendingOperation authorizes a decision on the pending operation given by
+// the keyID and operationID parameters and returns it with its key.
+func getPendingOperation(m KeyManager, principal knox.Principal, parameters map[string]string, action string) (*knox.Key, *knox.PendingOperation, *HTTPError) {
+	keyID := parameters["keyID"]
+	operationID, err := strconv.ParseUint(parameters["operationID"], 10, 64)
+	if err != nil {
+		return nil, nil, errF(knox.BadRequestDataCode, fmt.Sprintf("Invalid operation ID %s", parameters["operationID"]))
+	}
+
+	key, getErr := getKeyOrDeleted(m, keyID)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+	if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, action, parameters) {
+		return nil, nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to decide pending operations for %s", principal.GetID(), keyID))
+	}
+
+	op, err := m.GetPendingOperation(keyID, operationID)
+	if err == knox.ErrPendingOperationNotFound {
+		return nil, nil, errF(knox.OperationDoesNotExistCode, fmt.Sprintf("No pending operation %d for %s", operationID, keyID))
+	}
+	if err != nil {
+		return nil, nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	return key, op, nil
+}
+
+// confirmOperationHandler runs a pending operation. Lifecycle pre hooks run
+// now, when the operation runs, rather than when it was filed.
+// The route for this handler is POST /v0/keys/<key_id>/operations/<operation_id>/
+// The principal needs Admin access and must not be the one that asked for the
+// operation. Operations on more than the key also need the access that filing
+// them needs: Admin access to the alias or namespace, or being a global admin
+// for ACL migrations and stripping access.
+func confirmOperationHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	key, op, httpErr := getPendingOperation(m, principal, parameters, "ConfirmOperation")
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if op.RequestedBy == principal.GetID() {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Pending operation %d must be confirmed by a principal other than %s", op.ID, principal.GetID()))
+	}
+	switch op.Operation {
+	case knox.OperationUpdateAccess:
+		if err := validateAccessUpdate(op.ACL); err != nil {
+			return nil, err
+		}
+		if _, err := lintAccessUpdate(m, key, op.ACL); err != nil {
+			return nil, err
+		}
+	case knox.OperationDeleteAlias:
+		alias, err := m.GetAlias(op.AliasID)
+		if err == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such alias %s", op.AliasID))
+		}
+		if err != nil {
+			return nil, errF(knox.InternalServerErrorCode, err.Error())
+		}
+		if !CanAccess(principal, m, alias.ACL, knox.Admin, alias.Path, op.AliasID, "DeleteAlias", parameters) {
+			return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to delete alias %s", principal.GetID(), op.AliasID))
+		}
+	case knox.OperationUpdateNamespaceAccess:
+		if !CanAccess(principal, m, nil, knox.Admin, op.Path, "", "PutNamespaceACL", parameters) {
+			return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to update access for namespace %s", principal.GetID(), op.Path))
+		}
+		if err := validateAccessUpdate(op.ACL); err != nil {
+			return nil, err
+		}
+	case knox.OperationMigrateACL:
+		if !isGlobalAdmin(principal, m, "MigrateACL", parameters) {
+			return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to migrate ACLs", principal.GetID()))
+		}
+	case knox.OperationStripAccess:
+		if !isGlobalAdmin(principal, m, "StripAccess", parameters) {
+			return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to strip access", principal.GetID()))
+		}
+	}
+	var hookErr *HTTPError
+	switch op.Operation {
+	case knox.OperationDelete, knox.OperationPurge:
+		hookErr = runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreDelete(principal, op.KeyID, op.Operation == knox.OperationPurge) })
+	case knox.OperationUpdateAccess:
+		hookErr = runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreUpdateAccess(principal, op.KeyID, op.ACL) })
+	case knox.OperationPromote:
+		hookErr = runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreUpdateVersion(principal, op.KeyID, op.VersionID, knox.Primary) })
+	case knox.OperationRename:
+		hookErr = runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreRename(principal, op.KeyID, op.NewID) })
+	}
+	if hookErr != nil {
+		return nil, hookErr
+	}
+
+	// Removing the operation first makes sure it runs only once.
+	err := m.RemovePendingOperation(op.KeyID, op.ID)
+	if err == knox.ErrPendingOperationNotFound {
+		return nil, errF(knox.OperationDoesNotExistCode, fmt.Sprintf("No pending operation %d for %s", op.ID, op.KeyID))
+	}
+	if err == nil {
+		switch op.Operation {
+		case knox.OperationDelete:
+			err = m.DeleteKey(op.KeyID)
+		case knox.OperationPurge:
+			err = m.PurgeKey(op.KeyID)
+		case knox.OperationUpdateAccess:
+			err = m.UpdateAccess(op.KeyID, op.ACL...)
+		case knox.OperationPromote:
+			err = m.UpdateVersion(op.KeyID, op.VersionID, knox.Primary)
+		case knox.OperationDisableQuorum:
+			err = m.SetQuorum(op.KeyID, false)
+		case knox.OperationPurgeVersion:
+			err = m.PurgeVersion(op.KeyID, op.VersionID)
+		case knox.OperationRename:
+			err = m.RenameKey(op.KeyID, op.NewID)
+		case knox.OperationMove:
+			err = m.MoveKey(op.KeyID, op.Path)
+		case knox.OperationDeleteAlias:
+			err = m.RemoveAlias(op.AliasID)
+		case knox.OperationUpdateNamespaceAccess:
+			if err = m.UpdateNamespaceAccess(op.Path, op.ACL...); err != nil {
+				return nil, namespaceErrF(op.Path, err)
+			}
+		case knox.OperationMigrateACL:
+			var changes []knox.ACLMigration
+			changes, _, err = m.MigratePrincipal(op.Migration.From, op.Migration.To, op.Migration.After, op.Migration.Batch, false, true)
+			auditMigration(principal, changes)
+		case knox.OperationSetRetention:
+			err = m.SetVersionRetention(op.KeyID, op.Retention)
+		case knox.OperationStripAccess:
+			var changes []knox.ACLMigration
+			changes, err = m.StripAccess(op.ACL, true)
+			auditStrip(principal, changes)
+		default:
+			err = fmt.Errorf("unknown operation %s", op.Operation)
+		}
+	}
+	switch err {
+	case nil:
+	case knox.ErrKeyIDNotFound:
+		if op.Operation == knox.OperationDeleteAlias {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such alias %s", op.AliasID))
+		}
+		return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", op.KeyID))
+	case knox.ErrKeyVersionNotFound:
+		return nil, errF(knox.KeyVersionDoesNotExistCode, err.Error())
+	case knox.ErrInactiveToPrimary, knox.ErrPurgeNotInactive, knox.ErrInvalidNamespacePath:
+		return nil, errF(knox.BadRequestDataCode, err.Error())
+	case knox.ErrKeyExists:
+		return nil, errF(knox.KeyIdentifierExistsCode, fmt.Sprintf("Key %s already exists", op.NewID))
+	case knox.ErrKeyInTrash:
+		return nil, errF(knox.KeyInTrashCode, fmt.Sprintf("Key %s is in the trash, restore or purge it", op.NewID))
+	case knox.ErrInvalidKeyID:
+		return nil, errF(knox.BadKeyFormatCode, fmt.Sprintf("KeyID includes unsupported characters %s", op.NewID))
+	case knox.ErrNamespaceNotFound:
+		return nil, errF(knox.NamespaceDoesNotExistCode, fmt.Sprintf("No such namespace %s", op.Path))
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	switch op.Operation {
+	case knox.OperationDelete, knox.OperationPurge:
+		runPostHooks(m, func(h LifecycleHook) { h.PostDelete(principal, op.KeyID, op.Operation == knox.OperationPurge) })
+	case knox.OperationUpdateAccess:
+		runPostHooks(m, func(h LifecycleHook) { h.PostUpdateAccess(principal, op.KeyID, op.ACL) })
+	case knox.OperationPromote:
+		runPostHooks(m, func(h LifecycleHook) { h.PostUpdateVersion(principal, op.KeyID, op.VersionID, knox.Primary) })
+	case knox.OperationRename:
+		runPostHooks(m, func(h LifecycleHook) { h.PostRename(principal, op.KeyID, op.NewID) })
+	}
+	audit(map[string]interface{}{
+		"type":         "operation_confirmed",
+		"principal":    principal.GetID(),
+		"key_id":       op.KeyID,
+		"operation_id": op.ID,
+		"operation":    op.Operation,
+		"requested_by": op.RequestedBy,
+	})
+	return op, nil
+}
+
+// cancelOperationHandler drops a pending operation without running it.
+// The route for this handler is DELETE /v0/keys/<key_id>/operations/<operation_id>/
+// The principal needs Admin access.
+func cancelOperationHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	_, op, httpErr := getPendingOperation(m, principal, parameters, "CancelOperation")
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	switch err := m.RemovePendingOperation(op.KeyID, op.ID); err {
+	case nil:
+	case knox.ErrPendingOperationNotFound:
+		return nil, errF(knox.OperationDoesNotExistCode, fmt.Sprintf("No pending operation %d for %s", op.ID, op.KeyID))
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	audit(map[string]interface{}{
+		"type":         "operation_cancelled",
+		"principal":    principal.GetID(),
+		"key_id":       op.KeyID,
+		"operation_id": op.ID,
+		"operation":    op.Operation,
+	})
+	return nil, nil
+}
+
+// postVersionHandler creates a new key version. This version is immediately
+// added as an Active key.
+// The route for this handler is PUT /v0/keys/<key_id>/versions/
+// The principal needs Write access.
+// If-Match makes the update conditional on the key's ETag.
+func postVersionHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+
+	keyID := parameters["keyID"]
+	dataStr, dataOK := parameters["data"]
+	if !dataOK {
+		return nil, errF(knox.BadRequestDataCode, "Missing parameter 'data'")
+	}
+	decodedData, decodeErr := base64.StdEncoding.DecodeString(dataStr)
+	if decodeErr != nil {
+		return nil, errF(knox.BadRequestDataCode, decodeErr.Error())
+	}
+
+	// Get the key
+	key, getErr := m.GetKey(keyID, knox.Inactive)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+
+	// Authorize
+	if !CanAccess(principal, m, key.ACL, knox.Write, key.Path, keyID, "CreateVersion", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to write %s", principal.GetID(), keyID))
+	}
+	if err := checkPrecondition(key, parameters); err != nil {
+		return nil, err
+	}
+
+	// Create and add the new version
+	version := newKeyVersion(decodedData, knox.Active)
+	if err := runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreAddVersion(principal, keyID, &version) }); err != nil {
+		return nil, err
+	}
+
+	err := conditional(m, parameters).AddVersion(keyID, &version)
+
+	if err != nil {
+		if err == keydb.ErrDBVersion {
+			return nil, errF(knox.KeyConflictCode, err.Error())
+		}
+		if err == knox.ErrPreconditionFailed {
+			return nil, errF(knox.PreconditionFailedCode, fmt.Sprintf("Key %s does not match %s", keyID, parameters["If-Match"]))
+		}
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	runPostHooks(m, func(h LifecycleHook) { h.PostAddVersion(principal, keyID, &version) })
+	return version.ID, nil
+}
+
+// putVersionsHandler rotates key versions by changing the version status.
+// It takes the new status as input. Accepted inputs include:
+// If the key version is Inactive, it can become Active.
+// If the key version is Active, it can become Inactive or Primary. Note that,
+//   this will change the current Primary key to Active.
+// If the key version is Primary, the version status cannot be changed. Instead
+//   promote another key version to Primary to replace it.
+// The route for this handler is PUT /v0/keys/<key_id>/versions/<version_id>/
+// The principal needs Write access.
+// Promoting a version of a key that requires quorum files a pending operation
+// instead.
+// If-Match makes the update conditional on the key's ETag.
+func putVersionsHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+
+	keyID := parameters["keyID"]
+	versionID := parameters["versionID"]
+
+	statusStr, statusOK := parameters["status"]
+	if !statusOK {
+		return nil, errF(knox.BadRequestDataCode, "Missing parameter 'status'")
+	}
+	status := knox.Active
+	statusErr := status.UnmarshalJSON([]byte(statusStr))
+	if statusErr != nil {
+		return nil, errF(knox.BadRequestDataCode, statusErr.Error())
+	}
+	id, intErr := strconv.ParseUint(versionID, 10, 64)
+	if intErr != nil {
+		return nil, errF(knox.BadRequestDataCode, intErr.Error())
+	}
+
+	// Get the key
+	key, getErr := m.GetKey(keyID, knox.Inactive)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+
+	// Authorize
+	if !CanAccess(principal, m, key.ACL, knox.Write, key.Path, keyID, "PutVersion", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to write %s", principal.GetID(), keyID))
+	}
+	if err := checkPrecondition(key, parameters); err != nil {
+		return nil, err
+	}
+	if status == knox.Primary {
+		if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: keyID, Operation: knox.OperationPromote, VersionID: id}); err != nil {
+			return nil, err
+		}
+	}
+	if err := runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreUpdateVersion(principal, keyID, id, status) }); err != nil {
+		return nil, err
+	}
+
+	err := conditional(m, parameters).UpdateVersion(keyID, id, status)
+
+	switch err {
+	case nil:
+		runPostHooks(m, func(h LifecycleHook) { h.PostUpdateVersion(principal, keyID, id, status) })
+		return nil, nil
+	case knox.ErrKeyVersionNotFound:
+		return nil, errF(knox.KeyVersionDoesNotExistCode, err.Error())
+	case knox.ErrPreconditionFailed:
+		return nil, errF(knox.PreconditionFailedCode, fmt.Sprintf("Key %s does not match %s", keyID, parameters["If-Match"]))
+	case knox.ErrPrimaryToInactive, knox.ErrPrimaryToActive, knox.ErrInactiveToPrimary:
+		return nil, errF(knox.BadRequestDataCode, err.Error())
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+}
+
+// deleteVersionHandler permanently removes an Inactive key version, including
+// its encrypted data.
+// The route for this handler is DELETE /v0/keys/<key_id>/versions/<version_id>/
+// The principal needs Admin access.
+// If-Match makes the removal conditional on the key's ETag.
+// Purging a version of a key that requires quorum files a pending operation
+// instead.
+func deleteVersionHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	keyID := parameters["keyID"]
+	versionID := parameters["versionID"]
+
+	id, intErr := strconv.ParseUint(versionID, 10, 64)
+	if intErr != nil {
+		return nil, errF(knox.BadRequestDataCode, intErr.Error())
+	}
+
+	// Get the key
+	key, getErr := m.GetKey(keyID, knox.Inactive)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+
+	// Authorize
+	if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, "PurgeVersion", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to purge versions of %s", principal.GetID(), keyID))
+	}
+	if err := checkPrecondition(key, parameters); err != nil {
+		return nil, err
+	}
+	if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: keyID, Operation: knox.OperationPurgeVersion, VersionID: id}); err != nil {
+		return nil, err
+	}
+
+	switch err := conditional(m, parameters).PurgeVersion(keyID, id); err {
+	case nil:
+		return nil, nil
+	case knox.ErrKeyVersionNotFound:
+		return nil, errF(knox.KeyVersionDoesNotExistCode, err.Error())
+	case knox.ErrPreconditionFailed:
+		return nil, errF(knox.PreconditionFailedCode, fmt.Sprintf("Key %s does not match %s", keyID, parameters["If-Match"]))
+	case knox.ErrPurgeNotInactive:
+		return nil, errF(knox.BadRequestDataCode, err.Error())
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+}
+
+// getRetentionHandler gets the policy for purging Inactive versions of a key.
+// It returns null if the key keeps Inactive versions forever.
+// The route for this handler is GET /v0/keys/<key_id>/retention/
+// The principal needs Read access.
+func getRetentionHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	keyID := parameters["keyID"]
+
+	key, getErr := m.GetKey(keyID, knox.Primary)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+
+	if !CanAccess(principal, m, key.ACL, knox.Read, key.Path, keyID, "GetRetention", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to read %s", principal.GetID(), keyID))
+	}
+
+	r, err := m.GetVersionRetention(keyID)
+	if err != nil {
+		if err == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	return r, nil
+}
+
+// putRetentionHandler sets the policy for purging Inactive versions of a key.
+// It reads the JSON encoded policy from the retention form data. A policy
+// without limits removes the key's policy.
+// The route for this handler is PUT /v0/keys/<key_id>/retention/
+// The principal needs Admin access.
+// Changing the policy of a key that requires quorum files a pending operation
+// instead.
+func putRetentionHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	keyID := parameters["keyID"]
+
+	retentionStr, retentionOK := parameters["retention"]
+	if !retentionOK {
+		return nil, errF(knox.BadRequestDataCode, "Missing parameter 'retention'")
+	}
+	var r *knox.VersionRetention
+	if jsonErr := json.Unmarshal([]byte(retentionStr), &r); jsonErr != nil {
+		return nil, errF(knox.BadRequestDataCode, jsonErr.Error())
+	}
+	if r != nil && *r == (knox.VersionRetention{}) {
+		r = nil
+	}
+
+	key, getErr := m.GetKey(keyID, knox.Primary)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+
+	if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, "PutRetention", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to update retention for %s", principal.GetID(), keyID))
+	}
+	if r != nil {
+		if err := r.Validate(); err != nil {
+			return nil, errF(knox.BadRequestDataCode, err.Error())
+		}
+	}
+	if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: keyID, Operation: knox.OperationSetRetention, Retention: r}); err != nil {
+		return nil, err
+	}
+
+	switch err := m.SetVersionRetention(keyID, r); err {
+	case nil:
+		return nil, nil
+	case knox.ErrInvalidRetention:
+		return nil, errF(knox.BadRequestDataCode, err.Error())
+	case knox.ErrKeyIDNotFound:
+		return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+}
+
+// renameKeyHandler moves the key matching the keyID in the request to a new
+// ID and leaves an alias with the key's ACL at the old ID, so that consumers
+// still using the old ID keep working.
+// The route for this handler is POST /v0/keys/<key_id>/rename/
+// The principal needs Admin access to the key.
+// If-Match makes the rename conditional on the key's ETag.
+// Renaming a key that requires quorum files a pending operation instead.
+func renameKeyHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	keyID := parameters["keyID"]
+	newID, newIDOK := parameters["id"]
+	if !newIDOK {
+		return nil, errF(knox.NoKeyIDCode, "Missing parameter 'id'")
+	}
+
+	key, getErr := m.GetKey(keyID, knox.Primary)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+
+	// Authorize
+	if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, "RenameKey", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to rename %s", principal.GetID(), keyID))
+	}
+	if err := checkPrecondition(key, parameters); err != nil {
+		return nil, err
+	}
+	if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: keyID, Operation: knox.OperationRename, NewID: newID}); err != nil {
+		return nil, err
+	}
+	if err := runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreRename(principal, keyID, newID) }); err != nil {
+		return nil, err
+	}
+
+	switch err := conditional(m, parameters).RenameKey(keyID, newID); err {
+	case nil:
+		runPostHooks(m, func(h LifecycleHook) { h.PostRename(principal, keyID, newID) })
+		return nil, nil
+	case knox.ErrPreconditionFailed:
+		return nil, errF(knox.PreconditionFailedCode, fmt.Sprintf("Key %s does not match %s", keyID, parameters["If-Match"]))
+	case knox.ErrKeyExists:
+		return nil, errF(knox.KeyIdentifierExistsCode, fmt.Sprintf("Key %s already exists", newID))
+	case knox.ErrKeyInTrash:
+		return nil, errF(knox.KeyInTrashCode, fmt.Sprintf("Key %s is in the trash, restore or purge it", newID))
+	case knox.ErrInvalidKeyID:
+		return nil, errF(knox.BadKeyFormatCode, fmt.Sprintf("KeyID includes unsupported characters %s", newID))
+	case knox.ErrKeyIDNotFound:
+		return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+}
+
+// getAliasHandler gets the alias matching the aliasID in the request.
+// The route for this handler is GET /v0/aliases/<alias_id>/
+// The principal needs Read access to the alias.
+func getAliasHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	aliasID := parameters["aliasID"]
+
+	alias, err := m.GetAlias(aliasID)
+	if err != nil {
+		if err == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such alias %s", aliasID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+
+	if !CanAccess(principal, m, alias.ACL, knox.Read, alias.Path, aliasID, "GetAlias", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to read alias %s", principal.GetID(), aliasID))
+	}
+	return alias, nil
+}
+
+// putAliasHandler creates an alias for a key. The alias gets the JSON encoded
+// ACL from the acl form data, or a copy of the key's ACL if none is given. As
+// with new keys, the creator is added as an Admin of the alias.
+// The route for this handler is PUT /v0/aliases/<alias_id>/
+// The principal needs Admin access to the key.
+func putAliasHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	aliasID := parameters["aliasID"]
+	keyID, keyIDOK := parameters["key"]
+	if !keyIDOK {
+		return nil, errF(knox.NoKeyIDCode, "Missing parameter 'key'")
+	}
+
+	key, getErr := m.GetKey(keyID, knox.Primary)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+
+	// Authorize
+	if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, "PutAlias", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to alias %s", principal.GetID(), keyID))
+	}
+
+	alias := &knox.KeyAlias{ID: aliasID, KeyID: keyID, ACL: key.ACL, Path: key.Path}
+	if aclStr, aclOK := parameters["acl"]; aclOK {
+		alias.ACL = knox.ACL{}
+		if jsonErr := json.Unmarshal([]byte(aclStr), &alias.ACL); jsonErr != nil {
+			return nil, errF(knox.BadRequestDataCode, jsonErr.Error())
+		}
+	}
+	alias.ACL = alias.ACL.Add(knox.Access{ID: principal.GetID(), AccessType: knox.Admin, Type: knox.User})
+	if err := alias.Validate(); err != nil {
+		if err == knox.ErrInvalidKeyID {
+			return nil, errF(knox.BadKeyFormatCode, fmt.Sprintf("KeyID includes unsupported characters %s", aliasID))
+		}
+		return nil, errF(knox.BadRequestDataCode, err.Error())
+	}
+	if err := runPreHooks(m, func(h LifecycleHook) *HTTPError { return h.PreAddAlias(principal, alias) }); err != nil {
+		return nil, err
+	}
+
+	switch err := m.AddAlias(alias); err {
+	case nil:
+		runPostHooks(m, func(h LifecycleHook) { h.PostAddAlias(principal, alias) })
+		return nil, nil
+	case knox.ErrKeyExists:
+		return nil, errF(knox.KeyIdentifierExistsCode, fmt.Sprintf("Key %s already exists", aliasID))
+	case knox.ErrKeyInTrash:
+		return nil, errF(knox.KeyInTrashCode, fmt.Sprintf("Key %s is in the trash, restore or purge it", aliasID))
+	case knox.ErrKeyIDNotFound:
+		return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+}
+
+// deleteAliasHandler removes the alias matching the aliasID in the request.
+// The key it resolves to is left untouched.
+// The route for this handler is DELETE /v0/aliases/<alias_id>/
+// The principal needs Admin access to the alias.
+// Removing an alias of a key that requires quorum files a pending operation on
+// the key instead.
+func deleteAliasHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	aliasID := parameters["aliasID"]
+
+	alias, err := m.GetAlias(aliasID)
+	if err != nil {
+		if err == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such alias %s", aliasID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+
+	if !CanAccess(principal, m, alias.ACL, knox.Admin, alias.Path, aliasID, "DeleteAlias", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to delete alias %s", principal.GetID(), aliasID))
+	}
+	// Aliases of keys that are gone or in the trash are removed right away.
+	if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: alias.KeyID, Operation: knox.OperationDeleteAlias, AliasID: aliasID}); err != nil && err.Subcode != knox.KeyIdentifierDoesNotExistCode {
+		return nil, err
+	}
+
+	switch err := m.RemoveAlias(aliasID); err {
+	case nil:
+		return nil, nil
+	case knox.ErrKeyIDNotFound:
+		return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such alias %s", aliasID))
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+}
+
+// moveKeyHandler moves the key matching the keyID in the request into the
+// namespace given by the path form data, or out of any namespace if path is
+// empty. The key then inherits the ACL of its new namespace instead of the
+// old one's.
+// The route for this handler is POST /v0/keys/<key_id>/move/
+// The principal needs Admin access to the key and Write access to the
+// namespace it is moved into.
+// Moving a key that requires quorum files a pending operation instead.
+func moveKeyHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	keyID := parameters["keyID"]
+	path := parameters["path"]
+
+	key, getErr := m.GetKey(keyID, knox.Primary)
+	if getErr != nil {
+		if getErr == knox.ErrKeyIDNotFound {
+			return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+		}
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+
+	// Authorize
+	if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, "MoveKey", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to move %s", principal.GetID(), keyID))
+	}
+	if path != "" && !CanAccess(principal, m, nil, knox.Write, path, keyID, "MoveKey", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to move keys into %s", principal.GetID(), path))
+	}
+	if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: keyID, Operation: knox.OperationMove, Path: path}); err != nil {
+		return nil, err
+	}
+
+	switch err := m.MoveKey(keyID, path); err {
+	case nil:
+		return nil, nil
+	case knox.ErrKeyIDNotFound:
+		return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+	case knox.ErrNamespaceNotFound:
+		return nil, errF(knox.NamespaceDoesNotExistCode, fmt.Sprintf("No such namespace %s", path))
+	case knox.ErrInvalidNamespacePath:
+		return nil, errF(knox.BadRequestDataCode, err.Error())
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+}
+
+// namespaceErrF builds the HTTPError for an error returned by a KeyManager
+// namespace operation on path.
+func namespaceErrF(path string, err error) *HTTPError {
+	switch err {
+	case knox.ErrNamespaceNotFound:
+		return errF(knox.NamespaceDoesNotExistCode, fmt.Sprintf("No such namespace %s", path))
+	case knox.ErrNamespaceExists:
+		return errF(knox.NamespaceExistsCode, fmt.Sprintf("Namespace %s already exists", path))
+	case knox.ErrNamespaceNotEmpty:
+		return errF(knox.NamespaceNotEmptyCode, fmt.Sprintf("Namespace %s is not empty", path))
+	case knox.ErrInvalidNamespacePath, knox.ErrACLDuplicateEntries, knox.ErrACLContainsNone:
+		return errF(knox.BadRequestDataCode, err.Error())
+	case keydb.ErrDBVersion:
+		return errF(knox.KeyConflictCode, err.Error())
+	default:
+		return errF(knox.InternalServerErrorCode, err.Error())
+	}
+}
+
+// getNamespacesHandler lists the paths of the namespaces the principal can
+// read.
+// The route for this handler is GET /v0/namespaces/
+// The principal needs Read access to each namespace listed.
+func getNamespacesHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	if m.GetAuthorizationType() == OpaAuthorization && !canAccessOPA(principal, nil, "", "", "ListNamespaces", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to list namespaces", principal.GetID()))
+	}
+	paths, err := m.GetNamespaces()
+	if err != nil {
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	readable := []string{}
+	for _, path := range paths {
+		if CanAccess(principal, m, nil, knox.Read, path, "", "GetNamespace", parameters) {
+			readable = append(readable, path)
+		}
+	}
+	return readable, nil
+}
+
+// getNamespaceHandler gets the namespace matching the path in the request with
+// its ACL and the namespaces directly inside it. Of the keys directly inside
+// it, only those the principal can read are listed.
+// The route for this handler is GET /v0/namespaces/<path>/
+// The principal needs Read access to the namespace.
+func getNamespaceHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	path := parameters["path"]
+
+	n, err := m.GetNamespace(path)
+	if err != nil {
+		return nil, namespaceErrF(path, err)
+	}
+
+	if !CanAccess(principal, m, nil, knox.Read, path, "", "GetNamespace", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to read namespace %s", principal.GetID(), path))
+	}
+	keys, verifyErr := verifyKeys(m, principal, n.Keys, parameters)
+	if verifyErr != nil {
+		return nil, errF(knox.InternalServerErrorCode, verifyErr.Error())
+	}
+	n.Keys = keys
+	return n, nil
+}
+
+// postNamespaceHandler creates the namespace at the path in the request with
+// the JSON encoded ACL from the acl form data. As with new keys, the creator is
+// added as an Admin of the namespace. The namespace containing it must exist.
+// The route for this handler is POST /v0/namespaces/<path>/
+// The principal must be a User to create a top level namespace and needs Admin
+// access to the containing namespace to create a nested one.
+func postNamespaceHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	path := parameters["path"]
+
+	acl := knox.ACL{}
+	if aclStr, aclOK := parameters["acl"]; aclOK {
+		if jsonErr := json.Unmarshal([]byte(aclStr), &acl); jsonErr != nil {
+			return nil, errF(knox.BadRequestDataCode, jsonErr.Error())
+		}
+	}
+
+	// Authorize
+	paths := knox.NamespaceAncestry(path)
+	switch {
+	case m.GetAuthorizationType() == OpaAuthorization:
+		if !canAccessOPA(principal, nil, path, "", "CreateNamespace", parameters) {
+			return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to create namespace %s", principal.GetID(), path))
+		}
+	case len(paths) > 1:
+		parent := paths[len(paths)-2]
+		if !CanAccess(principal, m, nil, knox.Admin, parent, "", "CreateNamespace", parameters) {
+			return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to create namespaces in %s", principal.GetID(), parent))
+		}
+	case !auth.IsUser(principal):
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to create namespace %s", principal.GetID(), path))
+	}
+	if err := validateAccessUpdate(acl); err != nil {
+		return nil, err
+	}
+
+	n := &knox.Namespace{Path: path, ACL: acl.Add(knox.Access{ID: principal.GetID(), AccessType: knox.Admin, Type: knox.User})}
+	switch err := m.AddNamespace(n); err {
+	case nil:
+		return nil, nil
+	case knox.ErrNamespaceNotFound:
+		return nil, errF(knox.NamespaceDoesNotExistCode, fmt.Sprintf("No namespace contains %s", path))
+	default:
+		return nil, namespaceErrF(path, err)
+	}
+}
+
+// putNamespaceAccessHandler adds or updates entries of the ACL of the
+// namespace at the path in the request in the same way putAccessHandler does
+// for keys.
+// The route for this handler is PUT /v0/namespaces/<path>/
+// The principal needs Admin access to the namespace.
+// If a key inside the namespace, or inside the namespaces in it, requires
+// quorum, the update is filed as a pending operation on that key instead.
+func putNamespaceAccessHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	path := parameters["path"]
+
+	acl, parseErr := parseAccessUpdate(parameters)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	if _, err := m.GetNamespace(path); err != nil {
+		return nil, namespaceErrF(path, err)
+	}
+	if !CanAccess(principal, m, nil, knox.Admin, path, "", "PutNamespaceACL", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to update access for namespace %s", principal.GetID(), path))
+	}
+	if err := validateAccessUpdate(acl); err != nil {
+		return nil, err
+	}
+	keyIDs, err := namespaceKeyIDs(m, path)
+	if err != nil {
+		return nil, namespaceErrF(path, err)
+	}
+	keyID, httpErr := firstQuorumKey(m, keyIDs)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if keyID != "" {
+		if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: keyID, Operation: knox.OperationUpdateNamespaceAccess, Path: path, ACL: acl}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.UpdateNamespaceAccess(path, acl...); err != nil {
+		return nil, namespaceErrF(path, err)
+	}
+	return nil, nil
+}
+
+// deleteNamespaceHandler removes the namespace at the path in the request. Only
+// namespaces without keys, including keys in the trash, and without nested
+// namespaces can be removed.
+// The route for this handler is DELETE /v0/namespaces/<path>/
+// The principal needs Admin access to the namespace.
+func deleteNamespaceHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	path := parameters["path"]
+
+	if _, err := m.GetNamespace(path); err != nil {
+		return nil, namespaceErrF(path, err)
+	}
+	if !CanAccess(principal, m, nil, knox.Admin, path, "", "DeleteNamespace", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to delete namespace %s", principal.GetID(), path))
+	}
+
+	if err := m.RemoveNamespace(path); err != nil {
+		return nil, namespaceErrF(path, err)
+	}
+	return nil, nil
+}
+
+// isGlobalAdmin determines if principal may use the server-wide admin
+// endpoints: a global admin under ACL authorization, or allowed the action by
+// the policy under OPA authorization.
+func isGlobalAdmin(principal knox.Principal, m KeyManager, action string, parameters map[string]string) bool {
+	switch m.GetAuthorizationType() {
+	case AclAuthorization:
+		return principal.CanAccess(globalPolicy, knox.Admin)
+	case OpaAuthorization:
+		return canAccessOPA(principal, nil, "", "", action, parameters)
+	}
+	return false
+}
+
+// accessReportHandler lists every key the principal in the principal parameter
+// has access to, with the highest access type it has, sorted by key ID. The
+// principal is written as Type:ID, such as Machine:host1.example.com or
+// UserGroup:oncall, with its groups in groups as a comma separated list for
+// users. Access comes from key ACLs, the ACLs of their namespaces and the
+// global policy, so reports are only available under ACL authorization.
+// The route for this handler is GET /v0/reports/access/
+// The principal needs to be a global admin.
+func accessReportHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	if !isGlobalAdmin(principal, m, "AccessReport", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to get access reports", principal.GetID()))
+	}
+	if m.GetAuthorizationType() != AclAuthorization {
+		return nil, errF(knox.NotYetImplementedCode, "Access reports are only available with ACL authorization")
+	}
+
+	var groups []string
+	if groupsStr := parameters["groups"]; groupsStr != "" {
+		groups = strings.Split(groupsStr, ",")
+	}
+	target, err := auth.ParsePrincipal(parameters["principal"], groups)
+	if err != nil {
+		return nil, errF(knox.BadPrincipalIdentifier, err.Error())
+	}
+
+	keyIDs, err := m.GetAllKeyIDs()
+	if err != nil {
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	sort.Strings(keyIDs)
+	report := []knox.AccessReportEntry{}
+	for _, keyID := range keyIDs {
+		key, err := m.GetKey(keyID, knox.Primary)
+		if err == knox.ErrKeyIDNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, errF(knox.InternalServerErrorCode, err.Error())
+		}
+		for _, at := range []knox.AccessType{knox.Admin, knox.Write, knox.Read} {
+			if CanAccess(target, m, key.ACL, at, key.Path, keyID, "AccessReport", nil) {
+				report = append(report, knox.AccessReportEntry{KeyID: keyID, Path: key.Path, Access: at})
+				break
+			}
+		}
+	}
+	return report, nil
+}
+
+// defaultMigrationBatch and maxMigrationBatch bound how many keys a single
+// ACL migration request changes.
+const (
+	defaultMigrationBatch = 100
+	maxMigrationBatch     = 1000
+)
+
+// migrateACLHandler replaces the ACL entries for the principal in from with
+// entries for the principal in to, keeping their access types, or removes them
+// if remove is true, in every key, alias and namespace. Principals are written
+// as Type:ID, such as UserGroup:security-team. At most batch keys, after the
+// key ID in after, are changed per request; the result says where to continue.
+// With dry_run the changes are only reported. Every change is audited. If a key
+// the batch changes requires quorum, the batch is filed as a pending operation
+// on that key instead.
+// The route for this handler is POST /v0/acl-migrations/
+// The principal needs to be a global admin.
+func migrateACLHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	if !isGlobalAdmin(principal, m, "MigrateACL", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to migrate ACLs", principal.GetID()))
+	}
+
+	from, err := knox.ParseAccessPrincipal(parameters["from"])
+	if err != nil {
+		return nil, errF(knox.BadPrincipalIdentifier, err.Error())
+	}
+	var to *knox.Access
+	toStr, toOK := parameters["to"]
+	remove := parameters["remove"] == "true"
+	switch {
+	case toOK && remove, !toOK && !remove:
+		return nil, errF(knox.BadRequestDataCode, "Exactly one of to and remove must be given")
+	case toOK:
+		a, err := knox.ParseAccessPrincipal(toStr)
+		if err != nil {
+			return nil, errF(knox.BadPrincipalIdentifier, err.Error())
+		}
+		if err := a.Type.IsValidPrincipal(a.ID, extraPrincipalValidators); err != nil {
+			return nil, errF(knox.BadPrincipalIdentifier, err.Error())
+		}
+		if a.Type == from.Type && a.ID == from.ID {
+			return nil, errF(knox.BadRequestDataCode, "from and to are the same principal")
+		}
+		to = &a
+	}
+	dryRun := parameters["dry_run"] == "true"
+	batch := defaultMigrationBatch
+	if batchStr, ok := parameters["batch"]; ok {
+		batch, err = strconv.Atoi(batchStr)
+		if err != nil || batch <= 0 || batch > maxMigrationBatch {
+			return nil, errF(knox.BadRequestDataCode, fmt.Sprintf("batch must be between 1 and %d", maxMigrationBatch))
+		}
+	}
+
+	migration := &knox.ACLMigrationRequest{From: from, To: to, After: parameters["after"], Batch: batch}
+	if !dryRun {
+		changes, _, err := m.MigratePrincipal(from, to, parameters["after"], batch, true, false)
+		if err != nil {
+			return nil, errF(knox.InternalServerErrorCode, err.Error())
+		}
+		keyIDs := []string{}
+		for _, c := range changes {
+			if !strings.HasPrefix(c.KeyID, "/") {
+				keyIDs = append(keyIDs, c.KeyID)
+				continue
+			}
+			nested, err := namespaceKeyIDs(m, c.KeyID)
+			if err != nil && err != knox.ErrNamespaceNotFound {
+				return nil, namespaceErrF(c.KeyID, err)
+			}
+			keyIDs = append(keyIDs, nested...)
+		}
+		keyID, httpErr := firstQuorumKey(m, keyIDs)
+		if httpErr != nil {
+			return nil, httpErr
+		}
+		if keyID != "" {
+			if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: keyID, Operation: knox.OperationMigrateACL, Migration: migration}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// A key can start requiring quorum after the dry run above, so the
+	// migration checks quorum again before changing each key and stops at the
+	// first one that does.
+	changes, next, err := m.MigratePrincipal(from, to, parameters["after"], batch, dryRun, false)
+	if !dryRun {
+		auditMigration(principal, changes)
+	}
+	if e, ok := err.(*QuorumRequiredError); ok {
+		if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: e.KeyID, Operation: knox.OperationMigrateACL, Migration: migration}); err != nil {
+			return nil, err
+		}
+		return nil, errF(knox.KeyConflictCode, fmt.Sprintf("Quorum for key %s changed during the migration", e.KeyID))
+	}
+	switch err {
+	case nil:
+		return knox.ACLMigrationResult{Changes: changes, Next: next}, nil
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+}
+
+// auditMigration writes an audit record for every change of an ACL migration
+// made for principal.
+func auditMigration(principal knox.Principal, changes []knox.ACLMigration) {
+	for _, c := range changes {
+		audit(map[string]interface{}{
+			"type":      "acl_migration",
+			"principal": principal.GetID(),
+			"key_id":    c.KeyID,
+			"removed":   c.Removed,
+			"added":     c.Added,
+		})
+	}
+}
+
+// stripAccessHandler removes every ACL entry of the principals in principals,
+// whatever their access type, from every key, alias and namespace. Principals
+// are written as Type:ID and separated by semicolons. It is run once to remove
+// default access that older servers copied into the ACL of each new key. Every
+// change is audited. If a key that would change requires quorum, or is in a
+// namespace that would change, nothing is changed and the strip is filed as a
+// pending operation on the first such key instead.
+// The route for this handler is POST /v0/acl-migrations/strip/
+// The principal needs to be a global admin.
+func stripAccessHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	if !isGlobalAdmin(principal, m, "StripAccess", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to strip access", principal.GetID()))
+	}
+
+	principals := knox.ACL{}
+	for _, s := range strings.Split(parameters["principals"], ";") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		a, err := knox.ParseAccessPrincipal(s)
+		if err != nil {
+			return nil, errF(knox.BadPrincipalIdentifier, err.Error())
+		}
+		principals = append(principals, a)
+	}
+	if len(principals) == 0 {
+		return nil, errF(knox.BadRequestDataCode, "Missing parameter 'principals'")
+	}
+
+	changes, err := m.StripAccess(principals, false)
+	auditStrip(principal, changes)
+	if e, ok := err.(*QuorumRequiredError); ok {
+		if err := holdForQuorum(m, principal, knox.PendingOperation{KeyID: e.KeyID, Operation: knox.OperationStripAccess, ACL: principals}); err != nil {
+			return nil, err
+		}
+		return nil, errF(knox.KeyConflictCode, fmt.Sprintf("Quorum for key %s changed while stripping access", e.KeyID))
+	}
+	switch err {
+	case nil:
+		return changes, nil
+	case keydb.ErrDBVersion:
+		return nil, errF(knox.KeyConflictCode, err.Error())
+	default:
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+}
+
+// auditStrip writes an audit record for every change made by stripping access
+// for principal.
+func auditStrip(principal knox.Principal, changes []knox.ACLMigration) {
+	for _, c := range changes {
+		audit(map[string]interface{}{
+			"type":      "access_stripped",
+			"principal": principal.GetID(),
+			"key_id":    c.KeyID,
+			"removed":   c.Removed,
+		})
+	}
+}
+
+// aclLintReportHandler checks the ACL of every key against the lint rules and
+// lists the findings, sorted by key ID.
+// The route for this handler is GET /v0/reports/acl-lint/
+// The principal needs to be a global admin.
+func aclLintReportHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	if !isGlobalAdmin(principal, m, "ACLLintReport", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to get ACL lint reports", principal.GetID()))
+	}
+
+	keyIDs, err := m.GetAllKeyIDs()
+	if err != nil {
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	sort.Strings(keyIDs)
+	report := []knox.ACLLintFinding{}
+	for _, keyID := range keyIDs {
+		key, err := m.GetKey(keyID, knox.Primary)
+		if err == knox.ErrKeyIDNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, errF(knox.InternalServerErrorCode, err.Error())
+		}
+		var inherited knox.ACL
+		if key.Path != "" {
+			if inherited, err = m.GetInheritedACL(key.Path); err != nil {
+				return nil, errF(knox.InternalServerErrorCode, err.Error())
+			}
+		}
+		report = append(report, lintACL(keyID, key.ACL, inherited)...)
+	}
+	return report, nil
+}
+
+// breakGlassReportHandler lists every break-glass grant, in the order they
+// were taken: the grants still kept on keys and the expired grants in the
+// break-glass archive, if one is set.
+// The route for this handler is GET /v0/reports/break-glass/
+// The principal needs to be a global admin.
+func breakGlassReportHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	if !isGlobalAdmin(principal, m, "BreakGlassReport", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to get break-glass reports", principal.GetID()))
+	}
+	grants := []knox.BreakGlassGrant{}
+	if breakGlassArchive != nil {
+		archived, err := breakGlassArchive.All()
+		if err != nil {
+			return nil, errF(knox.InternalServerErrorCode, err.Error())
+		}
+		grants = append(grants, archived...)
+	}
+	current, err := m.GetAllBreakGlassGrants()
+	if err != nil {
+		return nil, errF(knox.InternalServerErrorCode, err.Error())
+	}
+	grants = append(grants, current...)
+	sort.SliceStable(grants, func(i, j int) bool { return grants[i].GrantedAt < grants[j].GrantedAt })
+	return grants, nil
+}
+
+// opaDecisionReportHandler lists the most recent OPA authorization decisions,
+// oldest first. Only the embedded evaluator keeps them; an OPA server has its
+// own decision logs.
+// The route for this handler is GET /v0/reports/opa-decisions/
+// The principal needs to be a global admin.
+func opaDecisionReportHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	if !isGlobalAdmin(principal, m, "OPADecisionReport", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to get OPA decision reports", principal.GetID()))
+	}
+	e, ok := opaEvaluator.(*RegoEvaluator)
+	if !ok || m.GetAuthorizationType() != OpaAuthorization {
+		return nil, errF(knox.NotYetImplementedCode, "OPA decision reports are only available with embedded OPA policies")
+	}
+	return e.Decisions(), nil
+}
+
+// whoamiHandler describes the principal the request was authenticated as and
+// its credential.
+// The route for this handler is GET /v0/whoami/
+// Every authenticated principal may call it.
+func whoamiHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	id := knox.Identity{
+		ID:       principal.GetID(),
+		Type:     principal.Type(),
+		Groups:   auth.Groups(principal),
+		Provider: parameters["provider"],
+	}
+	if expiresAt, ok := parameters["expires_at"]; ok {
+		id.ExpiresAt, _ = strconv.ParseInt(expiresAt, 10, 64)
+	}
+	return id, nil
+}
+
+// explainAccessHandler explains whether a principal has an access type to a
+// key and why. By default it explains the requesting principal's Read access
+// for the GetKey action. The as parameter names another principal as Type:ID,
+// such as Machine:host1.example.com, with its groups in groups as a comma
+// separated list for users; access and action choose the access type and the
+// OPA action checked.
+// The route for this handler is GET /v0/keys/<key_id>/explain/
+// The principal needs Read access to the key, and Admin access to explain
+// another principal's access. Principals that may read the key but not its ACL
+// only see the ACL entries that match them, and the OPA input without the ACL.
+func explainAccessHandler(m KeyManager, principal knox.Principal, parameters map[string]string) (interface{}, *HTTPError) {
+	keyID := parameters["keyID"]
+
+	at := knox.Read
+	if accessStr, ok := parameters["access"]; ok {
+		if err := json.Unmarshal([]byte(strconv.Quote(accessStr)), &at); err != nil || at <= knox.None {
+			return nil, errF(knox.BadRequestDataCode, fmt.Sprintf("Invalid access type %s", accessStr))
+		}
+	}
+	action := "GetKey"
+	if actionStr, ok := parameters["action"]; ok && actionStr != "" {
+		action = actionStr
+	}
+
+	key, getErr := m.GetKey(keyID, knox.Primary)
+	if getErr != nil && getErr != knox.ErrKeyIDNotFound {
+		return nil, errF(knox.InternalServerErrorCode, getErr.Error())
+	}
+	// Only principals that could read the key learn whether it exists.
+	readKey := key
+	if readKey == nil {
+		readKey = &knox.Key{}
+	}
+	if !CanAccess(principal, m, readKey.ACL, knox.Read, readKey.Path, keyID, "GetKey", parameters) {
+		return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to explain access to %s", principal.GetID(), keyID))
+	}
+	if getErr != nil {
+		return nil, errF(knox.KeyIdentifierDoesNotExistCode, fmt.Sprintf("No such key %s", keyID))
+	}
+
+	// Decisions for other principals are not about this request.
+	target, request := principal, parameters
+	if as, ok := parameters["as"]; ok && as != "" {
+		request = nil
+		if !CanAccess(principal, m, key.ACL, knox.Admin, key.Path, keyID, "ExplainAccess", parameters) {
+			return nil, errF(knox.UnauthorizedCode, fmt.Sprintf("Principal %s not authorized to explain access to %s for other principals", principal.GetID(), keyID))
+		}
+		var groups []string
+		if groupsStr := parameters["groups"]; groupsStr != "" {
+			groups = strings.Split(groupsStr, ",")
+		}
+		var err error
+		if target, err = auth.ParsePrincipal(as, groups); err != nil {
+			return nil, errF(knox.BadPrincipalIdentifier, err.Error())
+		}
+	}
+
+	e := knox.AccessExplanation{
+		KeyID:           keyID,
+		Principal:       target.GetID(),
+		PrincipalType:   target.Type(),
+		PrincipalGroups: auth.Groups(target),
+		AccessType:      at,
+		Action:          action,
+		Allowed:         CanAccess(target, m, key.ACL, at, key.Path, keyID, action, request),
+	}
+	showAll := CanAccess(principal, m, key.ACL, knox.Read, key.Path, keyID, "GetACL", parameters)
+
+	switch m.GetAuthorizationType() {
+	case OpaAuthorization:
+		acl := key.ACL
+		if !showAll {
+			acl = nil
+		}
+		e.OPAInput = opaInput(target, acl, key.Path, keyID, action, request)
+		e.OPAResult = &e.Allowed
+		if e.Allowed {
+			e.Reason = "allowed by the OPA policy"
+		} else {
+			e.Reason = "denied by the OPA policy"
+		}
+	case AclAuthorization:
+		entries, err := explainACL(m, target, key)
+		if err != nil {
+			return nil, errF(knox.InternalServerErrorCode, err.Error())
+		}
+		denied := false
+		for _, entry := range entries {
+			if entry.Matched && entry.Access.AccessType == knox.Deny {
+				denied = true
+			}
+			if showAll || entry.Matched {
+				e.Entries = append(e.Entries, entry)
+			}
+		}
+		switch {
+		case e.Allowed:
+			e.Reason = fmt.Sprintf("a matching entry grants %s", accessTypeName(at))
+		case denied:
+			e.Reason = "a matching Deny entry overrides every grant"
+		default:
+			e.Reason = fmt.Sprintf("no matching entry grants %s", accessTypeName(at))
+		}
+	}
+	return e, nil
+}
+
+// explainACL lists every ACL entry that applies to key under ACL authorization
+// in the order CanAccess evaluates them: the global policy, the ACLs of the
+// namespaces containing the key from the outermost one, and the key's own ACL.
+func explainACL(m KeyManager, principal knox.Principal, key *knox.Key) ([]knox.ExplainedAccess, error) {
+	entries := []knox.ExplainedAccess{}
+	add := func(source string, acl knox.ACL) {
+		now := time.Now()
+		for _, a := range acl {
+			match := auth.MatchAccess(principal, a)
+			entries = append(entries, knox.ExplainedAccess{
+				Access:  a,
+				Source:  source,
+				Matched: match != "" && !a.Expired(now),
+				Match:   match,
+			})
+		}
+	}
+	add("global", globalPolicy)
+	for _, path := range knox.NamespaceAncestry(key.Path) {
+		n, err := m.GetNamespace(path)
+		if err == knox.ErrNamespaceNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		add(path, n.ACL)
+	}
+	add("key", key.ACL)
+	return entries, nil
+}
+
+// accessTypeName returns the name of an access type as it is written in JSON.
+func accessTypeName(at knox.AccessType) string {
+	name, err := json.Marshal(at)
+	if err != nil {
+		return strconv.Itoa(int(at))
+	}
+	return strings.Trim(string(name), `"`)
+}
@@ -1,11 +1,17 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/pavelzhurov/knox"
+	"github.com/pavelzhurov/knox/log"
 	"github.com/pavelzhurov/knox/server/auth"
 	"github.com/pavelzhurov/knox/server/keydb"
 )
@@ -166,7 +172,7 @@ func TestGetKey(t *testing.T) {
 	}
 
 	i, err := getKeyHandler(m, u, map[string]string{"keyID": "a1"})
-	switch k := i.(type) {
+	switch k := i.(etagged).Data.(type) {
 	default:
 		t.Fatal("Unexpected type of response")
 	case *knox.Key:
@@ -185,7 +191,7 @@ func TestGetKey(t *testing.T) {
 	}
 
 	i, err = getKeyHandler(m, u, map[string]string{"keyID": "a1", "status": "\"Inactive\""})
-	switch k := i.(type) {
+	switch k := i.(etagged).Data.(type) {
 	default:
 		t.Fatal("Unexpected type of response")
 	case *knox.Key:
@@ -204,7 +210,7 @@ func TestGetKey(t *testing.T) {
 	}
 
 	i, err = getKeyHandler(m, u, map[string]string{"keyID": "a1", "status": "\"Primary\""})
-	switch k := i.(type) {
+	switch k := i.(etagged).Data.(type) {
 	default:
 		t.Fatal("Unexpected type of response")
 	case *knox.Key:
@@ -304,7 +310,7 @@ func TestGetAccess(t *testing.T) {
 		t.Fatalf("%+v is not nil", err)
 	}
 
-	switch acl := i.(type) {
+	switch acl := i.(etagged).Data.(type) {
 	default:
 		t.Fatal("Unexpected type of response")
 	case knox.ACL:
@@ -598,3 +604,1407 @@ func TestPutVersions(t *testing.T) {
 	}
 
 }
+
+func TestPreconditions(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	_, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	i, err := getKeyHandler(m, u, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	keyTag := i.(etagged).ETag
+	i, err = getAccessHandler(m, u, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if i.(etagged).ETag != keyTag {
+		t.Fatalf("key and access ETags differ: %s %s", keyTag, i.(etagged).ETag)
+	}
+
+	_, err = postVersionHandler(m, u, map[string]string{"keyID": "a1", "data": "Mg==", "If-Match": `"stale"`})
+	if err == nil || err.Subcode != knox.PreconditionFailedCode {
+		t.Fatalf("Expected precondition failure not %+v", err)
+	}
+
+	// An ETag that is still current lets the write through and changes the ETag.
+	_, err = putAccessHandler(m, u, map[string]string{
+		"keyID":    "a1",
+		"access":   `{"type":"Machine","id":"MrRoboto","access":"Read"}`,
+		"If-Match": `W/"other", ` + keyTag,
+	})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = putAccessHandler(m, u, map[string]string{
+		"keyID":    "a1",
+		"access":   `{"type":"Machine","id":"MrRoboto2","access":"Read"}`,
+		"If-Match": keyTag,
+	})
+	if err == nil || err.Subcode != knox.PreconditionFailedCode {
+		t.Fatalf("Expected precondition failure not %+v", err)
+	}
+
+	_, err = deleteKeyHandler(m, u, map[string]string{"keyID": "a1", "If-Match": keyTag})
+	if err == nil || err.Subcode != knox.PreconditionFailedCode {
+		t.Fatalf("Expected precondition failure not %+v", err)
+	}
+	_, err = deleteKeyHandler(m, u, map[string]string{"keyID": "a1", "If-Match": "*"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+}
+
+func TestRestoreKey(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	machine := auth.NewMachine("MrRoboto")
+	_, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	_, err = restoreKeyHandler(m, u, map[string]string{"keyID": "a1"})
+	if err == nil || err.Subcode != knox.KeyIdentifierDoesNotExistCode {
+		t.Fatalf("Expected missing key error not %+v", err)
+	}
+
+	_, err = deleteKeyHandler(m, u, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	i, err := getKeysHandler(m, u, map[string]string{})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if len(i.([]string)) != 0 {
+		t.Fatalf("deleted key is still listed: %v", i)
+	}
+	_, err = postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ=="})
+	if err == nil || err.Subcode != knox.KeyInTrashCode {
+		t.Fatalf("Expected key in trash error not %+v", err)
+	}
+
+	_, err = restoreKeyHandler(m, machine, map[string]string{"keyID": "a1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = restoreKeyHandler(m, u, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = getKeyHandler(m, u, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+}
+
+func TestPurgeKey(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	for _, id := range []string{"a1", "a2"} {
+		_, err := postKeysHandler(m, u, map[string]string{"id": id, "data": "MQ=="})
+		if err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+	}
+
+	// Live keys and keys already in the trash can both be purged.
+	_, err := deleteKeyHandler(m, u, map[string]string{"keyID": "a1", "purge": "true"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = deleteKeyHandler(m, u, map[string]string{"keyID": "a2"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = deleteKeyHandler(m, u, map[string]string{"keyID": "a2", "purge": "true"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	for _, id := range []string{"a1", "a2"} {
+		_, err = restoreKeyHandler(m, u, map[string]string{"keyID": id})
+		if err == nil || err.Subcode != knox.KeyIdentifierDoesNotExistCode {
+			t.Fatalf("Expected missing key error not %+v", err)
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	machine := auth.NewMachine("MrRoboto")
+	machineRead := `[{"type":"Machine","id":"MrRoboto","access":"Read"}]`
+	_, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	_, err = putAliasHandler(m, machine, map[string]string{"aliasID": "old1", "key": "a1", "acl": machineRead})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = putAliasHandler(m, u, map[string]string{"aliasID": "old1", "key": "a1", "acl": machineRead})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = putAliasHandler(m, u, map[string]string{"aliasID": "old2", "key": "a1", "acl": "[]"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = putAliasHandler(m, u, map[string]string{"aliasID": "a1", "key": "a1"})
+	if err == nil || err.Subcode != knox.KeyIdentifierExistsCode {
+		t.Fatalf("Expected key exists error not %+v", err)
+	}
+
+	// An alias's ACL can not grant more than the key's ACL.
+	_, err = getKeyHandler(m, machine, map[string]string{"keyID": "old1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = putAccessHandler(m, u, map[string]string{"keyID": "a1", "acl": machineRead})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	i, err := getKeyHandler(m, machine, map[string]string{"keyID": "old1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	key := i.(etagged).Data.(*knox.Key)
+	if key.ID != "a1" {
+		t.Fatalf("Expected a1 not %s", key.ID)
+	}
+	// Nor can the key's ACL be used through an alias that does not grant access.
+	_, err = getKeyHandler(m, machine, map[string]string{"keyID": "old2"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+
+	i, err = getKeysHandler(m, machine, map[string]string{"queryString": "old1=" + key.VersionHash + "&old2=NOHASH"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if len(i.([]string)) != 0 {
+		t.Fatalf("Expected no updated keys not %v", i)
+	}
+	i, err = getKeysHandler(m, machine, map[string]string{"queryString": "old1=NOHASH"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if d := i.([]string); len(d) != 1 || d[0] != "old1" {
+		t.Fatalf("Expected [old1] not %v", d)
+	}
+
+	_, err = renameKeyHandler(m, machine, map[string]string{"keyID": "a1", "id": "b1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = renameKeyHandler(m, u, map[string]string{"keyID": "a1", "id": "b1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	for _, id := range []string{"a1", "b1", "old1"} {
+		i, err = getKeyHandler(m, machine, map[string]string{"keyID": id})
+		if err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+		if k := i.(etagged).Data.(*knox.Key); k.ID != "b1" || k.VersionHash != key.VersionHash {
+			t.Fatalf("Expected b1 with hash %s not %s with %s", key.VersionHash, k.ID, k.VersionHash)
+		}
+	}
+	i, err = getAliasHandler(m, machine, map[string]string{"aliasID": "old1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if a := i.(*knox.KeyAlias); a.KeyID != "b1" {
+		t.Fatalf("Expected old1 to point to b1 not %s", a.KeyID)
+	}
+
+	_, err = deleteAliasHandler(m, machine, map[string]string{"aliasID": "old1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = deleteAliasHandler(m, u, map[string]string{"aliasID": "old1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = getKeyHandler(m, machine, map[string]string{"keyID": "old1"})
+	if err == nil || err.Subcode != knox.KeyIdentifierDoesNotExistCode {
+		t.Fatalf("Expected missing key error not %+v", err)
+	}
+}
+
+func TestAliasInNamespace(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	machine := auth.NewMachine("MrRoboto")
+	machineRead := `[{"type":"Machine","id":"MrRoboto","access":"Read"}]`
+	_, err := postNamespaceHandler(m, u, map[string]string{"path": "/teams", "acl": machineRead})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ==", "path": "/teams"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = putAliasHandler(m, u, map[string]string{"aliasID": "old1", "key": "a1", "acl": "[]"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	// The alias lives in the key's namespace, whose ACL applies to it.
+	i, err := getAliasHandler(m, machine, map[string]string{"aliasID": "old1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if a := i.(*knox.KeyAlias); a.Path != "/teams" {
+		t.Fatalf("Expected /teams not %s", a.Path)
+	}
+	_, err = getKeyHandler(m, machine, map[string]string{"keyID": "old1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+}
+
+func TestPurgeVersion(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	machine := auth.NewMachine("MrRoboto")
+	i, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	primary := strconv.FormatUint(i.(uint64), 10)
+	i, err = postVersionHandler(m, u, map[string]string{"keyID": "a1", "data": "Mg=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	version := strconv.FormatUint(i.(uint64), 10)
+
+	_, err = deleteVersionHandler(m, u, map[string]string{"keyID": "a1", "versionID": version})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+	_, err = deleteVersionHandler(m, u, map[string]string{"keyID": "a1", "versionID": primary})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+	_, err = putVersionsHandler(m, u, map[string]string{"keyID": "a1", "versionID": version, "status": "\"Inactive\""})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = deleteVersionHandler(m, machine, map[string]string{"keyID": "a1", "versionID": version})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = deleteVersionHandler(m, u, map[string]string{"keyID": "a1", "versionID": version})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = deleteVersionHandler(m, u, map[string]string{"keyID": "a1", "versionID": version})
+	if err == nil || err.Subcode != knox.KeyVersionDoesNotExistCode {
+		t.Fatalf("Expected missing version error not %+v", err)
+	}
+
+	key, getErr := m.GetKey("a1", knox.Inactive)
+	if getErr != nil {
+		t.Fatalf("%s is not nil", getErr)
+	}
+	if len(key.VersionList) != 1 {
+		t.Fatalf("Expected 1 version not %d", len(key.VersionList))
+	}
+	if err := key.Validate(); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+}
+
+func TestVersionRetention(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	machine := auth.NewMachine("MrRoboto")
+	_, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	i, err := getRetentionHandler(m, u, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if i.(*knox.VersionRetention) != nil {
+		t.Fatalf("Expected no policy not %+v", i)
+	}
+
+	_, err = putRetentionHandler(m, u, map[string]string{"keyID": "a1", "retention": `{"max_inactive":-1}`})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+	_, err = putRetentionHandler(m, machine, map[string]string{"keyID": "a1", "retention": `{"max_inactive":2}`})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = putRetentionHandler(m, u, map[string]string{"keyID": "a1", "retention": `{"max_inactive":2,"inactive_days":30}`})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	i, err = getRetentionHandler(m, u, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if r := i.(*knox.VersionRetention); r == nil || r.MaxInactive != 2 || r.InactiveDays != 30 {
+		t.Fatalf("Unexpected policy %+v", r)
+	}
+
+	_, err = putRetentionHandler(m, u, map[string]string{"keyID": "a1", "retention": `{}`})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	i, err = getRetentionHandler(m, u, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if i.(*knox.VersionRetention) != nil {
+		t.Fatalf("Expected no policy not %+v", i)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	other := auth.NewUser("otheruser", []string{})
+	machine := auth.NewMachine("MrRoboto")
+	machineRead := `[{"type":"Machine","id":"MrRoboto","access":"Read"}]`
+
+	_, err := postNamespaceHandler(m, machine, map[string]string{"path": "/teams"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = postNamespaceHandler(m, u, map[string]string{"path": "/teams"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = postNamespaceHandler(m, u, map[string]string{"path": "/teams"})
+	if err == nil || err.Subcode != knox.NamespaceExistsCode {
+		t.Fatalf("Expected namespace exists error not %+v", err)
+	}
+	_, err = postNamespaceHandler(m, other, map[string]string{"path": "/teams/payments"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = postNamespaceHandler(m, u, map[string]string{"path": "/teams/payments", "acl": machineRead})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = postNamespaceHandler(m, u, map[string]string{"path": "/teams/search/prod"})
+	if err == nil || err.Subcode != knox.NamespaceDoesNotExistCode {
+		t.Fatalf("Expected namespace does not exist error not %+v", err)
+	}
+
+	_, err = postKeysHandler(m, other, map[string]string{"id": "a1", "data": "MQ==", "path": "/teams/payments"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ==", "path": "/teams/payments"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	// The machine can read the key through the namespace's ACL.
+	i, err := getKeyHandler(m, machine, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if path := i.(etagged).Data.(*knox.Key).Path; path != "/teams/payments" {
+		t.Fatalf("Expected /teams/payments not %s", path)
+	}
+	_, err = postVersionHandler(m, machine, map[string]string{"keyID": "a1", "data": "Mg=="})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	// A deny on an outer namespace overrides grants further in.
+	_, err = putNamespaceAccessHandler(m, machine, map[string]string{"path": "/teams", "access": `{"type":"Machine","id":"MrRoboto","access":"Deny"}`})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = putNamespaceAccessHandler(m, u, map[string]string{"path": "/teams", "access": `{"type":"Machine","id":"MrRoboto","access":"Deny"}`})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = getKeyHandler(m, machine, map[string]string{"keyID": "a1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = putNamespaceAccessHandler(m, u, map[string]string{"path": "/teams", "access": `{"type":"Machine","id":"MrRoboto","access":"None"}`})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	// Only the namespaces the principal can read are listed.
+	i, err = getNamespacesHandler(m, u, map[string]string{})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if paths := i.([]string); len(paths) != 2 || paths[0] != "/teams" || paths[1] != "/teams/payments" {
+		t.Fatalf("Unexpected namespaces %v", paths)
+	}
+	i, err = getNamespacesHandler(m, machine, map[string]string{})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if paths := i.([]string); len(paths) != 1 || paths[0] != "/teams/payments" {
+		t.Fatalf("Unexpected namespaces %v", paths)
+	}
+	_, err = getNamespaceHandler(m, machine, map[string]string{"path": "/teams"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	i, err = getNamespaceHandler(m, u, map[string]string{"path": "/teams"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if n := i.(*knox.Namespace); len(n.Namespaces) != 1 || n.Namespaces[0] != "/teams/payments" || len(n.Keys) != 0 {
+		t.Fatalf("Unexpected namespace %+v", n)
+	}
+	i, err = getNamespaceHandler(m, machine, map[string]string{"path": "/teams/payments"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if n := i.(*knox.Namespace); len(n.Keys) != 1 || n.Keys[0] != "a1" {
+		t.Fatalf("Unexpected namespace %+v", n)
+	}
+	_, err = getNamespaceHandler(m, u, map[string]string{"path": "/nothing"})
+	if err == nil || err.Subcode != knox.NamespaceDoesNotExistCode {
+		t.Fatalf("Expected namespace does not exist error not %+v", err)
+	}
+
+	_, err = deleteNamespaceHandler(m, u, map[string]string{"path": "/teams/payments"})
+	if err == nil || err.Subcode != knox.NamespaceNotEmptyCode {
+		t.Fatalf("Expected namespace not empty error not %+v", err)
+	}
+
+	// Moving the key out of the namespace takes the inherited access away.
+	_, err = moveKeyHandler(m, machine, map[string]string{"keyID": "a1", "path": ""})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = moveKeyHandler(m, u, map[string]string{"keyID": "a1", "path": "/nothing"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = moveKeyHandler(m, u, map[string]string{"keyID": "a1", "path": ""})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = getKeyHandler(m, machine, map[string]string{"keyID": "a1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = moveKeyHandler(m, u, map[string]string{"keyID": "a1", "path": "/teams"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	_, err = deleteNamespaceHandler(m, machine, map[string]string{"path": "/teams/payments"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = deleteNamespaceHandler(m, u, map[string]string{"path": "/teams/payments"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = deleteNamespaceHandler(m, u, map[string]string{"path": "/teams/payments"})
+	if err == nil || err.Subcode != knox.NamespaceDoesNotExistCode {
+		t.Fatalf("Expected namespace does not exist error not %+v", err)
+	}
+}
+
+func TestGlobalPolicy(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	admin := auth.NewUser("secadmin", []string{"security-team"})
+	reader := auth.NewMachine("auditor")
+
+	_, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = getKeyHandler(m, admin, map[string]string{"keyID": "a1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+
+	SetGlobalPolicy(
+		[]knox.Access{{Type: knox.UserGroup, ID: "security-team"}},
+		[]knox.Access{{Type: knox.Machine, ID: "auditor"}},
+	)
+	defer SetGlobalPolicy(nil, nil)
+
+	_, err = getKeyHandler(m, reader, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = deleteKeyHandler(m, reader, map[string]string{"keyID": "a1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	acl, err := getAccessHandler(m, admin, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if len(acl.(etagged).Data.(knox.ACL)) != 1 {
+		t.Fatalf("global policy was stored in the ACL: %v", acl)
+	}
+
+	deny := `{"type":"UserGroup","id":"security-team","access":"Deny"}`
+	_, err = putAccessHandler(m, u, map[string]string{"keyID": "a1", "access": deny})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = getKeyHandler(m, admin, map[string]string{"keyID": "a1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+}
+
+func TestExplainAccess(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	machine := auth.NewMachine("MrRoboto")
+	acl := `[{"type":"MachinePrefix","id":"MrRob","access":"Read"},{"type":"UserGroup","id":"oncall","access":"Write"}]`
+
+	_, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ==", "acl": acl})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	i, err := explainAccessHandler(m, machine, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	e := i.(knox.AccessExplanation)
+	if !e.Allowed || e.Principal != "MrRoboto" || e.PrincipalType != "machine" || e.AccessType != knox.Read {
+		t.Fatalf("unexpected explanation %+v", e)
+	}
+	if len(e.Entries) != 3 || !e.Entries[0].Matched || e.Entries[0].Match != auth.MatchPrefix || e.Entries[0].Source != "key" {
+		t.Fatalf("unexpected entries %+v", e.Entries)
+	}
+
+	_, err = explainAccessHandler(m, machine, map[string]string{"keyID": "a1", "as": "User:testuser"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	i, err = explainAccessHandler(m, u, map[string]string{"keyID": "a1", "as": "User:oncaller", "groups": "oncall", "access": "Admin"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	e = i.(knox.AccessExplanation)
+	if e.Allowed || e.Principal != "oncaller" || len(e.PrincipalGroups) != 1 || e.Entries[1].Match != auth.MatchGroup {
+		t.Fatalf("unexpected explanation %+v", e)
+	}
+	_, err = explainAccessHandler(m, u, map[string]string{"keyID": "a1", "as": "Robot:r2d2"})
+	if err == nil || err.Subcode != knox.BadPrincipalIdentifier {
+		t.Fatalf("Expected bad principal error not %+v", err)
+	}
+	_, err = explainAccessHandler(m, u, map[string]string{"keyID": "a1", "access": "Deny"})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+
+	// Principals that cannot read the key can not tell whether it exists.
+	stranger := auth.NewMachine("R2D2")
+	for _, id := range []string{"a1", "nokey"} {
+		_, err = explainAccessHandler(m, stranger, map[string]string{"keyID": id})
+		if err == nil || err.Subcode != knox.UnauthorizedCode {
+			t.Fatalf("Expected unauthorized error not %+v", err)
+		}
+	}
+	SetGlobalPolicy(nil, []knox.Access{{Type: knox.Machine, ID: "R2D2"}})
+	defer SetGlobalPolicy(nil, nil)
+	_, err = explainAccessHandler(m, stranger, map[string]string{"keyID": "nokey"})
+	if err == nil || err.Subcode != knox.KeyIdentifierDoesNotExistCode {
+		t.Fatalf("Expected missing key error not %+v", err)
+	}
+}
+
+func TestWhoami(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{"b", "a"})
+
+	i, err := whoamiHandler(m, u, map[string]string{"provider": "jwt", "expires_at": "1700000000000000000"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	id := i.(knox.Identity)
+	if id.ID != "testuser" || id.Type != "user" || id.Provider != "jwt" || id.ExpiresAt != 1700000000000000000 {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+	if len(id.Groups) != 2 || id.Groups[0] != "a" || id.Groups[1] != "b" {
+		t.Fatalf("unexpected groups %v", id.Groups)
+	}
+
+	i, err = whoamiHandler(m, auth.NewMachine("MrRoboto"), map[string]string{"provider": "mtls"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	id = i.(knox.Identity)
+	if id.ID != "MrRoboto" || id.Type != "machine" || id.Groups != nil || id.ExpiresAt != 0 {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+}
+
+func TestAccessReport(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	admin := auth.NewUser("secadmin", []string{"security-team"})
+	SetGlobalPolicy([]knox.Access{{Type: knox.UserGroup, ID: "security-team"}}, nil)
+	defer SetGlobalPolicy(nil, nil)
+
+	_, err := postNamespaceHandler(m, u, map[string]string{"path": "/teams", "acl": `[{"type":"UserGroup","id":"oncall","access":"Write"}]`})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	keys := map[string]string{
+		"b1": `[{"type":"MachinePrefix","id":"web","access":"Read"}]`,
+		"a1": `[{"type":"UserGroup","id":"oncall","access":"Admin"}]`,
+		"c1": `[]`,
+	}
+	for id, acl := range keys {
+		params := map[string]string{"id": id, "data": "MQ==", "acl": acl}
+		if id == "c1" {
+			params["path"] = "/teams"
+		}
+		if _, err := postKeysHandler(m, u, params); err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+	}
+
+	_, err = accessReportHandler(m, u, map[string]string{"principal": "User:testuser"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = accessReportHandler(m, admin, map[string]string{"principal": "Robot:r2d2"})
+	if err == nil || err.Subcode != knox.BadPrincipalIdentifier {
+		t.Fatalf("Expected bad principal error not %+v", err)
+	}
+
+	i, err := accessReportHandler(m, admin, map[string]string{"principal": "UserGroup:oncall"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	report := i.([]knox.AccessReportEntry)
+	expected := []knox.AccessReportEntry{{KeyID: "a1", Access: knox.Admin}, {KeyID: "c1", Path: "/teams", Access: knox.Write}}
+	if len(report) != len(expected) || report[0] != expected[0] || report[1] != expected[1] {
+		t.Fatalf("%v is not %v", report, expected)
+	}
+
+	i, err = accessReportHandler(m, admin, map[string]string{"principal": "Machine:web01"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	report = i.([]knox.AccessReportEntry)
+	if len(report) != 1 || report[0] != (knox.AccessReportEntry{KeyID: "b1", Access: knox.Read}) {
+		t.Fatalf("unexpected report %v", report)
+	}
+}
+
+func TestMigrateACL(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	admin := auth.NewUser("secadmin", []string{"security-team"})
+	SetGlobalPolicy([]knox.Access{{Type: knox.UserGroup, ID: "security-team"}}, nil)
+	defer SetGlobalPolicy(nil, nil)
+	var records bytes.Buffer
+	SetAuditLogger(log.New(&records, "", 0))
+	defer SetAuditLogger(nil)
+
+	oldGroup := `[{"type":"UserGroup","id":"sec","access":"Admin"}]`
+	for _, id := range []string{"c1", "a1", "b1"} {
+		if _, err := postKeysHandler(m, u, map[string]string{"id": id, "data": "MQ==", "acl": oldGroup}); err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+	}
+	if _, err := postKeysHandler(m, u, map[string]string{"id": "d1", "data": "MQ=="}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	params := map[string]string{"from": "UserGroup:sec", "to": "UserGroup:security"}
+	_, err := migrateACLHandler(m, u, params)
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = migrateACLHandler(m, admin, map[string]string{"from": "UserGroup:sec"})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+	_, err = migrateACLHandler(m, admin, map[string]string{"from": "UserGroup:sec", "to": "Service:nope"})
+	if err == nil || err.Subcode != knox.BadPrincipalIdentifier {
+		t.Fatalf("Expected bad principal error not %+v", err)
+	}
+
+	dryRun := map[string]string{"from": "UserGroup:sec", "to": "UserGroup:security", "dry_run": "true"}
+	i, err := migrateACLHandler(m, admin, dryRun)
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	r := i.(knox.ACLMigrationResult)
+	if len(r.Changes) != 3 || r.Changes[0].KeyID != "a1" || r.Next != "" {
+		t.Fatalf("unexpected dry run %+v", r)
+	}
+	if key, _ := m.GetKey("a1", knox.Primary); key.ACL[0].ID != "sec" || records.Len() != 0 {
+		t.Fatalf("dry run changed %v", key.ACL)
+	}
+
+	params["batch"] = "2"
+	i, err = migrateACLHandler(m, admin, params)
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	r = i.(knox.ACLMigrationResult)
+	if len(r.Changes) != 2 || r.Changes[1].KeyID != "b1" || r.Next != "b1" {
+		t.Fatalf("unexpected batch %+v", r)
+	}
+	params["after"] = r.Next
+	i, err = migrateACLHandler(m, admin, params)
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	r = i.(knox.ACLMigrationResult)
+	if len(r.Changes) != 1 || r.Changes[0].KeyID != "c1" || r.Next != "" {
+		t.Fatalf("unexpected batch %+v", r)
+	}
+	key, _ := m.GetKey("c1", knox.Primary)
+	if key.ACL[1].ID != "security" || key.ACL[1].AccessType != knox.Admin {
+		t.Fatalf("unexpected ACL %v", key.ACL)
+	}
+	if n := strings.Count(records.String(), `"acl_migration"`); n != 3 {
+		t.Fatalf("expected 3 audit records not %d", n)
+	}
+}
+
+func TestStripAccess(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	admin := auth.NewUser("secadmin", []string{"security-team"})
+	SetGlobalPolicy([]knox.Access{{Type: knox.UserGroup, ID: "security-team"}}, nil)
+	defer SetGlobalPolicy(nil, nil)
+	var records bytes.Buffer
+	SetAuditLogger(log.New(&records, "", 0))
+	defer SetAuditLogger(nil)
+
+	baked := `[{"type":"UserGroup","id":"security-team","access":"Admin"},{"type":"Machine","id":"old","access":"Read"}]`
+	for _, id := range []string{"b1", "a1"} {
+		if _, err := postKeysHandler(m, u, map[string]string{"id": id, "data": "MQ==", "acl": baked}); err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+	}
+
+	params := map[string]string{"principals": "UserGroup:security-team; Machine:old"}
+	_, err := stripAccessHandler(m, u, params)
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = stripAccessHandler(m, admin, map[string]string{})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+
+	i, err := stripAccessHandler(m, admin, params)
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	changes := i.([]knox.ACLMigration)
+	if len(changes) != 2 || changes[0].KeyID != "a1" || len(changes[0].Removed) != 2 {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	key, _ := m.GetKey("a1", knox.Primary)
+	if len(key.ACL) != 1 || key.ACL[0].ID != u.GetID() {
+		t.Fatalf("unexpected ACL %v", key.ACL)
+	}
+	if n := strings.Count(records.String(), `"access_stripped"`); n != 2 {
+		t.Fatalf("expected 2 audit records not %d", n)
+	}
+}
+
+func TestACLLint(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	admin := auth.NewUser("secadmin", []string{"security-team"})
+	SetGlobalPolicy([]knox.Access{{Type: knox.UserGroup, ID: "security-team"}}, nil)
+	defer SetGlobalPolicy(nil, nil)
+	AddACLLintRule("short-machine-prefix", ShortMachinePrefixRule(4))
+	defer func() { aclLintRules = nil }()
+	defer SetACLLintMode(ACLLintOff)
+
+	_, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ==", "acl": `[{"type":"MachinePrefix","id":"db","access":"Read"}]`})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	short := `{"type":"MachinePrefix","id":"web","access":"Read"}`
+
+	SetACLLintMode(ACLLintBlock)
+	_, err = putAccessHandler(m, u, map[string]string{"keyID": "a1", "access": short})
+	if err == nil || err.Subcode != knox.ACLLintFailedCode {
+		t.Fatalf("Expected lint error not %+v", err)
+	}
+	// Findings the ACL already has do not block unrelated changes.
+	_, err = putAccessHandler(m, u, map[string]string{"keyID": "a1", "access": `{"type":"Machine","id":"host1","access":"Read"}`})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	SetACLLintMode(ACLLintWarn)
+	i, err := putAccessHandler(m, u, map[string]string{"keyID": "a1", "access": short})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	findings, ok := i.([]knox.ACLLintFinding)
+	if !ok || len(findings) != 1 || findings[0].Access.ID != "web" {
+		t.Fatalf("unexpected findings %v", i)
+	}
+
+	_, err = aclLintReportHandler(m, u, map[string]string{})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	i, err = aclLintReportHandler(m, admin, map[string]string{})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	report := i.([]knox.ACLLintFinding)
+	if len(report) != 2 || report[0].KeyID != "a1" || report[0].Rule != "short-machine-prefix" {
+		t.Fatalf("unexpected report %v", report)
+	}
+}
+
+func TestAccessRequests(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	alice := auth.NewUser("alice", []string{})
+	bob := auth.NewUser("bob", []string{})
+	_, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	_, err = postAccessRequestHandler(m, alice, map[string]string{"keyID": "a1", "access": "Read"})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+	_, err = postAccessRequestHandler(m, alice, map[string]string{"keyID": "a1", "access": "Deny", "justification": "oncall"})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+	// Requests for missing keys look like any other, but are not kept.
+	_, err = postAccessRequestHandler(m, alice, map[string]string{"keyID": "NOTAKEY", "access": "Read", "justification": "oncall"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	_, err = postAccessRequestHandler(m, alice, map[string]string{"keyID": "a1", "access": "Write", "justification": "oncall"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	// The second request replaces the first.
+	i, err := postAccessRequestHandler(m, alice, map[string]string{"keyID": "a1", "access": "Read", "justification": "oncall", "duration": "1h"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	r := i.(knox.AccessRequest)
+	if r.Access != (knox.Access{Type: knox.User, ID: "alice", AccessType: knox.Read}) || r.RequestedBy != "alice" || r.Duration != int64(time.Hour) {
+		t.Fatalf("unexpected request %+v", r)
+	}
+
+	for _, tc := range []struct {
+		principal knox.Principal
+		count     int
+	}{{u, 1}, {alice, 1}, {bob, 0}} {
+		i, err = getAccessRequestsHandler(m, tc.principal, map[string]string{})
+		if err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+		if requests := i.([]knox.AccessRequest); len(requests) != tc.count {
+			t.Fatalf("%s: expected %d requests, got %v", tc.principal.GetID(), tc.count, requests)
+		}
+	}
+
+	params := map[string]string{"keyID": "a1", "requestID": strconv.FormatUint(r.ID, 10), "decision": "approve"}
+	_, err = decideAccessRequestHandler(m, alice, params)
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = decideAccessRequestHandler(m, u, params)
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	key, _ := m.GetKey("a1", knox.Primary)
+	granted := false
+	for _, a := range key.ACL {
+		if a.Type == knox.User && a.ID == "alice" && a.AccessType == knox.Read && a.ExpiresAt > time.Now().UnixNano() {
+			granted = true
+		}
+	}
+	if !granted {
+		t.Fatalf("approved access missing from %v", key.ACL)
+	}
+	_, err = decideAccessRequestHandler(m, u, params)
+	if err == nil || err.Subcode != knox.AccessRequestDoesNotExistCode {
+		t.Fatalf("Expected no request error not %+v", err)
+	}
+
+	i, err = postAccessRequestHandler(m, auth.NewMachine("host1"), map[string]string{"keyID": "a1", "access": "Admin", "justification": "deploys"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	r = i.(knox.AccessRequest)
+	_, err = decideAccessRequestHandler(m, u, map[string]string{"keyID": "a1", "requestID": strconv.FormatUint(r.ID, 10), "decision": "deny"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	key, _ = m.GetKey("a1", knox.Primary)
+	for _, a := range key.ACL {
+		if a.Type == knox.Machine {
+			t.Fatalf("denied access added to %v", key.ACL)
+		}
+	}
+
+	SetAccessRequestTTL(0)
+	defer SetAccessRequestTTL(7 * 24 * time.Hour)
+	i, err = postAccessRequestHandler(m, bob, map[string]string{"keyID": "a1", "access": "Read", "justification": "curious"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	r = i.(knox.AccessRequest)
+	_, err = decideAccessRequestHandler(m, u, map[string]string{"keyID": "a1", "requestID": strconv.FormatUint(r.ID, 10), "decision": "approve"})
+	if err == nil || err.Subcode != knox.AccessRequestDoesNotExistCode {
+		t.Fatalf("Expected expired request error not %+v", err)
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	alice := auth.NewUser("alice", []string{})
+	bob := auth.NewUser("bob", []string{})
+	for _, id := range []string{"a1", "b1"} {
+		if _, err := postKeysHandler(m, u, map[string]string{"id": id, "data": "MQ==", "acl": `[{"type":"User","id":"alice","access":"Admin"}]`}); err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+		_, err := putQuorumHandler(m, bob, map[string]string{"keyID": id, "required": "true"})
+		if err == nil || err.Subcode != knox.UnauthorizedCode {
+			t.Fatalf("Expected unauthorized error not %+v", err)
+		}
+		if _, err := putQuorumHandler(m, u, map[string]string{"keyID": id, "required": "true"}); err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+	}
+	i, err := getQuorumHandler(m, u, map[string]string{"keyID": "a1"})
+	if err != nil || i != true {
+		t.Fatalf("expected quorum, got %v, %+v", i, err)
+	}
+
+	// pending returns the ID of the operation the handler result held.
+	pending := func(err *HTTPError, keyID string) string {
+		if err == nil || err.Subcode != knox.OperationPendingCode {
+			t.Fatalf("Expected pending operation not %+v", err)
+		}
+		i, err := getPendingOperationsHandler(m, alice, map[string]string{"key_id": keyID})
+		if err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+		ops := i.([]knox.PendingOperation)
+		if len(ops) != 1 {
+			t.Fatalf("expected one pending operation, got %v", ops)
+		}
+		return strconv.FormatUint(ops[0].ID, 10)
+	}
+
+	_, err = deleteKeyHandler(m, u, map[string]string{"keyID": "a1"})
+	opID := pending(err, "a1")
+	if i, _ := getPendingOperationsHandler(m, bob, map[string]string{}); len(i.([]knox.PendingOperation)) != 0 {
+		t.Fatalf("bob sees pending operations %v", i)
+	}
+	_, err = confirmOperationHandler(m, u, map[string]string{"keyID": "a1", "operationID": opID})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	if _, err := m.GetKey("a1", knox.Primary); err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	if _, err := confirmOperationHandler(m, alice, map[string]string{"keyID": "a1", "operationID": opID}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if _, err := m.GetKey("a1", knox.Primary); err != knox.ErrKeyIDNotFound {
+		t.Fatalf("%v is not %v", err, knox.ErrKeyIDNotFound)
+	}
+
+	_, err = putAccessHandler(m, u, map[string]string{"keyID": "b1", "access": `{"type":"User","id":"bob","access":"Admin"}`})
+	opID = pending(err, "b1")
+	if _, err := cancelOperationHandler(m, alice, map[string]string{"keyID": "b1", "operationID": opID}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = confirmOperationHandler(m, alice, map[string]string{"keyID": "b1", "operationID": opID})
+	if err == nil || err.Subcode != knox.OperationDoesNotExistCode {
+		t.Fatalf("Expected no operation error not %+v", err)
+	}
+
+	i, err = postAccessRequestHandler(m, bob, map[string]string{"keyID": "b1", "access": "Admin", "justification": "takeover"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	r := i.(knox.AccessRequest)
+	_, err = decideAccessRequestHandler(m, u, map[string]string{"keyID": "b1", "requestID": strconv.FormatUint(r.ID, 10), "decision": "approve"})
+	opID = pending(err, "b1")
+	if _, err := m.GetAccessRequest("b1", r.ID); err != knox.ErrAccessRequestNotFound {
+		t.Fatalf("%v is not %v", err, knox.ErrAccessRequestNotFound)
+	}
+	if _, err := confirmOperationHandler(m, alice, map[string]string{"keyID": "b1", "operationID": opID}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if !bob.CanAccess(mustGetKey(t, m, "b1").ACL, knox.Admin) {
+		t.Fatal("confirmed access was not granted")
+	}
+
+	i, err = postVersionHandler(m, u, map[string]string{"keyID": "b1", "data": "Mg=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	versionID := strconv.FormatUint(i.(uint64), 10)
+	_, err = putVersionsHandler(m, u, map[string]string{"keyID": "b1", "versionID": versionID, "status": `"Primary"`})
+	opID = pending(err, "b1")
+	if _, err := confirmOperationHandler(m, alice, map[string]string{"keyID": "b1", "operationID": opID}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if primary := mustGetKey(t, m, "b1").VersionList[0].ID; strconv.FormatUint(primary, 10) != versionID {
+		t.Fatalf("%d is not %s", primary, versionID)
+	}
+
+	_, err = putQuorumHandler(m, u, map[string]string{"keyID": "b1", "required": "false"})
+	opID = pending(err, "b1")
+	if _, err := confirmOperationHandler(m, alice, map[string]string{"keyID": "b1", "operationID": opID}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if _, err := deleteKeyHandler(m, u, map[string]string{"keyID": "b1"}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+}
+
+func TestQuorumHeldOperations(t *testing.T) {
+	m, _ := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	alice := auth.NewUser("alice", []string{})
+	bob := auth.NewUser("bob", []string{})
+	erin := auth.NewUser("erin", []string{"security-team"})
+	frank := auth.NewUser("frank", []string{"security-team"})
+	SetGlobalPolicy([]knox.Access{{Type: knox.UserGroup, ID: "security-team"}}, nil)
+	defer SetGlobalPolicy(nil, nil)
+
+	for _, path := range []string{"/teams", "/teams/db"} {
+		if _, err := postNamespaceHandler(m, u, map[string]string{"path": path, "acl": `[{"type":"User","id":"alice","access":"Admin"}]`}); err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+	}
+	acl := `[{"type":"User","id":"alice","access":"Admin"},{"type":"User","id":"bob","access":"Admin"}]`
+	if _, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ==", "acl": acl, "path": "/teams/db"}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if _, err := putQuorumHandler(m, u, map[string]string{"keyID": "a1", "required": "true"}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+
+	// confirm confirms the single operation the handler result held on keyID
+	// as principal.
+	confirm := func(err *HTTPError, keyID string, principal knox.Principal) *HTTPError {
+		if err == nil || err.Subcode != knox.OperationPendingCode {
+			t.Fatalf("Expected pending operation not %+v", err)
+		}
+		i, err := getPendingOperationsHandler(m, alice, map[string]string{"key_id": keyID})
+		if err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+		ops := i.([]knox.PendingOperation)
+		if len(ops) != 1 {
+			t.Fatalf("expected one pending operation, got %v", ops)
+		}
+		_, err = confirmOperationHandler(m, principal, map[string]string{"keyID": keyID, "operationID": strconv.FormatUint(ops[0].ID, 10)})
+		return err
+	}
+
+	// Namespace ACL changes are held on the keys in nested namespaces too, and
+	// the confirming admin needs Admin access to the namespace.
+	machineRead := knox.Access{Type: knox.Machine, ID: "MrRoboto", AccessType: knox.Read}
+	_, err := putNamespaceAccessHandler(m, u, map[string]string{"path": "/teams", "access": `{"type":"Machine","id":"MrRoboto","access":"Read"}`})
+	if err := confirm(err, "a1", bob); err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = putNamespaceAccessHandler(m, u, map[string]string{"path": "/teams", "access": `{"type":"Machine","id":"MrRoboto","access":"Read"}`})
+	if err == nil || err.Subcode != knox.OperationPendingCode {
+		t.Fatalf("Expected pending operation not %+v", err)
+	}
+	ops, _ := m.GetPendingOperations()
+	if len(ops) != 2 {
+		t.Fatalf("expected two pending operations, got %v", ops)
+	}
+	for _, op := range ops {
+		if _, err := cancelOperationHandler(m, alice, map[string]string{"keyID": "a1", "operationID": strconv.FormatUint(op.ID, 10)}); err != nil {
+			t.Fatalf("%+v is not nil", err)
+		}
+	}
+	_, err = putNamespaceAccessHandler(m, u, map[string]string{"path": "/teams", "access": `{"type":"Machine","id":"MrRoboto","access":"Read"}`})
+	if err := confirm(err, "a1", alice); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	n, _ := m.GetNamespace("/teams")
+	if n.ACL[len(n.ACL)-1] != machineRead {
+		t.Fatalf("confirmed access was not granted: %v", n.ACL)
+	}
+
+	// ACL migrations are confirmed by a second global admin.
+	_, err = migrateACLHandler(m, erin, map[string]string{"from": "User:bob", "to": "User:carol"})
+	if err := confirm(err, "a1", alice); err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	ops, _ = m.GetPendingOperations()
+	if _, err := confirmOperationHandler(m, frank, map[string]string{"keyID": "a1", "operationID": strconv.FormatUint(ops[0].ID, 10)}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	carol := auth.NewUser("carol", []string{})
+	if key := mustGetKey(t, m, "a1"); bob.CanAccess(key.ACL, knox.Admin) || !carol.CanAccess(key.ACL, knox.Admin) {
+		t.Fatalf("bob was not migrated to carol: %v", key.ACL)
+	}
+
+	// Migrations that only change a namespace are held on the keys inside it,
+	// and the migration itself stops at keys that require quorum.
+	dave := knox.Access{Type: knox.User, ID: "dave", AccessType: knox.Read}
+	if err := m.UpdateNamespaceAccess("/teams/db", dave); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = migrateACLHandler(m, erin, map[string]string{"from": "User:dave", "remove": "true"})
+	if err := confirm(err, "a1", frank); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if n, _ := m.GetNamespace("/teams/db"); n.ACL[len(n.ACL)-1] == dave {
+		t.Fatalf("dave was not removed: %v", n.ACL)
+	}
+	if err := m.UpdateNamespaceAccess("/teams/db", dave); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, _, migrateErr := m.MigratePrincipal(dave, nil, "", 100, false, false)
+	if e, ok := migrateErr.(*QuorumRequiredError); !ok || e.KeyID != "a1" {
+		t.Fatalf("Expected quorum required for a1 not %v", migrateErr)
+	}
+	if _, _, err := m.MigratePrincipal(dave, nil, "", 100, false, true); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if err := m.UpdateNamespaceAccess("/teams/db", dave); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = stripAccessHandler(m, erin, map[string]string{"principals": "User:dave"})
+	if err := confirm(err, "a1", frank); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if n, _ := m.GetNamespace("/teams/db"); n.ACL[len(n.ACL)-1] == dave {
+		t.Fatalf("dave was not stripped: %v", n.ACL)
+	}
+
+	i, err := postVersionHandler(m, u, map[string]string{"keyID": "a1", "data": "Mg=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	versionID := strconv.FormatUint(i.(uint64), 10)
+	if _, err := putVersionsHandler(m, u, map[string]string{"keyID": "a1", "versionID": versionID, "status": `"Inactive"`}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = deleteVersionHandler(m, u, map[string]string{"keyID": "a1", "versionID": versionID})
+	if err := confirm(err, "a1", alice); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if key, _ := m.GetKey("a1", knox.Inactive); len(key.VersionList) != 1 {
+		t.Fatalf("version %s was not purged: %v", versionID, key.VersionList)
+	}
+
+	if _, err := putAliasHandler(m, u, map[string]string{"aliasID": "old1", "key": "a1"}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = deleteAliasHandler(m, u, map[string]string{"aliasID": "old1"})
+	if err := confirm(err, "a1", alice); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if _, err := m.GetAlias("old1"); err != knox.ErrKeyIDNotFound {
+		t.Fatalf("%v is not %v", err, knox.ErrKeyIDNotFound)
+	}
+
+	_, err = moveKeyHandler(m, u, map[string]string{"keyID": "a1", "path": ""})
+	if err := confirm(err, "a1", alice); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if path := mustGetKey(t, m, "a1").Path; path != "" {
+		t.Fatalf("%s is not empty", path)
+	}
+
+	_, err = putRetentionHandler(m, u, map[string]string{"keyID": "a1", "retention": `{"max_inactive":1}`})
+	if err := confirm(err, "a1", alice); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if r, _ := m.GetVersionRetention("a1"); r == nil || r.MaxInactive != 1 {
+		t.Fatalf("retention was not set: %v", r)
+	}
+
+	_, err = renameKeyHandler(m, u, map[string]string{"keyID": "a1", "id": "a2"})
+	if err := confirm(err, "a1", alice); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	mustGetKey(t, m, "a2")
+
+	// Keys in the trash still need quorum to be purged.
+	_, err = deleteKeyHandler(m, u, map[string]string{"keyID": "a2"})
+	if err := confirm(err, "a2", alice); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	_, err = deleteKeyHandler(m, u, map[string]string{"keyID": "a2", "purge": "true"})
+	if err := confirm(err, "a2", alice); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if _, err := m.GetDeletedKey("a2"); err != knox.ErrKeyIDNotFound {
+		t.Fatalf("%v is not %v", err, knox.ErrKeyIDNotFound)
+	}
+}
+
+func mustGetKey(t *testing.T, m KeyManager, keyID string) *knox.Key {
+	key, err := m.GetKey(keyID, knox.Primary)
+	if err != nil {
+		t.Fatalf("%s is not nil", err)
+	}
+	return key
+}
+
+func TestBreakGlass(t *testing.T) {
+	m, db := makeDB()
+	u := auth.NewUser("testuser", []string{})
+	oncall := auth.NewUser("oncall", []string{"sre"})
+	bob := auth.NewUser("bob", []string{})
+	admin := auth.NewUser("secadmin", []string{"security-team"})
+	SetGlobalPolicy([]knox.Access{{Type: knox.UserGroup, ID: "security-team"}}, nil)
+	defer SetGlobalPolicy(nil, nil)
+	SetBreakGlassPolicy([]string{"sre"}, 30*time.Minute)
+	defer SetBreakGlassPolicy(nil, 30*time.Minute)
+	alerts := make(chan knox.BreakGlassGrant, 1)
+	AddBreakGlassHook(func(g knox.BreakGlassGrant) { alerts <- g })
+	defer func() { breakGlassHooks = nil }()
+
+	_, err := postKeysHandler(m, u, map[string]string{"id": "a1", "data": "MQ=="})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	acl := mustGetKey(t, m, "a1").ACL
+
+	_, err = breakGlassHandler(m, bob, map[string]string{"keyID": "a1", "justification": "incident 42"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	_, err = breakGlassHandler(m, oncall, map[string]string{"keyID": "a1", "justification": " "})
+	if err == nil || err.Subcode != knox.BadRequestDataCode {
+		t.Fatalf("Expected bad request error not %+v", err)
+	}
+	_, err = breakGlassHandler(m, oncall, map[string]string{"keyID": "NOTAKEY", "justification": "incident 42"})
+	if err == nil || err.Subcode != knox.KeyIdentifierDoesNotExistCode {
+		t.Fatalf("Expected no key error not %+v", err)
+	}
+	_, err = getKeyHandler(m, oncall, map[string]string{"keyID": "a1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+
+	i, err := breakGlassHandler(m, oncall, map[string]string{"keyID": "a1", "justification": "incident 42"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	grant := i.(knox.BreakGlassGrant)
+	if grant.Principal != "oncall" || grant.Justification != "incident 42" || grant.ExpiresAt-grant.GrantedAt != int64(30*time.Minute) {
+		t.Fatalf("unexpected grant %+v", grant)
+	}
+	select {
+	case g := <-alerts:
+		if g != grant {
+			t.Fatalf("hook got %+v, not %+v", g, grant)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("break-glass hook was not called")
+	}
+
+	i, err = getKeyHandler(m, oncall, map[string]string{"keyID": "a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if k := i.(etagged).Data.(*knox.Key); k.ID != "a1" {
+		t.Fatalf("unexpected key %+v", k)
+	}
+	if got := mustGetKey(t, m, "a1").ACL; len(got) != len(acl) {
+		t.Fatalf("break glass changed the ACL from %v to %v", acl, got)
+	}
+	// The grant is for the user, not a machine that happens to share its name.
+	_, err = getKeyHandler(m, auth.NewMachine("oncall"), map[string]string{"keyID": "a1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	// The grant also covers reads through an alias of the key.
+	if _, err := putAliasHandler(m, u, map[string]string{"aliasID": "old-a1", "key": "a1"}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	i, err = getKeyHandler(m, oncall, map[string]string{"keyID": "old-a1"})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if k := i.(etagged).Data.(*knox.Key); k.ID != "a1" {
+		t.Fatalf("unexpected key %+v", k)
+	}
+
+	SetBreakGlassPolicy([]string{"sre"}, 0)
+	if _, err := postKeysHandler(m, u, map[string]string{"id": "b1", "data": "MQ=="}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if _, err := breakGlassHandler(m, oncall, map[string]string{"keyID": "b1", "justification": "incident 43"}); err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	<-alerts
+	_, err = getKeyHandler(m, oncall, map[string]string{"keyID": "b1"})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+
+	_, err = breakGlassReportHandler(m, oncall, map[string]string{})
+	if err == nil || err.Subcode != knox.UnauthorizedCode {
+		t.Fatalf("Expected unauthorized error not %+v", err)
+	}
+	i, err = breakGlassReportHandler(m, admin, map[string]string{})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	grants := i.([]knox.BreakGlassGrant)
+	if len(grants) != 2 || grants[0].KeyID != "a1" || grants[1].KeyID != "b1" {
+		t.Fatalf("unexpected report %+v", grants)
+	}
+
+	// Expired grants move from the key to the archive and stay in the report.
+	SetBreakGlassArchive(NewFileBreakGlassArchive(filepath.Join(t.TempDir(), "archive")))
+	defer SetBreakGlassArchive(nil)
+	removed, removeErr := keydb.RemoveExpiredBreakGlassGrants(db, time.Now())
+	if removeErr != nil {
+		t.Fatalf("%s is not nil", removeErr)
+	}
+	ArchiveBreakGlassGrants(removed)
+	if stored, _ := db.Get("b1"); len(stored.BreakGlassGrants) != 0 {
+		t.Fatalf("expired grants were kept on the key: %+v", stored.BreakGlassGrants)
+	}
+	i, err = breakGlassReportHandler(m, admin, map[string]string{})
+	if err != nil {
+		t.Fatalf("%+v is not nil", err)
+	}
+	if report := i.([]knox.BreakGlassGrant); len(report) != 2 || report[0] != grants[0] || report[1] != grants[1] {
+		t.Fatalf("report %+v is not %+v", report, grants)
+	}
+}